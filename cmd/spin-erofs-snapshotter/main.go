@@ -41,7 +41,7 @@ import (
 
 	"github.com/spin-stack/erofs-snapshotter/internal/differ"
 	"github.com/spin-stack/erofs-snapshotter/internal/grpcservice"
-	"github.com/spin-stack/erofs-snapshotter/internal/preflight"
+	"github.com/spin-stack/erofs-snapshotter/internal/mountutils"
 	"github.com/spin-stack/erofs-snapshotter/internal/snapshotter"
 	"github.com/spin-stack/erofs-snapshotter/internal/store"
 )
@@ -61,12 +61,6 @@ const (
 )
 
 func main() {
-	// Run preflight checks early to fail fast
-	if err := preflight.Check(); err != nil {
-		fmt.Fprintf(os.Stderr, "preflight check failed: %v\n", err)
-		os.Exit(1)
-	}
-
 	app := &cli.App{
 		Name:    "spin-erofs-snapshotter",
 		Usage:   "External EROFS snapshotter for containerd",
@@ -110,12 +104,23 @@ func main() {
 				Value:   64 * 1024 * 1024, // 64 MiB
 				EnvVars: []string{"EROFS_SNAPSHOTTER_DEFAULT_SIZE"},
 			},
+			&cli.BoolFlag{
+				Name:    "auto-modprobe",
+				Usage:   "Attempt 'modprobe erofs' if EROFS is not registered in /proc/filesystems",
+				EnvVars: []string{"EROFS_SNAPSHOTTER_AUTO_MODPROBE"},
+			},
 			&cli.BoolFlag{
 				Name:    "set-immutable",
 				Usage:   "Set immutable flag on committed layers",
 				Value:   true,
 				EnvVars: []string{"EROFS_SNAPSHOTTER_SET_IMMUTABLE"},
 			},
+			&cli.IntFlag{
+				Name:    "mount-concurrency",
+				Usage:   fmt.Sprintf("Loop devices the differ sets up in parallel when mounting layers on the host (1-%d)", mountutils.MaxLoopConcurrency),
+				Value:   mountutils.DefaultLoopConcurrency,
+				EnvVars: []string{"EROFS_SNAPSHOTTER_MOUNT_CONCURRENCY"},
+			},
 		},
 		Action: run,
 	}
@@ -160,15 +165,19 @@ func run(cliCtx *cli.Context) error {
 	}
 
 	// Build snapshotter options
-	var snapshotterOpts []snapshotter.Opt
+	snapshotterOpts := []snapshotter.Opt{snapshotter.WithDefaultNamespace(containerdNamespace)}
 	if size := cliCtx.Int64("default-size"); size > 0 {
 		snapshotterOpts = append(snapshotterOpts, snapshotter.WithDefaultSize(size))
 	}
 	if cliCtx.Bool("set-immutable") {
 		snapshotterOpts = append(snapshotterOpts, snapshotter.WithImmutable())
 	}
+	if cliCtx.Bool("auto-modprobe") {
+		snapshotterOpts = append(snapshotterOpts, snapshotter.WithAutoModprobe())
+	}
 
-	// Create snapshotter
+	// Create snapshotter (runs the preflight checks, loading EROFS first
+	// when --auto-modprobe is set)
 	sn, err := snapshotter.NewSnapshotter(root, snapshotterOpts...)
 	if err != nil {
 		return fmt.Errorf("failed to create snapshotter: %w", err)
@@ -185,7 +194,10 @@ func run(cliCtx *cli.Context) error {
 	// Use namespace-aware store to properly handle namespace from gRPC request context.
 	// This is necessary because proxy plugins receive namespace in gRPC metadata,
 	// not from the client's default namespace.
-	contentStore := store.NewNamespaceAwareStore(client, containerdNamespace)
+	contentStore, err := store.NewNamespaceAwareStore(client, containerdNamespace)
+	if err != nil {
+		return fmt.Errorf("failed to create content store: %w", err)
+	}
 
 	// Build differ options
 	var differOpts []differ.DifferOpt
@@ -208,6 +220,7 @@ func run(cliCtx *cli.Context) error {
 
 	// Add mount manager to differ options for template resolution
 	differOpts = append(differOpts, differ.WithMountManager(mm))
+	differOpts = append(differOpts, differ.WithMountConcurrency(cliCtx.Int("mount-concurrency")))
 
 	// Create differ
 	df := differ.NewErofsDiffer(contentStore, differOpts...)
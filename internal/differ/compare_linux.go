@@ -44,9 +44,9 @@ import (
 // diffWriteFunc is a function that writes diff content to the provided writer.
 type diffWriteFunc func(ctx context.Context, w io.Writer) error
 
-func writeDiffFromMounts(ctx context.Context, w io.Writer, lower, upper []mount.Mount, mm mount.Manager) error {
-	return withLowerMount(ctx, lower, mm, func(lowerRoot string) error {
-		return withUpperMount(ctx, upper, mm, func(upperRoot string) error {
+func writeDiffFromMounts(ctx context.Context, w io.Writer, lower, upper []mount.Mount, mm mount.Manager, hm hostMountOptions) error {
+	return withLowerMount(ctx, lower, mm, hm, func(lowerRoot string) error {
+		return withUpperMount(ctx, upper, mm, hm, func(upperRoot string) error {
 			if err := archive.WriteDiff(ctx, w, lowerRoot, upperRoot); err != nil {
 				return fmt.Errorf("failed to write diff: %w", err)
 			}
@@ -55,6 +55,16 @@ func writeDiffFromMounts(ctx context.Context, w io.Writer, lower, upper []mount.
 	})
 }
 
+// hostMountOptions configures the mounts Compare makes on the host.
+type hostMountOptions struct {
+	rootless    bool
+	concurrency int
+}
+
+func (s *ErofsDiff) hostMountOptions() hostMountOptions {
+	return hostMountOptions{rootless: s.rootless, concurrency: s.mountConcurrency}
+}
+
 // mountManager resolves and returns the mount manager.
 // Returns nil if no resolver is configured.
 func (s *ErofsDiff) mountManager() mount.Manager {
@@ -93,7 +103,7 @@ func (s *ErofsDiff) Compare(ctx context.Context, lower, upper []mount.Mount, opt
 	mm := s.mountManager()
 
 	return s.writeAndCommitDiff(ctx, config, func(ctx context.Context, w io.Writer) error {
-		return writeDiffFromMounts(ctx, w, lower, upper, mm)
+		return writeDiffFromMounts(ctx, w, lower, upper, mm, s.hostMountOptions())
 	})
 }
 
@@ -237,11 +247,11 @@ func (s *ErofsDiff) writeAndCommitDiff(ctx context.Context, config diff.Config,
 // withLowerMount resolves lower mounts and calls f with the resulting root path.
 // If mounts require the mount manager (formatted mounts, templates, or EROFS),
 // it activates them through the mount manager first.
-func withLowerMount(ctx context.Context, lower []mount.Mount, mm mount.Manager, f func(root string) error) error {
+func withLowerMount(ctx context.Context, lower []mount.Mount, mm mount.Manager, hm hostMountOptions, f func(root string) error) error {
 	// Handle EROFS multi-device mounts directly - the containerd mount manager
 	// cannot handle EROFS with device= options (fsmeta multi-device).
 	if mountutils.HasErofsMultiDevice(lower) {
-		return withErofsTempMount(ctx, lower, f)
+		return withErofsTempMount(ctx, lower, hm, f)
 	}
 
 	if mountutils.NeedsMountManager(lower) {
@@ -285,16 +295,16 @@ func withLowerMount(ctx context.Context, lower []mount.Mount, mm mount.Manager,
 // withUpperMount resolves upper mounts and calls f with the resulting root path.
 // If mounts require the mount manager (formatted mounts, templates, or EROFS),
 // it activates them through the mount manager first.
-func withUpperMount(ctx context.Context, upper []mount.Mount, mm mount.Manager, f func(root string) error) error {
+func withUpperMount(ctx context.Context, upper []mount.Mount, mm mount.Manager, hm hostMountOptions, f func(root string) error) error {
 	// Handle active snapshot mounts (EROFS + ext4) - create overlay on host
 	if mountutils.HasActiveSnapshotMounts(upper) {
-		return withActiveSnapshotMount(ctx, upper, f)
+		return withActiveSnapshotMount(ctx, upper, hm, f)
 	}
 
 	// Handle EROFS multi-device mounts directly - the containerd mount manager
 	// cannot handle EROFS with device= options (fsmeta multi-device).
 	if mountutils.HasErofsMultiDevice(upper) {
-		return withErofsTempMount(ctx, upper, f)
+		return withErofsTempMount(ctx, upper, hm, f)
 	}
 
 	if mountutils.NeedsMountManager(upper) {
@@ -334,7 +344,7 @@ func withUpperMount(ctx context.Context, upper []mount.Mount, mm mount.Manager,
 // withActiveSnapshotMount handles active snapshot mounts (EROFS + ext4) by creating
 // an overlay on the host. The EROFS layers form the lowerdir, and the ext4's /upper
 // forms the upperdir. This allows Compare to see the changes made in the container.
-func withActiveSnapshotMount(ctx context.Context, mounts []mount.Mount, f func(root string) error) error {
+func withActiveSnapshotMount(ctx context.Context, mounts []mount.Mount, hm hostMountOptions, f func(root string) error) error {
 	// Separate EROFS and ext4 mounts
 	var erofsMounts []mount.Mount
 	var ext4Mount *mount.Mount
@@ -370,7 +380,7 @@ func withActiveSnapshotMount(ctx context.Context, mounts []mount.Mount, f func(r
 	}
 
 	// Mount EROFS layers
-	erofsCleanup, err := mountutils.MountAll(erofsMounts, erofsDir)
+	erofsCleanup, err := mountutils.MountAll(erofsMounts, erofsDir, mountutils.WithLoopConcurrency(hm.concurrency))
 	if err != nil {
 		return fmt.Errorf("failed to mount EROFS: %w", err)
 	}
@@ -404,14 +414,12 @@ func withActiveSnapshotMount(ctx context.Context, mounts []mount.Mount, f func(r
 			return fmt.Errorf("failed to create upper dir: %w", err)
 		}
 	}
-	if _, err := os.Stat(workDir); err != nil {
-		if err := os.MkdirAll(workDir, 0o755); err != nil {
-			return fmt.Errorf("failed to create work dir: %w", err)
-		}
+	if err := ensureOverlayWorkDir(workDir); err != nil {
+		return err
 	}
 
 	// Create overlay mount
-	overlayOpts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", erofsDir, upperDir, workDir)
+	overlayOpts := overlayMountOptions(erofsDir, upperDir, workDir, hm.rootless)
 	if err := unix.Mount("overlay", overlayDir, "overlay", 0, overlayOpts); err != nil {
 		return fmt.Errorf("failed to mount overlay: %w", err)
 	}
@@ -424,17 +432,42 @@ func withActiveSnapshotMount(ctx context.Context, mounts []mount.Mount, f func(r
 	return f(overlayDir)
 }
 
+// ensureOverlayWorkDir recreates the overlay workdir if it is missing and
+// removes the work/work scratch directory a previous overlay mount may have
+// left behind. A stale work/work from an unclean unmount can make the next
+// mount fail with a confusing error; overlayfs recreates it on mount.
+func ensureOverlayWorkDir(workDir string) error {
+	if err := os.MkdirAll(workDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	if err := os.RemoveAll(filepath.Join(workDir, "work")); err != nil {
+		return fmt.Errorf("failed to clear stale overlay work dir: %w", err)
+	}
+	return nil
+}
+
+// overlayMountOptions returns the overlay mount data for the host overlay
+// used to read an active snapshot. In a user namespace, overlayfs stores
+// whiteouts and opaque markers in user.* xattrs, which requires "userxattr".
+func overlayMountOptions(lower, upper, work string, rootless bool) string {
+	opts := []string{"lowerdir=" + lower, "upperdir=" + upper, "workdir=" + work}
+	if rootless {
+		opts = append(opts, "userxattr")
+	}
+	return strings.Join(opts, ",")
+}
+
 // withErofsTempMount mounts EROFS mounts (including multi-device fsmeta) to a
 // temporary directory and calls f with the mount root. This handles EROFS mounts
 // that the containerd mount manager cannot handle.
-func withErofsTempMount(ctx context.Context, mounts []mount.Mount, f func(root string) error) error {
+func withErofsTempMount(ctx context.Context, mounts []mount.Mount, hm hostMountOptions, f func(root string) error) error {
 	tempDir, err := os.MkdirTemp("", "erofs-diff-")
 	if err != nil {
 		return fmt.Errorf("failed to create temp dir: %w", err)
 	}
 	defer os.RemoveAll(tempDir)
 
-	cleanup, err := mountutils.MountAll(mounts, tempDir)
+	cleanup, err := mountutils.MountAll(mounts, tempDir, mountutils.WithLoopConcurrency(hm.concurrency))
 	if err != nil {
 		return fmt.Errorf("failed to mount EROFS: %w", err)
 	}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 
@@ -71,6 +72,53 @@ func (m *memoryLabelStore) Update(d digest.Digest, update map[string]string) (ma
 	return labels, nil
 }
 
+func TestOverlayMountOptions(t *testing.T) {
+	t.Run("rootful", func(t *testing.T) {
+		got := overlayMountOptions("/l", "/u", "/w", false)
+		if want := "lowerdir=/l,upperdir=/u,workdir=/w"; got != want {
+			t.Errorf("overlayMountOptions() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("rootless adds userxattr once", func(t *testing.T) {
+		got := overlayMountOptions("/l", "/u", "/w", true)
+		if n := strings.Count(got, "userxattr"); n != 1 {
+			t.Errorf("expected userxattr exactly once, got %d in %q", n, got)
+		}
+	})
+}
+
+func TestEnsureOverlayWorkDir(t *testing.T) {
+	t.Run("recreates missing work dir", func(t *testing.T) {
+		workDir := filepath.Join(t.TempDir(), "work")
+
+		if err := ensureOverlayWorkDir(workDir); err != nil {
+			t.Fatalf("ensureOverlayWorkDir: %v", err)
+		}
+		if fi, err := os.Stat(workDir); err != nil || !fi.IsDir() {
+			t.Errorf("expected work dir to be created, got %v", err)
+		}
+	})
+
+	t.Run("clears stale overlay scratch dir", func(t *testing.T) {
+		workDir := t.TempDir()
+		stale := filepath.Join(workDir, "work")
+		if err := os.MkdirAll(filepath.Join(stale, "#1"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ensureOverlayWorkDir(workDir); err != nil {
+			t.Fatalf("ensureOverlayWorkDir: %v", err)
+		}
+		if _, err := os.Stat(stale); !os.IsNotExist(err) {
+			t.Errorf("expected stale work/work to be removed, got %v", err)
+		}
+		if _, err := os.Stat(workDir); err != nil {
+			t.Errorf("work dir itself should remain: %v", err)
+		}
+	})
+}
+
 func TestLowerOverlayOnly(t *testing.T) {
 	tests := []struct {
 		name   string
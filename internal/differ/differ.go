@@ -32,10 +32,12 @@ import (
 	"github.com/containerd/containerd/v2/core/mount"
 	"github.com/containerd/log"
 	"github.com/google/uuid"
+	"github.com/moby/sys/userns"
 	digest "github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"github.com/spin-stack/erofs-snapshotter/internal/erofs"
+	"github.com/spin-stack/erofs-snapshotter/internal/mountutils"
 )
 
 // MountManagerResolver is a function that resolves the mount manager lazily.
@@ -47,6 +49,11 @@ type MountManagerResolver func() mount.Manager
 type ErofsDiff struct {
 	store      content.Store
 	mmResolver MountManagerResolver
+	// rootless adds "userxattr" to host overlay mounts made by Compare,
+	// which is required for whiteouts inside a user namespace.
+	rootless bool
+	// mountConcurrency bounds parallel loop device setup for host mounts.
+	mountConcurrency int
 }
 
 // DifferOpt is an option for configuring the erofs differ
@@ -71,11 +78,33 @@ func WithMountManagerResolver(resolver MountManagerResolver) DifferOpt {
 	}
 }
 
+// WithRootless marks the differ as running without real root (e.g., rootless
+// containerd in a user namespace), so host overlay mounts use "userxattr".
+// This is enabled automatically when the process runs in a user namespace;
+// use it where that detection does not apply.
+func WithRootless() DifferOpt {
+	return func(d *ErofsDiff) {
+		d.rootless = true
+	}
+}
+
+// WithMountConcurrency bounds how many loop devices are set up in parallel
+// when Compare mounts a multi-layer EROFS image on the host. It defaults to
+// mountutils.DefaultLoopConcurrency to avoid loop device storms and is
+// clamped to [1, mountutils.MaxLoopConcurrency].
+func WithMountConcurrency(n int) DifferOpt {
+	return func(d *ErofsDiff) {
+		d.mountConcurrency = mountutils.ClampLoopConcurrency(n)
+	}
+}
+
 // NewErofsDiffer creates a new EROFS differ with the provided options.
 // The returned *ErofsDiff implements diff.Applier and diff.Comparer.
 func NewErofsDiffer(store content.Store, opts ...DifferOpt) *ErofsDiff {
 	d := &ErofsDiff{
-		store: store,
+		store:            store,
+		rootless:         userns.RunningInUserNS(),
+		mountConcurrency: mountutils.DefaultLoopConcurrency,
 	}
 
 	// Apply all options
@@ -9,8 +9,10 @@ import (
 	"testing"
 
 	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/moby/sys/userns"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
+	"github.com/spin-stack/erofs-snapshotter/internal/mountutils"
 	// Import testutil to register the -test.root flag
 	_ "github.com/spin-stack/erofs-snapshotter/internal/testutil"
 )
@@ -52,6 +54,28 @@ func TestNewErofsDiffer(t *testing.T) {
 			t.Error("resolver should have been called")
 		}
 	})
+
+	t.Run("applies WithMountConcurrency", func(t *testing.T) {
+		if d := NewErofsDiffer(nil); d.mountConcurrency != mountutils.DefaultLoopConcurrency {
+			t.Errorf("default mountConcurrency = %d, want %d", d.mountConcurrency, mountutils.DefaultLoopConcurrency)
+		}
+		if d := NewErofsDiffer(nil, WithMountConcurrency(8)); d.mountConcurrency != 8 {
+			t.Errorf("mountConcurrency = %d, want 8", d.mountConcurrency)
+		}
+		if d := NewErofsDiffer(nil, WithMountConcurrency(1000)); d.mountConcurrency != mountutils.MaxLoopConcurrency {
+			t.Errorf("mountConcurrency = %d, want %d", d.mountConcurrency, mountutils.MaxLoopConcurrency)
+		}
+	})
+
+	t.Run("applies WithRootless", func(t *testing.T) {
+		if d := NewErofsDiffer(nil); d.rootless != userns.RunningInUserNS() {
+			t.Errorf("default rootless = %v, want %v", d.rootless, userns.RunningInUserNS())
+		}
+		d := NewErofsDiffer(nil, WithRootless())
+		if !d.rootless {
+			t.Error("expected rootless to be set")
+		}
+	})
 }
 
 func TestDefaultMkfsOpts(t *testing.T) {
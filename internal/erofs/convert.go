@@ -24,11 +24,14 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/containerd/containerd/v2/core/mount"
 	"github.com/containerd/errdefs"
 	"github.com/containerd/log"
+	"github.com/google/uuid"
 	"github.com/opencontainers/go-digest"
 
 	"github.com/spin-stack/erofs-snapshotter/internal/stringutil"
@@ -168,10 +171,66 @@ func GenerateTarIndexAndAppendTar(ctx context.Context, r io.Reader, layerPath st
 	return nil
 }
 
-// ConvertErofs converts a directory to an EROFS image
-func ConvertErofs(ctx context.Context, layerPath string, srcDir string, mkfsExtraOpts []string) error {
-	args := append([]string{"--quiet", "-Enoinline_data"}, mkfsExtraOpts...)
+// buildDirErofsArgs constructs the command-line arguments for mkfs.erofs
+// when converting a directory to an EROFS image.
+//
+// The arguments follow the pattern: --quiet -Enoinline_data [extraOpts] FILE SOURCE
+// -Enoinline_data is left out when extraOpts holds TailPackingOpt.
+//
+// Unlike the tar path, --aufs is not used: the source is an overlayfs
+// upperdir whose whiteouts (0/0 character devices) and opaque directory
+// xattrs are already in overlay format and are copied verbatim. That is
+// what overlayfs expects from a lower layer, so deletions recorded in the
+// upperdir stay effective when the layer is stacked.
+func buildDirErofsArgs(layerPath, srcDir string, mkfsExtraOpts []string) []string {
+	args := []string{"--quiet"}
+	if !slices.Contains(mkfsExtraOpts, TailPackingOpt) {
+		args = append(args, "-Enoinline_data")
+	}
+	args = append(args, mkfsExtraOpts...)
 	args = append(args, layerPath, srcDir)
+	return args
+}
+
+// sparseChunkSize is the chunk size used for directories holding sparse
+// files. In chunked mode mkfs.erofs finds holes with SEEK_DATA and records
+// them as unallocated chunks instead of writing zero blocks.
+const sparseChunkSize = "--chunksize=4096"
+
+// sparseAwareOpts adds sparseChunkSize to mkfsExtraOpts when the source
+// holds sparse files, unless the caller already chose a chunk size or
+// compression, which handles zero runs on its own.
+func sparseAwareOpts(mkfsExtraOpts []string, sparse bool) []string {
+	if !sparse {
+		return mkfsExtraOpts
+	}
+	for _, opt := range mkfsExtraOpts {
+		if strings.HasPrefix(opt, "--chunksize") || strings.HasPrefix(opt, "-z") {
+			return mkfsExtraOpts
+		}
+	}
+	return append(slices.Clone(mkfsExtraOpts), sparseChunkSize)
+}
+
+// ConvertErofs converts a directory to an EROFS image.
+//
+// Options that strip overlayfs metadata (--ovlfs-strip) are rejected, since
+// they would drop whiteouts and resurrect deleted files from lower layers.
+//
+// If srcDir holds sparse files, the image is built in chunked mode so holes
+// are not materialized as zero blocks (see sparseAwareOpts).
+func ConvertErofs(ctx context.Context, layerPath string, srcDir string, mkfsExtraOpts []string) error {
+	for _, opt := range mkfsExtraOpts {
+		if strings.HasPrefix(opt, "--ovlfs-strip") {
+			return fmt.Errorf("mkfs.erofs option %q would drop overlay whiteouts: %w", opt, errdefs.ErrInvalidArgument)
+		}
+	}
+	sparse, err := hasSparseFiles(srcDir)
+	if err != nil {
+		// Detection is an optimization; let mkfs.erofs report real errors.
+		log.G(ctx).WithError(err).Debug("failed to detect sparse files, converting without chunks")
+	}
+	args := buildDirErofsArgs(layerPath, srcDir, sparseAwareOpts(mkfsExtraOpts, sparse))
 	cmd := exec.CommandContext(ctx, "mkfs.erofs", args...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
@@ -323,6 +382,22 @@ func layerFromUpperdir(upperdir string) string {
 	return parent
 }
 
+// TailPackingOpt makes ConvertErofs pack the tail of each file into its
+// inode instead of a block of its own, inlining uncompressed tails and
+// passing this option so compressed ones are packed too. It saves up to a
+// block per file, which adds up for layers with many small files.
+const TailPackingOpt = "-Eztailpacking"
+
+// SupportsTailPacking checks if the installed version of mkfs.erofs
+// supports TailPackingOpt.
+func SupportsTailPacking() (bool, error) {
+	output, err := exec.Command("mkfs.erofs", "--help").CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("failed to run mkfs.erofs --help: %w", err)
+	}
+	return bytes.Contains(output, []byte("ztailpacking")), nil
+}
+
 // SupportGenerateFromTar checks if the installed version of mkfs.erofs supports
 // the tar mode (--tar option).
 func SupportGenerateFromTar() (bool, error) {
@@ -368,27 +443,42 @@ const (
 	// erofsBlkszBitsOffset is the byte offset of the blkszbits field within the superblock.
 	// Superblock layout: magic(4) + checksum(4) + feature_compat(4) + blkszbits(1).
 	erofsBlkszBitsOffset = 12
+
+	// erofsUUIDOffset is the byte offset of the 16-byte uuid field within the superblock,
+	// after blkszbits(1) + sb_extslots(1) + root_nid(2) + inos(8) + build_time(8) +
+	// build_time_nsec(4) + blocks(4) + meta_blkaddr(4) + xattr_blkaddr(4).
+	erofsUUIDOffset = 48
 )
 
-// GetBlockSize reads the block size from an EROFS layer file.
-// Returns the block size in bytes, or an error if the file is not a valid EROFS image.
-func GetBlockSize(path string) (int, error) {
+// readSuperblock reads the first size bytes of the EROFS superblock of the
+// image at path, checking its magic number.
+func readSuperblock(path string, size int) ([]byte, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return 0, fmt.Errorf("failed to open EROFS file: %w", err)
+		return nil, fmt.Errorf("failed to open EROFS file: %w", err)
 	}
 	defer f.Close()
 
-	// Read the superblock (we need magic + blkszbits)
-	buf := make([]byte, 16)
+	buf := make([]byte, size)
 	if _, err := f.ReadAt(buf, erofsSuperblocOffset); err != nil {
-		return 0, fmt.Errorf("failed to read EROFS superblock: %w", err)
+		return nil, fmt.Errorf("failed to read EROFS superblock: %w", err)
 	}
 
 	// Check magic number (little-endian)
 	magic := uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16 | uint32(buf[3])<<24
 	if magic != erofsMagic {
-		return 0, fmt.Errorf("invalid EROFS magic: 0x%X (expected 0x%X)", magic, erofsMagic)
+		return nil, fmt.Errorf("invalid EROFS magic: 0x%X (expected 0x%X)", magic, erofsMagic)
+	}
+	return buf, nil
+}
+
+// GetBlockSize reads the block size from an EROFS layer file.
+// Returns the block size in bytes, or an error if the file is not a valid EROFS image.
+func GetBlockSize(path string) (int, error) {
+	// We need magic + blkszbits
+	buf, err := readSuperblock(path, 16)
+	if err != nil {
+		return 0, err
 	}
 
 	// Get block size bits (log2 of block size)
@@ -398,6 +488,35 @@ func GetBlockSize(path string) (int, error) {
 	return blockSize, nil
 }
 
+// GetUUID reads the filesystem UUID from an EROFS layer file. mkfs.erofs
+// generates a random UUID unless one is given with -U, so rebuilding a
+// layer changes it even when the contents are the same.
+func GetUUID(path string) (uuid.UUID, error) {
+	buf, err := readSuperblock(path, erofsUUIDOffset+16)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.UUID(buf[erofsUUIDOffset : erofsUUIDOffset+16]), nil
+}
+
+// Block sizes accepted by BlockSizeOpt. mkfs.erofs supports block sizes
+// from 512 bytes up to 64 KiB; the kernel additionally requires the block
+// size not to exceed its page size to mount an image.
+const (
+	MinBlockSize = 512
+	MaxBlockSize = 64 * 1024
+)
+
+// BlockSizeOpt returns the mkfs.erofs option selecting a block size of size
+// bytes. size must be a power of two between MinBlockSize and MaxBlockSize.
+func BlockSizeOpt(size int) (string, error) {
+	if size < MinBlockSize || size > MaxBlockSize || size&(size-1) != 0 {
+		return "", fmt.Errorf("erofs block size %d must be a power of two between %d and %d: %w",
+			size, MinBlockSize, MaxBlockSize, errdefs.ErrInvalidArgument)
+	}
+	return "-b" + strconv.Itoa(size), nil
+}
+
 // CanMergeFsmeta checks if all EROFS layers have block sizes compatible with fsmeta merge.
 // Returns true if all layers have block size >= 4096, false otherwise.
 func CanMergeFsmeta(layerPaths []string) bool {
@@ -0,0 +1,109 @@
+package erofs
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/v2/pkg/testutil"
+	"golang.org/x/sys/unix"
+)
+
+// TestConvertErofsPreservesWhiteouts converts an overlay upperdir holding a
+// whiteout and an opaque directory, stacks it over a lower layer with
+// overlayfs, and checks that the deletions are still in effect.
+func TestConvertErofsPreservesWhiteouts(t *testing.T) {
+	testutil.RequiresRoot(t)
+	skipIfNoMkfsErofs(t)
+
+	lowerSrc := t.TempDir()
+	mustWrite(t, filepath.Join(lowerSrc, "deleted.txt"), "lower")
+	mustWrite(t, filepath.Join(lowerSrc, "opaque", "hidden.txt"), "lower")
+	mustWrite(t, filepath.Join(lowerSrc, "kept.txt"), "lower")
+
+	upperSrc := t.TempDir()
+	if err := unix.Mknod(filepath.Join(upperSrc, "deleted.txt"), unix.S_IFCHR, 0); err != nil {
+		t.Fatalf("create whiteout: %v", err)
+	}
+	mustWrite(t, filepath.Join(upperSrc, "opaque", "new.txt"), "upper")
+	if err := unix.Setxattr(filepath.Join(upperSrc, "opaque"), "trusted.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Skipf("filesystem does not support trusted xattrs: %v", err)
+	}
+
+	images := t.TempDir()
+	lowerImg := filepath.Join(images, "lower.erofs")
+	upperImg := filepath.Join(images, "upper.erofs")
+	for img, src := range map[string]string{lowerImg: lowerSrc, upperImg: upperSrc} {
+		if err := ConvertErofs(t.Context(), img, src, nil); err != nil {
+			t.Fatalf("convert %s: %v", src, err)
+		}
+	}
+
+	lowerMnt := mountErofsImage(t, lowerImg)
+	upperMnt := mountErofsImage(t, upperImg)
+
+	merged := t.TempDir()
+	if err := unix.Mount("overlay", merged, "overlay", unix.MS_RDONLY, "lowerdir="+upperMnt+":"+lowerMnt); err != nil {
+		t.Skipf("overlay mount not available: %v", err)
+	}
+	t.Cleanup(func() { _ = unix.Unmount(merged, 0) })
+
+	for _, p := range []string{"deleted.txt", "opaque/hidden.txt"} {
+		if _, err := os.Lstat(filepath.Join(merged, p)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s should stay deleted, got: %v", p, err)
+		}
+	}
+	for _, p := range []string{"kept.txt", "opaque/new.txt"} {
+		if _, err := os.Stat(filepath.Join(merged, p)); err != nil {
+			t.Errorf("%s should be visible: %v", p, err)
+		}
+	}
+}
+
+// TestConvertErofsSparseFile checks that a file with a large hole does not
+// inflate the image with zero blocks.
+func TestConvertErofsSparseFile(t *testing.T) {
+	skipIfNoMkfsErofs(t)
+
+	const logicalSize = 256 << 20
+	src := t.TempDir()
+	writeSparseFile(t, filepath.Join(src, "prealloc.img"), logicalSize)
+	if sparse, err := hasSparseFiles(src); err != nil || !sparse {
+		t.Skipf("filesystem does not report holes: %v", err)
+	}
+
+	layer := filepath.Join(t.TempDir(), "layer.erofs")
+	if err := ConvertErofs(t.Context(), layer, src, nil); err != nil {
+		t.Fatalf("ConvertErofs: %v", err)
+	}
+	st, err := os.Stat(layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Size() > logicalSize/64 {
+		t.Errorf("layer is %d bytes for a %d byte sparse file", st.Size(), logicalSize)
+	}
+}
+
+// mountErofsImage loop-mounts an EROFS image read-only and returns the mount point.
+func mountErofsImage(t *testing.T, img string) string {
+	t.Helper()
+	mnt := t.TempDir()
+	if out, err := exec.Command("mount", "-t", "erofs", "-o", "ro,loop", img, mnt).CombinedOutput(); err != nil {
+		t.Skipf("cannot mount erofs image: %v: %s", err, out)
+	}
+	t.Cleanup(func() { _ = unix.Unmount(mnt, 0) })
+	return mnt
+}
+
+func mustWrite(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -20,13 +20,17 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/errdefs"
+	"github.com/google/uuid"
 
 	// Import testutil to register the -test.root flag
 	_ "github.com/spin-stack/erofs-snapshotter/internal/testutil"
@@ -502,6 +506,111 @@ func TestBuildTarIndexArgs(t *testing.T) {
 	}
 }
 
+func TestBuildDirErofsArgs(t *testing.T) {
+	got := buildDirErofsArgs("/path/to/layer.erofs", "/path/to/upper", []string{"-zlz4hc"})
+	want := []string{"--quiet", "-Enoinline_data", "-zlz4hc", "/path/to/layer.erofs", "/path/to/upper"}
+	if !slices.Equal(got, want) {
+		t.Errorf("buildDirErofsArgs() = %v, want %v", got, want)
+	}
+	// The upperdir already holds overlay-format whiteouts; --aufs would
+	// reinterpret .wh. names and is only meant for tar input.
+	if slices.Contains(got, "--aufs") {
+		t.Errorf("directory conversion must not use --aufs: %v", got)
+	}
+
+	got = buildDirErofsArgs("/path/to/layer.erofs", "/path/to/upper", []string{TailPackingOpt})
+	want = []string{"--quiet", TailPackingOpt, "/path/to/layer.erofs", "/path/to/upper"}
+	if !slices.Equal(got, want) {
+		t.Errorf("buildDirErofsArgs() with tail packing = %v, want %v", got, want)
+	}
+}
+
+// TestConvertErofsTailPacking converts a directory of small files with and
+// without TailPackingOpt and checks that packing tails shrinks the image.
+func TestConvertErofsTailPacking(t *testing.T) {
+	skipIfNoMkfsErofs(t)
+	if ok, err := SupportsTailPacking(); err != nil || !ok {
+		t.Skipf("mkfs.erofs does not support tail packing: %v", err)
+	}
+
+	src := t.TempDir()
+	for i := range 256 {
+		name := filepath.Join(src, fmt.Sprintf("file-%03d.js", i))
+		if err := os.WriteFile(name, bytes.Repeat([]byte{byte(i)}, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	size := func(opts []string) int64 {
+		t.Helper()
+		dst := filepath.Join(t.TempDir(), "layer.erofs")
+		if err := ConvertErofs(t.Context(), dst, src, opts); err != nil {
+			t.Fatalf("ConvertErofs(%v): %v", opts, err)
+		}
+		fi, err := os.Stat(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	plain := size(nil)
+	packed := size([]string{TailPackingOpt})
+	// Without packing each file takes a 4KiB block of its own.
+	if packed >= plain/2 {
+		t.Errorf("expected tail packing to at least halve the image: %d bytes packed, %d bytes plain", packed, plain)
+	}
+}
+
+func TestSparseAwareOpts(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []string
+		sparse bool
+		want   []string
+	}{
+		{"dense", []string{"-T0"}, false, []string{"-T0"}},
+		{"sparse", []string{"-T0"}, true, []string{"-T0", sparseChunkSize}},
+		{"sparse no opts", nil, true, []string{sparseChunkSize}},
+		{"explicit chunk size", []string{"--chunksize=65536"}, true, []string{"--chunksize=65536"}},
+		{"compressed", []string{"-zlz4hc"}, true, []string{"-zlz4hc"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := slices.Clone(tc.opts)
+			if got := sparseAwareOpts(opts, tc.sparse); !slices.Equal(got, tc.want) {
+				t.Errorf("sparseAwareOpts(%v, %v) = %v, want %v", tc.opts, tc.sparse, got, tc.want)
+			}
+			if !slices.Equal(opts, tc.opts) {
+				t.Errorf("caller options modified: %v", opts)
+			}
+		})
+	}
+}
+
+func TestBlockSizeOpt(t *testing.T) {
+	for _, size := range []int{512, 4096, 16384, 65536} {
+		opt, err := BlockSizeOpt(size)
+		if err != nil {
+			t.Errorf("BlockSizeOpt(%d): %v", size, err)
+		}
+		if want := fmt.Sprintf("-b%d", size); opt != want {
+			t.Errorf("BlockSizeOpt(%d) = %q, want %q", size, opt, want)
+		}
+	}
+	for _, size := range []int{0, 256, 3000, 131072} {
+		if _, err := BlockSizeOpt(size); !errdefs.IsInvalidArgument(err) {
+			t.Errorf("BlockSizeOpt(%d): expected invalid argument, got %v", size, err)
+		}
+	}
+}
+
+func TestConvertErofsRejectsOvlfsStrip(t *testing.T) {
+	err := ConvertErofs(t.Context(), filepath.Join(t.TempDir(), "layer.erofs"), t.TempDir(), []string{"--ovlfs-strip=1"})
+	if !errdefs.IsInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+}
+
 // TestArgsEndWithLayerPath verifies that both tar conversion functions
 // end with the layer path as the last argument. mkfs.erofs reads from
 // stdin automatically when no SOURCE is specified after FILE.
@@ -808,6 +917,58 @@ func TestGetBlockSize(t *testing.T) {
 	})
 }
 
+// TestGetUUID tests reading the filesystem UUID from EROFS layers.
+func TestGetUUID(t *testing.T) {
+	t.Run("non-erofs file", func(t *testing.T) {
+		f := filepath.Join(t.TempDir(), "invalid.erofs")
+		if err := os.WriteFile(f, make([]byte, 2048), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := GetUUID(f); err == nil {
+			t.Error("expected error for non-EROFS file")
+		}
+	})
+
+	t.Run("superblock", func(t *testing.T) {
+		want := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+		data := make([]byte, 2048)
+		copy(data[1024:], []byte{0xe2, 0xe1, 0xf5, 0xe0})
+		copy(data[1024+48:], want[:])
+		f := filepath.Join(t.TempDir(), "layer.erofs")
+		if err := os.WriteFile(f, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := GetUUID(f)
+		if err != nil {
+			t.Fatalf("GetUUID failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("GetUUID() = %s, want %s", got, want)
+		}
+	})
+
+	t.Run("mkfs.erofs -U", func(t *testing.T) {
+		skipIfNoMkfsErofs(t)
+
+		const want = "550e8400-e29b-41d4-a716-446655440000"
+		layerPath := filepath.Join(t.TempDir(), "layer.erofs")
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := ConvertTarErofs(ctx, createTestTar(t), layerPath, want, nil); err != nil {
+			t.Fatalf("ConvertTarErofs failed: %v", err)
+		}
+
+		got, err := GetUUID(layerPath)
+		if err != nil {
+			t.Fatalf("GetUUID failed: %v", err)
+		}
+		if got.String() != want {
+			t.Errorf("GetUUID() = %s, want %s", got, want)
+		}
+	})
+}
+
 // TestConvertErofsIntegration tests the actual conversion of a directory to EROFS.
 // This is an integration test that requires mkfs.erofs to be installed.
 func TestConvertErofsIntegration(t *testing.T) {
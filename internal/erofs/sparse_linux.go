@@ -0,0 +1,63 @@
+package erofs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// errSparseFound stops the directory walk at the first sparse file.
+var errSparseFound = errors.New("sparse file found")
+
+// hasSparseFiles reports whether any regular file under dir contains a hole,
+// as reported by SEEK_HOLE. Files on filesystems without hole reporting
+// appear dense and are ignored.
+func hasSparseFiles(dir string) (bool, error) {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		sparse, err := isSparse(path)
+		if err != nil {
+			return err
+		}
+		if sparse {
+			return errSparseFound
+		}
+		return nil
+	})
+	if errors.Is(err, errSparseFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// isSparse reports whether the regular file at path has a hole before its end.
+func isSparse(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if st.Size() == 0 {
+		return false, nil
+	}
+	// SEEK_HOLE returns the file size when the file has no holes; the
+	// implicit hole at EOF does not count.
+	hole, err := unix.Seek(int(f.Fd()), 0, unix.SEEK_HOLE)
+	if err != nil {
+		return false, err
+	}
+	return hole < st.Size(), nil
+}
@@ -0,0 +1,36 @@
+package erofs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHasSparseFiles(t *testing.T) {
+	dense := t.TempDir()
+	mustWrite(t, filepath.Join(dense, "sub", "file.txt"), "dense content")
+	if sparse, err := hasSparseFiles(dense); err != nil || sparse {
+		t.Errorf("dense dir: got sparse=%v err=%v, want false", sparse, err)
+	}
+
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "a.txt"), "dense")
+	writeSparseFile(t, filepath.Join(dir, "sub", "big.img"), 64<<20)
+	sparse, err := hasSparseFiles(dir)
+	if err != nil {
+		t.Fatalf("hasSparseFiles: %v", err)
+	}
+	if !sparse {
+		t.Skip("filesystem does not report holes")
+	}
+}
+
+// writeSparseFile creates a file of the given logical size holding a few
+// bytes at its start and a hole after them.
+func writeSparseFile(t *testing.T, path string, size int64) {
+	t.Helper()
+	mustWrite(t, path, "data")
+	if err := os.Truncate(path, size); err != nil {
+		t.Fatal(err)
+	}
+}
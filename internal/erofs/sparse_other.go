@@ -0,0 +1,8 @@
+//go:build !linux
+
+package erofs
+
+// hasSparseFiles always reports false on non-Linux platforms.
+func hasSparseFiles(dir string) (bool, error) {
+	return false, nil
+}
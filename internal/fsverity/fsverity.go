@@ -0,0 +1,101 @@
+// Package fsverity enables and measures fs-verity on layer blobs, and
+// computes fs-verity digests in userspace for files without it.
+package fsverity
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+)
+
+const (
+	// hashAlgSHA256 is FS_VERITY_HASH_ALG_SHA256 from <linux/fsverity.h>.
+	hashAlgSHA256 = 1
+
+	// descriptorSize is the size of struct fsverity_descriptor.
+	descriptorSize = 256
+)
+
+// MeasureFsverity returns the fs-verity digest of path as "sha256:<hex>",
+// the format printed by "fsverity measure". When fs-verity is enabled on
+// path the kernel's measurement is returned. Otherwise the digest is
+// computed in userspace with the page size as Merkle tree block size, which
+// matches what the kernel reports once Enable is called on the same file.
+func MeasureFsverity(path string) (string, error) {
+	if IsEnabled(path) {
+		return measureEnabled(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	d, err := Compute(f, os.Getpagesize())
+	if err != nil {
+		return "", fmt.Errorf("compute fs-verity digest of %s: %w", path, err)
+	}
+	return d, nil
+}
+
+// Compute returns the SHA-256 fs-verity digest of the data read from r,
+// without salt, for a Merkle tree of blockSize blocks. blockSize must be a
+// power of two between 1024 and 65536.
+func Compute(r io.Reader, blockSize int) (string, error) {
+	if blockSize < 1024 || blockSize > 65536 || blockSize&(blockSize-1) != 0 {
+		return "", fmt.Errorf("invalid fs-verity block size %d", blockSize)
+	}
+
+	// Hash the data blocks, zero-padding the last one.
+	var (
+		size  uint64
+		level []byte
+		buf   = make([]byte, blockSize)
+	)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			clear(buf[n:])
+			size += uint64(n)
+			h := sha256.Sum256(buf)
+			level = append(level, h[:]...)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Hash each level of the tree in blocks until one hash, the root, is
+	// left. An empty file has an all-zero root hash.
+	var root [sha256.Size]byte
+	if size > 0 {
+		for len(level) > sha256.Size {
+			var next []byte
+			for off := 0; off < len(level); off += blockSize {
+				clear(buf)
+				copy(buf, level[off:])
+				h := sha256.Sum256(buf)
+				next = append(next, h[:]...)
+			}
+			level = next
+		}
+		copy(root[:], level)
+	}
+
+	// The digest is the hash of struct fsverity_descriptor.
+	desc := make([]byte, descriptorSize)
+	desc[0] = 1 // version
+	desc[1] = hashAlgSHA256
+	desc[2] = byte(bits.TrailingZeros(uint(blockSize)))
+	binary.LittleEndian.PutUint64(desc[8:16], size)
+	copy(desc[16:], root[:])
+	d := sha256.Sum256(desc)
+	return "sha256:" + hex.EncodeToString(d[:]), nil
+}
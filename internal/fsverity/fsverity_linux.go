@@ -0,0 +1,68 @@
+package fsverity
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// IsEnabled reports whether fs-verity is enabled on path.
+func IsEnabled(path string) bool {
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, 0, 0, &stx); err != nil {
+		return false
+	}
+	return stx.Attributes&unix.STATX_ATTR_VERITY != 0
+}
+
+// Enable enables fs-verity with SHA-256 on path. The file must not be
+// open for writing.
+func Enable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	arg := unix.FsverityEnableArg{
+		Version:        1,
+		Hash_algorithm: unix.FS_VERITY_HASH_ALG_SHA256,
+		Block_size:     uint32(os.Getpagesize()),
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.FS_IOC_ENABLE_VERITY, uintptr(unsafe.Pointer(&arg))); errno != 0 {
+		return fmt.Errorf("enable fs-verity on %s: %w", path, errno)
+	}
+	return nil
+}
+
+// measureEnabled returns the kernel's fs-verity digest of path as
+// "<algorithm>:<hex>". fs-verity must be enabled on path.
+func measureEnabled(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var arg struct {
+		unix.FsverityDigest
+		Digest [64]byte
+	}
+	arg.Size = uint16(len(arg.Digest))
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.FS_IOC_MEASURE_VERITY, uintptr(unsafe.Pointer(&arg))); errno != 0 {
+		return "", fmt.Errorf("measure fs-verity of %s: %w", path, errno)
+	}
+	var algo string
+	switch arg.Algorithm {
+	case unix.FS_VERITY_HASH_ALG_SHA256:
+		algo = "sha256"
+	case unix.FS_VERITY_HASH_ALG_SHA512:
+		algo = "sha512"
+	default:
+		return "", fmt.Errorf("measure fs-verity of %s: unknown hash algorithm %d", path, arg.Algorithm)
+	}
+	return algo + ":" + hex.EncodeToString(arg.Digest[:arg.Size]), nil
+}
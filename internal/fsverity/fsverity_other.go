@@ -0,0 +1,19 @@
+//go:build !linux
+
+package fsverity
+
+import "github.com/containerd/errdefs"
+
+// IsEnabled reports whether fs-verity is enabled on path.
+func IsEnabled(path string) bool {
+	return false
+}
+
+// Enable enables fs-verity with SHA-256 on path.
+func Enable(path string) error {
+	return errdefs.ErrNotImplemented
+}
+
+func measureEnabled(path string) (string, error) {
+	return "", errdefs.ErrNotImplemented
+}
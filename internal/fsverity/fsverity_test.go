@@ -0,0 +1,106 @@
+package fsverity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	// Import testutil to register the -test.root flag
+	_ "github.com/spin-stack/erofs-snapshotter/internal/testutil"
+)
+
+// emptyFileDigest is the SHA-256 fs-verity digest "fsverity digest"
+// prints for an empty file with 4096-byte blocks.
+const emptyFileDigest = "sha256:3d248ca542a24fc62d1c43b916eae5016878e2533c88238480b26128a1f1af95"
+
+// descriptorDigest hashes a version 1 SHA-256 fsverity_descriptor for a
+// file of size bytes with the given root hash and 4096-byte blocks.
+func descriptorDigest(size uint64, root []byte) string {
+	var desc [256]byte
+	desc[0], desc[1], desc[2] = 1, 1, 12
+	binary.LittleEndian.PutUint64(desc[8:], size)
+	copy(desc[16:], root)
+	d := sha256.Sum256(desc[:])
+	return "sha256:" + hex.EncodeToString(d[:])
+}
+
+// blockHash returns the SHA-256 of b zero-padded to 4096 bytes.
+func blockHash(b []byte) []byte {
+	block := make([]byte, 4096)
+	copy(block, b)
+	h := sha256.Sum256(block)
+	return h[:]
+}
+
+func TestCompute(t *testing.T) {
+	oneBlock := bytes.Repeat([]byte("a"), 100)
+	twoBlocks := bytes.Repeat([]byte("b"), 4097)
+	// 129 data blocks need two hash blocks at the first level, so the tree
+	// has two levels above the data.
+	deep := bytes.Repeat([]byte("c"), 128*4096+1)
+
+	level1 := func(data []byte) []byte {
+		var hashes []byte
+		for off := 0; off < len(data); off += 4096 {
+			hashes = append(hashes, blockHash(data[off:min(off+4096, len(data))])...)
+		}
+		return hashes
+	}
+	deepLevel1 := level1(deep)
+	deepRoot := blockHash(append(blockHash(deepLevel1[:4096]), blockHash(deepLevel1[4096:])...))
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, emptyFileDigest},
+		{"one block", oneBlock, descriptorDigest(100, blockHash(oneBlock))},
+		{"two blocks", twoBlocks, descriptorDigest(4097, blockHash(level1(twoBlocks)))},
+		{"two levels", deep, descriptorDigest(uint64(len(deep)), deepRoot)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Compute(bytes.NewReader(tc.data), 4096)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("Compute = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestComputeInvalidBlockSize(t *testing.T) {
+	for _, bs := range []int{0, 512, 3000, 1 << 17} {
+		if _, err := Compute(bytes.NewReader(nil), bs); err == nil {
+			t.Errorf("expected block size %d to be rejected", bs)
+		}
+	}
+}
+
+func TestMeasureFsverityMatchesKernel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "layer.erofs")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("layer"), 3000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	computed, err := MeasureFsverity(path)
+	if err != nil {
+		t.Fatalf("MeasureFsverity: %v", err)
+	}
+	if err := Enable(path); err != nil {
+		t.Skipf("fs-verity not supported: %v", err)
+	}
+	measured, err := MeasureFsverity(path)
+	if err != nil {
+		t.Fatalf("MeasureFsverity with fs-verity: %v", err)
+	}
+	if computed != measured {
+		t.Errorf("userspace digest %s, kernel digest %s", computed, measured)
+	}
+}
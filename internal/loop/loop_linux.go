@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -24,7 +25,9 @@ const (
 const loopDevicePrefix = "loop"
 
 // Setup creates and configures a loop device for the given backing file.
-// Returns the loop device path (e.g., "/dev/loop0").
+// Returns the loop device path (e.g., "/dev/loop0"). Devices are acquired
+// one at a time (see acquireDevice); concurrent calls only overlap in
+// opening the backing file and setting the device status and serial.
 func Setup(backingFile string, cfg Config) (*Device, error) {
 	// Open the backing file
 	flags := unix.O_CLOEXEC
@@ -46,41 +49,9 @@ func Setup(backingFile string, cfg Config) (*Device, error) {
 	}
 	defer unix.Close(ctlFd)
 
-	// Retry loop for acquiring a free device (handles race with recently released devices)
-	const maxRetries = 5
-	var loopPath string
-	var loopFd int
-	var devNum uintptr
-
-	for attempt := range maxRetries {
-		var errno unix.Errno
-		devNum, _, errno = unix.Syscall(unix.SYS_IOCTL, uintptr(ctlFd), loopCtlGetFree, 0)
-		if errno != 0 {
-			return nil, fmt.Errorf("LOOP_CTL_GET_FREE failed: %w", errno)
-		}
-
-		loopPath = fmt.Sprintf("/dev/loop%d", devNum)
-
-		// Open the loop device
-		loopFd, err = unix.Open(loopPath, unix.O_RDWR|unix.O_CLOEXEC, 0)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open loop device %s: %w", loopPath, err)
-		}
-
-		// Associate the loop device with the backing file
-		_, _, errno = unix.Syscall(unix.SYS_IOCTL, uintptr(loopFd), loopSetFd, uintptr(backingFd))
-		if errno == 0 {
-			break // Success
-		}
-
-		unix.Close(loopFd)
-
-		if errno == unix.EBUSY && attempt < maxRetries-1 {
-			// Device was grabbed by another process, try again
-			continue
-		}
-
-		return nil, fmt.Errorf("LOOP_SET_FD failed for %s: %w", loopPath, errno)
+	loopPath, loopFd, devNum, err := acquireDevice(ctlFd, backingFd)
+	if err != nil {
+		return nil, err
 	}
 	defer unix.Close(loopFd)
 
@@ -121,6 +92,51 @@ func Setup(backingFile string, cfg Config) (*Device, error) {
 	return dev, nil
 }
 
+// acquireMu serializes acquireDevice within the process.
+var acquireMu sync.Mutex
+
+// acquireDevice finds a free loop device with LOOP_CTL_GET_FREE and binds
+// backingFd to it with LOOP_SET_FD. Both steps run under acquireMu, so that
+// concurrent Setup calls do not all pick the same free device and exhaust
+// their retries on EBUSY. Other processes can still race; that is what the
+// retries are for.
+func acquireDevice(ctlFd, backingFd int) (string, int, uintptr, error) {
+	acquireMu.Lock()
+	defer acquireMu.Unlock()
+
+	// Retry loop for acquiring a free device (handles race with recently released devices)
+	const maxRetries = 5
+	for attempt := 0; ; attempt++ {
+		devNum, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(ctlFd), loopCtlGetFree, 0)
+		if errno != 0 {
+			return "", 0, 0, fmt.Errorf("LOOP_CTL_GET_FREE failed: %w", errno)
+		}
+
+		loopPath := fmt.Sprintf("/dev/loop%d", devNum)
+
+		// Open the loop device
+		loopFd, err := unix.Open(loopPath, unix.O_RDWR|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("failed to open loop device %s: %w", loopPath, err)
+		}
+
+		// Associate the loop device with the backing file
+		_, _, errno = unix.Syscall(unix.SYS_IOCTL, uintptr(loopFd), loopSetFd, uintptr(backingFd))
+		if errno == 0 {
+			return loopPath, loopFd, devNum, nil
+		}
+
+		unix.Close(loopFd)
+
+		if errno == unix.EBUSY && attempt < maxRetries-1 {
+			// Device was grabbed by another process, try again
+			continue
+		}
+
+		return "", 0, 0, fmt.Errorf("LOOP_SET_FD failed for %s: %w", loopPath, errno)
+	}
+}
+
 // SetSerial sets the serial number on a loop device via sysfs.
 // Requires Linux 5.17+ where /sys/block/loopN/loop/serial is writable.
 // Returns an error if the sysfs attribute doesn't exist or isn't writable.
@@ -210,6 +226,16 @@ func DetachPath(loopPath string) error {
 // FindByBackingFile finds a loop device associated with the given backing file.
 // Returns nil if no loop device is found.
 func FindByBackingFile(backingFile string) (*Device, error) {
+	devices, err := FindAllByBackingFile(backingFile)
+	if err != nil || len(devices) == 0 {
+		return nil, err
+	}
+	return devices[0], nil
+}
+
+// FindAllByBackingFile finds every loop device associated with the given
+// backing file, for example when a file is loop mounted more than once.
+func FindAllByBackingFile(backingFile string) ([]*Device, error) {
 	// Get absolute path for comparison
 	absPath, err := filepath.Abs(backingFile)
 	if err != nil {
@@ -222,6 +248,7 @@ func FindByBackingFile(backingFile string) (*Device, error) {
 		return nil, fmt.Errorf("failed to read /sys/block: %w", err)
 	}
 
+	var devices []*Device
 	for _, entry := range entries {
 		name := entry.Name()
 		if len(name) < len(loopDevicePrefix) || name[:len(loopDevicePrefix)] != loopDevicePrefix {
@@ -241,14 +268,63 @@ func FindByBackingFile(backingFile string) (*Device, error) {
 		if sysfsBackingFile == absPath || sysfsBackingFile == backingFile {
 			var devNum int
 			_, _ = fmt.Sscanf(name, "loop%d", &devNum)
-			return &Device{
+			devices = append(devices, &Device{
 				Path:   "/dev/" + name,
 				Number: devNum,
-			}, nil
+			})
 		}
 	}
 
-	return nil, nil
+	return devices, nil
+}
+
+// FindByBackingDir finds all loop devices whose backing file is inside dir.
+// Returns an empty slice if no devices are found.
+func FindByBackingDir(dir string) ([]*Device, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		absDir = dir
+	}
+	prefix := strings.TrimSuffix(absDir, "/") + "/"
+
+	entries, err := os.ReadDir("/sys/block")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /sys/block: %w", err)
+	}
+
+	var devices []*Device
+	for _, entry := range entries {
+		name := entry.Name()
+		if len(name) < len(loopDevicePrefix) || name[:len(loopDevicePrefix)] != loopDevicePrefix {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join("/sys/block", name, "loop", "backing_file"))
+		if err != nil {
+			continue // Device may not be configured
+		}
+
+		if strings.HasPrefix(strings.TrimSuffix(string(data), "\n"), prefix) {
+			var devNum int
+			_, _ = fmt.Sscanf(name, "loop%d", &devNum)
+			devices = append(devices, &Device{
+				Path:   "/dev/" + name,
+				Number: devNum,
+			})
+		}
+	}
+
+	return devices, nil
+}
+
+// DirectIO reports whether the loop device reads its backing file with
+// direct I/O, bypassing the host page cache.
+func (d *Device) DirectIO() (bool, error) {
+	data, err := os.ReadFile(filepath.Join("/sys/block", filepath.Base(d.Path), "loop", "dio"))
+	if err != nil {
+		return false, fmt.Errorf("failed to read direct I/O state of %s: %w", d.Path, err)
+	}
+	return strings.TrimSpace(string(data)) == "1", nil
 }
 
 // FindBySerial finds a loop device with the given serial number.
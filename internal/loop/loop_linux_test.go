@@ -302,6 +302,51 @@ func TestFindByBackingFile(t *testing.T) {
 	}
 }
 
+func TestFindAllByBackingFile(t *testing.T) {
+	testutil.RequiresRoot(t)
+
+	backingFile := filepath.Join(t.TempDir(), "backing.img")
+	if err := os.WriteFile(backingFile, make([]byte, 1024*1024), 0o644); err != nil {
+		t.Fatalf("failed to create backing file: %v", err)
+	}
+
+	want := map[string]bool{}
+	for i := range 2 {
+		dev, err := Setup(backingFile, Config{
+			ReadOnly: true,
+			Serial:   fmt.Sprintf("erofs-test-find-all-%d", i),
+		})
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer dev.Detach()
+		want[dev.Path] = true
+	}
+
+	found, err := FindAllByBackingFile(backingFile)
+	if err != nil {
+		t.Fatalf("FindAllByBackingFile failed: %v", err)
+	}
+	if len(found) != len(want) {
+		t.Fatalf("found %d devices, want %d", len(found), len(want))
+	}
+	inDir, err := FindByBackingDir(filepath.Dir(backingFile))
+	if err != nil {
+		t.Fatalf("FindByBackingDir failed: %v", err)
+	}
+	if len(inDir) != len(want) {
+		t.Errorf("found %d devices in backing dir, want %d", len(inDir), len(want))
+	}
+	for _, dev := range found {
+		if !want[dev.Path] {
+			t.Errorf("unexpected device %s", dev.Path)
+		}
+		if dio, err := dev.DirectIO(); err != nil || dio {
+			t.Errorf("DirectIO(%s) = %v, %v; want false", dev.Path, dio, err)
+		}
+	}
+}
+
 func TestFindBySerial(t *testing.T) {
 	testutil.RequiresRoot(t)
 
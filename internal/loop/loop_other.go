@@ -40,6 +40,21 @@ func FindByBackingFile(backingFile string) (*Device, error) {
 	return nil, errdefs.ErrNotImplemented
 }
 
+// FindAllByBackingFile finds every loop device associated with the given backing file.
+func FindAllByBackingFile(backingFile string) ([]*Device, error) {
+	return nil, errdefs.ErrNotImplemented
+}
+
+// FindByBackingDir finds all loop devices whose backing file is inside dir.
+func FindByBackingDir(dir string) ([]*Device, error) {
+	return nil, errdefs.ErrNotImplemented
+}
+
+// DirectIO reports whether the loop device uses direct I/O.
+func (d *Device) DirectIO() (bool, error) {
+	return false, errdefs.ErrNotImplemented
+}
+
 // FindBySerial finds a loop device with the given serial number.
 func FindBySerial(serial string) (*Device, error) {
 	return nil, errdefs.ErrNotImplemented
@@ -24,6 +24,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/containerd/containerd/v2/core/mount"
@@ -38,9 +39,15 @@ import (
 // - Loop devices must be set up for both the main fsmeta and each blob
 // - The mount options must be rewritten to use loop device paths
 //
+// Loop devices for the device= blobs are set up in parallel, bounded by
+// WithLoopConcurrency (DefaultLoopConcurrency by default), so images with
+// many layers don't pay for each setup serially.
+//
 // Returns a cleanup function that must be called to release resources (loop devices).
 // The cleanup function is always non-nil, even on error.
-func MountAll(mounts []mount.Mount, target string) (cleanup func() error, err error) {
+func MountAll(mounts []mount.Mount, target string, opts ...MountOpt) (cleanup func() error, err error) {
+	config := newMountConfig(opts)
+
 	// Find EROFS mounts with device= options
 	erofsIdx := -1
 	for i, m := range mounts {
@@ -97,13 +104,13 @@ func MountAll(mounts []mount.Mount, target string) (cleanup func() error, err er
 	loopDevices = append(loopDevices, mainDev)
 
 	// Set up loop devices for each device= blob
+	blobDevs, err := setupLoopDevices(devices, config.loopConcurrency)
+	if err != nil {
+		return cleanupLoops, err
+	}
+	loopDevices = append(loopDevices, blobDevs...)
 	var deviceOpts []string
-	for _, dev := range devices {
-		loopDev, err := loop.Setup(dev, loop.Config{ReadOnly: true})
-		if err != nil {
-			return cleanupLoops, fmt.Errorf("failed to setup loop device for %s: %w", dev, err)
-		}
-		loopDevices = append(loopDevices, loopDev)
+	for _, loopDev := range blobDevs {
 		deviceOpts = append(deviceOpts, fmt.Sprintf("device=%s", loopDev.Path))
 	}
 
@@ -126,6 +133,44 @@ func MountAll(mounts []mount.Mount, target string) (cleanup func() error, err er
 	}, nil
 }
 
+// setupLoopDevices attaches a read-only loop device to each path using at
+// most concurrency workers. loop.Setup acquires devices one at a time, so
+// the workers only overlap in the steps before and after that. Devices are
+// returned in the order of paths. On failure, every device that was
+// attached is detached again.
+func setupLoopDevices(paths []string, concurrency int) ([]*loop.Device, error) {
+	devs := make([]*loop.Device, len(paths))
+	errs := make([]error, len(paths))
+
+	sem := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for i, p := range paths {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			dev, err := loop.Setup(p, loop.Config{ReadOnly: true})
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to setup loop device for %s: %w", p, err)
+				return
+			}
+			devs[i] = dev
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		for _, dev := range devs {
+			if dev != nil {
+				_ = dev.Detach()
+			}
+		}
+		return nil, err
+	}
+	return devs, nil
+}
+
 // MountExt4 mounts an ext4 filesystem image to the target directory using a loop device.
 // Returns a cleanup function that unmounts and detaches the loop device.
 //
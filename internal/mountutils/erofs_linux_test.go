@@ -0,0 +1,25 @@
+package mountutils
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetupLoopDevicesFailure(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "missing-1.erofs"), filepath.Join(dir, "missing-2.erofs")}
+
+	devs, err := setupLoopDevices(paths, 2)
+	if err == nil {
+		t.Fatal("expected error for missing blobs")
+	}
+	if devs != nil {
+		t.Errorf("expected no devices on failure, got %v", devs)
+	}
+	for _, p := range paths {
+		if !strings.Contains(err.Error(), p) {
+			t.Errorf("error should mention %s: %v", p, err)
+		}
+	}
+}
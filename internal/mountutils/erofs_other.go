@@ -27,7 +27,7 @@ import (
 
 // MountAll mounts all provided mounts to the target directory.
 // On non-Linux platforms, EROFS mounts are not supported.
-func MountAll(_ []mount.Mount, _ string) (cleanup func() error, err error) {
+func MountAll(_ []mount.Mount, _ string, _ ...MountOpt) (cleanup func() error, err error) {
 	return func() error { return nil }, fmt.Errorf("EROFS mounts not supported on %s", runtime.GOOS)
 }
 
@@ -31,6 +31,43 @@ import (
 // fsTypeErofs is the filesystem type string for EROFS mounts.
 const fsTypeErofs = "erofs"
 
+// DefaultLoopConcurrency is the default number of loop devices MountAll sets
+// up in parallel for an EROFS multi-device mount.
+const DefaultLoopConcurrency = 4
+
+// MaxLoopConcurrency caps the loop device setup concurrency. Devices are
+// acquired one at a time anyway (see loop.Setup), so more workers only add
+// contention.
+const MaxLoopConcurrency = 16
+
+// MountOpt configures MountAll.
+type MountOpt func(*mountConfig)
+
+type mountConfig struct {
+	loopConcurrency int
+}
+
+// WithLoopConcurrency bounds how many loop devices MountAll sets up in
+// parallel. It is clamped to [1, MaxLoopConcurrency].
+func WithLoopConcurrency(n int) MountOpt {
+	return func(c *mountConfig) {
+		c.loopConcurrency = ClampLoopConcurrency(n)
+	}
+}
+
+// ClampLoopConcurrency limits n to [1, MaxLoopConcurrency].
+func ClampLoopConcurrency(n int) int {
+	return min(max(n, 1), MaxLoopConcurrency)
+}
+
+func newMountConfig(opts []MountOpt) mountConfig {
+	c := mountConfig{loopConcurrency: DefaultLoopConcurrency}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
 // NeedsMountManager returns true if any mount requires the mount manager to resolve.
 // This includes mounts with template syntax (e.g., "{{ mount 0 }}"), formatted mounts
 // (format/, mkfs/, mkdir/), and mounts with loop options (which require loop device setup).
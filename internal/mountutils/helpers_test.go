@@ -432,3 +432,18 @@ func TestHasActiveSnapshotMounts(t *testing.T) {
 		})
 	}
 }
+
+func TestMountConfig(t *testing.T) {
+	if c := newMountConfig(nil); c.loopConcurrency != DefaultLoopConcurrency {
+		t.Errorf("default loopConcurrency = %d, want %d", c.loopConcurrency, DefaultLoopConcurrency)
+	}
+	if c := newMountConfig([]MountOpt{WithLoopConcurrency(16)}); c.loopConcurrency != 16 {
+		t.Errorf("loopConcurrency = %d, want 16", c.loopConcurrency)
+	}
+	if c := newMountConfig([]MountOpt{WithLoopConcurrency(0)}); c.loopConcurrency != 1 {
+		t.Errorf("loopConcurrency for 0 = %d, want 1", c.loopConcurrency)
+	}
+	if c := newMountConfig([]MountOpt{WithLoopConcurrency(1000)}); c.loopConcurrency != MaxLoopConcurrency {
+		t.Errorf("loopConcurrency for 1000 = %d, want %d", c.loopConcurrency, MaxLoopConcurrency)
+	}
+}
@@ -0,0 +1,76 @@
+package preflight
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kernel features with distinct minimum version requirements.
+const (
+	// FeatureErofs is baseline EROFS support required by the snapshotter.
+	FeatureErofs = "erofs"
+	// FeatureFsverity is fs-verity support.
+	FeatureFsverity = "fsverity"
+	// FeatureLoopDiscard is discard (hole punching) support on loop devices.
+	FeatureLoopDiscard = "loop-discard"
+)
+
+// FeatureRequirements maps each known feature to its minimum kernel version.
+var FeatureRequirements = map[string]string{
+	FeatureErofs:       MinKernelVersion,
+	FeatureFsverity:    "5.4",
+	FeatureLoopDiscard: "3.2",
+}
+
+// UnsupportedFeaturesError lists features whose minimum kernel version is
+// not met by the running kernel.
+type UnsupportedFeaturesError struct {
+	Kernel   string
+	Features []string
+}
+
+func (e *UnsupportedFeaturesError) Error() string {
+	reqs := make([]string, 0, len(e.Features))
+	for _, f := range e.Features {
+		reqs = append(reqs, fmt.Sprintf("%s (requires %s)", f, FeatureRequirements[f]))
+	}
+	return fmt.Sprintf("kernel %s does not support: %s", e.Kernel, strings.Join(reqs, ", "))
+}
+
+// CheckFeatures checks that the running kernel meets the minimum version of
+// each requested feature. Returns *UnsupportedFeaturesError listing every
+// feature that is not supported, or an error for unknown feature names.
+func CheckFeatures(features ...string) error {
+	if len(features) == 0 {
+		return nil
+	}
+	current, err := KernelVersion()
+	if err != nil {
+		return err
+	}
+	return checkFeatures(current, features)
+}
+
+// checkFeatures validates features against the given kernel version.
+func checkFeatures(kernel string, features []string) error {
+	var unsupported []string
+	for _, f := range features {
+		minVersion, ok := FeatureRequirements[f]
+		if !ok {
+			return fmt.Errorf("unknown feature %q", f)
+		}
+		cmp, err := CompareVersions(kernel, minVersion)
+		if err != nil {
+			return fmt.Errorf("failed to compare versions for %s: %w", f, err)
+		}
+		if cmp < 0 {
+			unsupported = append(unsupported, f)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return &UnsupportedFeaturesError{Kernel: kernel, Features: unsupported}
+	}
+	return nil
+}
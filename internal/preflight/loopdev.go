@@ -0,0 +1,23 @@
+package preflight
+
+import "fmt"
+
+// DefaultMinFreeLoopDevices is the loop device headroom Check warns about.
+// Each mounted layer and writable layer on the host holds one device.
+const DefaultMinFreeLoopDevices = 16
+
+// LoopDeviceHeadroomError indicates that fewer loop devices are free than
+// required. Raise the limit with the loop module's max_loop parameter
+// (loop.max_loop=N on the kernel command line, or max_loop=0 to allocate
+// devices on demand).
+type LoopDeviceHeadroomError struct {
+	// Max is the loop module's max_loop parameter.
+	Max  int
+	Used int
+	Min  int
+}
+
+func (e *LoopDeviceHeadroomError) Error() string {
+	return fmt.Sprintf("%d of %d loop devices in use, fewer than %d free: raise loop.max_loop",
+		e.Used, e.Max, e.Min)
+}
@@ -3,13 +3,18 @@ package preflight
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 
+	"github.com/containerd/log"
 	"golang.org/x/sys/unix"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/stringutil"
 )
 
 // MinKernelVersion is the minimum required kernel version.
@@ -17,6 +22,8 @@ const MinKernelVersion = "6.10"
 
 // Check runs all preflight checks and returns an error if any fail.
 // This should be called early in main() to fail fast.
+//
+// Loop device headroom is advisory: a shortage is logged, not returned.
 func Check() error {
 	if err := CheckKernelVersion(MinKernelVersion); err != nil {
 		return err
@@ -24,9 +31,30 @@ func Check() error {
 	if err := CheckErofsSupport(); err != nil {
 		return err
 	}
+	checkLoopHeadroom()
 	return nil
 }
 
+// CheckKernel is like Check but does not require mkfs.erofs, for callers
+// that build EROFS images without it.
+func CheckKernel() error {
+	if err := CheckKernelVersion(MinKernelVersion); err != nil {
+		return err
+	}
+	if err := CheckErofsFilesystem(); err != nil {
+		return err
+	}
+	checkLoopHeadroom()
+	return nil
+}
+
+// checkLoopHeadroom logs a warning if few loop devices are left.
+func checkLoopHeadroom() {
+	if err := CheckLoopDevices(DefaultMinFreeLoopDevices); err != nil {
+		log.L.WithError(err).Warn("loop device headroom is low")
+	}
+}
+
 // KernelVersion returns the current kernel version as a string (e.g., "6.16.0").
 func KernelVersion() (string, error) {
 	var uname unix.Utsname
@@ -146,12 +174,86 @@ func CheckErofsSupport() error {
 	if _, err := exec.LookPath("mkfs.erofs"); err != nil {
 		return fmt.Errorf("mkfs.erofs not found in PATH, please install erofs-utils")
 	}
+	return CheckErofsFilesystem()
+}
+
+// CheckErofsFilesystem checks that the kernel supports EROFS, without
+// requiring mkfs.erofs.
+func CheckErofsFilesystem() error {
 	if !isErofsRegistered() {
 		return fmt.Errorf("EROFS filesystem not available, please run: modprobe erofs")
 	}
 	return nil
 }
 
+// LoadErofsModule attempts to load the EROFS kernel module with modprobe and
+// re-checks /proc/filesystems. It is a no-op if EROFS is already registered.
+// Returns an error if modprobe is unavailable, fails, or EROFS is still not
+// registered afterwards.
+func LoadErofsModule() error {
+	if isErofsRegistered() {
+		return nil
+	}
+	modprobe, err := exec.LookPath("modprobe")
+	if err != nil {
+		return fmt.Errorf("modprobe not found in PATH: %w", err)
+	}
+	if out, err := exec.Command(modprobe, "erofs").CombinedOutput(); err != nil {
+		return fmt.Errorf("modprobe erofs failed: %w: %s", err, stringutil.TruncateOutput(out, 256))
+	}
+	if !isErofsRegistered() {
+		return fmt.Errorf("EROFS filesystem still not available after modprobe erofs")
+	}
+	return nil
+}
+
+// CheckLoopDevices checks that at least minFree loop devices are free,
+// comparing the loop module's max_loop parameter with the devices that have
+// a backing file. Returns *LoopDeviceHeadroomError if there are fewer. A
+// max_loop of 0 means devices are allocated on demand and always passes.
+func CheckLoopDevices(minFree int) error {
+	return checkLoopDevices("/sys", minFree)
+}
+
+// checkLoopDevices implements CheckLoopDevices against the sysfs tree at
+// sysfs.
+func checkLoopDevices(sysfs string, minFree int) error {
+	data, err := os.ReadFile(filepath.Join(sysfs, "module", "loop", "parameters", "max_loop"))
+	if errors.Is(err, os.ErrNotExist) {
+		// Loop is not loaded as a module (or not at all): no limit to read.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read loop max_loop: %w", err)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("parse loop max_loop %q: %w", data, err)
+	}
+	if limit == 0 {
+		// Devices are created on demand through /dev/loop-control.
+		return nil
+	}
+
+	used, err := usedLoopDevices(sysfs)
+	if err != nil {
+		return err
+	}
+	if limit-used < minFree {
+		return &LoopDeviceHeadroomError{Max: limit, Used: used, Min: minFree}
+	}
+	return nil
+}
+
+// usedLoopDevices counts loop devices with a backing file attached.
+func usedLoopDevices(sysfs string) (int, error) {
+	devs, err := filepath.Glob(filepath.Join(sysfs, "block", "loop*", "loop", "backing_file"))
+	if err != nil {
+		return 0, fmt.Errorf("list loop devices: %w", err)
+	}
+	return len(devs), nil
+}
+
 // isErofsRegistered checks if EROFS is registered in /proc/filesystems.
 func isErofsRegistered() bool {
 	data, err := os.ReadFile("/proc/filesystems")
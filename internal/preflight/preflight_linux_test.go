@@ -1,7 +1,10 @@
 package preflight
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -107,6 +110,49 @@ func TestCheckKernelVersion(t *testing.T) {
 	}
 }
 
+func TestFeatureRequirementsParse(t *testing.T) {
+	for feature, version := range FeatureRequirements {
+		if _, _, _, err := parseVersion(version); err != nil {
+			t.Errorf("feature %s has invalid minimum version %q: %v", feature, version, err)
+		}
+	}
+}
+
+func TestCheckFeatures(t *testing.T) {
+	t.Run("all supported", func(t *testing.T) {
+		if err := checkFeatures("99.0.0", []string{FeatureErofs, FeatureFsverity}); err != nil {
+			t.Errorf("expected all features supported, got %v", err)
+		}
+	})
+
+	t.Run("reports unsupported features", func(t *testing.T) {
+		err := checkFeatures("5.0.0", []string{FeatureFsverity, FeatureErofs, FeatureLoopDiscard})
+		var unsupported *UnsupportedFeaturesError
+		if !errors.As(err, &unsupported) {
+			t.Fatalf("expected UnsupportedFeaturesError, got %T: %v", err, err)
+		}
+		want := []string{FeatureErofs, FeatureFsverity}
+		if strings.Join(unsupported.Features, ",") != strings.Join(want, ",") {
+			t.Errorf("unsupported = %v, want %v", unsupported.Features, want)
+		}
+		if unsupported.Kernel != "5.0.0" {
+			t.Errorf("kernel = %q, want 5.0.0", unsupported.Kernel)
+		}
+	})
+
+	t.Run("unknown feature", func(t *testing.T) {
+		if err := checkFeatures("6.16.0", []string{"no-such-feature"}); err == nil {
+			t.Error("expected error for unknown feature")
+		}
+	})
+
+	t.Run("no features", func(t *testing.T) {
+		if err := CheckFeatures(); err != nil {
+			t.Errorf("expected nil for no features, got %v", err)
+		}
+	})
+}
+
 func TestCheckErofsSupport(t *testing.T) {
 	err := CheckErofsSupport()
 	if err != nil {
@@ -116,6 +162,16 @@ func TestCheckErofsSupport(t *testing.T) {
 	t.Log("EROFS is available")
 }
 
+func TestLoadErofsModule(t *testing.T) {
+	if !isErofsRegistered() {
+		t.Skip("EROFS not registered; loading the module requires root")
+	}
+	// Already registered: must be a no-op that succeeds without modprobe.
+	if err := LoadErofsModule(); err != nil {
+		t.Errorf("LoadErofsModule should succeed when EROFS is registered: %v", err)
+	}
+}
+
 func TestCheck(t *testing.T) {
 	err := Check()
 	if err != nil {
@@ -125,3 +181,53 @@ func TestCheck(t *testing.T) {
 	}
 	t.Log("All preflight checks passed")
 }
+
+func TestCheckLoopDevices(t *testing.T) {
+	// fakeSysfs builds a sysfs tree with the given max_loop ("" to omit the
+	// loop module) and number of attached loop devices.
+	fakeSysfs := func(t *testing.T, maxLoop string, used int) string {
+		t.Helper()
+		root := t.TempDir()
+		if maxLoop != "" {
+			params := filepath.Join(root, "module", "loop", "parameters")
+			if err := os.MkdirAll(params, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(params, "max_loop"), []byte(maxLoop+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := range used + 2 {
+			dev := filepath.Join(root, "block", fmt.Sprintf("loop%d", i), "loop")
+			if err := os.MkdirAll(dev, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			// Two extra devices exist but are detached.
+			if i < used {
+				if err := os.WriteFile(filepath.Join(dev, "backing_file"), []byte("/layer.erofs\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return root
+	}
+
+	if err := checkLoopDevices(fakeSysfs(t, "", 8), 16); err != nil {
+		t.Errorf("no loop module: %v", err)
+	}
+	if err := checkLoopDevices(fakeSysfs(t, "0", 200), 16); err != nil {
+		t.Errorf("dynamic loop devices: %v", err)
+	}
+	if err := checkLoopDevices(fakeSysfs(t, "64", 8), 16); err != nil {
+		t.Errorf("enough headroom: %v", err)
+	}
+
+	err := checkLoopDevices(fakeSysfs(t, "16", 8), 16)
+	var herr *LoopDeviceHeadroomError
+	if !errors.As(err, &herr) {
+		t.Fatalf("expected LoopDeviceHeadroomError, got %v", err)
+	}
+	if herr.Max != 16 || herr.Used != 8 || herr.Min != 16 {
+		t.Errorf("unexpected headroom error %+v", herr)
+	}
+}
@@ -14,6 +14,12 @@ func Check() error {
 	return errdefs.ErrNotImplemented
 }
 
+// CheckKernel runs the preflight checks that do not need mkfs.erofs.
+// On non-Linux platforms, this returns ErrNotImplemented.
+func CheckKernel() error {
+	return errdefs.ErrNotImplemented
+}
+
 // KernelVersion returns the current kernel version.
 func KernelVersion() (string, error) {
 	return "", errdefs.ErrNotImplemented
@@ -33,3 +39,18 @@ func CheckKernelVersion(minVersion string) error {
 func CheckErofsSupport() error {
 	return errdefs.ErrNotImplemented
 }
+
+// CheckErofsFilesystem checks that the kernel supports EROFS.
+func CheckErofsFilesystem() error {
+	return errdefs.ErrNotImplemented
+}
+
+// LoadErofsModule attempts to load the EROFS kernel module.
+func LoadErofsModule() error {
+	return errdefs.ErrNotImplemented
+}
+
+// CheckLoopDevices checks that at least minFree loop devices are free.
+func CheckLoopDevices(minFree int) error {
+	return errdefs.ErrNotImplemented
+}
@@ -0,0 +1,86 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/fsverity"
+)
+
+// LayerRef describes one EROFS layer in a snapshot's parent chain.
+type LayerRef struct {
+	// ID is the committed snapshot ID of the layer.
+	ID string
+	// BlobPath is the resolved EROFS layer blob, or "" if none was found.
+	BlobPath string
+	// Exists reports whether BlobPath exists on disk.
+	Exists bool
+	// Size is the blob size in bytes when it exists.
+	Size int64
+	// Fsverity reports whether fs-verity is enabled on the blob.
+	Fsverity bool
+	// Merged reports whether mounts use a merged fsmeta.erofs covering this
+	// layer instead of mounting it individually.
+	Merged bool
+	// Err is why the blob could not be resolved, or nil. It wraps a
+	// *LayerBlobNotFoundError when the blob is missing.
+	Err error
+}
+
+// ChainResolver is implemented by snapshotters that can describe the layers
+// mounted for a snapshot.
+type ChainResolver interface {
+	Chain(ctx context.Context, key string) ([]LayerRef, error)
+}
+
+// Chain returns the parent layers of the active or view snapshot key,
+// oldest first, resolved the same way Mounts resolves them.
+func (s *snapshotter) Chain(ctx context.Context, key string) ([]LayerRef, error) {
+	var snap storage.Snapshot
+	var info snapshots.Info
+	var blobs layerBlobIndex
+	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		var err error
+		snap, err = storage.GetSnapshot(ctx, key)
+		if err != nil {
+			return fmt.Errorf("get snapshot %q: %w", key, err)
+		}
+
+		_, info, _, err = storage.GetInfo(ctx, key)
+		if err != nil {
+			return fmt.Errorf("get snapshot info: %w", err)
+		}
+		blobs, err = parentLayerBlobs(ctx, info)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	// Extract snapshots and single-parent views never use fsmeta.
+	_, merged := s.mountFsMeta(snap, blobs)
+	if isExtractSnapshot(info) || (snap.Kind == snapshots.KindView && len(snap.ParentIDs) == 1) {
+		merged = false
+	}
+
+	refs := make([]LayerRef, 0, len(snap.ParentIDs))
+	for i := len(snap.ParentIDs) - 1; i >= 0; i-- {
+		id := snap.ParentIDs[i]
+		ref := LayerRef{ID: id, Merged: merged}
+		if blob, err := s.lowerPath(id, blobs); err != nil {
+			ref.Err = fmt.Errorf("layer %s: %w", id, err)
+		} else {
+			ref.BlobPath = blob
+			if fi, err := os.Stat(blob); err == nil {
+				ref.Exists = true
+				ref.Size = fi.Size()
+				ref.Fsverity = fsverity.IsEnabled(blob)
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
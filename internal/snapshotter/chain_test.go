@@ -0,0 +1,91 @@
+package snapshotter
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+// commitMetadataLayer creates and commits a layer with a fallback blob of
+// the given content, returning the committed snapshot's ID.
+func commitMetadataLayer(t *testing.T, s *snapshotter, name, parent string, content []byte) string {
+	t.Helper()
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, name+"-active", parent)
+	if err := os.MkdirAll(s.upperPath(snap.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.fallbackLayerBlobPath(snap.ID), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(t.Context(), name, name+"-active"); err != nil {
+		t.Fatalf("commit %s: %v", name, err)
+	}
+	return snap.ID
+}
+
+func TestChain(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	base := commitMetadataLayer(t, s, "base", "", []byte("base"))
+	top := commitMetadataLayer(t, s, "top", "base", []byte("top layer"))
+	createMetadataSnapshot(t, s, snapshots.KindActive, "container", "top")
+
+	refs, err := s.Chain(t.Context(), "container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 {
+		t.Fatalf("expected 2 layers, got %+v", refs)
+	}
+	// Oldest first.
+	if refs[0].ID != base || refs[1].ID != top {
+		t.Errorf("chain IDs = [%s %s], want [%s %s]", refs[0].ID, refs[1].ID, base, top)
+	}
+	for _, ref := range refs {
+		if !ref.Exists || ref.BlobPath != s.fallbackLayerBlobPath(ref.ID) || ref.Merged {
+			t.Errorf("unexpected layer ref %+v", ref)
+		}
+	}
+	if refs[1].Size != int64(len("top layer")) {
+		t.Errorf("top layer size = %d, want %d", refs[1].Size, len("top layer"))
+	}
+
+	// A merged fsmeta under the immediate parent short-circuits the chain.
+	for _, p := range []string{s.fsMetaPath(top), s.vmdkPath(top)} {
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	refs, err = s.Chain(t.Context(), "container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range refs {
+		if !ref.Merged {
+			t.Errorf("expected layer %s to be merged", ref.ID)
+		}
+	}
+
+	// A missing blob is reported, not fatal.
+	if err := os.Remove(s.fallbackLayerBlobPath(base)); err != nil {
+		t.Fatal(err)
+	}
+	refs, err = s.Chain(t.Context(), "container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs[0].Exists || refs[0].BlobPath != "" {
+		t.Errorf("expected missing base blob, got %+v", refs[0])
+	}
+	var notFound *LayerBlobNotFoundError
+	if !errors.As(refs[0].Err, &notFound) {
+		t.Fatalf("expected LayerBlobNotFoundError, got %v", refs[0].Err)
+	}
+	if notFound.ExpectedPath != s.fallbackLayerBlobPath(base) {
+		t.Errorf("expected path = %q, want %q", notFound.ExpectedPath, s.fallbackLayerBlobPath(base))
+	}
+	if refs[1].Err != nil {
+		t.Errorf("unexpected error for top layer: %v", refs[1].Err)
+	}
+}
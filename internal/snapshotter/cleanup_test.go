@@ -0,0 +1,83 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+// createOrphans creates n snapshot directories unknown to the metadata
+// store, next to one that is.
+func createOrphans(t *testing.T, s *snapshotter, n int) []string {
+	t.Helper()
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	if err := os.MkdirAll(s.snapshotDir(snap.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var dirs []string
+	for i := range n {
+		dir := filepath.Join(s.snapshotsDir(), "orphan"+strconv.Itoa(i))
+		if err := os.MkdirAll(filepath.Join(dir, "fs"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+func TestCleanupProgressParallel(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.cleanupConcurrency = 4
+	dirs := createOrphans(t, s, 10)
+
+	n, err := s.CleanupProgress(t.Context())
+	if err != nil {
+		t.Fatalf("CleanupProgress: %v", err)
+	}
+	if n != len(dirs) {
+		t.Errorf("removed %d directories, want %d", n, len(dirs))
+	}
+	if _, err := os.Stat(s.snapshotDir("1")); err != nil {
+		t.Errorf("referenced snapshot directory removed: %v", err)
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s not removed: %v", dir, err)
+		}
+	}
+}
+
+func TestCleanupProgressCancelled(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	dirs := createOrphans(t, s, 3)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	n, err := s.CleanupProgress(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if n != 0 {
+		t.Errorf("removed %d directories after cancellation", n)
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("%s removed after cancellation: %v", dir, err)
+		}
+	}
+
+	// A later run picks up the remaining orphans.
+	if err := s.Cleanup(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s not removed on retry: %v", dir, err)
+		}
+	}
+}
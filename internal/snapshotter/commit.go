@@ -2,19 +2,27 @@ package snapshotter
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/containerd/containerd/v2/core/snapshots"
 	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/containerd/v2/pkg/tracing"
 	"github.com/containerd/continuity/fs"
+	"github.com/containerd/errdefs"
 	"github.com/containerd/log"
 	"github.com/opencontainers/go-digest"
 
 	"github.com/spin-stack/erofs-snapshotter/internal/erofs"
+	"github.com/spin-stack/erofs-snapshotter/internal/fsverity"
 )
 
 // getCommitUpperDir returns the upper directory path for EROFS conversion.
@@ -27,7 +35,12 @@ import (
 //
 // For block mode, the ext4 must already be mounted by Prepare() for extract snapshots.
 // If the block mount isn't available, falls back to overlay mode.
-func (s *snapshotter) getCommitUpperDir(id string) string {
+// Snapshots created with LabelBlockFallback always commit from fs/.
+func (s *snapshotter) getCommitUpperDir(id string, info snapshots.Info) string {
+	if info.Labels[LabelBlockFallback] == "true" {
+		return s.upperPath(id)
+	}
+
 	rwLayer := s.writablePath(id)
 
 	// Check if block layer exists (rwlayer.img)
@@ -54,11 +67,25 @@ func (s *snapshotter) getCommitUpperDir(id string) string {
 }
 
 // commitBlock handles the conversion of a writable layer to EROFS.
-// It determines the appropriate source (block or overlay) and performs conversion.
-func (s *snapshotter) commitBlock(ctx context.Context, layerBlob string, id string) error {
-	upperDir := s.getCommitUpperDir(id)
+// It determines the appropriate source (block or overlay) and performs conversion,
+// reporting progress to the CommitProgressFunc set by WithCommitProgress.
+// info is the snapshot being committed. compression, if set, is the
+// mkfs.erofs compressor to use.
+func (s *snapshotter) commitBlock(ctx context.Context, layerBlob, id string, info snapshots.Info, compression string) (err error) {
+	ctx, span := startSpan(ctx, "commitBlock", tracing.WithAttribute(attrSnapshotID, id))
+	defer func() { endSpan(span, err) }()
+
+	upperDir := s.getCommitUpperDir(id, info)
+
+	// Refuse to read an upper that something else may still be writing to.
+	if err := s.checkCommitSourceIdle(id, upperDir); err != nil {
+		return err
+	}
 
-	if err := convertDirToErofs(ctx, layerBlob, upperDir); err != nil {
+	stopProgress := watchCommitProgress(ctx, layerBlob, upperDir)
+	err = s.convertDirToErofs(ctx, layerBlob, upperDir, compression)
+	stopProgress(err == nil)
+	if err != nil {
 		return &CommitConversionError{
 			SnapshotID: id,
 			UpperDir:   upperDir,
@@ -74,7 +101,8 @@ func (s *snapshotter) commitBlock(ctx context.Context, layerBlob string, id stri
 //
 // CALLER CONTRACT: parentIDs must be in snapshot chain order (newest-first).
 // This is the order returned by containerd's snapshot storage. We convert to
-// OCI manifest order (oldest-first) internally for mkfs.erofs.
+// OCI manifest order (oldest-first) internally for mkfs.erofs. blobs holds
+// the blob paths recorded on the chain (see parentLayerBlobs) and may be nil.
 //
 // CONCURRENCY: Multiple goroutines may try to generate fsmeta for the same parent
 // chain. A lock file (O_EXCL) ensures only one wins. Others exit silently.
@@ -85,17 +113,23 @@ func (s *snapshotter) commitBlock(ctx context.Context, layerBlob string, id stri
 //
 // SILENT FAILURE: If fsmeta generation fails, callers fall back to individual
 // layer mounts. This is slightly slower but functionally correct.
-func (s *snapshotter) generateFsMeta(ctx context.Context, parentIDs []string) {
+func (s *snapshotter) generateFsMeta(ctx context.Context, parentIDs []string, blobIndex layerBlobIndex) {
 	if len(parentIDs) == 0 {
 		return
 	}
 
+	ctx, span := startSpan(ctx, "generateFsMeta",
+		tracing.WithAttribute(attrSnapshotID, parentIDs[0]),
+		tracing.WithAttribute(attrSnapshotParents, len(parentIDs)))
+	defer span.End()
+
 	t1 := time.Now()
 
 	// parentIDs[0] is the newest snapshot in chain order
 	newestID := parentIDs[0]
 	mergedMeta := s.fsMetaPath(newestID)
 	vmdkFile := s.vmdkPath(newestID)
+	devicesFile := s.fsMetaDevicesPath(newestID)
 	lockFile := mergedMeta + ".lock"
 
 	// Check if already generated (fast path)
@@ -120,9 +154,26 @@ func (s *snapshotter) generateFsMeta(ctx context.Context, parentIDs []string) {
 	// Always remove lock file when done
 	defer os.Remove(lockFile)
 
+	// Bound concurrent merges (see WithFsMetaConcurrency). The slot is taken
+	// after the lock, so duplicate requests for this chain still exit early.
+	if s.fsMetaSem != nil {
+		select {
+		case s.fsMetaSem <- struct{}{}:
+			defer func() { <-s.fsMetaSem }()
+		case <-ctx.Done():
+			log.G(ctx).WithError(ctx.Err()).WithField("layerCount", len(parentIDs)).
+				Debug("fsmeta generation skipped: cancelled while queued")
+			return
+		}
+	}
+
 	// Temporary file paths for atomic generation
 	tmpMeta := mergedMeta + ".tmp"
 	tmpVmdk := vmdkFile + ".tmp"
+	if scratchDir := s.scratchDirPath(); scratchDir != "" {
+		tmpMeta = filepath.Join(scratchDir, newestID+"-"+filepath.Base(tmpMeta))
+		tmpVmdk = filepath.Join(scratchDir, newestID+"-"+filepath.Base(tmpVmdk))
+	}
 
 	// Cleanup temp files on failure
 	success := false
@@ -130,6 +181,7 @@ func (s *snapshotter) generateFsMeta(ctx context.Context, parentIDs []string) {
 		if !success {
 			_ = os.Remove(tmpMeta)
 			_ = os.Remove(tmpVmdk)
+			_ = os.Remove(devicesFile)
 		}
 	}()
 
@@ -139,7 +191,7 @@ func (s *snapshotter) generateFsMeta(ctx context.Context, parentIDs []string) {
 	// Collect layer blob paths in OCI order (oldest-first)
 	var blobs []string
 	for _, snapID := range ociOrder {
-		blob, err := s.findLayerBlob(snapID)
+		blob, err := s.lowerPath(snapID, blobIndex)
 		if err != nil {
 			log.G(ctx).WithError(err).WithFields(log.Fields{
 				"snapshot":       snapID,
@@ -164,15 +216,20 @@ func (s *snapshotter) generateFsMeta(ctx context.Context, parentIDs []string) {
 	// Generate fsmeta and VMDK to temp files.
 	// mkfs.erofs embeds the fsmeta path in the VMDK, so we generate to temp
 	// and then fix up the VMDK paths before the final rename.
-	args := append([]string{"--quiet", "--vmdk-desc=" + tmpVmdk, tmpMeta}, blobs...)
-
-	cmd := exec.CommandContext(ctx, "mkfs.erofs", args...)
-	out, err := cmd.CombinedOutput()
+	var opts []string
+	if s.erofsBlockSize != 0 && s.blobsMatchBlockSize(blobs) {
+		// Validated by NewSnapshotter.
+		opt, _ := erofs.BlockSizeOpt(s.erofsBlockSize)
+		opts = []string{opt}
+	}
+	err = s.withMkfsTimeout(ctx, "mkfs.erofs", tmpMeta, func(ctx context.Context) error {
+		return s.fsMetaMerger().MergeFsMeta(ctx, tmpMeta, tmpVmdk, blobs, opts)
+	})
 	if err != nil {
+		span.SetStatus(err)
 		log.G(ctx).WithError(err).WithFields(log.Fields{
 			"layerCount": len(blobs),
 			"stage":      "mkfs_erofs",
-			"output":     string(out),
 		}).Warn("fsmeta generation failed: mkfs.erofs error")
 		return
 	}
@@ -180,6 +237,7 @@ func (s *snapshotter) generateFsMeta(ctx context.Context, parentIDs []string) {
 	// Fix VMDK to reference final fsmeta path instead of temp path.
 	// The VMDK is a simple text file with embedded paths.
 	if err := fixVmdkPaths(tmpVmdk, tmpMeta, mergedMeta); err != nil {
+		span.SetStatus(err)
 		log.G(ctx).WithError(err).WithFields(log.Fields{
 			"layerCount": len(blobs),
 			"stage":      "fix_vmdk_paths",
@@ -187,8 +245,20 @@ func (s *snapshotter) generateFsMeta(ctx context.Context, parentIDs []string) {
 		return
 	}
 
+	// Record the device UUIDs before fsmeta appears, so mounts can always
+	// check them against the current blobs.
+	if err := writeDeviceUUIDs(devicesFile, blobs); err != nil {
+		span.SetStatus(err)
+		log.G(ctx).WithError(err).WithFields(log.Fields{
+			"layerCount": len(blobs),
+			"stage":      "record_devices",
+		}).Warn("fsmeta generation failed: cannot record device UUIDs")
+		return
+	}
+
 	// Atomic rename: first fsmeta, then VMDK (VMDK references fsmeta)
-	if err := os.Rename(tmpMeta, mergedMeta); err != nil {
+	if err := moveFile(tmpMeta, mergedMeta); err != nil {
+		span.SetStatus(err)
 		log.G(ctx).WithError(err).WithFields(log.Fields{
 			"layerCount": len(blobs),
 			"stage":      "rename_fsmeta",
@@ -197,7 +267,8 @@ func (s *snapshotter) generateFsMeta(ctx context.Context, parentIDs []string) {
 		}).Warn("fsmeta generation failed: cannot rename fsmeta file")
 		return
 	}
-	if err := os.Rename(tmpVmdk, vmdkFile); err != nil {
+	if err := moveFile(tmpVmdk, vmdkFile); err != nil {
+		span.SetStatus(err)
 		log.G(ctx).WithError(err).WithFields(log.Fields{
 			"layerCount": len(blobs),
 			"stage":      "rename_vmdk",
@@ -222,6 +293,65 @@ func (s *snapshotter) generateFsMeta(ctx context.Context, parentIDs []string) {
 	}).Debug("fsmeta and VMDK generated")
 }
 
+// blobsMatchBlockSize reports whether every blob was built with the block
+// size set by WithErofsBlockSize. fsmeta must use the block size of its
+// layers, so the option is not passed for chains mixing in layers built
+// elsewhere (for example by the differ).
+func (s *snapshotter) blobsMatchBlockSize(blobs []string) bool {
+	for _, blob := range blobs {
+		size, err := erofs.GetBlockSize(blob)
+		if err != nil || size != s.erofsBlockSize {
+			return false
+		}
+	}
+	return true
+}
+
+// moveFile renames src to dst. If they are on different filesystems, src is
+// copied to a temporary file next to dst, synced and renamed into place, so
+// dst never appears partially written.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
 // fixVmdkPaths replaces oldPath with newPath in a VMDK descriptor file.
 // VMDK is a simple text format where paths appear in FLAT extent lines.
 func fixVmdkPaths(vmdkFile, oldPath, newPath string) error {
@@ -270,52 +400,138 @@ func (s *snapshotter) writeLayerManifest(manifestFile string, blobs []string) er
 //
 // The commit process:
 // 1. Find or create the EROFS layer blob
-// 2. Enable fs-verity if configured (integrity protection)
-// 3. Set immutable flag if configured (accidental deletion protection)
-// 4. Update metadata to mark snapshot as committed
+// 2. Record the blob's digest and path in LabelLayerDigest/LabelLayerBlobPath
+// 3. Link the blob into the content store if configured (see WithContentStore)
+// 4. Set immutable flag if configured (accidental deletion protection)
+// 5. Update metadata to mark snapshot as committed
+//
+// Commit does not enable fs-verity; blobs that already have it (e.g. from
+// the EROFS differ) are left as they are, and their measured digest is
+// recorded in LabelFsverityDigest.
 //
 // If no layer blob exists (EROFS differ hasn't processed it), we fall back
 // to converting the upper directory ourselves using the fallback naming scheme.
 func (s *snapshotter) Commit(ctx context.Context, name, key string, opts ...snapshots.Opt) error {
+	return withTimeout(ctx, "commit", key, s.settings().timeouts.Commit, func(ctx context.Context) error {
+		return s.commit(ctx, name, key, opts...)
+	})
+}
+
+// commit implements Commit without a time limit.
+func (s *snapshotter) commit(ctx context.Context, name, key string, opts ...snapshots.Opt) (err error) {
 	var layerBlob string
 	var id string
+	var info snapshots.Info
+	start := time.Now()
+
+	defer func() {
+		s.emitEvent(SnapshotOpCommit, key, id, snapshots.KindCommitted, start, err)
+	}()
 
 	// Get snapshot ID in a read transaction (conversion can be slow)
-	err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
-		sid, _, _, err := storage.GetInfo(ctx, key)
+	var parents int
+	err = s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		sid, sinfo, _, err := storage.GetInfo(ctx, key)
 		if err != nil {
 			return fmt.Errorf("get snapshot info for %q: %w", key, err)
 		}
 		id = sid
-		return nil
+		info = sinfo
+		parents, err = parentCount(ctx, info)
+		return err
 	})
 	if err != nil {
 		return err
 	}
 
-	log.G(ctx).WithFields(log.Fields{
-		"name": name,
-		"key":  key,
-		"id":   id,
-	}).Debug("starting commit")
+	ctx = withLifecycleFields(ctx, key, id, info, parents)
+	log.G(ctx).WithField("name", name).Debug("starting commit")
+
+	target, err := s.targetLayerBlob(id, opts)
+	if err != nil {
+		return err
+	}
+	usage, usageGiven, err := commitUsage(opts)
+	if err != nil {
+		return err
+	}
+	compression, err := commitCompression(opts)
+	if err != nil {
+		return err
+	}
+	// LabelCommitCompression is a request, not a property of the layer;
+	// LabelCompression records what was applied.
+	opts = append(opts, func(info *snapshots.Info) error {
+		delete(info.Labels, LabelCommitCompression)
+		return nil
+	})
 
 	// Find existing layer blob or create via fallback
-	layerBlob, err = s.findLayerBlob(id)
+	layerBlob, err = s.findLayerBlobFromInfo(id, info)
+	if err == nil && target != "" && layerBlob != target {
+		return fmt.Errorf("layer blob %s already exists, cannot commit to %s: %w",
+			layerBlob, target, errdefs.ErrFailedPrecondition)
+	}
 	if err != nil {
 		// Layer doesn't exist - EROFS differ hasn't processed this layer.
 		// Fall back to converting the upper directory ourselves.
-		log.G(ctx).WithField("id", id).Debug("layer blob not found, using fallback conversion")
+		log.G(ctx).Debug("layer blob not found, using fallback conversion")
 
 		layerBlob = s.fallbackLayerBlobPath(id)
-		if cerr := s.commitBlock(ctx, layerBlob, id); cerr != nil {
+		if target != "" {
+			layerBlob = target
+		}
+		if cerr := s.commitBlock(ctx, layerBlob, id, info, compression); cerr != nil {
+			var convErr *CommitConversionError
+			if errors.As(cerr, &convErr) {
+				s.recordConversionError(ctx, key, convErr)
+			}
 			return fmt.Errorf("fallback conversion failed: %w", cerr)
 		}
+		opts = append(opts, s.withBuildLabels())
+		if compression == "" {
+			compression = mkfsCompression(s.mkfsOpts)
+		}
+		if compression != "" {
+			opts = append(opts, snapshots.WithLabels(map[string]string{LabelCompression: compression}))
+		}
+	} else if compression != "" {
+		log.G(ctx).WithField("compression", compression).Debug("layer blob already built, ignoring commit compression")
+	}
+
+	// Hash the final blob outside the write transaction; it can be large.
+	layerDigest, err := digestFile(ctx, layerBlob)
+	if err != nil {
+		return fmt.Errorf("compute layer digest: %w", err)
+	}
+	opts = append(opts, withLayerLabels(layerDigest, layerBlob))
+	if enabled := fsverity.IsEnabled(layerBlob); enabled || s.fsverityMeasurement {
+		verityDigest, err := fsverity.MeasureFsverity(layerBlob)
+		if err != nil {
+			return err
+		}
+		label := LabelFsverityDigest
+		if !enabled {
+			label = LabelFsverityMeasurement
+		}
+		opts = append(opts, withFsverityDigest(label, verityDigest))
+	}
+
+	// Share the blob with identical layers before it becomes immutable;
+	// an immutable file cannot be linked.
+	unlockStore, err := s.addToContentStore(ctx, id, layerBlob, layerDigest)
+	if err != nil {
+		return err
 	}
 
 	// Set immutable flag to prevent accidental deletion
 	if s.setImmutable {
-		if err := setImmutable(layerBlob, true); err != nil {
+		if err := setImmutable(layerBlob, true); errdefs.IsNotImplemented(err) {
+			log.G(ctx).WithError(err).Debug("filesystem does not support immutable flag")
+		} else if err != nil {
 			log.G(ctx).WithError(err).Warn("failed to set immutable flag (non-fatal)")
+		} else {
+			opts = append(opts, snapshots.WithLabels(map[string]string{LabelImmutable: "true"}))
 		}
 	}
 
@@ -325,12 +541,20 @@ func (s *snapshotter) Commit(ctx context.Context, name, key string, opts ...snap
 			return fmt.Errorf("verify layer blob: %w", err)
 		}
 
-		usage, err := fs.DiskUsage(ctx, layerBlob)
-		if err != nil {
-			return fmt.Errorf("calculate disk usage: %w", err)
+		if !usageGiven {
+			du, err := fs.DiskUsage(ctx, layerBlob)
+			if err != nil {
+				return fmt.Errorf("calculate disk usage: %w", err)
+			}
+			usage = snapshots.Usage(du)
+		}
+
+		// The committed blob replaces the active snapshot's writable layer.
+		if err := s.checkNamespaceQuota(ctx, name, key, usage.Size); err != nil {
+			return err
 		}
 
-		if _, err = storage.CommitActive(ctx, key, name, snapshots.Usage(usage), opts...); err != nil {
+		if _, err = storage.CommitActive(ctx, key, name, usage, opts...); err != nil {
 			return fmt.Errorf("commit snapshot: %w", err)
 		}
 
@@ -342,18 +566,204 @@ func (s *snapshotter) Commit(ctx context.Context, name, key string, opts ...snap
 
 		return nil
 	})
+	unlockStore()
 	if err != nil {
 		return err
 	}
 
+	// The snapshot is no longer active; drop any cached active usage.
+	s.usageCache.invalidate(id)
+
 	// Cleanup the ext4 mount from Prepare (for extract snapshots).
 	// The EROFS blob now contains the layer data, so the ext4 is no longer needed.
-	rwMount := s.blockRwMountPath(id)
-	if isMounted(rwMount) {
-		if unmountErr := unmountAll(rwMount); unmountErr != nil {
-			log.G(ctx).WithError(unmountErr).WithField("id", id).Warn("failed to cleanup ext4 mount after commit")
+	if unmountErr := s.hostMounts().Unmount(s.blockRwMountPath(id)); unmountErr != nil {
+		log.G(ctx).WithError(unmountErr).Warn("failed to cleanup ext4 mount after commit")
+	}
+
+	return s.runCommitHook(ctx, CommitInfo{
+		Name:     name,
+		ID:       id,
+		BlobPath: layerBlob,
+		Digest:   layerDigest,
+		Usage:    usage,
+	})
+}
+
+// WithTargetBlobDigest returns a Commit option that sets
+// LabelTargetBlobDigest, so the committed blob is named after dgst.
+func WithTargetBlobDigest(dgst digest.Digest) snapshots.Opt {
+	return snapshots.WithLabels(map[string]string{LabelTargetBlobDigest: dgst.String()})
+}
+
+// targetLayerBlob returns the blob path requested by LabelTargetBlobDigest in
+// the commit options, or "" if none was requested. Only sha256 digests are
+// accepted, matching the digests the EROFS differ names its blobs after.
+func (s *snapshotter) targetLayerBlob(id string, opts []snapshots.Opt) (string, error) {
+	labels, err := optLabels(opts)
+	if err != nil {
+		return "", err
+	}
+	v, ok := labels[LabelTargetBlobDigest]
+	if !ok {
+		return "", nil
+	}
+	dgst, err := digest.Parse(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s %q: %v: %w", LabelTargetBlobDigest, v, err, errdefs.ErrInvalidArgument)
+	}
+	if dgst.Algorithm() != digest.SHA256 {
+		return "", fmt.Errorf("%s %q: unsupported algorithm %s, expected %s: %w",
+			LabelTargetBlobDigest, v, dgst.Algorithm(), digest.SHA256, errdefs.ErrInvalidArgument)
+	}
+	return filepath.Join(s.snapshotDir(id), s.blobName(id, dgst)), nil
+}
+
+// WithCommitUsage returns a Commit option that sets LabelUsageSize, so
+// Commit records size as the layer's usage instead of measuring the blob.
+func WithCommitUsage(size int64) snapshots.Opt {
+	return snapshots.WithLabels(map[string]string{LabelUsageSize: strconv.FormatInt(size, 10)})
+}
+
+// commitUsage returns the usage requested by LabelUsageSize in the commit
+// options. ok is false if none was requested.
+func commitUsage(opts []snapshots.Opt) (_ snapshots.Usage, ok bool, _ error) {
+	labels, err := optLabels(opts)
+	if err != nil {
+		return snapshots.Usage{}, false, err
+	}
+	v, ok := labels[LabelUsageSize]
+	if !ok {
+		return snapshots.Usage{}, false, nil
+	}
+	size, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || size < 0 {
+		return snapshots.Usage{}, false, fmt.Errorf("%s %q must be a non-negative byte count: %w",
+			LabelUsageSize, v, errdefs.ErrInvalidArgument)
+	}
+	// The layer is a single blob file.
+	return snapshots.Usage{Size: size, Inodes: 1}, true, nil
+}
+
+// WithCommitCompression returns a Commit option that sets
+// LabelCommitCompression, so the layer blob Commit builds is compressed with
+// the mkfs.erofs compressor algo (e.g. "zstd" or "lz4hc,level=9").
+func WithCommitCompression(algo string) snapshots.Opt {
+	return snapshots.WithLabels(map[string]string{LabelCommitCompression: algo})
+}
+
+// commitCompression returns the compressor requested by
+// LabelCommitCompression in the commit options, or "" if none was requested.
+func commitCompression(opts []snapshots.Opt) (string, error) {
+	labels, err := optLabels(opts)
+	if err != nil {
+		return "", err
+	}
+	algo, ok := labels[LabelCommitCompression]
+	if !ok {
+		return "", nil
+	}
+	if err := validateCompression(algo); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", LabelCommitCompression, err)
+	}
+	return algo, nil
+}
+
+// withCompressionOpt returns mkfsOpts with any compressor option replaced by
+// "-z"+compression, leaving mkfsOpts itself unchanged.
+func withCompressionOpt(mkfsOpts []string, compression string) []string {
+	opts := slices.DeleteFunc(slices.Clone(mkfsOpts), func(opt string) bool {
+		return strings.HasPrefix(opt, "-z")
+	})
+	return append(opts, "-z"+compression)
+}
+
+// mkfsCompression returns the compressor set by the last "-z" option in
+// mkfsOpts, or "" if none is set.
+func mkfsCompression(mkfsOpts []string) string {
+	for _, opt := range slices.Backward(mkfsOpts) {
+		if algo, ok := strings.CutPrefix(opt, "-z"); ok {
+			return algo
 		}
 	}
+	return ""
+}
 
-	return nil
+// optLabels returns the labels set by opts.
+func optLabels(opts []snapshots.Opt) (map[string]string, error) {
+	var info snapshots.Info
+	for _, opt := range opts {
+		if err := opt(&info); err != nil {
+			return nil, err
+		}
+	}
+	return info.Labels, nil
+}
+
+// withLayerLabels records the layer blob's digest and path on the committed
+// snapshot, preserving labels set by other options.
+func withLayerLabels(dgst digest.Digest, layerBlob string) snapshots.Opt {
+	return func(info *snapshots.Info) error {
+		if info.Labels == nil {
+			info.Labels = make(map[string]string)
+		}
+		info.Labels[LabelLayerDigest] = dgst.String()
+		info.Labels[LabelLayerBlobPath] = layerBlob
+		return nil
+	}
+}
+
+// withFsverityDigest records the layer blob's fs-verity digest on the
+// committed snapshot, in LabelFsverityDigest or LabelFsverityMeasurement.
+func withFsverityDigest(label, d string) snapshots.Opt {
+	return func(info *snapshots.Info) error {
+		if info.Labels == nil {
+			info.Labels = make(map[string]string)
+		}
+		info.Labels[label] = d
+		return nil
+	}
+}
+
+// withBuildLabels records the mkfs.erofs options a layer blob built by the
+// snapshotter used, preserving labels set by other options.
+func (s *snapshotter) withBuildLabels() snapshots.Opt {
+	return func(info *snapshots.Info) error {
+		if !s.erofsTailPacking {
+			return nil
+		}
+		if info.Labels == nil {
+			info.Labels = make(map[string]string)
+		}
+		info.Labels[LabelTailPacking] = "true"
+		return nil
+	}
+}
+
+// digestFile computes the sha256 digest of the file at path, streaming its
+// content. Hashing stops early if ctx is canceled.
+func digestFile(ctx context.Context, path string) (digest.Digest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	digester := digest.SHA256.Digester()
+	if _, err := io.Copy(digester.Hash(), &contextReader{ctx: ctx, r: f}); err != nil {
+		return "", err
+	}
+	return digester.Digest(), nil
+}
+
+// contextReader is an io.Reader that fails once its context is done.
+type contextReader struct {
+	ctx context.Context //nolint:containedctx // scoped to a single io.Copy
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
 }
@@ -0,0 +1,112 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/pkg/testutil"
+	"github.com/containerd/errdefs"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/fsverity"
+)
+
+func TestCheckCommitSourceIdle(t *testing.T) {
+	testutil.RequiresRoot(t)
+	s := newMetadataOnlySnapshotter(t)
+	upper := s.upperPath("1")
+	nested := filepath.Join(upper, "merged")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.checkCommitSourceIdle("1", upper); err != nil {
+		t.Fatalf("idle upper: %v", err)
+	}
+
+	if err := mount.All([]mount.Mount{{Type: "tmpfs", Source: "tmpfs"}}, nested); err != nil {
+		t.Skipf("cannot mount tmpfs: %v", err)
+	}
+	t.Cleanup(func() { _ = mount.UnmountAll(nested, 0) })
+
+	if err := s.checkCommitSourceIdle("1", upper); !errdefs.IsFailedPrecondition(err) {
+		t.Fatalf("expected failed precondition with a nested mount, got %v", err)
+	}
+}
+
+func TestCommitRecordsFsverityDigest(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "layer-active", "")
+	if err := os.MkdirAll(s.upperPath(snap.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	blob := s.fallbackLayerBlobPath(snap.ID)
+	if err := os.WriteFile(blob, []byte("layer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsverity.Enable(blob); err != nil {
+		t.Skipf("fs-verity not supported: %v", err)
+	}
+	if err := s.Commit(t.Context(), "layer", "layer-active"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := s.Stat(t.Context(), "layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, ok := FsverityDigest(info)
+	if !ok {
+		t.Fatalf("expected %s label, got %v", LabelFsverityDigest, info.Labels)
+	}
+	if want, err := fsverity.MeasureFsverity(blob); err != nil || d != want {
+		t.Errorf("fs-verity digest = %q, want %q (%v)", d, want, err)
+	}
+	if !strings.HasPrefix(d, "sha256:") || len(d) != len("sha256:")+64 {
+		t.Errorf("malformed fs-verity digest %q", d)
+	}
+}
+
+func TestCommitWithoutFsverity(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	commitMetadataLayer(t, s, "layer", "", []byte("layer"))
+
+	info, err := s.Stat(t.Context(), "layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := FsverityDigest(info); ok {
+		t.Errorf("unexpected fs-verity digest %q", d)
+	}
+}
+
+func TestCommitRecordsFsverityMeasurement(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.fsverityMeasurement = true
+	id := commitMetadataLayer(t, s, "layer", "", []byte("layer"))
+
+	info, err := s.Stat(t.Context(), "layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := FsverityDigest(info); ok {
+		t.Errorf("unexpected fs-verity digest %q", d)
+	}
+	f, err := os.Open(s.fallbackLayerBlobPath(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want, err := fsverity.Compute(f, os.Getpagesize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Labels[LabelFsverityMeasurement]; got != want {
+		t.Errorf("fs-verity measurement = %q, want %q", got, want)
+	}
+}
@@ -1,9 +1,17 @@
 package snapshotter
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
 )
 
 func TestGetCommitUpperDir(t *testing.T) {
@@ -21,7 +29,7 @@ func TestGetCommitUpperDir(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		upperDir := s.getCommitUpperDir("test-id")
+		upperDir := s.getCommitUpperDir("test-id", snapshots.Info{})
 
 		// Should return overlay upper dir (fs/)
 		expectedUpper := filepath.Join(snapshotDir, "fs")
@@ -47,7 +55,7 @@ func TestGetCommitUpperDir(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		result := s.getCommitUpperDir("test-id")
+		result := s.getCommitUpperDir("test-id", snapshots.Info{})
 
 		// Should return block upper dir (rw/upper/)
 		if result != upperDir {
@@ -76,7 +84,7 @@ func TestGetCommitUpperDir(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		result := s.getCommitUpperDir("test-id")
+		result := s.getCommitUpperDir("test-id", snapshots.Info{})
 
 		// Should return mount root (rw/) when it has content but no upper/
 		if result != rwDir {
@@ -104,7 +112,7 @@ func TestGetCommitUpperDir(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		result := s.getCommitUpperDir("test-id")
+		result := s.getCommitUpperDir("test-id", snapshots.Info{})
 
 		// Should fall back to overlay (fs/) when rw/ is empty (not mounted)
 		if result != fsDir {
@@ -128,11 +136,333 @@ func TestGetCommitUpperDir(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		result := s.getCommitUpperDir("test-id")
+		result := s.getCommitUpperDir("test-id", snapshots.Info{})
 
 		// Should fall back to overlay (fs/) when rw/ doesn't exist
 		if result != fsDir {
 			t.Errorf("upperDir = %q, want %q", result, fsDir)
 		}
 	})
+
+	t.Run("directory mode for block fallback snapshots", func(t *testing.T) {
+		root := t.TempDir()
+		s := newTestSnapshotterWithRoot(t, root)
+
+		// Even with a populated rw/upper/, the label selects fs/
+		snapshotDir := filepath.Join(root, "snapshots", "test-id")
+		if err := os.MkdirAll(filepath.Join(snapshotDir, "rw", "upper"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(snapshotDir, "rwlayer.img"), []byte("fake ext4"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		info := snapshots.Info{Labels: map[string]string{LabelBlockFallback: "true"}}
+		result := s.getCommitUpperDir("test-id", info)
+
+		if fsDir := filepath.Join(snapshotDir, "fs"); result != fsDir {
+			t.Errorf("upperDir = %q, want %q", result, fsDir)
+		}
+	})
+}
+
+func TestCommitSetsLayerLabels(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	layerBlob := s.fallbackLayerBlobPath(snap.ID)
+	if err := os.MkdirAll(filepath.Dir(layerBlob), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("erofs layer content")
+	if err := os.WriteFile(layerBlob, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Commit(t.Context(), "committed", "active", snapshots.WithLabels(map[string]string{"user": "label"})); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	info, err := s.Stat(t.Context(), "committed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.Labels[LabelLayerDigest], digest.FromBytes(content).String(); got != want {
+		t.Errorf("%s = %q, want %q", LabelLayerDigest, got, want)
+	}
+	if got := info.Labels[LabelLayerBlobPath]; got != layerBlob {
+		t.Errorf("%s = %q, want %q", LabelLayerBlobPath, got, layerBlob)
+	}
+	if info.Labels["user"] != "label" {
+		t.Errorf("user labels should be preserved, got %v", info.Labels)
+	}
+}
+
+func TestTargetLayerBlob(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	dgst := digest.FromString("layer")
+
+	got, err := s.targetLayerBlob("1", []snapshots.Opt{WithTargetBlobDigest(dgst)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(s.snapshotDir("1"), "sha256-"+dgst.Encoded()+".erofs"); got != want {
+		t.Errorf("target blob = %q, want %q", got, want)
+	}
+
+	if got, err := s.targetLayerBlob("1", nil); err != nil || got != "" {
+		t.Errorf("expected no target without the label, got %q, %v", got, err)
+	}
+
+	for _, v := range []string{"sha256:xyz", "not-a-digest", digest.SHA512.FromString("layer").String()} {
+		opt := snapshots.WithLabels(map[string]string{LabelTargetBlobDigest: v})
+		if _, err := s.targetLayerBlob("1", []snapshots.Opt{opt}); !errdefs.IsInvalidArgument(err) {
+			t.Errorf("%q: expected invalid argument, got %v", v, err)
+		}
+	}
+}
+
+func TestWithBuildLabels(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+
+	var info snapshots.Info
+	if err := s.withBuildLabels()(&info); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := info.Labels[LabelTailPacking]; ok {
+		t.Errorf("%s set without tail packing", LabelTailPacking)
+	}
+
+	s.erofsTailPacking = true
+	info = snapshots.Info{Labels: map[string]string{"keep": "1"}}
+	if err := s.withBuildLabels()(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Labels[LabelTailPacking] != "true" || info.Labels["keep"] != "1" {
+		t.Errorf("unexpected labels %v", info.Labels)
+	}
+}
+
+func TestCommitTargetBlobDigest(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	dgst := digest.FromString("layer")
+
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	target, err := s.targetLayerBlob(snap.ID, []snapshots.Opt{WithTargetBlobDigest(dgst)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("erofs layer content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Commit(t.Context(), "committed", "active", WithTargetBlobDigest(dgst)); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	info, err := s.Stat(t.Context(), "committed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Labels[LabelLayerBlobPath]; got != target {
+		t.Errorf("%s = %q, want %q", LabelLayerBlobPath, got, target)
+	}
+}
+
+func TestCommitTargetBlobDigestConflict(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	existing := s.fallbackLayerBlobPath(snap.ID)
+	if err := os.MkdirAll(filepath.Dir(existing), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(existing, []byte("erofs layer content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.Commit(t.Context(), "committed", "active", WithTargetBlobDigest(digest.FromString("other")))
+	if !errdefs.IsFailedPrecondition(err) {
+		t.Fatalf("expected failed precondition, got %v", err)
+	}
+	if _, err := s.Stat(t.Context(), "active"); err != nil {
+		t.Errorf("active snapshot should remain after rejected commit: %v", err)
+	}
+}
+
+func TestCommitUsage(t *testing.T) {
+	usage, ok, err := commitUsage([]snapshots.Opt{WithCommitUsage(8192)})
+	if err != nil || !ok {
+		t.Fatalf("commitUsage: %v, %v", ok, err)
+	}
+	if usage.Size != 8192 || usage.Inodes != 1 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	if _, ok, err := commitUsage(nil); ok || err != nil {
+		t.Errorf("expected no usage without the label, got %v, %v", ok, err)
+	}
+
+	for _, v := range []string{"-1", "1k", ""} {
+		opt := snapshots.WithLabels(map[string]string{LabelUsageSize: v})
+		if _, _, err := commitUsage([]snapshots.Opt{opt}); !errdefs.IsInvalidArgument(err) {
+			t.Errorf("%q: expected invalid argument, got %v", v, err)
+		}
+	}
+}
+
+func TestCommitWithProvidedUsage(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	layerBlob := s.fallbackLayerBlobPath(snap.ID)
+	if err := os.MkdirAll(filepath.Dir(layerBlob), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(layerBlob, []byte("erofs layer content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Commit(t.Context(), "committed", "active", WithCommitUsage(1<<20)); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	usage, err := s.Usage(t.Context(), "committed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Size != 1<<20 {
+		t.Errorf("usage size = %d, want %d", usage.Size, 1<<20)
+	}
+}
+
+func TestDigestFileCanceled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blob")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := digestFile(ctx, path); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	move := func(t *testing.T, srcDir, dstDir string) {
+		t.Helper()
+		src := filepath.Join(srcDir, "fsmeta.erofs.tmp")
+		dst := filepath.Join(dstDir, "fsmeta.erofs")
+		if err := os.WriteFile(src, []byte("fsmeta"), 0o640); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := moveFile(src, dst); err != nil {
+			t.Fatalf("moveFile: %v", err)
+		}
+		data, err := os.ReadFile(dst)
+		if err != nil || string(data) != "fsmeta" {
+			t.Errorf("dst content = %q, %v", data, err)
+		}
+		if _, err := os.Stat(src); !os.IsNotExist(err) {
+			t.Errorf("src should be removed, got %v", err)
+		}
+		if _, err := os.Stat(dst + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("temporary copy should not remain, got %v", err)
+		}
+	}
+
+	t.Run("same filesystem", func(t *testing.T) {
+		dir := t.TempDir()
+		move(t, dir, dir)
+	})
+
+	t.Run("across filesystems", func(t *testing.T) {
+		dst := t.TempDir()
+		src, err := os.MkdirTemp("/dev/shm", "movefile-")
+		if err != nil {
+			t.Skipf("no /dev/shm: %v", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(src) })
+		var a, b syscall.Stat_t
+		if syscall.Stat(src, &a) != nil || syscall.Stat(dst, &b) != nil || a.Dev == b.Dev {
+			t.Skip("/dev/shm is on the same filesystem as the temp dir")
+		}
+		move(t, src, dst)
+	})
+}
+
+func TestBlobsMatchBlockSize(t *testing.T) {
+	// writeBlob writes a minimal EROFS superblock with the given blkszbits.
+	writeBlob := func(name string, bits byte) string {
+		buf := make([]byte, 1024+16)
+		copy(buf[1024:], []byte{0xe2, 0xe1, 0xf5, 0xe0})
+		buf[1024+12] = bits
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, buf, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	small := writeBlob("4k.erofs", 12)
+	large := writeBlob("16k.erofs", 14)
+
+	s := &snapshotter{erofsBlockSize: 16384}
+	if !s.blobsMatchBlockSize([]string{large, large}) {
+		t.Error("expected matching block sizes")
+	}
+	if s.blobsMatchBlockSize([]string{large, small}) {
+		t.Error("expected mixed block sizes not to match")
+	}
+	if s.blobsMatchBlockSize([]string{filepath.Join(t.TempDir(), "missing.erofs")}) {
+		t.Error("expected unreadable blob not to match")
+	}
+}
+
+func TestGenerateFsMetaConcurrencyLimit(t *testing.T) {
+	s := &snapshotter{root: t.TempDir(), fsMetaSem: make(chan struct{}, 1)}
+	ids := []string{"2", "1"}
+	if err := os.MkdirAll(s.snapshotDir(ids[0]), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	lockFile := s.fsMetaPath(ids[0]) + ".lock"
+
+	// Occupy the only slot.
+	s.fsMetaSem <- struct{}{}
+
+	// A queued generation whose context is cancelled gives up, releasing
+	// its lock without taking a slot.
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	s.generateFsMeta(ctx, ids, nil)
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Errorf("expected lock file to be removed, got %v", err)
+	}
+	if len(s.fsMetaSem) != 1 {
+		t.Errorf("expected one slot in use, got %d", len(s.fsMetaSem))
+	}
+
+	// Otherwise it waits for the slot. The layer blobs are missing, so it
+	// returns as soon as it runs.
+	done := make(chan struct{})
+	go func() {
+		s.generateFsMeta(t.Context(), ids, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected generation to wait for a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-s.fsMetaSem
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("generation did not run after the slot was released")
+	}
+	if len(s.fsMetaSem) != 0 {
+		t.Errorf("expected slot to be released, got %d in use", len(s.fsMetaSem))
+	}
 }
@@ -0,0 +1,49 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+)
+
+// CommitInfo describes a layer committed by Commit.
+type CommitInfo struct {
+	// Name is the name the snapshot was committed as.
+	Name string
+	// ID is the internal snapshot ID.
+	ID string
+	// BlobPath is the path of the committed EROFS layer blob.
+	BlobPath string
+	// Digest is the sha256 digest of the layer blob.
+	Digest digest.Digest
+	// Usage is the usage recorded for the committed snapshot.
+	Usage snapshots.Usage
+}
+
+// CommitHook is called by Commit once the committed snapshot is recorded,
+// for example to register the layer blob with an external index.
+type CommitHook func(ctx context.Context, info CommitInfo) error
+
+// runCommitHook calls the hook set by WithCommitHook, if any. A hook error
+// is logged, unless WithCommitHookErrorsFatal is set, in which case the
+// committed snapshot is removed again and the error returned.
+func (s *snapshotter) runCommitHook(ctx context.Context, info CommitInfo) error {
+	if s.commitHook == nil {
+		return nil
+	}
+	err := s.commitHook(ctx, info)
+	if err == nil {
+		return nil
+	}
+	if !s.commitHookFatal {
+		log.G(ctx).WithError(err).WithField("name", info.Name).Warn("commit hook failed (non-fatal)")
+		return nil
+	}
+	if rerr := s.Remove(ctx, info.Name); rerr != nil {
+		log.G(ctx).WithError(rerr).WithField("name", info.Name).Warn("failed to remove snapshot after commit hook error")
+	}
+	return fmt.Errorf("commit hook: %w", err)
+}
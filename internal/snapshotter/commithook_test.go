@@ -0,0 +1,66 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestCommitHook(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	var got []CommitInfo
+	s.commitHook = func(_ context.Context, info CommitInfo) error {
+		got = append(got, info)
+		return nil
+	}
+
+	id := commitMetadataLayer(t, s, "base", "", []byte("base"))
+	if len(got) != 1 {
+		t.Fatalf("expected one hook call, got %d", len(got))
+	}
+	info := got[0]
+	if info.Name != "base" || info.ID != id || info.BlobPath != s.fallbackLayerBlobPath(id) {
+		t.Errorf("unexpected commit info %+v", info)
+	}
+	if info.Digest != digest.FromString("base") || info.Usage.Size == 0 {
+		t.Errorf("unexpected digest %s or usage %+v", info.Digest, info.Usage)
+	}
+}
+
+func TestCommitHookError(t *testing.T) {
+	hookErr := errors.New("index unavailable")
+
+	t.Run("non-fatal", func(t *testing.T) {
+		s := newMetadataOnlySnapshotter(t)
+		s.commitHook = func(context.Context, CommitInfo) error { return hookErr }
+		commitMetadataLayer(t, s, "base", "", []byte("base"))
+		if _, err := s.Stat(t.Context(), "base"); err != nil {
+			t.Errorf("expected snapshot to stay committed: %v", err)
+		}
+	})
+
+	t.Run("fatal", func(t *testing.T) {
+		s := newMetadataOnlySnapshotter(t)
+		s.commitHook = func(context.Context, CommitInfo) error { return hookErr }
+		s.commitHookFatal = true
+		snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+		if err := os.MkdirAll(s.upperPath(snap.ID), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(s.fallbackLayerBlobPath(snap.ID), []byte("base"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.Commit(t.Context(), "base", "active"); !errors.Is(err, hookErr) {
+			t.Fatalf("expected hook error, got %v", err)
+		}
+		if _, err := s.Stat(t.Context(), "base"); !errdefs.IsNotFound(err) {
+			t.Errorf("expected committed snapshot to be removed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/log"
+)
+
+// MissingSnapshotDir is a snapshot in the metadata store whose snapshot
+// directory does not exist. Mounting it or building on it fails.
+type MissingSnapshotDir struct {
+	Key  string
+	ID   string
+	Kind snapshots.Kind
+}
+
+// ConsistencyReport lists the disagreements between the metadata store and
+// the snapshots directory.
+type ConsistencyReport struct {
+	// MissingDirs are snapshots whose directory is missing, sorted by key.
+	MissingDirs []MissingSnapshotDir
+	// OrphanDirs are snapshot directories without a snapshot in the
+	// metadata store, sorted. Cleanup removes them.
+	OrphanDirs []string
+}
+
+// Consistent reports whether the metadata store and the snapshots directory
+// agree.
+func (r ConsistencyReport) Consistent() bool {
+	return len(r.MissingDirs) == 0 && len(r.OrphanDirs) == 0
+}
+
+// ConsistencyChecker is implemented by snapshotters that can compare their
+// metadata store with the snapshot directories on disk.
+type ConsistencyChecker interface {
+	CheckConsistency(ctx context.Context) (ConsistencyReport, error)
+}
+
+// CheckConsistency reports snapshots whose directory is missing and
+// directories that belong to no snapshot. It only reads: nothing is
+// removed or marked, so that an operator can decide what to do.
+func (s *snapshotter) CheckConsistency(ctx context.Context) (ConsistencyReport, error) {
+	var report ConsistencyReport
+	err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		orphans, err := s.getCleanupDirectories(ctx)
+		if err != nil {
+			return err
+		}
+		report.OrphanDirs = orphans
+
+		ids, err := storage.IDMap(ctx)
+		if err != nil {
+			return fmt.Errorf("get snapshot ID map: %w", err)
+		}
+		for id, key := range ids {
+			if _, err := os.Stat(s.snapshotDir(id)); err == nil {
+				continue
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("stat snapshot directory: %w", err)
+			}
+			_, info, _, err := storage.GetInfo(ctx, key)
+			if err != nil {
+				return fmt.Errorf("get snapshot info for %q: %w", key, err)
+			}
+			report.MissingDirs = append(report.MissingDirs, MissingSnapshotDir{Key: key, ID: id, Kind: info.Kind})
+		}
+		return nil
+	})
+	if err != nil {
+		return ConsistencyReport{}, err
+	}
+	slices.Sort(report.OrphanDirs)
+	slices.SortFunc(report.MissingDirs, func(a, b MissingSnapshotDir) int { return strings.Compare(a.Key, b.Key) })
+	return report, nil
+}
+
+// startupConsistencyCheck runs CheckConsistency, logs every finding and
+// labels snapshots with a missing directory with LabelDirectoryMissing.
+// Failures are logged; they do not prevent startup.
+func (s *snapshotter) startupConsistencyCheck(ctx context.Context) {
+	report, err := s.CheckConsistency(ctx)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("startup consistency check failed")
+		return
+	}
+	for _, d := range report.OrphanDirs {
+		log.G(ctx).WithField("path", d).Warn("snapshot directory has no snapshot in the metadata store")
+	}
+	for _, m := range report.MissingDirs {
+		log.G(ctx).WithFields(log.Fields{
+			"key":  m.Key,
+			"id":   m.ID,
+			"kind": m.Kind.String(),
+		}).Warn("snapshot directory is missing")
+	}
+	if len(report.MissingDirs) == 0 {
+		return
+	}
+
+	detected := time.Now().UTC().Format(time.RFC3339)
+	if err := s.ms.WithTransaction(ctx, true, func(ctx context.Context) error {
+		for _, m := range report.MissingDirs {
+			if _, err := storage.UpdateInfo(ctx, snapshots.Info{
+				Name:   m.Key,
+				Labels: map[string]string{LabelDirectoryMissing: detected},
+			}, "labels."+LabelDirectoryMissing); err != nil {
+				return fmt.Errorf("mark snapshot %q: %w", m.Key, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		log.G(ctx).WithError(err).Warn("failed to mark snapshots with a missing directory")
+	}
+}
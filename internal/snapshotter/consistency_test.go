@@ -0,0 +1,53 @@
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	base := commitMetadataLayer(t, s, "base", "", []byte("base"))
+	lost := createMetadataSnapshot(t, s, snapshots.KindActive, "lost", "base")
+	orphan := filepath.Join(s.snapshotsDir(), "999")
+	if err := os.MkdirAll(orphan, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.CheckConsistency(t.Context())
+	if err != nil {
+		t.Fatalf("CheckConsistency: %v", err)
+	}
+	if report.Consistent() {
+		t.Fatal("expected an inconsistent report")
+	}
+	want := []MissingSnapshotDir{{Key: "lost", ID: lost.ID, Kind: snapshots.KindActive}}
+	if !slices.Equal(report.MissingDirs, want) {
+		t.Errorf("MissingDirs = %+v, want %+v", report.MissingDirs, want)
+	}
+	if !slices.Equal(report.OrphanDirs, []string{orphan}) {
+		t.Errorf("OrphanDirs = %v, want [%s]", report.OrphanDirs, orphan)
+	}
+
+	// The startup check marks the snapshot but deletes nothing.
+	s.startupConsistencyCheck(t.Context())
+	info, err := s.Stat(t.Context(), "lost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Labels[LabelDirectoryMissing] == "" {
+		t.Errorf("expected %s label, got %v", LabelDirectoryMissing, info.Labels)
+	}
+	if info, err := s.Stat(t.Context(), "base"); err != nil || info.Labels[LabelDirectoryMissing] != "" {
+		t.Errorf("expected base to be unmarked, got %v (%v)", info.Labels, err)
+	}
+	for _, p := range []string{orphan, s.snapshotDir(base)} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("expected %s to be kept: %v", p, err)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/erofs"
+)
+
+// contentStorePath returns the path of the blob with digest dgst in the
+// content store set by WithContentStore.
+func (s *snapshotter) contentStorePath(dgst digest.Digest) string {
+	return filepath.Join(s.contentStore, erofs.LayerBlobFilename(dgst.String()))
+}
+
+// addToContentStore adds the layer blob of snapshot id, whose digest is
+// dgst, to the content store and locks the store until the returned unlock
+// is called, so that Cleanup cannot prune the blob before the commit
+// records its digest. Without a content store it does nothing.
+func (s *snapshotter) addToContentStore(ctx context.Context, id, layerBlob string, dgst digest.Digest) (unlock func(), _ error) {
+	if s.contentStore == "" {
+		return func() {}, nil
+	}
+	s.contentStoreMu.Lock()
+	if err := s.storeLayerBlob(ctx, id, layerBlob, dgst); err != nil {
+		s.contentStoreMu.Unlock()
+		return nil, fmt.Errorf("add layer blob to content store: %w", err)
+	}
+	return s.contentStoreMu.Unlock, nil
+}
+
+// storeLayerBlob makes layerBlob, the blob of snapshot id, share its data
+// with the content store entry for dgst. If the store already has the
+// blob, layerBlob is replaced by a link to it; otherwise layerBlob becomes
+// the store entry. Hard links are used where possible. Across filesystems,
+// or when the stored blob is immutable, the snapshot directory holds a
+// symlink into the store instead. Must be called with contentStoreMu held.
+func (s *snapshotter) storeLayerBlob(ctx context.Context, id, layerBlob string, dgst digest.Digest) error {
+	stored := s.contentStorePath(dgst)
+	fi, err := os.Lstat(layerBlob)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		// Already a link into the store.
+		return nil
+	}
+
+	sfi, err := os.Stat(stored)
+	switch {
+	case err == nil:
+		if os.SameFile(fi, sfi) {
+			return nil
+		}
+		tmp := layerBlob + ".store"
+		if err := os.Link(stored, tmp); err != nil {
+			if err := os.Symlink(stored, tmp); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(tmp, layerBlob); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+		log.G(ctx).WithFields(log.Fields{"blob": layerBlob, "stored": stored}).Debug("layer blob deduplicated")
+		return nil
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	tmp := stored + "." + id + ".tmp"
+	if err := os.Link(layerBlob, tmp); err == nil {
+		if err := os.Rename(tmp, stored); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+		return nil
+	}
+	// The store is on another filesystem: move the blob there and leave a
+	// symlink behind.
+	if err := moveFile(layerBlob, stored); err != nil {
+		return err
+	}
+	return os.Symlink(stored, layerBlob)
+}
+
+// storedLayer is a committed layer blob to add to the content store.
+type storedLayer struct {
+	id     string
+	blob   string
+	digest digest.Digest
+}
+
+// migrateToContentStore adds the blobs of layers committed before the
+// content store was enabled. The digest label of each layer is checked
+// against its blob first, since labels can be changed by clients and a
+// wrong one would otherwise put the blob in the store under another
+// layer's digest. Failures are logged; the layers keep their own blobs.
+func (s *snapshotter) migrateToContentStore(ctx context.Context) {
+	var layers []storedLayer
+	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		return storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+			if info.Kind != snapshots.KindCommitted {
+				return nil
+			}
+			dgst, err := digest.Parse(info.Labels[LabelLayerDigest])
+			if err != nil || dgst.Algorithm() != digest.SHA256 {
+				return nil //nolint:nilerr // layers without a digest are not stored
+			}
+			id, _, _, err := storage.GetInfo(ctx, info.Name)
+			if err != nil {
+				return fmt.Errorf("get snapshot info for %q: %w", info.Name, err)
+			}
+			blob, err := s.findLayerBlobFromInfo(id, info)
+			if err != nil {
+				return nil //nolint:nilerr // reported by Mounts and CheckConsistency
+			}
+			layers = append(layers, storedLayer{id: id, blob: blob, digest: dgst})
+			return nil
+		})
+	}); err != nil {
+		log.G(ctx).WithError(err).Warn("content store migration failed")
+		return
+	}
+
+	migrated := 0
+	for _, l := range layers {
+		if err := s.migrateLayerBlob(ctx, l); err != nil {
+			log.G(ctx).WithError(err).WithField("blob", l.blob).Warn("failed to add layer blob to content store")
+			continue
+		}
+		migrated++
+	}
+	if migrated > 0 {
+		log.G(ctx).WithField("layers", migrated).Info("layer blobs added to content store")
+	}
+}
+
+// migrateLayerBlob adds one existing layer blob to the content store,
+// lifting its immutable flag while the blob is linked.
+func (s *snapshotter) migrateLayerBlob(ctx context.Context, l storedLayer) error {
+	if fi, err := os.Lstat(l.blob); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	actual, err := digestFile(ctx, l.blob)
+	if err != nil {
+		return err
+	}
+	if actual != l.digest {
+		return fmt.Errorf("%s is %s, not %s", LabelLayerDigest, actual, l.digest)
+	}
+
+	if isImmutable(l.blob) {
+		if err := setImmutable(l.blob, false); err != nil {
+			return err
+		}
+		defer func() {
+			if err := setImmutable(l.blob, true); err != nil {
+				log.G(ctx).WithError(err).WithField("blob", l.blob).Warn("failed to restore immutable flag")
+			}
+		}()
+	}
+
+	s.contentStoreMu.Lock()
+	defer s.contentStoreMu.Unlock()
+	return s.storeLayerBlob(ctx, l.id, l.blob, l.digest)
+}
+
+// sharedBlob reports whether the layer blob at path shares its data with
+// the content store or other layers: it is a symlink into the store, or
+// one of several hard links. Clearing the immutable flag on such a blob
+// would lift it for every layer linked to it.
+func sharedBlob(path string) bool {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeSymlink != 0 || linkCount(fi) > 1
+}
+
+// unlinkBlob calls unlink, which removes or replaces the layer blob at
+// path, after lifting the immutable flag that would make it fail. For a
+// hard link shared with other layers the flag is restored once path is
+// gone, so the remaining links stay immutable. Symlinks are unlinked as is.
+func unlinkBlob(path string, unlink func() error) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 || !isImmutable(path) {
+		return unlink()
+	}
+	if linkCount(fi) == 1 {
+		if err := setImmutable(path, false); err != nil {
+			return err
+		}
+		return unlink()
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := setImmutable(path, false); err != nil {
+		return err
+	}
+	uerr := unlink()
+	if err := setFileImmutable(f, true); err != nil && uerr == nil {
+		return fmt.Errorf("restore immutable flag: %w", err)
+	}
+	return uerr
+}
+
+// pruneContentStore removes content store entries that no committed
+// snapshot uses any more, neither by its digest label nor by a link to the
+// entry. Failures are logged.
+func (s *snapshotter) pruneContentStore(ctx context.Context) {
+	s.contentStoreMu.Lock()
+	defer s.contentStoreMu.Unlock()
+
+	entries, err := filepath.Glob(filepath.Join(s.contentStore, erofs.LayerBlobPattern))
+	if err != nil || len(entries) == 0 {
+		return
+	}
+
+	digests := make(map[digest.Digest]struct{})
+	var blobs []os.FileInfo
+	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		return storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+			if info.Kind != snapshots.KindCommitted {
+				return nil
+			}
+			if d, err := digest.Parse(info.Labels[LabelLayerDigest]); err == nil {
+				digests[d] = struct{}{}
+			}
+			id, _, _, err := storage.GetInfo(ctx, info.Name)
+			if err != nil {
+				return fmt.Errorf("get snapshot info for %q: %w", info.Name, err)
+			}
+			if blob, err := s.findLayerBlobFromInfo(id, info); err == nil {
+				if fi, err := os.Stat(blob); err == nil {
+					blobs = append(blobs, fi)
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		log.G(ctx).WithError(err).Warn("content store prune failed")
+		return
+	}
+
+	for _, entry := range entries {
+		if _, ok := digests[erofs.DigestFromLayerBlobPath(entry)]; ok {
+			continue
+		}
+		fi, err := os.Stat(entry)
+		if err != nil || slices.ContainsFunc(blobs, func(b os.FileInfo) bool { return os.SameFile(b, fi) }) {
+			continue
+		}
+		// Links left in snapshot directories still being removed keep
+		// the flag until the last of them goes.
+		if err := unlinkBlob(entry, func() error { return os.Remove(entry) }); err != nil {
+			log.G(ctx).WithError(err).WithField("path", entry).Warn("failed to remove content store entry")
+			continue
+		}
+		log.G(ctx).WithField("path", entry).Debug("content store entry removed")
+	}
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestContentStoreRemoveKeepsSharedBlobImmutable(t *testing.T) {
+	for _, removed := range []string{"base-a", "base-b"} {
+		t.Run(removed, func(t *testing.T) {
+			s := newContentStoreSnapshotter(t)
+			skipIfNoImmutableSupport(t, s.root)
+			s.setImmutable = true
+			t.Cleanup(func() {
+				paths, _ := filepath.Glob(filepath.Join(s.snapshotsDir(), "*", "*.erofs"))
+				paths = append(paths, s.contentStorePath(digest.FromString("shared")))
+				for _, p := range paths {
+					_ = setImmutable(p, false)
+				}
+			})
+
+			// The first layer is hard linked to the store entry; the
+			// second, linked after the entry became immutable, is a
+			// symlink to it.
+			ids := map[string]string{
+				"base-a": commitMetadataLayer(t, s, "base-a", "", []byte("shared")),
+				"base-b": commitMetadataLayer(t, s, "base-b", "", []byte("shared")),
+			}
+			if err := s.Remove(t.Context(), removed); err != nil {
+				t.Fatalf("Remove: %v", err)
+			}
+			if err := s.Cleanup(t.Context()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(s.snapshotDir(ids[removed])); !os.IsNotExist(err) {
+				t.Errorf("expected removed snapshot directory to be deleted, got %v", err)
+			}
+
+			for name, id := range ids {
+				if name == removed {
+					continue
+				}
+				if blob := s.fallbackLayerBlobPath(id); !isImmutable(blob) {
+					t.Errorf("expected %s blob to stay immutable", name)
+				}
+			}
+			if stored := s.contentStorePath(digest.FromString("shared")); !isImmutable(stored) {
+				t.Error("expected stored blob to stay immutable")
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/opencontainers/go-digest"
+)
+
+// newContentStoreSnapshotter returns a metadata-only snapshotter with a
+// content store under its root.
+func newContentStoreSnapshotter(t *testing.T) *snapshotter {
+	t.Helper()
+	s := newMetadataOnlySnapshotter(t)
+	s.contentStore = filepath.Join(s.root, "store")
+	if err := os.MkdirAll(s.contentStore, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// assertSameFile fails unless a and b are the same file.
+func assertSameFile(t *testing.T, a, b string) {
+	t.Helper()
+	afi, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bfi, err := os.Stat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(afi, bfi) {
+		t.Errorf("expected %s and %s to be the same file", a, b)
+	}
+}
+
+func TestContentStoreDeduplicatesLayers(t *testing.T) {
+	s := newContentStoreSnapshotter(t)
+	content := []byte("shared base layer")
+	stored := s.contentStorePath(digest.FromBytes(content))
+
+	first := commitMetadataLayer(t, s, "base-a", "", content)
+	second := commitMetadataLayer(t, s, "base-b", "", content)
+
+	assertSameFile(t, s.fallbackLayerBlobPath(first), stored)
+	assertSameFile(t, s.fallbackLayerBlobPath(second), stored)
+	entries, err := os.ReadDir(s.contentStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected one stored blob, got %d", len(entries))
+	}
+
+	// The store entry outlives the first layer and is pruned with the last.
+	if err := s.Remove(t.Context(), "base-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Cleanup(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stored); err != nil {
+		t.Fatalf("expected stored blob to be kept for base-b: %v", err)
+	}
+	if err := s.Remove(t.Context(), "base-b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Cleanup(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stored); !os.IsNotExist(err) {
+		t.Errorf("expected stored blob to be pruned, got %v", err)
+	}
+}
+
+func TestContentStoreSymlinkedLayer(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	content := []byte("layer")
+	id := commitMetadataLayer(t, s, "layer", "", content)
+	blob := s.fallbackLayerBlobPath(id)
+
+	// Move the blob into the store and leave a symlink, as happens when the
+	// store is on another filesystem. Without its digest label, only the
+	// link keeps the entry from being pruned.
+	s.contentStore = filepath.Join(s.root, "store")
+	if err := os.MkdirAll(s.contentStore, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	stored := s.contentStorePath(digest.FromBytes(content))
+	if err := os.Rename(blob, stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(stored, blob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Update(t.Context(), snapshots.Info{Name: "layer"}, "labels."+LabelLayerDigest); err != nil {
+		t.Fatal(err)
+	}
+
+	s.pruneContentStore(t.Context())
+	if _, err := os.Stat(stored); err != nil {
+		t.Errorf("expected symlinked blob to be kept: %v", err)
+	}
+}
+
+func TestMigrateToContentStore(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	id := commitMetadataLayer(t, s, "base", "", []byte("base"))
+	wrong := commitMetadataLayer(t, s, "mislabeled", "", []byte("mislabeled"))
+	if _, err := s.Update(t.Context(), snapshots.Info{
+		Name:   "mislabeled",
+		Labels: map[string]string{LabelLayerDigest: digest.FromString("other").String()},
+	}, "labels."+LabelLayerDigest); err != nil {
+		t.Fatal(err)
+	}
+
+	s.contentStore = filepath.Join(s.root, "store")
+	if err := os.MkdirAll(s.contentStore, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	s.migrateToContentStore(t.Context())
+
+	assertSameFile(t, s.fallbackLayerBlobPath(id), s.contentStorePath(digest.FromString("base")))
+	if _, err := os.Stat(s.contentStorePath(digest.FromString("other"))); !os.IsNotExist(err) {
+		t.Errorf("expected mislabeled layer not to be stored, got %v", err)
+	}
+	if _, err := os.Stat(s.fallbackLayerBlobPath(wrong)); err != nil {
+		t.Errorf("expected mislabeled layer to keep its blob: %v", err)
+	}
+}
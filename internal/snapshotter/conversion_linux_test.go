@@ -0,0 +1,105 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/erofs"
+	"github.com/spin-stack/erofs-snapshotter/internal/testutil"
+)
+
+// commitUpperLayer commits an active snapshot whose upper directory holds
+// a single file, converting it with mkfs.erofs.
+func commitUpperLayer(t *testing.T, s *snapshotter, name, parent string) string {
+	t.Helper()
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, name+"-active", parent)
+	if err := os.MkdirAll(s.upperPath(snap.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.upperPath(snap.ID), name), []byte(name), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(t.Context(), name, name+"-active"); err != nil {
+		t.Fatalf("commit %s: %v", name, err)
+	}
+	return snap.ID
+}
+
+func TestCommitAndFsMetaWithMkfs(t *testing.T) {
+	testutil.InstallFakeMkfs(t)
+	s := newMetadataOnlySnapshotter(t)
+	s.mkfsOpts = []string{"-b4096"}
+	s.erofsBlockSize = 4096
+
+	base := commitUpperLayer(t, s, "base", "")
+	top := commitUpperLayer(t, s, "top", "base")
+	for _, id := range []string{base, top} {
+		blob := s.fallbackLayerBlobPath(id)
+		if bs, err := erofs.GetBlockSize(blob); err != nil || bs != 4096 {
+			t.Errorf("layer %s: GetBlockSize = %d, %v", id, bs, err)
+		}
+		if entries, err := os.ReadDir(s.upperPath(id)); err != nil || len(entries) != 0 {
+			t.Errorf("layer %s: expected the converted upper to be emptied, got %v, %v", id, entries, err)
+		}
+	}
+
+	s.generateFsMeta(t.Context(), []string{top, base}, nil)
+	layers, err := ParseVMDK(s.vmdkPath(top))
+	if err != nil {
+		t.Fatalf("ParseVMDK: %v", err)
+	}
+	want := []string{s.fsMetaPath(top), s.fallbackLayerBlobPath(base), s.fallbackLayerBlobPath(top)}
+	if len(layers) != len(want) {
+		t.Fatalf("expected %d VMDK extents, got %+v", len(want), layers)
+	}
+	for i, l := range layers {
+		if l.Path != want[i] {
+			t.Errorf("extent %d: got %s, want %s", i, l.Path, want[i])
+		}
+	}
+
+	snap := storage.Snapshot{ID: "view", Kind: snapshots.KindView, ParentIDs: []string{top, base}}
+	m, ok := s.mountFsMeta(snap, nil)
+	if !ok || m.Source != s.fsMetaPath(top) {
+		t.Errorf("expected fsmeta mount, got %+v, %v", m, ok)
+	}
+}
+
+func TestFsMetaWithTailPacking(t *testing.T) {
+	testutil.InstallFakeMkfs(t)
+	s := newMetadataOnlySnapshotter(t)
+	s.mkfsOpts = []string{"-b4096", erofs.TailPackingOpt}
+	s.erofsBlockSize = 4096
+	s.erofsTailPacking = true
+
+	base := commitUpperLayer(t, s, "base", "")
+	top := commitUpperLayer(t, s, "top", "base")
+	for _, name := range []string{"base", "top"} {
+		info, err := s.Stat(t.Context(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Labels[LabelTailPacking] != "true" {
+			t.Errorf("%s: expected %s", name, LabelTailPacking)
+		}
+	}
+
+	s.generateFsMeta(t.Context(), []string{top, base}, nil)
+	layers, err := ParseVMDK(s.vmdkPath(top))
+	if err != nil {
+		t.Fatalf("ParseVMDK: %v", err)
+	}
+	if len(layers) != 3 {
+		t.Fatalf("expected 3 VMDK extents, got %+v", layers)
+	}
+	snap := storage.Snapshot{ID: "view", Kind: snapshots.KindView, ParentIDs: []string{top, base}}
+	if m, ok := s.mountFsMeta(snap, nil); !ok || m.Source != s.fsMetaPath(top) {
+		t.Errorf("expected fsmeta mount for a tail-packed chain, got %+v, %v", m, ok)
+	}
+}
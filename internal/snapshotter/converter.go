@@ -0,0 +1,74 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/erofs"
+	"github.com/spin-stack/erofs-snapshotter/internal/stringutil"
+)
+
+// Converter builds EROFS layer blobs from directories. The default runs
+// mkfs.erofs; WithConverter replaces it, for example with a Go-native or
+// containerized implementation.
+type Converter interface {
+	// Convert builds the EROFS image dest from the contents of srcDir,
+	// keeping overlayfs whiteouts and opaque markers. opts are mkfs.erofs
+	// options such as "-zlz4hc" or "-b4096"; an implementation that cannot
+	// honor one must fail rather than ignore it.
+	Convert(ctx context.Context, dest, srcDir string, opts []string) error
+}
+
+// FsMetaMerger is implemented by Converters that can also build the merged
+// fsmeta image of a layer chain. Without it, fsmeta is generated with
+// mkfs.erofs.
+type FsMetaMerger interface {
+	// MergeFsMeta writes to dest a metadata-only EROFS image referencing
+	// blobs (oldest first) as external devices, and to vmdk a VMDK
+	// descriptor exposing dest followed by blobs as one disk. opts are
+	// mkfs.erofs options, as for Convert.
+	MergeFsMeta(ctx context.Context, dest, vmdk string, blobs []string, opts []string) error
+}
+
+// mkfsConverter is the default Converter and FsMetaMerger, running
+// mkfs.erofs.
+type mkfsConverter struct {
+	// scratchDir, if set, is used as mkfs.erofs's TMPDIR for merges.
+	scratchDir string
+}
+
+func (mkfsConverter) Convert(ctx context.Context, dest, srcDir string, opts []string) error {
+	return erofs.ConvertErofs(ctx, dest, srcDir, opts)
+}
+
+func (c mkfsConverter) MergeFsMeta(ctx context.Context, dest, vmdk string, blobs []string, opts []string) error {
+	args := append([]string{"--quiet", "--vmdk-desc=" + vmdk}, opts...)
+	args = append(append(args, dest), blobs...)
+	cmd := exec.CommandContext(ctx, "mkfs.erofs", args...)
+	if c.scratchDir != "" {
+		cmd.Env = append(os.Environ(), "TMPDIR="+c.scratchDir)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("mkfs.erofs failed: %s: %w", stringutil.TruncateOutput(out, 256), err)
+	}
+	return nil
+}
+
+// erofsConverter returns the Converter set by WithConverter, or mkfs.erofs.
+func (s *snapshotter) erofsConverter() Converter {
+	if s.converter != nil {
+		return s.converter
+	}
+	return mkfsConverter{scratchDir: s.scratchDirPath()}
+}
+
+// fsMetaMerger returns the Converter set by WithConverter if it is also an
+// FsMetaMerger, or mkfs.erofs.
+func (s *snapshotter) fsMetaMerger() FsMetaMerger {
+	if m, ok := s.converter.(FsMetaMerger); ok {
+		return m
+	}
+	return mkfsConverter{scratchDir: s.scratchDirPath()}
+}
@@ -0,0 +1,201 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+)
+
+// fakeConverter records its calls and writes placeholder images, standing
+// in for mkfs.erofs.
+type fakeConverter struct {
+	image     []byte // written by Convert, "erofs" if nil
+	converted []string
+	opts      [][]string
+	merged    [][]string
+}
+
+func (c *fakeConverter) Convert(_ context.Context, dest, srcDir string, opts []string) error {
+	c.converted = append(c.converted, srcDir)
+	c.opts = append(c.opts, opts)
+	if c.image != nil {
+		return os.WriteFile(dest, c.image, 0o644)
+	}
+	return os.WriteFile(dest, []byte("erofs"), 0o644)
+}
+
+func (c *fakeConverter) MergeFsMeta(_ context.Context, dest, vmdk string, blobs []string, _ []string) error {
+	c.merged = append(c.merged, blobs)
+	if err := os.WriteFile(dest, []byte("fsmeta"), 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(vmdk, []byte("RW 8 FLAT \""+dest+"\" 0\n"), 0o644)
+}
+
+func TestConverterConvert(t *testing.T) {
+	c := &fakeConverter{}
+	s := &snapshotter{root: t.TempDir(), converter: c}
+	upper := filepath.Join(s.root, "upper")
+	if err := os.MkdirAll(filepath.Join(upper, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	blob := filepath.Join(s.root, "layer.erofs")
+
+	if err := s.convertDirToErofs(t.Context(), blob, upper, ""); err != nil {
+		t.Fatalf("convertDirToErofs: %v", err)
+	}
+	if !slices.Equal(c.converted, []string{upper}) {
+		t.Errorf("expected one conversion of %s, got %v", upper, c.converted)
+	}
+	if _, err := os.Stat(blob); err != nil {
+		t.Errorf("expected converted blob: %v", err)
+	}
+}
+
+func TestConverterMergeFsMeta(t *testing.T) {
+	// A minimal EROFS superblock, up to its UUID, with 4KiB blocks, so the
+	// chain can merge.
+	blob := make([]byte, 1024+64)
+	copy(blob[1024:], []byte{0xe2, 0xe1, 0xf5, 0xe0})
+	blob[1024+12] = 12
+
+	c := &fakeConverter{}
+	s := newMetadataOnlySnapshotter(t)
+	s.converter = c
+	base := commitMetadataLayer(t, s, "base", "", blob)
+	top := commitMetadataLayer(t, s, "top", "base", blob)
+
+	s.generateFsMeta(t.Context(), []string{top, base}, nil)
+	want := []string{s.fallbackLayerBlobPath(base), s.fallbackLayerBlobPath(top)}
+	if len(c.merged) != 1 || !slices.Equal(c.merged[0], want) {
+		t.Fatalf("expected one merge of %v, got %v", want, c.merged)
+	}
+	if _, err := os.Stat(s.fsMetaPath(top)); err != nil {
+		t.Errorf("expected fsmeta: %v", err)
+	}
+	if _, err := os.Stat(s.vmdkPath(top)); err != nil {
+		t.Errorf("expected VMDK: %v", err)
+	}
+}
+
+func TestGenerateFsMetaWithDigestNamer(t *testing.T) {
+	blob := make([]byte, 1024+64)
+	copy(blob[1024:], []byte{0xe2, 0xe1, 0xf5, 0xe0})
+	blob[1024+12] = 12
+
+	c := &fakeConverter{image: blob}
+	s := newMetadataOnlySnapshotter(t)
+	s.converter = c
+	// Digest-named blobs can only be found through LabelLayerBlobPath.
+	s.blobNamer = func(id string, dgst digest.Digest) string {
+		if dgst == "" {
+			return DefaultBlobNamer(id, dgst)
+		}
+		return "layer-" + dgst.Encoded()[:12] + ".erofs"
+	}
+
+	commit := func(name, parent string) string {
+		snap := createMetadataSnapshot(t, s, snapshots.KindActive, name+"-active", parent)
+		if err := os.MkdirAll(s.upperPath(snap.ID), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		dgst := digest.FromString(name)
+		if err := s.Commit(t.Context(), name, name+"-active", WithTargetBlobDigest(dgst)); err != nil {
+			t.Fatalf("commit %s: %v", name, err)
+		}
+		return snap.ID
+	}
+	base := commit("base", "")
+	top := commit("top", "base")
+
+	var blobs layerBlobIndex
+	if err := s.ms.WithTransaction(t.Context(), false, func(ctx context.Context) (err error) {
+		blobs, err = parentLayerBlobs(ctx, snapshots.Info{Parent: "top"})
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.generateFsMeta(t.Context(), []string{top, base}, blobs)
+	want := []string{
+		filepath.Join(s.snapshotDir(base), s.blobNamer(base, digest.FromString("base"))),
+		filepath.Join(s.snapshotDir(top), s.blobNamer(top, digest.FromString("top"))),
+	}
+	if len(c.merged) != 1 || !slices.Equal(c.merged[0], want) {
+		t.Fatalf("expected one merge of %v, got %v", want, c.merged)
+	}
+	if _, err := os.Stat(s.fsMetaPath(top)); err != nil {
+		t.Errorf("expected fsmeta: %v", err)
+	}
+	if _, err := os.Stat(s.vmdkPath(top)); err != nil {
+		t.Errorf("expected VMDK: %v", err)
+	}
+}
+
+func TestNeedsMkfsErofs(t *testing.T) {
+	if !(&SnapshotterConfig{}).needsMkfsErofs() {
+		t.Error("expected the default converter to need mkfs.erofs")
+	}
+	if (&SnapshotterConfig{converter: &fakeConverter{}}).needsMkfsErofs() {
+		t.Error("expected a converter that merges fsmeta not to need mkfs.erofs")
+	}
+}
+
+func TestCommitCompressionOverride(t *testing.T) {
+	c := &fakeConverter{}
+	s := newMetadataOnlySnapshotter(t)
+	s.converter = c
+	s.mkfsOpts = []string{"-b4096", "-zlz4"}
+
+	commit := func(name, parent string, opts ...snapshots.Opt) error {
+		snap := createMetadataSnapshot(t, s, snapshots.KindActive, name+"-active", parent)
+		if err := os.MkdirAll(s.upperPath(snap.ID), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return s.Commit(t.Context(), name, name+"-active", opts...)
+	}
+	if err := commit("base", "", WithCommitCompression("lzma")); err != nil {
+		t.Fatalf("commit base: %v", err)
+	}
+	if err := commit("top", "base", WithCommitCompression("lz4hc,level=3")); err != nil {
+		t.Fatalf("commit top: %v", err)
+	}
+	if err := commit("plain", "top"); err != nil {
+		t.Fatalf("commit plain: %v", err)
+	}
+
+	want := [][]string{{"-b4096", "-zlzma"}, {"-b4096", "-zlz4hc,level=3"}, {"-b4096", "-zlz4"}}
+	if !slices.EqualFunc(c.opts, want, slices.Equal) {
+		t.Errorf("mkfs options = %v, want %v", c.opts, want)
+	}
+	if !slices.Equal(s.mkfsOpts, []string{"-b4096", "-zlz4"}) {
+		t.Errorf("global mkfs options changed to %v", s.mkfsOpts)
+	}
+	for name, algo := range map[string]string{"base": "lzma", "top": "lz4hc,level=3", "plain": "lz4"} {
+		info, err := s.Stat(t.Context(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Labels[LabelCompression]; got != algo {
+			t.Errorf("%s: %s = %q, want %q", name, LabelCompression, got, algo)
+		}
+		if _, ok := info.Labels[LabelCommitCompression]; ok {
+			t.Errorf("%s: expected %s to be dropped on commit", name, LabelCommitCompression)
+		}
+	}
+
+	if err := commit("bad", "plain", WithCommitCompression("gzip")); !errdefs.IsInvalidArgument(err) {
+		t.Errorf("expected invalid argument for an unsupported compressor, got %v", err)
+	}
+	if len(c.opts) != 3 {
+		t.Errorf("expected no conversion for an invalid override, got %d", len(c.opts))
+	}
+}
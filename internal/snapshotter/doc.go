@@ -41,7 +41,7 @@
 //
 //	format/erofs  - Multi-layer with fsmeta/VMDK (VM runtimes only)
 //	erofs         - Single EROFS layer
-//	ext4          - Writable layer for active snapshots
+//	ext4          - Writable layer for active snapshots, and named volumes
 //	bind          - Bind mount for extract snapshots and empty views
 //
 // The "format/erofs" type signals VM-only mounts. Containerd's standard
@@ -86,13 +86,21 @@
 //	├── .erofslayer       # Marker: EROFS-managed snapshot (for differ)
 //	├── fs/               # Overlay upper directory (overlay mode)
 //	├── rwlayer.img       # ext4 writable layer file (block mode only)
+//	├── volume-{name}.img # Named ext4 writable volumes (LabelVolumePrefix)
 //	├── rw/               # Mount point for rwlayer.img
 //	│   └── upper/        # Actual upper directory in block mode
 //	├── layer.erofs       # Committed EROFS layer (digest or fallback named)
 //	├── fsmeta.erofs      # Merged metadata for multi-layer (async generated)
 //	├── merged.vmdk       # VMDK descriptor for QEMU (async generated)
+//	├── fsmeta.devices    # Layer UUIDs in VMDK order (checked before fsmeta mounts)
 //	└── layers.manifest   # Layer digests in VMDK order (for verification)
 //
+// With WithRetainRemoved, removed active snapshot directories are moved to
+// graveyard/{removal-unix-nanos}-{id}/ under the root instead of deleted.
+//
+// With WithContentStore, layer.erofs is a hard link to, or a symlink into,
+// {store}/sha256-{hex}.erofs, shared by every layer with that digest.
+//
 // # Concurrency
 //
 // Multiple goroutines may try to generate fsmeta for the same parent chain.
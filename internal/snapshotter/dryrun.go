@@ -0,0 +1,111 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+)
+
+// CleanupPlan describes the filesystem changes Cleanup or Remove would make.
+type CleanupPlan struct {
+	// Directories are the snapshot directories that would be removed.
+	Directories []string
+	// Retained are the snapshot directories that would be moved to the
+	// graveyard instead (see WithRetainRemoved).
+	Retained []string
+	// ImmutableBlobs are EROFS blobs whose IMMUTABLE_FL would be cleared.
+	// Blobs shared through the content store keep the flag.
+	ImmutableBlobs []string
+}
+
+// DryRunner is implemented by snapshotters that can report what Cleanup and
+// Remove would delete without mutating anything.
+type DryRunner interface {
+	CleanupDryRun(ctx context.Context) (CleanupPlan, error)
+	RemoveDryRun(ctx context.Context, key string) (CleanupPlan, error)
+}
+
+// errDryRun aborts a write transaction so that its changes are rolled back.
+var errDryRun = errors.New("dry run")
+
+// CleanupDryRun returns the directories Cleanup would remove and the blobs
+// whose immutable flag it would clear. Nothing is modified.
+func (s *snapshotter) CleanupDryRun(ctx context.Context) (CleanupPlan, error) {
+	var removals []string
+	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		var err error
+		removals, err = s.getCleanupDirectories(ctx)
+		return err
+	}); err != nil {
+		return CleanupPlan{}, err
+	}
+	return planForDirectories(removals, nil), nil
+}
+
+// RemoveDryRun returns what Remove(key) would delete. The metadata removal is
+// performed in a write transaction that is always rolled back, and the plan
+// is built with the same checks Remove uses, so it reflects exactly what
+// Remove would do. Nothing is modified.
+func (s *snapshotter) RemoveDryRun(ctx context.Context, key string) (CleanupPlan, error) {
+	var plan CleanupPlan
+	err := s.ms.WithTransaction(ctx, true, func(ctx context.Context) error {
+		if err := checkNotPinned(ctx, key); err != nil {
+			return err
+		}
+
+		_, info, _, err := storage.GetInfo(ctx, key)
+		if err != nil {
+			return fmt.Errorf("remove snapshot %s: %w", key, err)
+		}
+		id, k, err := storage.Remove(ctx, key)
+		if err != nil {
+			return fmt.Errorf("remove snapshot %s: %w", key, err)
+		}
+
+		removals, err := s.getCleanupDirectories(ctx)
+		if err != nil {
+			return fmt.Errorf("get directories for removal: %w", err)
+		}
+		dir := s.snapshotDir(id)
+		var retained []string
+		if retainOnRemove(k, info) && s.retainable(id) {
+			removals = slices.DeleteFunc(removals, func(d string) bool { return d == dir })
+			retained = []string{dir}
+		}
+		plan = planForDirectories(removals, retained)
+
+		// Remove clears the flag on a committed snapshot's blobs even
+		// though its directory is only reclaimed later by Cleanup.
+		if k == snapshots.KindCommitted && !slices.Contains(removals, dir) {
+			for _, blob := range unsharedErofsBlobs(dir) {
+				if isImmutable(blob) {
+					plan.ImmutableBlobs = append(plan.ImmutableBlobs, blob)
+				}
+			}
+		}
+		return errDryRun
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return CleanupPlan{}, err
+	}
+	return plan, nil
+}
+
+// planForDirectories builds a plan for removing dirs, including any
+// immutable EROFS blobs inside them, and retaining the directories in
+// retained.
+func planForDirectories(dirs, retained []string) CleanupPlan {
+	plan := CleanupPlan{Directories: dirs, Retained: retained}
+	for _, dir := range dirs {
+		for _, blob := range unsharedErofsBlobs(dir) {
+			if isImmutable(blob) {
+				plan.ImmutableBlobs = append(plan.ImmutableBlobs, blob)
+			}
+		}
+	}
+	return plan
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+)
+
+func TestRemoveDryRunListsAllBlobs(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	skipIfNoImmutableSupport(t, s.root)
+
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "layer-active", "")
+	if err := s.ms.WithTransaction(t.Context(), true, func(ctx context.Context) error {
+		_, err := storage.CommitActive(ctx, "layer-active", "layer", snapshots.Usage{})
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(s.snapshotDir(snap.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	blobs := []string{s.fallbackLayerBlobPath(snap.ID), s.fsMetaPath(snap.ID)}
+	for _, blob := range blobs {
+		if err := os.WriteFile(blob, []byte("erofs"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := setImmutable(blob, true); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = setImmutable(blob, false) })
+	}
+
+	plan, err := s.RemoveDryRun(t.Context(), "layer")
+	if err != nil {
+		t.Fatalf("RemoveDryRun failed: %v", err)
+	}
+	slices.Sort(plan.ImmutableBlobs)
+	slices.Sort(blobs)
+	if !slices.Equal(plan.ImmutableBlobs, blobs) {
+		t.Errorf("ImmutableBlobs = %v, want %v", plan.ImmutableBlobs, blobs)
+	}
+}
@@ -0,0 +1,103 @@
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+func TestCleanupDryRun(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	if err := os.MkdirAll(s.snapshotsDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	if err := os.MkdirAll(s.snapshotDir(snap.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	orphan := filepath.Join(s.snapshotsDir(), "orphan")
+	if err := os.MkdirAll(orphan, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	blob := filepath.Join(orphan, "snapshot-orphan.erofs")
+	if err := os.WriteFile(blob, []byte("fake"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := s.CleanupDryRun(t.Context())
+	if err != nil {
+		t.Fatalf("CleanupDryRun failed: %v", err)
+	}
+	if !slices.Equal(plan.Directories, []string{orphan}) {
+		t.Errorf("Directories = %v, want [%s]", plan.Directories, orphan)
+	}
+	// The blob is not immutable, so no flag would be cleared.
+	if len(plan.ImmutableBlobs) != 0 {
+		t.Errorf("ImmutableBlobs = %v, want none", plan.ImmutableBlobs)
+	}
+
+	// Nothing may have been removed.
+	if _, err := os.Stat(blob); err != nil {
+		t.Errorf("dry run modified the filesystem: %v", err)
+	}
+}
+
+func TestRemoveDryRun(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	if err := os.MkdirAll(s.snapshotsDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	dir := s.snapshotDir(snap.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := s.RemoveDryRun(t.Context(), "active")
+	if err != nil {
+		t.Fatalf("RemoveDryRun failed: %v", err)
+	}
+	if !slices.Equal(plan.Directories, []string{dir}) {
+		t.Errorf("Directories = %v, want [%s]", plan.Directories, dir)
+	}
+
+	// The metadata removal must have been rolled back.
+	if _, err := s.Stat(t.Context(), "active"); err != nil {
+		t.Errorf("snapshot removed by dry run: %v", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("directory removed by dry run: %v", err)
+	}
+
+	if _, err := s.RemoveDryRun(t.Context(), "missing"); err == nil {
+		t.Error("expected error for missing snapshot")
+	}
+}
+
+func TestRemoveDryRunRetained(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.retainRemoved = 1
+
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	dir := s.snapshotDir(snap.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.writablePath(snap.ID), []byte("ext4"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := s.RemoveDryRun(t.Context(), "active")
+	if err != nil {
+		t.Fatalf("RemoveDryRun failed: %v", err)
+	}
+	if len(plan.Directories) != 0 || !slices.Equal(plan.Retained, []string{dir}) {
+		t.Errorf("plan = %+v, want %s retained", plan, dir)
+	}
+}
@@ -1,8 +1,15 @@
 package snapshotter
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
 )
 
 // LayerBlobNotFoundError indicates no EROFS layer blob exists for a snapshot.
@@ -12,15 +19,36 @@ import (
 // Recovery: The commit process will fall back to converting the upper
 // directory using mkfs.erofs directly. Check that the snapshot directory
 // exists and contains the expected upper directory (fs/ or rw/upper/).
+//
+// ExpectedPath is the blob path recorded in LabelLayerBlobPath, or the
+// fallback blob path when no label is set. DirMissing is set when the
+// snapshot directory itself does not exist. Empty is set when the blob at
+// ExpectedPath exists but is zero-length, typically from an interrupted
+// write; such a blob cannot be mounted, so it is treated as missing.
 type LayerBlobNotFoundError struct {
-	SnapshotID string
-	Dir        string
-	Searched   []string
+	SnapshotID   string
+	Dir          string
+	Searched     []string
+	ExpectedPath string
+	DirMissing   bool
+	Empty        bool
 }
 
 func (e *LayerBlobNotFoundError) Error() string {
-	return fmt.Sprintf("layer blob not found for snapshot %s in %s (searched patterns: %s)",
-		e.SnapshotID, e.Dir, strings.Join(e.Searched, ", "))
+	msg := fmt.Sprintf("layer blob not found for snapshot %s in %s", e.SnapshotID, e.Dir)
+	if len(e.Searched) > 0 {
+		msg += fmt.Sprintf(" (searched patterns: %s)", strings.Join(e.Searched, ", "))
+	}
+	if e.ExpectedPath != "" {
+		msg += ", expected " + e.ExpectedPath
+	}
+	if e.DirMissing {
+		msg += ", snapshot directory does not exist"
+	}
+	if e.Empty {
+		msg += ", blob exists but is empty"
+	}
+	return msg
 }
 
 // CommitConversionError indicates EROFS conversion failure during commit.
@@ -45,3 +73,144 @@ func (e *CommitConversionError) Error() string {
 func (e *CommitConversionError) Unwrap() error {
 	return e.Cause
 }
+
+// BlockMountError indicates the ext4 writable layer could not be
+// loop-mounted on the host for extraction or trimming.
+//
+// Common causes:
+//   - Loop devices exhausted or busy (retried with backoff first)
+//   - ext4 kernel module not loaded
+//   - Writable layer image missing or corrupt
+//
+// Errno holds the underlying syscall error when one is present in Cause,
+// so callers can decide whether to retry, free loop devices, or evict
+// without matching on the message.
+type BlockMountError struct {
+	SnapshotID string
+	Source     string
+	Target     string
+	Errno      syscall.Errno
+	Cause      error
+}
+
+// newBlockMountError builds a BlockMountError, capturing the errno from cause.
+func newBlockMountError(id, source, target string, cause error) *BlockMountError {
+	e := &BlockMountError{SnapshotID: id, Source: source, Target: target, Cause: cause}
+	var errno syscall.Errno
+	if errors.As(cause, &errno) {
+		e.Errno = errno
+	}
+	return e
+}
+
+func (e *BlockMountError) Error() string {
+	return fmt.Sprintf("failed to mount block layer %s at %s for snapshot %s: %v",
+		e.Source, e.Target, e.SnapshotID, e.Cause)
+}
+
+func (e *BlockMountError) Unwrap() error {
+	return e.Cause
+}
+
+// IsLoopExhausted reports whether the mount failed because the system ran
+// out of loop devices or file table entries.
+func (e *BlockMountError) IsLoopExhausted() bool {
+	return e.Errno == syscall.ENFILE
+}
+
+// IsNoSpace reports whether the mount failed because the backing filesystem
+// is out of space or quota.
+func (e *BlockMountError) IsNoSpace() bool {
+	return e.Errno == syscall.ENOSPC || e.Errno == syscall.EDQUOT
+}
+
+// QuotaExceededError indicates that creating or committing a snapshot would
+// take a namespace over its quota (see WithNamespaceQuota). It matches
+// errdefs.ErrResourceExhausted.
+//
+// Recovery: remove unused snapshots in the namespace or raise its quota.
+type QuotaExceededError struct {
+	Namespace string
+	// Used is the namespace's usage in bytes before the operation.
+	Used int64
+	// Requested is the number of bytes the operation would add.
+	Requested int64
+	Limit     int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("namespace %q quota exceeded: %d bytes used, %d requested, limit %d",
+		e.Namespace, e.Used, e.Requested, e.Limit)
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return errdefs.ErrResourceExhausted
+}
+
+// TimeoutError indicates that mkfs.erofs or mkfs.ext4 ran longer than the
+// limit set by WithMkfsTimeout and was killed. Its partial output has been
+// removed. It matches errdefs.ErrDeadlineExceeded, unlike a failure
+// reported by mkfs itself.
+//
+// Recovery: check the health of the snapshotter's storage or raise the
+// timeout; the operation can be retried.
+type TimeoutError struct {
+	// Command is the mkfs binary that was killed.
+	Command string
+	// Output is the image the command was writing.
+	Output  string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s writing %s timed out after %s", e.Command, e.Output, e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// OperationTimeoutError indicates that Prepare, View, Commit or Mounts ran
+// longer than the limit set by WithTimeouts. Directories, mounts and mkfs
+// output left by the operation have been removed. It matches
+// context.DeadlineExceeded and errdefs.ErrDeadlineExceeded, and wraps the
+// error the operation failed with.
+//
+// Recovery: check the health of the snapshotter's storage and loop devices
+// or raise the timeout; the operation can be retried.
+type OperationTimeoutError struct {
+	// Operation is "prepare", "view", "commit" or "mount".
+	Operation string
+	Key       string
+	Timeout   time.Duration
+	Err       error
+}
+
+func (e *OperationTimeoutError) Error() string {
+	return fmt.Sprintf("%s %s timed out after %s: %v", e.Operation, e.Key, e.Timeout, e.Err)
+}
+
+func (e *OperationTimeoutError) Unwrap() []error {
+	return []error{context.DeadlineExceeded, e.Err}
+}
+
+// IntegrityError indicates that a layer blob's content no longer matches the
+// digest recorded in LabelLayerDigest (see WithVerifyDigestOnMount). It
+// matches errdefs.ErrDataLoss.
+//
+// Recovery: remove the snapshot and its dependents and pull the image again.
+type IntegrityError struct {
+	SnapshotID string
+	Path       string
+	Expected   digest.Digest
+	Actual     digest.Digest
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("layer blob %s of snapshot %s failed integrity check: expected %s, got %s",
+		e.Path, e.SnapshotID, e.Expected, e.Actual)
+}
+
+func (e *IntegrityError) Unwrap() error {
+	return errdefs.ErrDataLoss
+}
@@ -2,8 +2,13 @@ package snapshotter
 
 import (
 	"errors"
+	"fmt"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/containerd/errdefs"
 )
 
 func TestLayerBlobNotFoundError(t *testing.T) {
@@ -64,6 +69,57 @@ func TestCommitConversionError(t *testing.T) {
 	}
 }
 
+func TestBlockMountError(t *testing.T) {
+	cause := fmt.Errorf("setup loop: %w", syscall.EBUSY)
+	err := &BlockMountError{
+		SnapshotID: "snap-42",
+		Source:     "/var/lib/snapshots/42/rwlayer.img",
+		Target:     "/var/lib/snapshots/42/rw",
+		Cause:      cause,
+	}
+
+	msg := err.Error()
+	if !strings.Contains(msg, "snap-42") || !strings.Contains(msg, "rwlayer.img") {
+		t.Errorf("error message should contain snapshot ID and source: %s", msg)
+	}
+	if !errors.Is(err, syscall.EBUSY) {
+		t.Error("should find errno through error chain")
+	}
+}
+
+func TestBlockMountErrorErrno(t *testing.T) {
+	tests := []struct {
+		name          string
+		cause         error
+		errno         syscall.Errno
+		loopExhausted bool
+		noSpace       bool
+	}{
+		{"busy", fmt.Errorf("mount: %w", syscall.EBUSY), syscall.EBUSY, false, false},
+		{"loop exhausted", fmt.Errorf("LOOP_CTL_GET_FREE failed: %w", syscall.ENFILE), syscall.ENFILE, true, false},
+		{"no space", fmt.Errorf("mount: %w", syscall.ENOSPC), syscall.ENOSPC, false, true},
+		{"quota", syscall.EDQUOT, syscall.EDQUOT, false, true},
+		{"no errno", errors.New("exit status 32"), 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newBlockMountError("snap-1", "/src", "/dst", tt.cause)
+			if err.Errno != tt.errno {
+				t.Errorf("Errno = %v, want %v", err.Errno, tt.errno)
+			}
+			if got := err.IsLoopExhausted(); got != tt.loopExhausted {
+				t.Errorf("IsLoopExhausted() = %v, want %v", got, tt.loopExhausted)
+			}
+			if got := err.IsNoSpace(); got != tt.noSpace {
+				t.Errorf("IsNoSpace() = %v, want %v", got, tt.noSpace)
+			}
+			if err.Unwrap() != tt.cause {
+				t.Error("Unwrap should return the cause")
+			}
+		})
+	}
+}
+
 func TestErrorWrapping(t *testing.T) {
 	// Test error wrapping through CommitConversionError
 	rootCause := errors.New("disk full")
@@ -94,3 +150,53 @@ func TestErrorWrapping(t *testing.T) {
 		t.Error("should find root cause through error chain")
 	}
 }
+
+func TestQuotaExceededError(t *testing.T) {
+	err := &QuotaExceededError{Namespace: "default", Used: 900, Requested: 200, Limit: 1000}
+
+	msg := err.Error()
+	if !strings.Contains(msg, `"default"`) || !strings.Contains(msg, "1000") {
+		t.Errorf("error message should contain namespace and limit: %s", msg)
+	}
+	if !errdefs.IsResourceExhausted(err) {
+		t.Error("should match errdefs.ErrResourceExhausted")
+	}
+}
+
+func TestIntegrityError(t *testing.T) {
+	err := &IntegrityError{SnapshotID: "3", Path: "/blob.erofs", Expected: "sha256:aaa", Actual: "sha256:bbb"}
+
+	msg := err.Error()
+	if !strings.Contains(msg, "sha256:aaa") || !strings.Contains(msg, "sha256:bbb") {
+		t.Errorf("error message should contain both digests: %s", msg)
+	}
+	if !errdefs.IsDataLoss(err) {
+		t.Error("should match errdefs.ErrDataLoss")
+	}
+}
+
+func TestTimeoutError(t *testing.T) {
+	err := &TimeoutError{Command: "mkfs.erofs", Output: "/blob.erofs", Timeout: time.Minute}
+
+	if msg := err.Error(); !strings.Contains(msg, "mkfs.erofs") || !strings.Contains(msg, "1m0s") {
+		t.Errorf("error message should contain the command and timeout: %s", msg)
+	}
+	if !errdefs.IsDeadlineExceeded(err) {
+		t.Error("should match errdefs.ErrDeadlineExceeded")
+	}
+}
+
+func TestOperationTimeoutError(t *testing.T) {
+	cause := errors.New("loop device busy")
+	err := &OperationTimeoutError{Operation: "prepare", Key: "k", Timeout: time.Second, Err: cause}
+
+	if msg := err.Error(); !strings.Contains(msg, "prepare k") || !strings.Contains(msg, "1s") || !strings.Contains(msg, "loop device busy") {
+		t.Errorf("error message should contain the operation, key, timeout and cause: %s", msg)
+	}
+	if !errdefs.IsDeadlineExceeded(err) {
+		t.Error("should match errdefs.ErrDeadlineExceeded")
+	}
+	if !errors.Is(err, cause) {
+		t.Error("should wrap the cause")
+	}
+}
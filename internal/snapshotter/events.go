@@ -0,0 +1,115 @@
+package snapshotter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+// defaultEventBuffer is the number of events buffered for a slow consumer
+// before new events are dropped.
+const defaultEventBuffer = 128
+
+// SnapshotOp identifies the operation a SnapshotEvent reports.
+type SnapshotOp string
+
+// Operations reported by SnapshotEvent.
+const (
+	SnapshotOpPrepare SnapshotOp = "prepare"
+	SnapshotOpView    SnapshotOp = "view"
+	SnapshotOpCommit  SnapshotOp = "commit"
+	SnapshotOpRemove  SnapshotOp = "remove"
+)
+
+// SnapshotEvent describes a completed snapshot operation.
+type SnapshotEvent struct {
+	// Op is the operation that completed.
+	Op SnapshotOp
+	// Key is the snapshot key passed to the operation.
+	Key string
+	// ID is the internal snapshot ID. It is empty if the operation failed
+	// before the snapshot was resolved.
+	ID string
+	// Kind is the kind of the snapshot: active or view for Prepare and View,
+	// committed for Commit, and the removed snapshot's kind for Remove.
+	Kind snapshots.Kind
+	// Start is when the operation began.
+	Start time.Time
+	// Duration is how long the operation took.
+	Duration time.Duration
+	// Err is the error returned by the operation, or nil on success.
+	Err error
+}
+
+// EventSource is implemented by snapshotters that publish SnapshotEvents.
+type EventSource interface {
+	Events() <-chan SnapshotEvent
+}
+
+// eventStream is a buffered, non-blocking publisher of SnapshotEvents.
+// Events are dropped when the buffer is full so that a slow consumer never
+// stalls snapshot operations.
+//
+// A nil *eventStream is valid and publishes nothing.
+type eventStream struct {
+	mu     sync.RWMutex
+	ch     chan SnapshotEvent
+	closed bool
+}
+
+func newEventStream(size int) *eventStream {
+	return &eventStream{ch: make(chan SnapshotEvent, size)}
+}
+
+// emit publishes ev, dropping it if the buffer is full or the stream is closed.
+func (e *eventStream) emit(ev SnapshotEvent) {
+	if e == nil {
+		return
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.ch <- ev:
+	default:
+	}
+}
+
+// close closes the channel. Later calls to emit are no-ops.
+func (e *eventStream) close() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.closed {
+		e.closed = true
+		close(e.ch)
+	}
+}
+
+// Events returns a channel of completed Prepare, View, Commit and Remove
+// operations. Events are dropped when the consumer falls behind. The
+// channel is closed by Close.
+func (s *snapshotter) Events() <-chan SnapshotEvent {
+	if s.events == nil {
+		return nil
+	}
+	return s.events.ch
+}
+
+// emitEvent publishes an event for an operation that began at start.
+func (s *snapshotter) emitEvent(op SnapshotOp, key, id string, kind snapshots.Kind, start time.Time, err error) {
+	s.events.emit(SnapshotEvent{
+		Op:       op,
+		Key:      key,
+		ID:       id,
+		Kind:     kind,
+		Start:    start,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+}
@@ -0,0 +1,93 @@
+package snapshotter
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+)
+
+func TestEventStreamDropsWhenFull(t *testing.T) {
+	e := newEventStream(1)
+	e.emit(SnapshotEvent{Key: "first"})
+	e.emit(SnapshotEvent{Key: "second"}) // must not block
+
+	if ev := <-e.ch; ev.Key != "first" {
+		t.Errorf("expected buffered event %q, got %q", "first", ev.Key)
+	}
+	select {
+	case ev := <-e.ch:
+		t.Errorf("expected dropped event, got %+v", ev)
+	default:
+	}
+}
+
+func TestEventStreamClose(t *testing.T) {
+	e := newEventStream(1)
+	e.close()
+	e.close()
+	e.emit(SnapshotEvent{}) // must not panic after close
+
+	if _, ok := <-e.ch; ok {
+		t.Error("expected channel to be closed")
+	}
+
+	var nilStream *eventStream
+	nilStream.emit(SnapshotEvent{})
+	nilStream.close()
+}
+
+func TestRemoveEmitsEvent(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.events = newEventStream(defaultEventBuffer)
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	if err := os.MkdirAll(s.snapshotDir(snap.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Remove(t.Context(), "active"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	ev := <-s.Events()
+	if ev.Op != SnapshotOpRemove || ev.Key != "active" || ev.ID != snap.ID || ev.Kind != snapshots.KindActive {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if ev.Err != nil || ev.Start.IsZero() || ev.Duration < 0 {
+		t.Errorf("unexpected event timing or error %+v", ev)
+	}
+
+	err := s.Remove(t.Context(), "missing")
+	if !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	ev = <-s.Events()
+	if ev.Op != SnapshotOpRemove || ev.Key != "missing" || ev.Err == nil {
+		t.Errorf("expected failed remove event, got %+v", ev)
+	}
+}
+
+func TestCommitEmitsEventOnFailure(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.events = newEventStream(defaultEventBuffer)
+
+	if err := s.Commit(t.Context(), "name", "missing"); err == nil {
+		t.Fatal("expected commit of missing snapshot to fail")
+	}
+	select {
+	case ev := <-s.Events():
+		if ev.Op != SnapshotOpCommit || ev.Key != "missing" || ev.ID != "" || ev.Err == nil {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no commit event emitted")
+	}
+}
+
+func TestEventsNilStream(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	if s.Events() != nil {
+		t.Error("expected nil channel without an event stream")
+	}
+}
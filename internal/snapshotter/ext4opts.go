@@ -0,0 +1,43 @@
+package snapshotter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/errdefs"
+)
+
+// reservedExt4MountOptions are set by the snapshotter on every writable
+// layer mount and cannot be overridden.
+var reservedExt4MountOptions = []string{"rw", "ro", "loop"}
+
+// unsafeExt4MountOptions trade crash consistency of the writable layer for
+// throughput. They require WithUnsafeExt4MountOptions.
+var unsafeExt4MountOptions = []string{"nobarrier", "barrier=0", "data=writeback", "noload"}
+
+// validateExt4MountOptions checks options set with WithExt4MountOptions.
+func validateExt4MountOptions(opts []string, allowUnsafe bool) error {
+	for _, o := range opts {
+		switch {
+		case o == "" || strings.Contains(o, ","):
+			return fmt.Errorf("ext4 mount option %q must be a single option: %w", o, errdefs.ErrInvalidArgument)
+		case slices.Contains(reservedExt4MountOptions, o) || strings.HasPrefix(o, "loop="):
+			return fmt.Errorf("ext4 mount option %q conflicts with the writable layer's rw,loop mount: %w", o, errdefs.ErrInvalidArgument)
+		case slices.Contains(unsafeExt4MountOptions, o) && !allowUnsafe:
+			return fmt.Errorf("ext4 mount option %q can lose writable layer data on a host crash and requires WithUnsafeExt4MountOptions: %w", o, errdefs.ErrInvalidArgument)
+		}
+	}
+	return nil
+}
+
+// writableMount returns the mount of the ext4 writable layer of snapshot id,
+// with any options set by WithExt4MountOptions.
+func (s *snapshotter) writableMount(id string) mount.Mount {
+	return mount.Mount{
+		Source:  s.writablePath(id),
+		Type:    "ext4",
+		Options: append([]string{"rw", "loop"}, s.ext4MountOpts...),
+	}
+}
@@ -0,0 +1,72 @@
+package snapshotter
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+)
+
+func TestValidateExt4MountOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []string
+		allowUnsafe bool
+		wantErr     bool
+	}{
+		{name: "none"},
+		{name: "safe", opts: []string{"commit=60", "noatime"}},
+		{name: "ro", opts: []string{"ro"}, wantErr: true},
+		{name: "rw", opts: []string{"rw"}, wantErr: true},
+		{name: "loop device", opts: []string{"loop=/dev/loop0"}, wantErr: true},
+		{name: "joined", opts: []string{"noatime,commit=60"}, wantErr: true},
+		{name: "empty", opts: []string{""}, wantErr: true},
+		{name: "unsafe", opts: []string{"nobarrier"}, wantErr: true},
+		{name: "unsafe acknowledged", opts: []string{"nobarrier", "data=writeback"}, allowUnsafe: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateExt4MountOptions(tt.opts, tt.allowUnsafe)
+			if tt.wantErr {
+				if !errdefs.IsInvalidArgument(err) {
+					t.Errorf("expected invalid argument, got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestExt4MountOptionsPropagate(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.ext4MountOpts = []string{"commit=60", "noatime"}
+	want := []string{"rw", "loop", "commit=60", "noatime"}
+
+	check := func(site string, m mount.Mount) {
+		t.Helper()
+		if m.Type != testMountExt4 || !slices.Equal(m.Options, want) {
+			t.Errorf("%s: got %s mount with options %v, want ext4 with %v", site, m.Type, m.Options, want)
+		}
+	}
+
+	// The mount used for extraction on the host.
+	check("writableMount", s.writableMount("1"))
+
+	single := createMetadataSnapshot(t, s, snapshots.KindActive, "single", "")
+	mounts, err := s.singleLayerMounts(single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("singleLayerMounts", mounts[len(mounts)-1])
+
+	commitMetadataLayer(t, s, "base", "", []byte("base"))
+	active := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "base")
+	mounts, err = s.activeMounts(active, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("activeMounts", mounts[len(mounts)-1])
+}
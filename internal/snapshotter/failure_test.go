@@ -8,6 +8,9 @@ import (
 	"testing"
 
 	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/testutil"
 )
 
 // TestLayerBlobNotFoundErrorAs verifies errors.As works correctly for type matching.
@@ -201,6 +204,97 @@ func TestFindLayerBlobDigestPriority(t *testing.T) {
 	}
 }
 
+// TestFindLayerBlobCustomNamer verifies blobs named by a custom namer are
+// found, and blobs named by the default namer still are.
+func TestFindLayerBlobCustomNamer(t *testing.T) {
+	root := t.TempDir()
+	s := &snapshotter{
+		root:      root,
+		blobNamer: func(id string, _ digest.Digest) string { return "layer-" + id + ".erofs" },
+	}
+
+	for _, id := range []string{"custom", "legacy"} {
+		if err := os.MkdirAll(filepath.Join(root, "snapshots", id), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	customBlob := s.fallbackLayerBlobPath("custom")
+	if filepath.Base(customBlob) != "layer-custom.erofs" {
+		t.Fatalf("unexpected fallback path %q", customBlob)
+	}
+	legacyBlob := filepath.Join(root, "snapshots", "legacy", "snapshot-legacy.erofs")
+	for _, blob := range []string{customBlob, legacyBlob} {
+		if err := os.WriteFile(blob, []byte("fake erofs"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for id, want := range map[string]string{"custom": customBlob, "legacy": legacyBlob} {
+		found, err := s.findLayerBlob(id)
+		if err != nil {
+			t.Fatalf("find %s: %v", id, err)
+		}
+		if found != want {
+			t.Errorf("expected %q, got %q", want, found)
+		}
+	}
+}
+
+func TestValidateBlobNamer(t *testing.T) {
+	dgst := digest.FromString("layer")
+	if got := DefaultBlobNamer("5", ""); got != "snapshot-5.erofs" {
+		t.Errorf("DefaultBlobNamer without digest = %q", got)
+	}
+	if got := DefaultBlobNamer("5", dgst); got != "sha256-"+dgst.Encoded()+".erofs" {
+		t.Errorf("DefaultBlobNamer with digest = %q", got)
+	}
+	if err := validateBlobNamer(DefaultBlobNamer); err != nil {
+		t.Errorf("default namer rejected: %v", err)
+	}
+
+	for _, name := range []string{"", "layer", "sub/layer.erofs", "../layer.erofs", fsmetaFilename} {
+		namer := func(string, digest.Digest) string { return name }
+		if err := validateBlobNamer(namer); err == nil {
+			t.Errorf("expected name %q to be rejected", name)
+		}
+	}
+}
+
+// TestLowerPathEmptyBlob verifies a truncated layer blob is reported as
+// missing instead of being handed to the mount.
+func TestLowerPathEmptyBlob(t *testing.T) {
+	root := t.TempDir()
+	s := &snapshotter{root: root}
+
+	snapshotDir := filepath.Join(root, "snapshots", "empty-test")
+	if err := os.MkdirAll(snapshotDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	blob := filepath.Join(snapshotDir, "snapshot-empty-test.erofs")
+	if err := os.WriteFile(blob, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.lowerPath("empty-test", nil)
+	var nerr *LayerBlobNotFoundError
+	if !errors.As(err, &nerr) {
+		t.Fatalf("expected LayerBlobNotFoundError, got %v", err)
+	}
+	if !nerr.Empty || nerr.ExpectedPath != blob {
+		t.Errorf("expected empty blob %s, got %+v", blob, nerr)
+	}
+	if !strings.Contains(err.Error(), "blob exists but is empty") {
+		t.Errorf("expected error to mention the empty blob, got %q", err)
+	}
+
+	if err := os.WriteFile(blob, []byte("fake erofs"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := s.lowerPath("empty-test", nil); err != nil || found != blob {
+		t.Errorf("expected %s after rewrite, got %q, %v", blob, found, err)
+	}
+}
+
 // TestRemoveWithChildren verifies removing a parent with children fails.
 func TestRemoveWithChildren(t *testing.T) {
 	s := newTestSnapshotter(t)
@@ -343,9 +437,7 @@ func TestPrepareAfterCommit(t *testing.T) {
 
 // TestCleanupRemovesOrphanedDirectories verifies Cleanup removes orphaned snapshot directories.
 func TestCleanupRemovesOrphanedDirectories(t *testing.T) {
-	if !checkBlockModeRequirements(t) {
-		t.Skip("mkfs.ext4 not available")
-	}
+	testutil.InstallFakeMkfs(t)
 
 	root := t.TempDir()
 	ss, err := NewSnapshotter(root, WithDefaultSize(1024*1024))
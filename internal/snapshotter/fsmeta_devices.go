@@ -0,0 +1,66 @@
+package snapshotter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/containerd/log"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/erofs"
+)
+
+// writeDeviceUUIDs records the filesystem UUID of each blob merged into an
+// fsmeta, one per line in device order (oldest first), so that mounts can
+// detect a blob rebuilt after the merge.
+func writeDeviceUUIDs(path string, blobs []string) error {
+	var b strings.Builder
+	for _, blob := range blobs {
+		u, err := erofs.GetUUID(blob)
+		if err != nil {
+			return fmt.Errorf("read uuid of %s: %w", blob, err)
+		}
+		b.WriteString(u.String() + "\n")
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// fsMetaDevicesMatch reports whether blobs, in device order, are the blobs
+// the fsmeta of snapshot id was merged from. The kernel resolves fsmeta
+// extents by device slot, so a rebuilt blob with a different UUID would
+// fail to mount, or mount the wrong data. An fsmeta generated before UUIDs
+// were recorded is assumed to match.
+func (s *snapshotter) fsMetaDevicesMatch(id string, blobs []string) bool {
+	data, err := os.ReadFile(s.fsMetaDevicesPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	if err != nil {
+		log.L.WithError(err).WithField("id", id).Warn("failed to read fsmeta device UUIDs")
+		return false
+	}
+
+	want := strings.Fields(string(data))
+	if len(want) != len(blobs) {
+		log.L.WithFields(log.Fields{
+			"id":       id,
+			"recorded": len(want),
+			"devices":  len(blobs),
+		}).Warn("fsmeta device count mismatch, mounting layers individually")
+		return false
+	}
+	for i, blob := range blobs {
+		u, err := erofs.GetUUID(blob)
+		if err != nil || u.String() != want[i] {
+			log.L.WithError(err).WithFields(log.Fields{
+				"id":       id,
+				"blob":     blob,
+				"expected": want[i],
+				"actual":   u.String(),
+			}).Warn("fsmeta device UUID mismatch, mounting layers individually")
+			return false
+		}
+	}
+	return true
+}
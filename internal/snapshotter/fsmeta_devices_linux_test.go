@@ -0,0 +1,84 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/google/uuid"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/testutil"
+)
+
+// erofsBlobWithUUID returns a minimal EROFS image with 4KiB blocks and the
+// given filesystem UUID.
+func erofsBlobWithUUID(u uuid.UUID) []byte {
+	blob := make([]byte, 1024+64)
+	copy(blob[1024:], []byte{0xe2, 0xe1, 0xf5, 0xe0})
+	blob[1024+12] = 12
+	copy(blob[1024+48:], u[:])
+	return blob
+}
+
+func TestMountFsMetaDeviceUUIDMismatch(t *testing.T) {
+	testutil.InstallFakeMkfs(t)
+
+	s := newMetadataOnlySnapshotter(t)
+	base := commitMetadataLayer(t, s, "base", "", erofsBlobWithUUID(uuid.New()))
+	top := commitMetadataLayer(t, s, "top", "base", erofsBlobWithUUID(uuid.New()))
+	s.generateFsMeta(t.Context(), []string{top, base}, nil)
+
+	recorded, err := os.ReadFile(s.fsMetaDevicesPath(top))
+	if err != nil {
+		t.Fatalf("expected device UUIDs to be recorded: %v", err)
+	}
+	if n := len(strings.Fields(string(recorded))); n != 2 {
+		t.Fatalf("expected 2 recorded UUIDs, got %d", n)
+	}
+
+	snap := storage.Snapshot{ID: "view", Kind: snapshots.KindView, ParentIDs: []string{top, base}}
+	if _, ok := s.mountFsMeta(snap, nil); !ok {
+		t.Fatal("expected fsmeta mount while the blobs are unchanged")
+	}
+
+	// Regenerate the base layer, as a rebuild by mkfs.erofs would, with
+	// the same contents but a new UUID.
+	if err := os.WriteFile(s.fallbackLayerBlobPath(base), erofsBlobWithUUID(uuid.New()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.mountFsMeta(snap, nil); ok {
+		t.Fatal("expected fsmeta mount to be refused after the UUID changed")
+	}
+
+	mounts, err := s.viewMountsForKind(snap, nil)
+	if err != nil {
+		t.Fatalf("viewMountsForKind: %v", err)
+	}
+	for _, m := range mounts {
+		if m.Type == "format/erofs" {
+			t.Errorf("expected individual layer mounts, got %+v", mounts)
+		}
+	}
+}
+
+func TestMountFsMetaWithoutDeviceRecord(t *testing.T) {
+	testutil.InstallFakeMkfs(t)
+
+	s := newMetadataOnlySnapshotter(t)
+	base := commitMetadataLayer(t, s, "base", "", erofsBlobWithUUID(uuid.New()))
+	top := commitMetadataLayer(t, s, "top", "base", erofsBlobWithUUID(uuid.New()))
+	s.generateFsMeta(t.Context(), []string{top, base}, nil)
+
+	// fsmeta generated before device UUIDs were recorded is still used.
+	if err := os.Remove(s.fsMetaDevicesPath(top)); err != nil {
+		t.Fatal(err)
+	}
+	snap := storage.Snapshot{ID: "view", Kind: snapshots.KindView, ParentIDs: []string{top, base}}
+	if _, ok := s.mountFsMeta(snap, nil); !ok {
+		t.Error("expected fsmeta mount without a device record")
+	}
+}
@@ -0,0 +1,99 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+// fsMetaLockStaleAfter is how long a fsmeta lock file may exist before
+// PruneFsMeta assumes the generation holding it crashed.
+const fsMetaLockStaleAfter = 10 * time.Minute
+
+// FsMetaPruner is implemented by snapshotters that can remove fsmeta files
+// left behind by interrupted generations.
+type FsMetaPruner interface {
+	PruneFsMeta(ctx context.Context) (int, error)
+}
+
+// PruneFsMeta removes fsmeta files that block regeneration: zero-byte
+// fsmeta.erofs placeholders (with their VMDK descriptor), leftover .tmp
+// files in snapshot directories and the scratch directory, and lock files
+// older than fsMetaLockStaleAfter. Temporary files of a generation whose
+// lock is still fresh are left alone. Non-empty fsmeta files are never
+// touched. It returns the number of files removed; the fsmeta is rebuilt
+// on the next Prepare or View of the chain.
+func (s *snapshotter) PruneFsMeta(ctx context.Context) (int, error) {
+	entries, err := os.ReadDir(s.snapshotsDir())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("read snapshots directory: %w", err)
+	}
+
+	pruned := 0
+	remove := func(path string) {
+		if err := os.Remove(path); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.G(ctx).WithError(err).WithField("path", path).Warn("failed to prune fsmeta file")
+			}
+			return
+		}
+		log.G(ctx).WithField("path", path).Debug("pruned fsmeta file")
+		pruned++
+	}
+
+	generating := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		id := entry.Name()
+		mergedMeta := s.fsMetaPath(id)
+
+		lockFile := mergedMeta + ".lock"
+		if fi, err := os.Stat(lockFile); err == nil {
+			if time.Since(fi.ModTime()) < fsMetaLockStaleAfter {
+				generating[id] = true
+				continue
+			}
+			remove(lockFile)
+		}
+
+		if fi, err := os.Stat(mergedMeta); err == nil && fi.Size() == 0 {
+			remove(mergedMeta)
+			remove(s.vmdkPath(id))
+			remove(s.fsMetaDevicesPath(id))
+		}
+		remove(mergedMeta + ".tmp")
+		remove(s.vmdkPath(id) + ".tmp")
+	}
+
+	if scratchDir := s.scratchDirPath(); scratchDir != "" {
+		// Scratch files are named "<id>-<name>.tmp" by generateFsMeta.
+		for _, name := range []string{fsmetaFilename, vmdkFilename} {
+			tmps, err := filepath.Glob(filepath.Join(scratchDir, "*-"+name+".tmp"))
+			if err != nil {
+				return pruned, fmt.Errorf("list scratch directory: %w", err)
+			}
+			for _, tmp := range tmps {
+				id := strings.TrimSuffix(filepath.Base(tmp), "-"+name+".tmp")
+				if !generating[id] {
+					remove(tmp)
+				}
+			}
+		}
+	}
+
+	if pruned > 0 {
+		log.G(ctx).WithField("count", pruned).Info("pruned dangling fsmeta files")
+	}
+	return pruned, nil
+}
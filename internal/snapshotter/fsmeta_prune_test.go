@@ -0,0 +1,70 @@
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPruneFsMeta(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.scratchDir = t.TempDir()
+	for _, id := range []string{"1", "2", "3", "4"} {
+		if err := os.MkdirAll(s.snapshotDir(id), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 1: zero-byte placeholder with its descriptor.
+	write(s.fsMetaPath("1"), "")
+	write(s.vmdkPath("1"), "vmdk")
+	// 2: valid fsmeta with a leftover .tmp and a stale lock.
+	write(s.fsMetaPath("2"), "fsmeta")
+	write(s.vmdkPath("2"), "vmdk")
+	write(s.fsMetaPath("2")+".tmp", "partial")
+	write(s.fsMetaPath("2")+".lock", "")
+	stale := time.Now().Add(-2 * fsMetaLockStaleAfter)
+	if err := os.Chtimes(s.fsMetaPath("2")+".lock", stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	// 3: generation in progress.
+	write(s.fsMetaPath("3")+".lock", "")
+	write(s.fsMetaPath("3")+".tmp", "partial")
+	write(filepath.Join(s.scratchDir, "3-"+vmdkFilename+".tmp"), "partial")
+	// 4: crashed generation in the scratch directory.
+	write(filepath.Join(s.scratchDir, "4-"+fsmetaFilename+".tmp"), "partial")
+
+	n, err := s.PruneFsMeta(t.Context())
+	if err != nil {
+		t.Fatalf("PruneFsMeta: %v", err)
+	}
+	if n != 5 {
+		t.Errorf("expected 5 files pruned, got %d", n)
+	}
+
+	for _, p := range []string{
+		s.fsMetaPath("1"), s.vmdkPath("1"),
+		s.fsMetaPath("2") + ".tmp", s.fsMetaPath("2") + ".lock",
+		filepath.Join(s.scratchDir, "4-"+fsmetaFilename+".tmp"),
+	} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be pruned, got %v", p, err)
+		}
+	}
+	for _, p := range []string{
+		s.fsMetaPath("2"), s.vmdkPath("2"),
+		s.fsMetaPath("3") + ".lock", s.fsMetaPath("3") + ".tmp",
+		filepath.Join(s.scratchDir, "3-"+vmdkFilename+".tmp"),
+	} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("expected %s to be kept: %v", p, err)
+		}
+	}
+}
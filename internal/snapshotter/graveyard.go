@@ -0,0 +1,135 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/log"
+)
+
+// RetainedSnapshot is a removed snapshot directory kept in the graveyard.
+type RetainedSnapshot struct {
+	// ID is the snapshot ID the directory had before removal.
+	ID string
+	// Path is the retained directory, including its rwlayer.img.
+	Path string
+	// RemovedAt is when the snapshot was removed.
+	RemovedAt time.Time
+}
+
+// RetainedLister is implemented by snapshotters that retain removed
+// snapshots for post-mortem (see WithRetainRemoved).
+type RetainedLister interface {
+	RetainedSnapshots(ctx context.Context) ([]RetainedSnapshot, error)
+}
+
+// RetainedSnapshots lists the graveyard entries, newest first.
+func (s *snapshotter) RetainedSnapshots(ctx context.Context) ([]RetainedSnapshot, error) {
+	entries, err := os.ReadDir(s.graveyardDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read graveyard: %w", err)
+	}
+
+	var retained []RetainedSnapshot
+	for _, entry := range entries {
+		ts, id, ok := strings.Cut(entry.Name(), "-")
+		if !entry.IsDir() || !ok {
+			continue
+		}
+		nsec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			log.G(ctx).WithField("name", entry.Name()).Debug("ignoring unexpected graveyard entry")
+			continue
+		}
+		retained = append(retained, RetainedSnapshot{
+			ID:        id,
+			Path:      filepath.Join(s.graveyardDir(), entry.Name()),
+			RemovedAt: time.Unix(0, nsec),
+		})
+	}
+
+	slices.SortFunc(retained, func(a, b RetainedSnapshot) int {
+		return b.RemovedAt.Compare(a.RemovedAt)
+	})
+	return retained, nil
+}
+
+// retainOnRemove reports whether a removed snapshot of kind k is offered to
+// the graveyard. Only container writable layers are worth retaining;
+// extract snapshots hold layer content that is pulled again.
+func retainOnRemove(k snapshots.Kind, info snapshots.Info) bool {
+	return k == snapshots.KindActive && !isExtractSnapshot(info)
+}
+
+// retainable reports whether the directory of snapshot id, removed with
+// retainOnRemove set, would be moved to the graveyard.
+func (s *snapshotter) retainable(id string) bool {
+	if s.retainRemoved <= 0 {
+		return false
+	}
+	// Snapshots that never got a writable layer have nothing to recover.
+	_, err := os.Stat(s.writablePath(id))
+	return err == nil
+}
+
+// retainRemovedSnapshot moves the directory of a removed active snapshot into
+// the graveyard and prunes the oldest entries beyond the retention limit.
+// It reports whether the directory was retained; if not, the caller deletes it.
+// Callers only pass snapshots for which retainOnRemove holds.
+//
+// The graveyard lives outside the snapshots directory, so retained entries
+// are never seen by the metadata-based orphan cleanup.
+func (s *snapshotter) retainRemovedSnapshot(ctx context.Context, id string) bool {
+	if !s.retainable(id) {
+		return false
+	}
+
+	s.graveyardMu.Lock()
+	defer s.graveyardMu.Unlock()
+
+	if err := os.MkdirAll(s.graveyardDir(), 0o700); err != nil {
+		log.G(ctx).WithError(err).Warn("failed to create graveyard directory")
+		return false
+	}
+
+	dst := filepath.Join(s.graveyardDir(), fmt.Sprintf("%020d-%s", time.Now().UnixNano(), id))
+	if err := os.Rename(s.snapshotDir(id), dst); err != nil {
+		log.G(ctx).WithError(err).WithField("id", id).Warn("failed to retain removed snapshot")
+		return false
+	}
+	log.G(ctx).WithFields(log.Fields{
+		"id":   id,
+		"path": dst,
+	}).Info("retained removed snapshot")
+
+	s.pruneGraveyard(ctx)
+	return true
+}
+
+// pruneGraveyard deletes graveyard entries beyond the retention limit,
+// oldest first. Must be called with graveyardMu held.
+func (s *snapshotter) pruneGraveyard(ctx context.Context) {
+	retained, err := s.RetainedSnapshots(ctx)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to list graveyard for pruning")
+		return
+	}
+	if len(retained) <= s.retainRemoved {
+		return
+	}
+	for _, r := range retained[s.retainRemoved:] {
+		if err := os.RemoveAll(r.Path); err != nil {
+			log.G(ctx).WithError(err).WithField("path", r.Path).Warn("failed to prune graveyard entry")
+		}
+	}
+}
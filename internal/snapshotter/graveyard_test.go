@@ -0,0 +1,121 @@
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+func TestRetainRemoved(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.retainRemoved = 2
+
+	var ids []string
+	for _, key := range []string{"a", "b", "c"} {
+		snap := createMetadataSnapshot(t, s, snapshots.KindActive, key, "")
+		if err := os.MkdirAll(s.snapshotDir(snap.ID), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(s.writablePath(snap.ID), []byte("ext4"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, snap.ID)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := s.Remove(t.Context(), key); err != nil {
+			t.Fatalf("remove %s: %v", key, err)
+		}
+	}
+
+	retained, err := s.RetainedSnapshots(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retained) != 2 {
+		t.Fatalf("expected 2 retained snapshots, got %+v", retained)
+	}
+	// Newest first: c, then b. a was pruned.
+	if retained[0].ID != ids[2] || retained[1].ID != ids[1] {
+		t.Errorf("retained IDs = [%s %s], want [%s %s]", retained[0].ID, retained[1].ID, ids[2], ids[1])
+	}
+	for _, r := range retained {
+		if _, err := os.Stat(filepath.Join(r.Path, rwLayerFilename)); err != nil {
+			t.Errorf("retained %s should keep its writable layer: %v", r.ID, err)
+		}
+	}
+	for _, id := range ids {
+		if _, err := os.Stat(s.snapshotDir(id)); !os.IsNotExist(err) {
+			t.Errorf("snapshot dir %s should be gone, got: %v", id, err)
+		}
+	}
+
+	// Cleanup must leave the graveyard alone.
+	if err := s.Cleanup(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := s.RetainedSnapshots(t.Context()); len(after) != 2 {
+		t.Errorf("cleanup should not touch the graveyard, got %+v", after)
+	}
+}
+
+func TestRetainRemovedSkipsSnapshotsWithoutWritableLayer(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.retainRemoved = 1
+
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "a", "")
+	if err := os.MkdirAll(s.upperPath(snap.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(t.Context(), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if retained, _ := s.RetainedSnapshots(t.Context()); len(retained) != 0 {
+		t.Errorf("expected nothing retained, got %+v", retained)
+	}
+	if _, err := os.Stat(s.snapshotDir(snap.ID)); !os.IsNotExist(err) {
+		t.Errorf("snapshot dir should be removed, got: %v", err)
+	}
+}
+
+func TestRetainRemovedSkipsExtractAndCommittedSnapshots(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.retainRemoved = 2
+
+	extract := createMetadataSnapshot(t, s, snapshots.KindActive, "extract-1", "",
+		snapshots.WithLabels(map[string]string{extractLabel: "true"}))
+	committed := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	for _, id := range []string{extract.ID, committed.ID} {
+		if err := os.MkdirAll(s.upperPath(id), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		// Both still have an ext4 image, e.g. from an interrupted commit.
+		if err := os.WriteFile(s.writablePath(id), []byte("ext4"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(s.fallbackLayerBlobPath(committed.ID), []byte("layer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(t.Context(), "layer", "active"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"extract-1", "layer"} {
+		if err := s.Remove(t.Context(), key); err != nil {
+			t.Fatalf("remove %s: %v", key, err)
+		}
+	}
+
+	if retained, _ := s.RetainedSnapshots(t.Context()); len(retained) != 0 {
+		t.Errorf("expected nothing retained, got %+v", retained)
+	}
+	for _, id := range []string{extract.ID, committed.ID} {
+		if _, err := os.Stat(s.snapshotDir(id)); !os.IsNotExist(err) {
+			t.Errorf("snapshot dir %s should be removed, got: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/errdefs"
+)
+
+// WritableDeviceProvider is implemented by snapshotters that can hand the
+// writable layer of an active snapshot to a VM runtime as a block device.
+type WritableDeviceProvider interface {
+	WritableDevice(ctx context.Context, key string) (string, error)
+}
+
+// WritableDevice returns the path of the ext4 writable layer image of the
+// active snapshot key, for a VM runtime to attach as a virtio-blk device.
+// It requires WithBlockDeviceHandoff and fails with
+// errdefs.ErrFailedPrecondition for extract snapshots, whose layer the
+// differ mounts on the host, and when the image is mounted or attached to a
+// loop device on the host at the time of the call.
+//
+// The check is not a reservation: the snapshotter does not track which
+// images were handed off. It never mounts a non-extract writable layer
+// itself, so auto-trim skips it.
+func (s *snapshotter) WritableDevice(ctx context.Context, key string) (string, error) {
+	if !s.blockDeviceHandoff {
+		return "", fmt.Errorf("block device handoff is not enabled (see WithBlockDeviceHandoff): %w", errdefs.ErrFailedPrecondition)
+	}
+
+	var id string
+	var info snapshots.Info
+	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) (err error) {
+		id, info, _, err = storage.GetInfo(ctx, key)
+		return err
+	}); err != nil {
+		return "", err
+	}
+	if info.Kind != snapshots.KindActive {
+		return "", fmt.Errorf("snapshot %q is %s, not active: %w", key, info.Kind, errdefs.ErrFailedPrecondition)
+	}
+	if isExtractSnapshot(info) {
+		return "", fmt.Errorf("extract snapshot %q is mounted on the host for the differ: %w", key, errdefs.ErrFailedPrecondition)
+	}
+
+	path := s.writablePath(id)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "", fmt.Errorf("writable layer of %q: %w", key, errdefs.ErrNotFound)
+	} else if err != nil {
+		return "", fmt.Errorf("writable layer of %q: %w", key, err)
+	}
+	inUse, err := s.writableLayerInUse(id)
+	if err != nil {
+		return "", err
+	}
+	if inUse {
+		return "", fmt.Errorf("writable layer %s is in use on the host: %w", path, errdefs.ErrFailedPrecondition)
+	}
+	return path, nil
+}
@@ -0,0 +1,49 @@
+package snapshotter
+
+import (
+	"os"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+)
+
+func TestWritableDevice(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	createMetadataSnapshot(t, s, snapshots.KindView, "view", "")
+	createMetadataSnapshot(t, s, snapshots.KindActive, "extract", "",
+		snapshots.WithLabels(map[string]string{extractLabel: "true"}))
+
+	if _, err := s.WritableDevice(t.Context(), "active"); !errdefs.IsFailedPrecondition(err) {
+		t.Errorf("expected failed precondition without handoff, got %v", err)
+	}
+
+	s.blockDeviceHandoff = true
+	if _, err := s.WritableDevice(t.Context(), "active"); !errdefs.IsNotFound(err) {
+		t.Errorf("expected not found without a writable layer, got %v", err)
+	}
+
+	if err := os.MkdirAll(s.snapshotDir(snap.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.writablePath(snap.ID), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path, err := s.WritableDevice(t.Context(), "active")
+	if err != nil {
+		t.Fatalf("WritableDevice: %v", err)
+	}
+	if path != s.writablePath(snap.ID) {
+		t.Errorf("path = %q, want %q", path, s.writablePath(snap.ID))
+	}
+
+	for _, key := range []string{"view", "extract"} {
+		if _, err := s.WritableDevice(t.Context(), key); !errdefs.IsFailedPrecondition(err) {
+			t.Errorf("%s: expected failed precondition, got %v", key, err)
+		}
+	}
+	if _, err := s.WritableDevice(t.Context(), "missing"); !errdefs.IsNotFound(err) {
+		t.Errorf("expected not found for a missing snapshot, got %v", err)
+	}
+}
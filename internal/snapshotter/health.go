@@ -0,0 +1,148 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/fsverity"
+	"github.com/spin-stack/erofs-snapshotter/internal/preflight"
+)
+
+// Health check names reported in HealthCheckResult.Name.
+const (
+	HealthCheckErofs     = "erofs"
+	HealthCheckFeatures  = "kernel-features"
+	HealthCheckMkfsErofs = "mkfs.erofs"
+	HealthCheckMkfsExt4  = "mkfs.ext4"
+	HealthCheckDType     = "d_type"
+	HealthCheckMetadata  = "metadata"
+	HealthCheckFsverity  = "fsverity"
+)
+
+// HealthCheckResult is the outcome of a single capability check.
+type HealthCheckResult struct {
+	// Name identifies the capability, one of the HealthCheck* constants.
+	Name string
+	// OK is true when the capability is available.
+	OK bool
+	// Message describes the failure. It is empty when OK is true.
+	Message string
+}
+
+// HealthReport aggregates the results of all capability checks.
+type HealthReport struct {
+	// Healthy is true when every check passed.
+	Healthy bool
+	// Checks holds one result per capability, in a fixed order.
+	Checks []HealthCheckResult
+}
+
+// HealthChecker is implemented by snapshotters that can report whether the
+// host still provides the capabilities they depend on.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) (HealthReport, error)
+}
+
+// HealthCheck re-runs the startup compatibility checks and reports each
+// capability separately. It does not load kernel modules or modify any state,
+// so it is safe to call periodically. An error is returned only when ctx is
+// done; failed checks are reported in the HealthReport.
+//
+// With WithFsverityMeasurement, a HealthCheckFsverity result is added last.
+func (s *snapshotter) HealthCheck(ctx context.Context) (HealthReport, error) {
+	checkErofs := preflight.CheckErofsSupport
+	checkMkfsErofs := func() error { return lookPath("mkfs.erofs") }
+	if _, ok := s.converter.(FsMetaMerger); ok {
+		// WithConverter replaces every use of mkfs.erofs.
+		checkErofs = preflight.CheckErofsFilesystem
+		checkMkfsErofs = func() error { return nil }
+	}
+	type check struct {
+		name string
+		fn   func() error
+	}
+	checks := []check{
+		{HealthCheckErofs, checkErofs},
+		{HealthCheckFeatures, func() error { return preflight.CheckFeatures(s.requiredFeatures...) }},
+		{HealthCheckMkfsErofs, checkMkfsErofs},
+		{HealthCheckMkfsExt4, func() error { return lookPath("mkfs.ext4") }},
+		{HealthCheckDType, func() error { return checkDType(s.rootDir()) }},
+		{HealthCheckMetadata, func() error {
+			// A read-only transaction proves the DB is open and readable.
+			return s.ms.WithTransaction(ctx, false, func(context.Context) error { return nil })
+		}},
+	}
+	if s.fsverityMeasurement {
+		checks = append(checks, check{HealthCheckFsverity, func() error { return s.checkFsverity(ctx) }})
+	}
+
+	report := HealthReport{Healthy: true, Checks: make([]HealthCheckResult, 0, len(checks))}
+	for _, c := range checks {
+		if err := ctx.Err(); err != nil {
+			return HealthReport{}, err
+		}
+		result := HealthCheckResult{Name: c.name, OK: true}
+		if err := c.fn(); err != nil {
+			result.OK = false
+			result.Message = err.Error()
+			report.Healthy = false
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	return report, nil
+}
+
+// lookPath returns an error if the named tool cannot be found in PATH.
+func lookPath(name string) error {
+	_, err := exec.LookPath(name)
+	return err
+}
+
+// checkFsverity queries the kernel's fs-verity digest of one committed layer
+// recorded with LabelFsverityDigest and compares it with the label. It passes
+// when no layer has fs-verity enabled; kernel support itself is covered by
+// HealthCheckFeatures.
+func (s *snapshotter) checkFsverity(ctx context.Context) error {
+	var blob, want string
+	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		return storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+			d, ok := FsverityDigest(info)
+			if blob != "" || !ok || info.Kind != snapshots.KindCommitted {
+				return nil
+			}
+			id, _, _, err := storage.GetInfo(ctx, info.Name)
+			if err != nil {
+				return fmt.Errorf("get snapshot info for %q: %w", info.Name, err)
+			}
+			path, err := s.findLayerBlobFromInfo(id, info)
+			if err != nil {
+				return nil //nolint:nilerr // reported by Mounts and CheckConsistency
+			}
+			blob, want = path, d
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+	if blob == "" {
+		return nil
+	}
+
+	// Only blobs with fs-verity enabled are measured: anything else would
+	// be hashed in userspace, which is too slow for a health check.
+	if !fsverity.IsEnabled(blob) {
+		return fmt.Errorf("fs-verity is no longer enabled on %s", blob)
+	}
+	got, err := fsverity.MeasureFsverity(blob)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("fs-verity digest of %s is %s, want %s", blob, got, want)
+	}
+	return nil
+}
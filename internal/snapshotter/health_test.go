@@ -0,0 +1,117 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+func healthResult(t *testing.T, report HealthReport, name string) HealthCheckResult {
+	t.Helper()
+	for _, r := range report.Checks {
+		if r.Name == name {
+			return r
+		}
+	}
+	t.Fatalf("health report has no %q check: %+v", name, report.Checks)
+	return HealthCheckResult{}
+}
+
+func TestHealthCheck(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+
+	report, err := s.HealthCheck(t.Context())
+	if err != nil {
+		t.Fatalf("HealthCheck: %v", err)
+	}
+
+	want := []string{
+		HealthCheckErofs,
+		HealthCheckFeatures,
+		HealthCheckMkfsErofs,
+		HealthCheckMkfsExt4,
+		HealthCheckDType,
+		HealthCheckMetadata,
+	}
+	if len(report.Checks) != len(want) {
+		t.Fatalf("expected %d checks, got %+v", len(want), report.Checks)
+	}
+	healthy := true
+	for i, r := range report.Checks {
+		if r.Name != want[i] {
+			t.Errorf("check %d: expected %q, got %q", i, want[i], r.Name)
+		}
+		if r.OK != (r.Message == "") {
+			t.Errorf("check %q: OK=%v with message %q", r.Name, r.OK, r.Message)
+		}
+		healthy = healthy && r.OK
+	}
+	if report.Healthy != healthy {
+		t.Errorf("Healthy=%v does not match checks %+v", report.Healthy, report.Checks)
+	}
+
+	if r := healthResult(t, report, HealthCheckMetadata); !r.OK {
+		t.Errorf("metadata check failed: %s", r.Message)
+	}
+}
+
+func TestHealthCheckClosedMetadata(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	// The metadata store opens its DB lazily; open it before closing it.
+	createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	if err := s.ms.Close(); err != nil {
+		t.Fatalf("close metadata store: %v", err)
+	}
+
+	report, err := s.HealthCheck(t.Context())
+	if err != nil {
+		t.Fatalf("HealthCheck: %v", err)
+	}
+	if report.Healthy {
+		t.Error("expected unhealthy report with closed metadata store")
+	}
+	if r := healthResult(t, report, HealthCheckMetadata); r.OK || r.Message == "" {
+		t.Errorf("expected failed metadata check with message, got %+v", r)
+	}
+}
+
+func TestHealthCheckCanceled(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	if _, err := s.HealthCheck(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestHealthCheckFsverity(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.fsverityMeasurement = true
+	commitMetadataLayer(t, s, "base", "", []byte("base"))
+
+	report, err := s.HealthCheck(t.Context())
+	if err != nil {
+		t.Fatalf("HealthCheck: %v", err)
+	}
+	if r := healthResult(t, report, HealthCheckFsverity); !r.OK {
+		t.Errorf("expected fs-verity check to pass without protected layers: %s", r.Message)
+	}
+
+	// A layer recorded as fs-verity protected whose blob is not.
+	if _, err := s.Update(t.Context(), snapshots.Info{
+		Name:   "base",
+		Labels: map[string]string{LabelFsverityDigest: "sha256:00"},
+	}, "labels."+LabelFsverityDigest); err != nil {
+		t.Fatal(err)
+	}
+	report, err = s.HealthCheck(t.Context())
+	if err != nil {
+		t.Fatalf("HealthCheck: %v", err)
+	}
+	if r := healthResult(t, report, HealthCheckFsverity); r.OK || r.Message == "" {
+		t.Errorf("expected failed fs-verity check with message, got %+v", r)
+	}
+}
@@ -0,0 +1,86 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+)
+
+// ImmutableReapplier is implemented by snapshotters that can protect layer
+// blobs committed before WithImmutable was enabled.
+type ImmutableReapplier interface {
+	ReapplyImmutable(ctx context.Context) (int, error)
+}
+
+// immutableLayer is a committed layer blob missing LabelImmutable.
+type immutableLayer struct {
+	key  string
+	blob string
+}
+
+// ReapplyImmutable sets IMMUTABLE_FL on the layer blob of every committed
+// snapshot without LabelImmutable and records the label, returning how many
+// blobs it changed. Blobs that already have the flag are only labeled. On
+// filesystems without inode flags the blobs are left unlabeled, so a later
+// run on a supporting filesystem still protects them. Failures on one blob
+// do not stop the others; they are returned together.
+//
+// It fails with errdefs.ErrFailedPrecondition unless WithImmutable is set.
+func (s *snapshotter) ReapplyImmutable(ctx context.Context) (int, error) {
+	if !s.setImmutable {
+		return 0, fmt.Errorf("immutable layers are not enabled: %w", errdefs.ErrFailedPrecondition)
+	}
+
+	var layers []immutableLayer
+	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		return storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+			if info.Kind != snapshots.KindCommitted || info.Labels[LabelImmutable] == "true" {
+				return nil
+			}
+			id, _, _, err := storage.GetInfo(ctx, info.Name)
+			if err != nil {
+				return fmt.Errorf("get snapshot info for %q: %w", info.Name, err)
+			}
+			blob, err := s.findLayerBlobFromInfo(id, info)
+			if err != nil {
+				return nil //nolint:nilerr // reported by Mounts and CheckConsistency
+			}
+			layers = append(layers, immutableLayer{key: info.Name, blob: blob})
+			return nil
+		})
+	}); err != nil {
+		return 0, err
+	}
+
+	var (
+		changed int
+		errs    []error
+	)
+	for _, l := range layers {
+		if err := ctx.Err(); err != nil {
+			return changed, errors.Join(append(errs, err)...)
+		}
+		if !isImmutable(l.blob) {
+			if err := setImmutable(l.blob, true); errdefs.IsNotImplemented(err) {
+				log.G(ctx).WithError(err).WithField("blob", l.blob).Debug("filesystem does not support immutable flag")
+				continue
+			} else if err != nil {
+				errs = append(errs, fmt.Errorf("set IMMUTABLE_FL on %s: %w", l.blob, err))
+				continue
+			}
+			changed++
+		}
+		if err := s.setSnapshotLabel(ctx, l.key, LabelImmutable, "true"); err != nil {
+			errs = append(errs, fmt.Errorf("label snapshot %q: %w", l.key, err))
+		}
+	}
+	if changed > 0 {
+		log.G(ctx).WithField("layers", changed).Info("immutable flag reapplied to layer blobs")
+	}
+	return changed, errors.Join(errs...)
+}
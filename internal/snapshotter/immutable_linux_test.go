@@ -0,0 +1,60 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"testing"
+
+	"github.com/containerd/errdefs"
+)
+
+func TestReapplyImmutable(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	skipIfNoImmutableSupport(t, s.root)
+
+	// Layers committed before WithImmutable was enabled.
+	base := commitMetadataLayer(t, s, "base", "", []byte("base"))
+	top := commitMetadataLayer(t, s, "top", "base", []byte("top"))
+	blobs := []string{s.fallbackLayerBlobPath(base), s.fallbackLayerBlobPath(top)}
+	t.Cleanup(func() {
+		for _, blob := range blobs {
+			_ = setImmutable(blob, false)
+		}
+	})
+
+	if _, err := s.ReapplyImmutable(t.Context()); !errdefs.IsFailedPrecondition(err) {
+		t.Fatalf("expected failed precondition without WithImmutable, got %v", err)
+	}
+
+	s.setImmutable = true
+	// A blob protected by hand is labeled but not counted.
+	if err := setImmutable(blobs[0], true); err != nil {
+		if errdefs.IsNotImplemented(err) {
+			t.Skip("filesystem does not support immutable flags")
+		}
+		t.Fatal(err)
+	}
+	n, err := s.ReapplyImmutable(t.Context())
+	if err != nil {
+		t.Fatalf("ReapplyImmutable: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 blob changed, got %d", n)
+	}
+	for i, name := range []string{"base", "top"} {
+		if !isImmutable(blobs[i]) {
+			t.Errorf("%s: expected IMMUTABLE_FL on %s", name, blobs[i])
+		}
+		info, err := s.Stat(t.Context(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Labels[LabelImmutable] != "true" {
+			t.Errorf("%s: expected %s, got %v", name, LabelImmutable, info.Labels)
+		}
+	}
+
+	if n, err := s.ReapplyImmutable(t.Context()); err != nil || n != 0 {
+		t.Errorf("second run = %d, %v; want 0, nil", n, err)
+	}
+}
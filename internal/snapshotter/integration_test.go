@@ -6,12 +6,13 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
 
-	// Import testutil to register the -test.root flag
-	_ "github.com/spin-stack/erofs-snapshotter/internal/testutil"
+	"github.com/spin-stack/erofs-snapshotter/internal/testutil"
 )
 
 const osLinux = "linux"
@@ -84,12 +85,36 @@ func newTestSnapshotterWithRoot(t *testing.T, root string, opts ...Opt) *snapsho
 	return s.(*snapshotter)
 }
 
+// newMetadataOnlySnapshotter returns a snapshotter backed by a real metadata
+// store but without the preflight checks, for tests that only touch metadata.
+func newMetadataOnlySnapshotter(t *testing.T) *snapshotter {
+	t.Helper()
+	root := t.TempDir()
+	ms, err := newMetaStore(filepath.Join(root, "metadata.db"))
+	if err != nil {
+		t.Fatalf("create metadata store: %v", err)
+	}
+	t.Cleanup(func() { ms.Close() })
+	return &snapshotter{root: root, ms: ms}
+}
+
+// createMetadataSnapshot creates a snapshot record without touching the filesystem.
+func createMetadataSnapshot(t *testing.T, s *snapshotter, kind snapshots.Kind, key, parent string, opts ...snapshots.Opt) storage.Snapshot {
+	t.Helper()
+	var snap storage.Snapshot
+	if err := s.ms.WithTransaction(t.Context(), true, func(ctx context.Context) (err error) {
+		snap, err = storage.CreateSnapshot(ctx, kind, key, parent, opts...)
+		return err
+	}); err != nil {
+		t.Fatalf("create snapshot %q: %v", key, err)
+	}
+	return snap
+}
+
 func TestNewSnapshotter(t *testing.T) {
-	t.Run("creates snapshotter with defaults", func(t *testing.T) {
-		if !checkBlockModeRequirements(t) {
-			t.Skip("mkfs.ext4 not available")
-		}
+	testutil.InstallFakeMkfs(t)
 
+	t.Run("creates snapshotter with defaults", func(t *testing.T) {
 		root := t.TempDir()
 
 		// Use block mode for cross-platform testing
@@ -108,11 +133,28 @@ func TestNewSnapshotter(t *testing.T) {
 		}
 	})
 
-	t.Run("fails on non-existent root", func(t *testing.T) {
-		if !checkBlockModeRequirements(t) {
-			t.Skip("mkfs.ext4 not available")
+	t.Run("stores metadata at WithMetadataPath", func(t *testing.T) {
+		root := t.TempDir()
+		dbPath := filepath.Join(t.TempDir(), "fast", "metadata.db")
+
+		s, err := NewSnapshotter(root, WithDefaultSize(1024*1024), WithMetadataPath(dbPath))
+		if err != nil {
+			t.Fatalf("failed to create snapshotter: %v", err)
 		}
+		defer s.Close()
+
+		if _, err := s.Prepare(t.Context(), "test-snapshot", ""); err != nil {
+			t.Fatalf("Prepare failed: %v", err)
+		}
+		if _, err := os.Stat(dbPath); err != nil {
+			t.Errorf("expected metadata at %s: %v", dbPath, err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "metadata.db")); !os.IsNotExist(err) {
+			t.Errorf("expected no metadata under root, got %v", err)
+		}
+	})
 
+	t.Run("fails on non-existent root", func(t *testing.T) {
 		// Create a file where we want the directory
 		root := t.TempDir()
 		blockerPath := filepath.Join(root, "blocker")
@@ -126,13 +168,18 @@ func TestNewSnapshotter(t *testing.T) {
 		}
 	})
 
+	t.Run("rejects content store inside root", func(t *testing.T) {
+		root := t.TempDir()
+		_, err := NewSnapshotter(root, WithDefaultSize(1024*1024), WithContentStore(filepath.Join(root, "store")))
+		if err == nil || !strings.Contains(err.Error(), "outside the root") {
+			t.Errorf("expected content store inside root to be rejected, got %v", err)
+		}
+	})
+
 	t.Run("immutable option rejected on non-linux", func(t *testing.T) {
 		if runtime.GOOS == osLinux {
 			t.Skip("only applies to non-Linux")
 		}
-		if !checkBlockModeRequirements(t) {
-			t.Skip("mkfs.ext4 not available")
-		}
 
 		root := t.TempDir()
 		_, err := NewSnapshotter(root, WithImmutable(), WithDefaultSize(1024*1024))
@@ -331,9 +378,7 @@ func TestSnapshotterUpdate(t *testing.T) {
 }
 
 func TestSnapshotterClose(t *testing.T) {
-	if !checkBlockModeRequirements(t) {
-		t.Skip("mkfs.ext4 not available")
-	}
+	testutil.InstallFakeMkfs(t)
 
 	root := t.TempDir()
 	s, err := NewSnapshotter(root, WithDefaultSize(1024*1024))
@@ -0,0 +1,144 @@
+package snapshotter
+
+import "github.com/containerd/containerd/v2/core/snapshots"
+
+// Snapshot labels written by the snapshotter for operators and tooling.
+const (
+	// LabelConversionError records why EROFS conversion of a snapshot failed.
+	//
+	// Set during: Commit on conversion failure, on the active snapshot.
+	// Value: snapshot ID and truncated mkfs.erofs error, plus the quarantine
+	// path when WithPreserveFailedUpper is enabled.
+	LabelConversionError = "containerd.io/snapshot/erofs.conversion-error"
+
+	// LabelLayerDigest is the sha256 digest of the committed EROFS layer blob.
+	//
+	// Set during: Commit and Recompress, on the committed snapshot.
+	LabelLayerDigest = "containerd.io/snapshot/erofs.layer-digest"
+
+	// LabelLayerBlobPath is the path of the committed EROFS layer blob.
+	// It lets lookups skip globbing the snapshot directory.
+	//
+	// Set during: Commit, on the committed snapshot.
+	LabelLayerBlobPath = "containerd.io/snapshot/erofs.layer-blob-path"
+
+	// LabelCompression is the mkfs.erofs compression of the layer blob,
+	// e.g. "zstd" or "lz4hc,level=9". Absent for uncompressed blobs and for
+	// blobs the snapshotter did not build.
+	//
+	// Set during: Commit, from LabelCommitCompression or the "-z" option in
+	// the snapshotter's mkfs.erofs options, and Recompress, on the
+	// committed snapshot.
+	LabelCompression = "containerd.io/snapshot/erofs.compression"
+
+	// LabelCommitCompression asks Commit to compress the layer blob it
+	// builds with this mkfs.erofs compressor, e.g. "lzma" for a base layer
+	// read many times or "lz4" for a short-lived one, replacing any
+	// compressor in the default mkfs.erofs options. It is ignored when the
+	// blob was already built, for example by the EROFS differ, and is not
+	// kept on the committed snapshot (see LabelCompression).
+	//
+	// Set by: clients, as a Commit option (see WithCommitCompression).
+	LabelCommitCompression = "containerd.io/snapshot/erofs.commit-compression"
+
+	// LabelImmutable is "true" on committed layers whose blob has
+	// IMMUTABLE_FL set by the snapshotter (see WithImmutable).
+	//
+	// Set during: Commit and ReapplyImmutable, on the committed snapshot.
+	LabelImmutable = "containerd.io/snapshot/erofs.immutable"
+
+	// LabelPinned marks a snapshot that Remove must refuse to delete.
+	//
+	// Set during: Pin, cleared by Unpin. Clients may also set it with Update.
+	LabelPinned = "containerd.io/snapshot/erofs.pinned"
+
+	// LabelTargetBlobDigest asks Commit to convert the snapshot into a blob
+	// named after this sha256 digest (sha256-{hex}.erofs), matching the
+	// naming used by the EROFS differ, instead of the snapshot ID.
+	//
+	// Set by: clients, as a Commit option (see WithTargetBlobDigest).
+	LabelTargetBlobDigest = "containerd.io/snapshot/erofs.target-blob-digest"
+
+	// LabelUsageSize gives Commit the layer blob's disk usage in bytes, so
+	// it need not measure the blob itself. Callers that already know the
+	// size, such as the EROFS differ, can use it to speed up large commits.
+	//
+	// Set by: clients, as a Commit option (see WithCommitUsage).
+	LabelUsageSize = "containerd.io/snapshot/erofs.usage-size"
+
+	// LabelTailPacking is "true" on layers whose blob the snapshotter built
+	// with file tails packed into inodes (see WithErofsTailPacking).
+	//
+	// Set by: Commit.
+	LabelTailPacking = "containerd.io/snapshot/erofs.tail-packing"
+
+	// LabelFsverityDigest is the fs-verity digest of the layer blob as
+	// measured by the kernel, e.g. "sha256:{hex}". Absent for blobs without
+	// fs-verity. Read it with FsverityDigest.
+	//
+	// Set during: Commit and Recompress, on the committed snapshot.
+	LabelFsverityDigest = "containerd.io/snapshot/erofs.fsverity-digest"
+
+	// LabelFsverityMeasurement is the fs-verity digest of a layer blob
+	// without fs-verity, computed in userspace. It equals the digest the
+	// kernel would report once fs-verity is enabled on the blob with the
+	// page size as block size, but nothing enforces it.
+	//
+	// Set during: Commit and Recompress with WithFsverityMeasurement, on the
+	// committed snapshot.
+	LabelFsverityMeasurement = "containerd.io/snapshot/erofs.fsverity-measurement"
+
+	// LabelUpperXattrPrefix prefixes labels that set an extended attribute on
+	// the upper directory of a new active snapshot. The rest of the label key
+	// is the attribute name, e.g. "containerd.io/snapshot/erofs.upper-xattr.user.marker",
+	// and the label value its value. They override WithUpperXattrs.
+	//
+	// Set by: clients, as a Prepare option.
+	LabelUpperXattrPrefix = "containerd.io/snapshot/erofs.upper-xattr."
+
+	// LabelUnreferenced marks a committed layer that no image references
+	// any more, so PruneUnreferenced may remove it once it has no children.
+	//
+	// Set by: clients, with Update.
+	LabelUnreferenced = "containerd.io/snapshot/erofs.unreferenced"
+
+	// LabelVolumePrefix prefixes labels that request a named writable volume
+	// for a new active snapshot, formatted as its own ext4 image next to
+	// the primary writable layer. The rest of the label key is the volume
+	// name, e.g. "containerd.io/snapshot/erofs.volume.tmp", and the label
+	// value its size in bytes. Volumes are counted against namespace quotas
+	// and are discarded on Commit. Extract snapshots ignore them.
+	//
+	// Set by: clients, as a Prepare option (see VolumeMountOption).
+	LabelVolumePrefix = "containerd.io/snapshot/erofs.volume."
+
+	// LabelBlockFallback is "true" on extract snapshots created without an
+	// ext4 writable layer because loop devices were unavailable. Their
+	// content is written to, and committed from, the snapshot's fs/
+	// directory.
+	//
+	// Set by: Prepare with WithBlockModeFallback.
+	LabelBlockFallback = "containerd.io/snapshot/erofs.block-fallback"
+
+	// LabelDirectoryMissing marks a snapshot whose snapshot directory was
+	// missing at startup. The value is the RFC 3339 time it was detected.
+	//
+	// Set by: NewSnapshotter with WithStartupConsistencyCheck.
+	LabelDirectoryMissing = "containerd.io/snapshot/erofs.directory-missing"
+)
+
+// labelSnapshotRef is containerd's target reference label, set on layers
+// unpacked for an image. PruneUnreferenced keeps layers that carry it even
+// when they are marked with LabelUnreferenced.
+const labelSnapshotRef = "containerd.io/snapshot.ref"
+
+// FsverityDigest returns the fs-verity digest recorded for a committed
+// snapshot, and whether its blob was fs-verity protected at commit time.
+func FsverityDigest(info snapshots.Info) (string, bool) {
+	d, ok := info.Labels[LabelFsverityDigest]
+	return d, ok && d != ""
+}
+
+// maxConversionErrorLen bounds the error text stored in LabelConversionError
+// so the label stays within containerd's label size limit.
+const maxConversionErrorLen = 1024
@@ -0,0 +1,56 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/log"
+)
+
+// Snapshot modes reported in the "mode" log field.
+const (
+	// logModeBlock snapshots hand their layers to a VM as block devices.
+	logModeBlock = "block"
+	// logModeExtract snapshots mount their writable layer on the host for
+	// the differ.
+	logModeExtract = "extract"
+)
+
+// lifecycleFields returns the fields carried by every Prepare, View, Commit
+// and Remove log line, so one snapshot can be followed across operations by
+// its key or ID.
+func lifecycleFields(key, id string, info snapshots.Info, parents int) log.Fields {
+	mode := logModeBlock
+	if isExtractSnapshot(info) {
+		mode = logModeExtract
+	}
+	return log.Fields{
+		"key":     key,
+		"id":      id,
+		"kind":    info.Kind.String(),
+		"parents": parents,
+		"mode":    mode,
+	}
+}
+
+// withLifecycleFields returns ctx with a logger that adds lifecycleFields to
+// every line logged through log.G.
+func withLifecycleFields(ctx context.Context, key, id string, info snapshots.Info, parents int) context.Context {
+	return log.WithLogger(ctx, log.G(ctx).WithFields(lifecycleFields(key, id, info, parents)))
+}
+
+// parentCount returns the number of ancestors of info.
+// Must be called within a transaction.
+func parentCount(ctx context.Context, info snapshots.Info) (int, error) {
+	n := 0
+	for parent := info.Parent; parent != ""; n++ {
+		_, pinfo, _, err := storage.GetInfo(ctx, parent)
+		if err != nil {
+			return 0, fmt.Errorf("get parent info %q: %w", parent, err)
+		}
+		parent = pinfo.Parent
+	}
+	return n, nil
+}
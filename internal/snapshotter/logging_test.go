@@ -0,0 +1,52 @@
+package snapshotter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/log"
+)
+
+func TestLifecycleFields(t *testing.T) {
+	info := snapshots.Info{Kind: snapshots.KindActive}
+	ctx := withLifecycleFields(t.Context(), "default/1/container", "7", info, 3)
+
+	want := log.Fields{"key": "default/1/container", "id": "7", "kind": "Active", "parents": 3, "mode": logModeBlock}
+	got := log.G(ctx).Data
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("field %q = %v, want %v", k, got[k], v)
+		}
+	}
+
+	info.Labels = map[string]string{extractLabel: "true"}
+	if mode := lifecycleFields("default/2/extract-1", "8", info, 0)["mode"]; mode != logModeExtract {
+		t.Errorf("mode = %v, want %v", mode, logModeExtract)
+	}
+}
+
+func TestParentCount(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	commitMetadataLayer(t, s, "base", "", []byte("base"))
+	commitMetadataLayer(t, s, "top", "base", []byte("top"))
+	createMetadataSnapshot(t, s, snapshots.KindActive, "container", "top")
+
+	for key, want := range map[string]int{"base": 0, "top": 1, "container": 2} {
+		var got int
+		if err := s.ms.WithTransaction(t.Context(), false, func(ctx context.Context) error {
+			_, info, _, err := storage.GetInfo(ctx, key)
+			if err != nil {
+				return err
+			}
+			got, err = parentCount(ctx, info)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("parentCount(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
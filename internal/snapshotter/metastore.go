@@ -0,0 +1,169 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// compactTxMaxSize bounds the size of each transaction bolt.Compact commits
+// to the new database, so large databases are not copied in one go.
+const compactTxMaxSize = 64 * 1024
+
+// metaStore wraps storage.MetaStore so that CompactMetadata can replace the
+// database file. Transactions hold mu for reading and the swap holds it for
+// writing, so a compaction waits for in-flight transactions and blocks new
+// ones until the compacted database is in place.
+type metaStore struct {
+	mu   sync.RWMutex
+	path string
+	ms   *storage.MetaStore
+}
+
+func newMetaStore(path string) (*metaStore, error) {
+	ms, err := storage.NewMetaStore(path)
+	if err != nil {
+		return nil, err
+	}
+	return &metaStore{path: path, ms: ms}, nil
+}
+
+// WithTransaction runs fn in a metadata transaction, see
+// storage.MetaStore.WithTransaction.
+func (m *metaStore) WithTransaction(ctx context.Context, writable bool, fn storage.TransactionCallback) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ms.WithTransaction(ctx, writable, fn)
+}
+
+// Close closes the underlying database.
+func (m *metaStore) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ms.Close()
+}
+
+// MetadataCompactor is implemented by snapshotters that can rewrite their
+// metadata database to release free pages.
+type MetadataCompactor interface {
+	CompactMetadata(ctx context.Context) (MetadataCompaction, error)
+}
+
+// MetadataCompaction reports the size of the metadata database before and
+// after CompactMetadata.
+type MetadataCompaction struct {
+	Before int64
+	After  int64
+}
+
+// CompactMetadata copies metadata.db into a fresh bolt database, which
+// leaves out the free pages accumulated by removed snapshots, and atomically
+// renames it over the original. It is meant to be run while the
+// snapshotter is otherwise idle: metadata operations block until it is done.
+// On failure the original database is kept.
+func (s *snapshotter) CompactMetadata(ctx context.Context) (MetadataCompaction, error) {
+	res, err := s.ms.compact()
+	if err != nil {
+		return MetadataCompaction{}, err
+	}
+	log.G(ctx).WithFields(log.Fields{
+		"before": res.Before,
+		"after":  res.After,
+	}).Info("metadata database compacted")
+	return res, nil
+}
+
+func (m *metaStore) compact() (res MetadataCompaction, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Closing waits for in-flight transactions and releases the file lock.
+	if err := m.ms.Close(); err != nil {
+		return res, fmt.Errorf("close metadata store: %w", err)
+	}
+	defer func() {
+		// storage.MetaStore cannot be reopened once closed; the new one
+		// opens the database lazily on the next transaction.
+		ms, oerr := storage.NewMetaStore(m.path)
+		if oerr != nil {
+			err = fmt.Errorf("reopen metadata store: %w", oerr)
+			return
+		}
+		m.ms = ms
+	}()
+
+	fi, err := os.Stat(m.path)
+	if err != nil {
+		return res, fmt.Errorf("stat metadata database: %w", err)
+	}
+	res.Before = fi.Size()
+
+	tmp := m.path + ".compact"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return res, fmt.Errorf("remove stale compacted database: %w", err)
+	}
+	if err := compactBolt(m.path, tmp); err != nil {
+		os.Remove(tmp)
+		return res, err
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		os.Remove(tmp)
+		return res, fmt.Errorf("replace metadata database: %w", err)
+	}
+
+	if fi, err = os.Stat(m.path); err != nil {
+		return res, fmt.Errorf("stat metadata database: %w", err)
+	}
+	res.After = fi.Size()
+	return res, nil
+}
+
+// relocate closes the database and runs fn, which may move it and returns
+// its new path, then reopens it there. Transactions block until it is done.
+// If fn fails, the database is reopened at its old path.
+func (m *metaStore) relocate(fn func(path string) (string, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.ms.Close(); err != nil {
+		return fmt.Errorf("close metadata store: %w", err)
+	}
+	path, err := fn(m.path)
+	if err != nil {
+		path = m.path
+	}
+	ms, oerr := storage.NewMetaStore(path)
+	if oerr != nil {
+		return errors.Join(err, fmt.Errorf("reopen metadata store: %w", oerr))
+	}
+	m.ms, m.path = ms, path
+	return err
+}
+
+// compactBolt writes a compacted copy of the bolt database src to dst.
+func compactBolt(src, dst string) error {
+	from, err := bolt.Open(src, 0o600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("open metadata database: %w", err)
+	}
+	defer from.Close()
+
+	to, err := bolt.Open(dst, 0o600, nil)
+	if err != nil {
+		return fmt.Errorf("create compacted database: %w", err)
+	}
+	if err := bolt.Compact(to, from, compactTxMaxSize); err != nil {
+		to.Close()
+		return fmt.Errorf("compact metadata database: %w", err)
+	}
+	if err := to.Close(); err != nil {
+		return fmt.Errorf("close compacted database: %w", err)
+	}
+	return nil
+}
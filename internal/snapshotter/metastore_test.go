@@ -0,0 +1,44 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+)
+
+func TestCompactMetadata(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	createMetadataSnapshot(t, s, snapshots.KindActive, "keep", "")
+	for i := range 200 {
+		key := fmt.Sprintf("tmp-%d", i)
+		createMetadataSnapshot(t, s, snapshots.KindActive, key, "",
+			snapshots.WithLabels(map[string]string{"padding": fmt.Sprintf("%01024d", i)}))
+	}
+	if err := s.ms.WithTransaction(t.Context(), true, func(ctx context.Context) error {
+		for i := range 200 {
+			if _, _, err := storage.Remove(ctx, fmt.Sprintf("tmp-%d", i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.CompactMetadata(t.Context())
+	if err != nil {
+		t.Fatalf("CompactMetadata: %v", err)
+	}
+	if res.After >= res.Before {
+		t.Errorf("expected database to shrink, got %d -> %d bytes", res.Before, res.After)
+	}
+
+	if _, err := s.Stat(t.Context(), "keep"); err != nil {
+		t.Errorf("snapshot lost by compaction: %v", err)
+	}
+	// The store is usable for writes after the swap.
+	createMetadataSnapshot(t, s, snapshots.KindActive, "after", "")
+}
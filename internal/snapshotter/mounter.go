@@ -0,0 +1,33 @@
+package snapshotter
+
+import "github.com/containerd/containerd/v2/core/mount"
+
+// mounter mounts and unmounts the filesystems the snapshotter uses on the
+// host, such as the ext4 writable layer of extract snapshots. Tests replace
+// it to cover mount sequencing and error handling without root.
+type mounter interface {
+	// Mount mounts m at target.
+	Mount(m mount.Mount, target string) error
+	// Unmount unmounts everything stacked on target. It returns nil if
+	// target is not a mount point.
+	Unmount(target string) error
+}
+
+// hostMounter is the mounter backed by the kernel.
+type hostMounter struct{}
+
+func (hostMounter) Mount(m mount.Mount, target string) error {
+	return m.Mount(target)
+}
+
+func (hostMounter) Unmount(target string) error {
+	return unmountAll(target)
+}
+
+// hostMounts returns the mounter set on the snapshotter, or hostMounter.
+func (s *snapshotter) hostMounts() mounter {
+	if s.mounter != nil {
+		return s.mounter
+	}
+	return hostMounter{}
+}
@@ -0,0 +1,206 @@
+package snapshotter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/core/snapshots"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/erofs"
+	"github.com/spin-stack/erofs-snapshotter/internal/testutil"
+)
+
+// fakeMounter records mounts instead of performing them.
+type fakeMounter struct {
+	mu       sync.Mutex
+	mounts   map[string]mount.Mount
+	unmounts []string
+	// mountErr is returned by every Mount.
+	mountErr error
+	// onMount runs after a successful Mount, e.g. to populate target.
+	onMount func(target string) error
+}
+
+func (f *fakeMounter) Mount(m mount.Mount, target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.mountErr != nil {
+		return f.mountErr
+	}
+	if f.mounts == nil {
+		f.mounts = make(map[string]mount.Mount)
+	}
+	f.mounts[target] = m
+	if f.onMount != nil {
+		return f.onMount(target)
+	}
+	return nil
+}
+
+func (f *fakeMounter) Unmount(target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unmounts = append(f.unmounts, target)
+	delete(f.mounts, target)
+	return nil
+}
+
+// newFakeMountSnapshotter returns a metadata-only snapshotter with a fake
+// mounter and fake mkfs tools.
+func newFakeMountSnapshotter(t *testing.T) (*snapshotter, *fakeMounter) {
+	t.Helper()
+	testutil.InstallFakeMkfs(t)
+
+	s := newMetadataOnlySnapshotter(t)
+	s.defaultWritable = 1 << 20
+	if err := os.MkdirAll(s.snapshotsDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	fm := &fakeMounter{}
+	s.mounter = fm
+	return s, fm
+}
+
+func TestExtractPrepareMountsWritableLayer(t *testing.T) {
+	s, fm := newFakeMountSnapshotter(t)
+
+	mounts, err := s.Prepare(t.Context(), "extract-1", "")
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	id := snapshotID(t.Context(), t, s, "extract-1")
+
+	m, ok := fm.mounts[s.blockRwMountPath(id)]
+	if !ok || m.Source != s.writablePath(id) || m.Type != "ext4" {
+		t.Fatalf("expected writable layer mounted at rw, got %+v", fm.mounts)
+	}
+	if len(mounts) != 1 || mounts[0].Type != "bind" || mounts[0].Source != s.blockUpperPath(id) {
+		t.Errorf("expected bind mount of the upper directory, got %+v", mounts)
+	}
+	for _, p := range []string{
+		s.blockUpperPath(id),
+		filepath.Join(s.blockRwMountPath(id), "work"),
+		filepath.Join(s.snapshotDir(id), erofs.ErofsLayerMarker),
+	} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("expected %s to exist: %v", p, err)
+		}
+	}
+
+	if err := s.Remove(t.Context(), "extract-1"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if !slices.Contains(fm.unmounts, s.blockRwMountPath(id)) {
+		t.Errorf("expected Remove to unmount the writable layer, got %v", fm.unmounts)
+	}
+}
+
+func TestExtractPrepareMountFailure(t *testing.T) {
+	s, fm := newFakeMountSnapshotter(t)
+	fm.mountErr = errors.New("mount failed")
+
+	_, err := s.Prepare(t.Context(), "extract-1", "")
+	var merr *BlockMountError
+	if !errors.As(err, &merr) || !errors.Is(err, fm.mountErr) {
+		t.Fatalf("expected BlockMountError wrapping the mount error, got %v", err)
+	}
+	if merr.Target != s.blockRwMountPath(merr.SnapshotID) {
+		t.Errorf("unexpected mount target %q", merr.Target)
+	}
+	if _, err := os.Stat(s.snapshotDir(merr.SnapshotID)); !os.IsNotExist(err) {
+		t.Errorf("expected snapshot directory to be removed, got %v", err)
+	}
+}
+
+func TestExtractPrepareUnmountsOnSetupFailure(t *testing.T) {
+	s, fm := newFakeMountSnapshotter(t)
+	// A file where the upper directory belongs makes creating it fail
+	// after the writable layer is mounted.
+	fm.onMount = func(target string) error {
+		return os.WriteFile(filepath.Join(target, upperDirName), nil, 0o644)
+	}
+
+	if _, err := s.Prepare(t.Context(), "extract-1", ""); err == nil {
+		t.Fatal("expected Prepare to fail")
+	}
+	if len(fm.unmounts) == 0 {
+		t.Fatal("expected the writable layer to be unmounted")
+	}
+	if len(fm.mounts) != 0 {
+		t.Errorf("expected no mounts left, got %+v", fm.mounts)
+	}
+}
+
+func TestExtractPrepareBlockModeFallback(t *testing.T) {
+	s, fm := newFakeMountSnapshotter(t)
+	s.blockModeFallback = true
+	orig := loopControlPath
+	t.Cleanup(func() { loopControlPath = orig })
+	loopControlPath = filepath.Join(t.TempDir(), "loop-control")
+
+	mounts, err := s.Prepare(t.Context(), "extract-1", "")
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	id := snapshotID(t.Context(), t, s, "extract-1")
+	if len(fm.mounts) != 0 {
+		t.Errorf("expected no host mounts, got %+v", fm.mounts)
+	}
+	if _, err := os.Stat(s.writablePath(id)); !os.IsNotExist(err) {
+		t.Errorf("expected no writable layer image, got %v", err)
+	}
+	if len(mounts) != 1 || mounts[0].Type != "bind" || mounts[0].Source != s.upperPath(id) {
+		t.Fatalf("expected bind mount of fs/, got %+v", mounts)
+	}
+	info, err := s.Stat(t.Context(), "extract-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Labels[LabelBlockFallback] != "true" {
+		t.Errorf("expected %s on the snapshot, got %v", LabelBlockFallback, info.Labels)
+	}
+
+	// Later operations follow the label: Mounts binds fs/ again and Commit
+	// converts what the differ wrote there.
+	if again, err := s.Mounts(t.Context(), "extract-1"); err != nil || again[0].Source != s.upperPath(id) {
+		t.Errorf("Mounts = %+v, %v; want bind mount of fs/", again, err)
+	}
+	if err := os.WriteFile(filepath.Join(s.upperPath(id), "file"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(t.Context(), "layer", "extract-1"); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if _, err := os.Stat(s.fallbackLayerBlobPath(id)); err != nil {
+		t.Errorf("expected committed layer blob: %v", err)
+	}
+
+	// With loop devices available the block writable layer is used.
+	loopControlPath = filepath.Join(t.TempDir(), "loop-control")
+	if err := os.WriteFile(loopControlPath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Prepare(t.Context(), "extract-2", ""); err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if id := snapshotID(t.Context(), t, s, "extract-2"); fm.mounts[s.blockRwMountPath(id)].Source != s.writablePath(id) {
+		t.Errorf("expected the writable layer to be mounted, got %+v", fm.mounts)
+	}
+
+	// Clients cannot request the fallback themselves.
+	if _, err := s.Prepare(t.Context(), "extract-3", "",
+		snapshots.WithLabels(map[string]string{LabelBlockFallback: "true"})); err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if info, err := s.Stat(t.Context(), "extract-3"); err != nil || info.Labels[LabelBlockFallback] != "" {
+		t.Errorf("expected client %s to be dropped, got %v, %v", LabelBlockFallback, info.Labels, err)
+	}
+	if id := snapshotID(t.Context(), t, s, "extract-3"); fm.mounts[s.blockRwMountPath(id)].Source != s.writablePath(id) {
+		t.Errorf("expected the writable layer to be mounted, got %+v", fm.mounts)
+	}
+}
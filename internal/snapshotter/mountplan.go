@@ -0,0 +1,88 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+)
+
+// MountPlanKind classifies the mounts Mounts returns for a snapshot.
+type MountPlanKind string
+
+const (
+	// MountPlanExtract is a bind mount of the writable layer's upper
+	// directory, mounted on the host for the differ.
+	MountPlanExtract MountPlanKind = "extract"
+	// MountPlanEmpty is a read-only bind mount of an empty directory, for a
+	// view without parents.
+	MountPlanEmpty MountPlanKind = "empty"
+	// MountPlanBlock is the ext4 writable layer alone, for an active
+	// snapshot without parents.
+	MountPlanBlock MountPlanKind = "block"
+	// MountPlanErofs is one erofs mount per parent layer, followed by the
+	// ext4 writable layer for active snapshots.
+	MountPlanErofs MountPlanKind = "erofs"
+	// MountPlanFsMeta is a single format/erofs mount of the merged fsmeta,
+	// followed by the ext4 writable layer for active snapshots.
+	MountPlanFsMeta MountPlanKind = "fsmeta"
+)
+
+// MountPlanner is implemented by snapshotters that can report which kind of
+// mounts a snapshot will get without building them.
+type MountPlanner interface {
+	MountPlan(ctx context.Context, key string) (MountPlanKind, error)
+}
+
+// MountPlan returns the kind of mounts Mounts would return for key. It
+// follows the same decision tree as mounts but creates no directories.
+// The answer can change from MountPlanErofs to MountPlanFsMeta once
+// background fsmeta generation for the parent chain completes.
+func (s *snapshotter) MountPlan(ctx context.Context, key string) (MountPlanKind, error) {
+	var snap storage.Snapshot
+	var info snapshots.Info
+	var blobs layerBlobIndex
+	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) (err error) {
+		snap, err = storage.GetSnapshot(ctx, key)
+		if err != nil {
+			return fmt.Errorf("get active mount: %w", err)
+		}
+		_, info, _, err = storage.GetInfo(ctx, key)
+		if err != nil {
+			return fmt.Errorf("get snapshot info: %w", err)
+		}
+		blobs, err = s.mountLayerBlobs(ctx, info)
+		return err
+	}); err != nil {
+		return "", err
+	}
+	return s.mountPlan(snap, info, blobs)
+}
+
+// mountPlan mirrors the decision tree of mounts, viewMountsForKind,
+// activeMountsForKind and buildErofsLayerMounts.
+func (s *snapshotter) mountPlan(snap storage.Snapshot, info snapshots.Info, blobs layerBlobIndex) (MountPlanKind, error) {
+	if isExtractSnapshot(info) {
+		return MountPlanExtract, nil
+	}
+	switch snap.Kind {
+	case snapshots.KindView:
+		switch len(snap.ParentIDs) {
+		case 0:
+			return MountPlanEmpty, nil
+		case 1:
+			return MountPlanErofs, nil
+		}
+	case snapshots.KindActive:
+		if len(snap.ParentIDs) == 0 {
+			return MountPlanBlock, nil
+		}
+	default:
+		return "", fmt.Errorf("unsupported snapshot kind: %v", snap.Kind)
+	}
+	if _, ok := s.mountFsMeta(snap, blobs); ok {
+		return MountPlanFsMeta, nil
+	}
+	return MountPlanErofs, nil
+}
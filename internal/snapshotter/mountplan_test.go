@@ -0,0 +1,75 @@
+package snapshotter
+
+import (
+	"os"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+)
+
+func TestMountPlan(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	commitMetadataLayer(t, s, "base", "", []byte("base"))
+	top := commitMetadataLayer(t, s, "top", "base", []byte("top"))
+	empty := createMetadataSnapshot(t, s, snapshots.KindView, "empty-view", "")
+	createMetadataSnapshot(t, s, snapshots.KindActive, "scratch", "")
+	createMetadataSnapshot(t, s, snapshots.KindView, "single-view", "base")
+	createMetadataSnapshot(t, s, snapshots.KindActive, "container", "top")
+	extract := createMetadataSnapshot(t, s, snapshots.KindActive, "default/1/extract-1", "top",
+		snapshots.WithLabels(map[string]string{extractLabel: "true"}))
+
+	tests := []struct {
+		key       string
+		want      MountPlanKind
+		mountType string
+	}{
+		{"default/1/extract-1", MountPlanExtract, "bind"},
+		{"empty-view", MountPlanEmpty, "bind"},
+		{"scratch", MountPlanBlock, "ext4"},
+		{"single-view", MountPlanErofs, "erofs"},
+		{"container", MountPlanErofs, "erofs"},
+	}
+	for _, tc := range tests {
+		got, err := s.MountPlan(t.Context(), tc.key)
+		if err != nil {
+			t.Fatalf("MountPlan(%q): %v", tc.key, err)
+		}
+		if got != tc.want {
+			t.Errorf("MountPlan(%q) = %q, want %q", tc.key, got, tc.want)
+		}
+	}
+
+	// MountPlan has no side effects; Mounts creates the empty view directory.
+	if _, err := os.Stat(s.viewLowerPath(empty.ID)); !os.IsNotExist(err) {
+		t.Errorf("MountPlan should not create directories, got %v", err)
+	}
+	for _, id := range []string{empty.ID, extract.ID} {
+		if err := os.MkdirAll(s.snapshotDir(id), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range tests {
+		mounts, err := s.Mounts(t.Context(), tc.key)
+		if err != nil {
+			t.Fatalf("Mounts(%q): %v", tc.key, err)
+		}
+		if mounts[0].Type != tc.mountType {
+			t.Errorf("Mounts(%q)[0].Type = %q, want %q", tc.key, mounts[0].Type, tc.mountType)
+		}
+	}
+
+	// Once fsmeta exists for the parent chain it short-circuits the layers.
+	for _, p := range []string{s.fsMetaPath(top), s.vmdkPath(top)} {
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := s.MountPlan(t.Context(), "container"); err != nil || got != MountPlanFsMeta {
+		t.Errorf("MountPlan(container) = %q, %v; want %q", got, err, MountPlanFsMeta)
+	}
+
+	if _, err := s.MountPlan(t.Context(), "missing"); !errdefs.IsNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
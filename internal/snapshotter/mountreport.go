@@ -0,0 +1,70 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+)
+
+// LoopDeviceInfo describes a host loop device backed by a layer blob.
+type LoopDeviceInfo struct {
+	// Path is the device path, e.g. "/dev/loop3".
+	Path string
+	// DirectIO reports whether the device bypasses the host page cache
+	// when reading the blob.
+	DirectIO bool
+}
+
+// LayerMountInfo describes how one layer of a snapshot is mounted on the
+// host and how much of its blob is in the page cache.
+type LayerMountInfo struct {
+	LayerRef
+	// LoopDevices are the host loop devices backed by the blob.
+	LoopDevices []LoopDeviceInfo
+	// Shared reports whether more than one loop device reads the blob.
+	// Buffered devices then share the blob's page cache.
+	Shared bool
+	// CachedBytes is how much of the blob is resident in the page cache.
+	CachedBytes int64
+}
+
+// MountInfoReport describes the layers mounted for a snapshot.
+type MountInfoReport struct {
+	// Key is the snapshot the report is for.
+	Key string
+	// Layers are the snapshot's parent layers, oldest first.
+	Layers []LayerMountInfo
+}
+
+// MountInspector is implemented by snapshotters that can report the host
+// state of a snapshot's layers for diagnostics.
+type MountInspector interface {
+	MountInfo(ctx context.Context, key string) (MountInfoReport, error)
+}
+
+// MountInfo reports the loop devices backed by each layer blob of the active
+// or view snapshot key and the blob's page cache residency. Layers handed to
+// a VM as block devices have no host loop devices, but their cached bytes
+// still show whether shared base layers share the host page cache. It only
+// reads /sys and the page cache state; mounts are not changed.
+func (s *snapshotter) MountInfo(ctx context.Context, key string) (MountInfoReport, error) {
+	refs, err := s.Chain(ctx, key)
+	if err != nil {
+		return MountInfoReport{}, err
+	}
+
+	report := MountInfoReport{Key: key, Layers: make([]LayerMountInfo, 0, len(refs))}
+	for _, ref := range refs {
+		layer := LayerMountInfo{LayerRef: ref}
+		if ref.Exists {
+			if layer.LoopDevices, err = blobLoopDevices(ref.BlobPath); err != nil {
+				return MountInfoReport{}, fmt.Errorf("layer %s: %w", ref.ID, err)
+			}
+			layer.Shared = len(layer.LoopDevices) > 1
+			if layer.CachedBytes, err = pageCacheResidency(ref.BlobPath); err != nil {
+				return MountInfoReport{}, fmt.Errorf("layer %s: %w", ref.ID, err)
+			}
+		}
+		report.Layers = append(report.Layers, layer)
+	}
+	return report, nil
+}
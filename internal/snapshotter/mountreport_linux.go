@@ -0,0 +1,67 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/loop"
+)
+
+// blobLoopDevices returns the loop devices backed by blob.
+func blobLoopDevices(blob string) ([]LoopDeviceInfo, error) {
+	devices, err := loop.FindAllByBackingFile(blob)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]LoopDeviceInfo, 0, len(devices))
+	for _, dev := range devices {
+		dio, err := dev.DirectIO()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, LoopDeviceInfo{Path: dev.Path, DirectIO: dio})
+	}
+	return infos, nil
+}
+
+// pageCacheResidency returns how many bytes of path are in the page cache,
+// counted with mincore(2) on a read-only mapping of the file.
+func pageCacheResidency(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if fi.Size() == 0 {
+		return 0, nil
+	}
+
+	data, err := unix.Mmap(int(f.Fd()), 0, int(fi.Size()), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return 0, fmt.Errorf("map %s: %w", path, err)
+	}
+	defer func() { _ = unix.Munmap(data) }()
+
+	pageSize := int64(os.Getpagesize())
+	vec := make([]byte, (fi.Size()+pageSize-1)/pageSize)
+	if _, _, errno := unix.Syscall(unix.SYS_MINCORE, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), uintptr(unsafe.Pointer(&vec[0]))); errno != 0 {
+		return 0, fmt.Errorf("mincore %s: %w", path, errno)
+	}
+	var resident int64
+	for _, v := range vec {
+		if v&1 != 0 {
+			resident++
+		}
+	}
+	return min(resident*pageSize, fi.Size()), nil
+}
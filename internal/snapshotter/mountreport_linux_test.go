@@ -0,0 +1,44 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"os"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+func TestMountInfo(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	base := commitMetadataLayer(t, s, "base", "", []byte("base"))
+	top := commitMetadataLayer(t, s, "top", "base", make([]byte, 3*os.Getpagesize()))
+	createMetadataSnapshot(t, s, snapshots.KindView, "view", "top")
+
+	// Pull the blob into the page cache.
+	if _, err := os.ReadFile(s.fallbackLayerBlobPath(top)); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.MountInfo(t.Context(), "view")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Key != "view" || len(report.Layers) != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Layers[0].ID != base || report.Layers[1].ID != top {
+		t.Errorf("layers = %s, %s; want %s, %s", report.Layers[0].ID, report.Layers[1].ID, base, top)
+	}
+	layer := report.Layers[1]
+	if len(layer.LoopDevices) != 0 || layer.Shared {
+		t.Errorf("expected no loop devices, got %+v", layer.LoopDevices)
+	}
+	if layer.CachedBytes != layer.Size {
+		t.Errorf("CachedBytes = %d, want %d", layer.CachedBytes, layer.Size)
+	}
+
+	if _, err := s.MountInfo(t.Context(), "missing"); err == nil {
+		t.Error("expected error for missing snapshot")
+	}
+}
@@ -1,6 +1,7 @@
 package snapshotter
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -14,6 +15,8 @@ import (
 
 // mountFsMeta returns a mount for merged fsmeta.erofs if VMDK exists.
 // When VMDK exists, the consumer can use a single virtio-blk device for all layers.
+// If a layer blob was rebuilt since the merge (see fsMetaDevicesMatch), the
+// caller falls back to individual layer mounts.
 //
 // The mount type is "format/erofs" (not plain "erofs") to signal that this is a
 // VM-only mount requiring special handling. Containerd's standard mount manager
@@ -21,7 +24,7 @@ import (
 // than the cryptic EINVAL that occurs when it tries to mount EROFS with file paths
 // in device= options. VM runtimes (like qemubox) and the custom mountutils.MountAll()
 // understand this type and handle it correctly.
-func (s *snapshotter) mountFsMeta(snap storage.Snapshot) (mount.Mount, bool) {
+func (s *snapshotter) mountFsMeta(snap storage.Snapshot, blobs layerBlobIndex) (mount.Mount, bool) {
 	if len(snap.ParentIDs) == 0 {
 		return mount.Mount{}, false
 	}
@@ -43,14 +46,18 @@ func (s *snapshotter) mountFsMeta(snap storage.Snapshot) (mount.Mount, bool) {
 	// This produces oldest-first order matching containerd's approach and the order
 	// used when generating fsmeta with mkfs.erofs.
 	// See: https://github.com/containerd/containerd/pull/12374
-	var deviceOptions []string
+	var devices, deviceOptions []string
 	for i := len(snap.ParentIDs) - 1; i >= 0; i-- {
-		blob, err := s.findLayerBlob(snap.ParentIDs[i])
+		blob, err := s.lowerPath(snap.ParentIDs[i], blobs)
 		if err != nil {
 			return mount.Mount{}, false
 		}
+		devices = append(devices, blob)
 		deviceOptions = append(deviceOptions, "device="+blob)
 	}
+	if !s.fsMetaDevicesMatch(parentID, devices) {
+		return mount.Mount{}, false
+	}
 
 	return mount.Mount{
 		Source:  fsmetaFile,
@@ -64,30 +71,34 @@ func (s *snapshotter) mountFsMeta(snap storage.Snapshot) (mount.Mount, bool) {
 // DECISION TREE:
 //
 //	Is extract snapshot (extractLabel=true)?
-//	├─ YES → diffMounts(): bind mount to rw/upper/ for EROFS differ
+//	├─ YES → diffMounts(): bind mount to rw/upper/ (or fs/ with
+//	│         LabelBlockFallback) for EROFS differ
 //	└─ NO  → Check snapshot kind:
 //	         ├─ KindView  → viewMountsForKind(): read-only layer access
 //	         └─ KindActive → activeMountsForKind(): layers + writable ext4
 //
+// blobs holds layer blob paths recorded on parent snapshots; parents missing
+// from it are located by globbing their snapshot directories.
+//
 // Mounts use raw file paths for VM consumers. The "loop" option signals
 // that host mounting requires loop device setup. VM runtimes convert
 // these paths to virtio-blk devices directly.
-func (s *snapshotter) mounts(snap storage.Snapshot, info snapshots.Info) ([]mount.Mount, error) {
+func (s *snapshotter) mounts(ctx context.Context, snap storage.Snapshot, info snapshots.Info, blobs layerBlobIndex) ([]mount.Mount, error) {
 	// Extract snapshots use bind mount to upper directory.
 	// The EROFS differ writes directly to this directory, which is inside
 	// the mounted rwlayer.img ext4 filesystem.
 	if isExtractSnapshot(info) {
-		return s.diffMounts(snap)
+		return s.diffMounts(ctx, snap, info)
 	}
 
 	// View snapshots: read-only access to committed layers
 	if snap.Kind == snapshots.KindView {
-		return s.viewMountsForKind(snap)
+		return s.viewMountsForKind(snap, blobs)
 	}
 
 	// Active snapshots: read-only layers + writable ext4
 	if snap.Kind == snapshots.KindActive {
-		return s.activeMountsForKind(snap)
+		return s.activeMountsForKind(snap, blobs)
 	}
 
 	return nil, fmt.Errorf("unsupported snapshot kind: %v", snap.Kind)
@@ -102,7 +113,7 @@ func (s *snapshotter) mounts(snap storage.Snapshot, info snapshots.Info) ([]moun
 //	N parents → viewMounts():
 //	            ├─ fsmeta exists? → single fsmeta mount (type: format/erofs)
 //	            └─ no fsmeta     → N individual EROFS mounts
-func (s *snapshotter) viewMountsForKind(snap storage.Snapshot) ([]mount.Mount, error) {
+func (s *snapshotter) viewMountsForKind(snap storage.Snapshot, blobs layerBlobIndex) ([]mount.Mount, error) {
 	// 0 parents: bind mount to empty directory.
 	// This is rare but valid for empty base images.
 	if len(snap.ParentIDs) == 0 {
@@ -123,7 +134,7 @@ func (s *snapshotter) viewMountsForKind(snap storage.Snapshot) ([]mount.Mount, e
 	// No fsmeta needed for single layer. Linux overlay requires 2+ lowerdirs
 	// or an upperdir, so we return the EROFS directly.
 	if len(snap.ParentIDs) == 1 {
-		layerBlob, err := s.lowerPath(snap.ParentIDs[0])
+		layerBlob, err := s.lowerPath(snap.ParentIDs[0], blobs)
 		if err != nil {
 			return nil, fmt.Errorf("get layer blob for view parent %s: %w", snap.ParentIDs[0], err)
 		}
@@ -137,7 +148,7 @@ func (s *snapshotter) viewMountsForKind(snap storage.Snapshot) ([]mount.Mount, e
 	}
 
 	// N parents: try fsmeta for efficiency, fall back to individual mounts
-	return s.viewMounts(snap)
+	return s.viewMounts(snap, blobs)
 }
 
 // activeMountsForKind returns mounts for KindActive snapshots.
@@ -149,14 +160,16 @@ func (s *snapshotter) viewMountsForKind(snap storage.Snapshot) ([]mount.Mount, e
 //	            ├─ fsmeta exists? → fsmeta mount + ext4 (2 mounts)
 //	            └─ no fsmeta     → N EROFS mounts + ext4 (N+1 mounts)
 //
+// Named writable volumes add one ext4 mount each, just before the last.
+//
 // The VM runtime combines these into an overlay filesystem inside the guest.
-func (s *snapshotter) activeMountsForKind(snap storage.Snapshot) ([]mount.Mount, error) {
+func (s *snapshotter) activeMountsForKind(snap storage.Snapshot, blobs layerBlobIndex) ([]mount.Mount, error) {
 	// 0 parents: only the writable ext4 layer
 	if len(snap.ParentIDs) == 0 {
 		return s.singleLayerMounts(snap)
 	}
 	// N parents: read-only EROFS layers + writable ext4
-	return s.activeMounts(snap)
+	return s.activeMounts(snap, blobs)
 }
 
 // isExtractSnapshot returns true if the snapshot is marked for layer extraction.
@@ -167,7 +180,8 @@ func isExtractSnapshot(info snapshots.Info) bool {
 }
 
 // singleLayerMounts returns mounts for an Active snapshot with no parent layers.
-// Returns the ext4 writable layer as a block device for VM runtimes.
+// Returns the ext4 writable layer as a block device for VM runtimes, after
+// any named writable volumes.
 func (s *snapshotter) singleLayerMounts(snap storage.Snapshot) ([]mount.Mount, error) {
 	if snap.Kind != snapshots.KindActive {
 		return nil, fmt.Errorf("singleLayerMounts only supports Active snapshots, got %v", snap.Kind)
@@ -175,24 +189,25 @@ func (s *snapshotter) singleLayerMounts(snap storage.Snapshot) ([]mount.Mount, e
 
 	// Return the ext4 writable layer file path directly.
 	// VM runtime (the consumer) passes this as a virtio-blk device to the guest.
-	rwLayerPath := s.writablePath(snap.ID)
-	return []mount.Mount{
-		{
-			Source:  rwLayerPath,
-			Type:    "ext4",
-			Options: []string{"rw", "loop"},
-		},
-	}, nil
+	mounts, err := s.volumeMounts(snap.ID)
+	if err != nil {
+		return nil, err
+	}
+	return append(mounts, s.writableMount(snap.ID)), nil
 }
 
 // diffMounts returns mounts for extract snapshots.
 // The ext4 is mounted at blockRwMountPath, and we return a bind mount to upper.
-func (s *snapshotter) diffMounts(snap storage.Snapshot) ([]mount.Mount, error) {
+// Snapshots with LabelBlockFallback bind their fs/ directory instead.
+func (s *snapshotter) diffMounts(ctx context.Context, snap storage.Snapshot, info snapshots.Info) ([]mount.Mount, error) {
 	upperRoot := s.blockUpperPath(snap.ID)
+	if info.Labels[LabelBlockFallback] == "true" {
+		upperRoot = s.upperPath(snap.ID)
+	}
 	snapshotDir := s.snapshotDir(snap.ID)
 
 	// Ensure EROFS layer marker exists at the snapshot root for diff operations.
-	if err := ensureMarkerFile(filepath.Join(snapshotDir, erofs.ErofsLayerMarker)); err != nil {
+	if err := s.ensureMarker(ctx, filepath.Join(snapshotDir, erofs.ErofsLayerMarker)); err != nil {
 		return nil, fmt.Errorf("create erofs marker: %w", err)
 	}
 
@@ -208,12 +223,12 @@ func (s *snapshotter) diffMounts(snap storage.Snapshot) ([]mount.Mount, error) {
 // getErofsLayerPaths returns the EROFS layer blob paths for a snapshot.
 // This returns file paths without mounting - the consumer
 // transforms these to virtio-blk disks or uses mount manager to mount them.
-func (s *snapshotter) getErofsLayerPaths(snap storage.Snapshot) ([]string, error) {
+func (s *snapshotter) getErofsLayerPaths(snap storage.Snapshot, blobs layerBlobIndex) ([]string, error) {
 	var paths []string
-	for _, parentID := range snap.ParentIDs {
-		layerBlob, err := s.lowerPath(parentID)
+	for i, parentID := range snap.ParentIDs {
+		layerBlob, err := s.lowerPath(parentID, blobs)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("parent %d of %d (snapshot %s): %w", i, len(snap.ParentIDs), parentID, err)
 		}
 		paths = append(paths, layerBlob)
 	}
@@ -229,14 +244,14 @@ func (s *snapshotter) getErofsLayerPaths(snap storage.Snapshot) ([]string, error
 // Return formats:
 //   - With fsmeta: [{type: format/erofs, source: fsmeta.erofs, options: [device=layer1, ...]}]
 //   - Without:     [{type: erofs, source: layer1.erofs}, {type: erofs, source: layer2.erofs}, ...]
-func (s *snapshotter) buildErofsLayerMounts(snap storage.Snapshot) ([]mount.Mount, error) {
+func (s *snapshotter) buildErofsLayerMounts(snap storage.Snapshot, blobs layerBlobIndex) ([]mount.Mount, error) {
 	// Try fsmeta first (single mount with VMDK) - preferred for efficiency
-	if m, ok := s.mountFsMeta(snap); ok {
+	if m, ok := s.mountFsMeta(snap, blobs); ok {
 		return []mount.Mount{m}, nil
 	}
 
 	// Fallback: individual EROFS mounts (fsmeta not ready or generation failed)
-	layerPaths, err := s.getErofsLayerPaths(snap)
+	layerPaths, err := s.getErofsLayerPaths(snap, blobs)
 	if err != nil {
 		return nil, err
 	}
@@ -256,8 +271,8 @@ func (s *snapshotter) buildErofsLayerMounts(snap storage.Snapshot) ([]mount.Moun
 }
 
 // viewMounts returns mounts for multi-layer KindView snapshots.
-func (s *snapshotter) viewMounts(snap storage.Snapshot) ([]mount.Mount, error) {
-	return s.buildErofsLayerMounts(snap)
+func (s *snapshotter) viewMounts(snap storage.Snapshot, blobs layerBlobIndex) ([]mount.Mount, error) {
+	return s.buildErofsLayerMounts(snap, blobs)
 }
 
 // activeMounts returns mounts for active (writable) snapshots with parents.
@@ -265,20 +280,19 @@ func (s *snapshotter) viewMounts(snap storage.Snapshot) ([]mount.Mount, error) {
 // Returns read-only EROFS layer(s) plus a writable ext4 block device.
 // The VM runtime creates an overlay filesystem from these inside the guest.
 // The ext4 mount is always last, making it easy for consumers to identify
-// the writable layer.
-func (s *snapshotter) activeMounts(snap storage.Snapshot) ([]mount.Mount, error) {
-	mounts, err := s.buildErofsLayerMounts(snap)
+// the writable layer. Named writable volumes, tagged with VolumeMountOption,
+// come just before it.
+func (s *snapshotter) activeMounts(snap storage.Snapshot, blobs layerBlobIndex) ([]mount.Mount, error) {
+	mounts, err := s.buildErofsLayerMounts(snap, blobs)
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := s.volumeMounts(snap.ID)
 	if err != nil {
 		return nil, err
 	}
+	mounts = append(mounts, volumes...)
 
 	// Writable layer: ext4 block device (always last)
-	rwLayerPath := s.writablePath(snap.ID)
-	mounts = append(mounts, mount.Mount{
-		Source:  rwLayerPath,
-		Type:    "ext4",
-		Options: []string{"rw", "loop"},
-	})
-
-	return mounts, nil
+	return append(mounts, s.writableMount(snap.ID)), nil
 }
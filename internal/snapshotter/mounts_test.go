@@ -1,8 +1,10 @@
 package snapshotter
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/containerd/containerd/v2/core/snapshots"
@@ -49,7 +51,7 @@ func TestViewMountsFallbackToIndividualLayers(t *testing.T) {
 		ParentIDs: parentIDs,
 	}
 
-	mounts, err := s.viewMounts(snap)
+	mounts, err := s.viewMounts(snap, nil)
 	if err != nil {
 		t.Fatalf("viewMounts failed: %v", err)
 	}
@@ -100,7 +102,7 @@ func TestActiveMountsFallbackToIndividualLayers(t *testing.T) {
 		ParentIDs: []string{"parent1"},
 	}
 
-	mounts, err := s.activeMounts(snap)
+	mounts, err := s.activeMounts(snap, nil)
 	if err != nil {
 		t.Fatalf("activeMounts failed: %v", err)
 	}
@@ -143,7 +145,7 @@ func TestViewMountsForKindDecisionTree(t *testing.T) {
 			ParentIDs: []string{}, // No parents
 		}
 
-		mounts, err := s.viewMountsForKind(snap)
+		mounts, err := s.viewMountsForKind(snap, nil)
 		if err != nil {
 			t.Fatalf("viewMountsForKind failed: %v", err)
 		}
@@ -177,7 +179,7 @@ func TestViewMountsForKindDecisionTree(t *testing.T) {
 			ParentIDs: []string{"parent1"},
 		}
 
-		mounts, err := s.viewMountsForKind(snap)
+		mounts, err := s.viewMountsForKind(snap, nil)
 		if err != nil {
 			t.Fatalf("viewMountsForKind failed: %v", err)
 		}
@@ -225,7 +227,7 @@ func TestViewMountsForKindDecisionTree(t *testing.T) {
 			ParentIDs: parentIDs,
 		}
 
-		mounts, err := s.viewMountsForKind(snap)
+		mounts, err := s.viewMountsForKind(snap, nil)
 		if err != nil {
 			t.Fatalf("viewMountsForKind failed: %v", err)
 		}
@@ -261,7 +263,7 @@ func TestActiveMountsForKindDecisionTree(t *testing.T) {
 			ParentIDs: []string{}, // No parents
 		}
 
-		mounts, err := s.activeMountsForKind(snap)
+		mounts, err := s.activeMountsForKind(snap, nil)
 		if err != nil {
 			t.Fatalf("activeMountsForKind failed: %v", err)
 		}
@@ -291,3 +293,97 @@ func TestSingleLayerMountsRequiresActive(t *testing.T) {
 		t.Error("singleLayerMounts should reject non-Active snapshots")
 	}
 }
+
+func TestViewMountsPreferLayerBlobLabel(t *testing.T) {
+	// Mounts for a child of a committed snapshot should use the blob path
+	// recorded in LabelLayerBlobPath instead of globbing the parent directory.
+	s := newMetadataOnlySnapshotter(t)
+	if err := os.MkdirAll(s.snapshotsDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	labeled := s.fallbackLayerBlobPath(snap.ID)
+	if err := os.MkdirAll(s.upperPath(snap.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(labeled, []byte("fake"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(t.Context(), "base", "active"); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	// A digest-named blob would win the glob lookup.
+	globbed := filepath.Join(s.snapshotDir(snap.ID), "sha256-"+strings.Repeat("b", 64)+".erofs")
+	if err := os.WriteFile(globbed, []byte("fake"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mounts, err := s.View(t.Context(), "view", "base")
+	if err != nil {
+		t.Fatalf("view: %v", err)
+	}
+	if len(mounts) != 1 || mounts[0].Type != testMountErofs {
+		t.Fatalf("expected single erofs mount, got %+v", mounts)
+	}
+	if mounts[0].Source != labeled {
+		t.Errorf("mount source = %q, want labeled blob %q", mounts[0].Source, labeled)
+	}
+}
+
+func TestLowerPathIgnoresInvalidIndexEntry(t *testing.T) {
+	root := t.TempDir()
+	s := &snapshotter{root: root}
+
+	dir := s.snapshotDir("1")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	blob := filepath.Join(dir, "sha256-"+strings.Repeat("c", 64)+".erofs")
+	if err := os.WriteFile(blob, []byte("fake"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.lowerPath("1", layerBlobIndex{"1": "/etc/passwd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != blob {
+		t.Errorf("lowerPath() = %q, want %q", got, blob)
+	}
+}
+
+func TestMountsNameMissingParentBlob(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	commitMetadataLayer(t, s, "base", "", []byte("base"))
+	middle := commitMetadataLayer(t, s, "middle", "base", []byte("middle"))
+	commitMetadataLayer(t, s, "top", "middle", []byte("top"))
+	createMetadataSnapshot(t, s, snapshots.KindView, "view", "top")
+
+	blob := s.fallbackLayerBlobPath(middle)
+	if err := os.Remove(blob); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.Mounts(t.Context(), "view")
+	var nerr *LayerBlobNotFoundError
+	if !errors.As(err, &nerr) {
+		t.Fatalf("expected LayerBlobNotFoundError, got %v", err)
+	}
+	if nerr.SnapshotID != middle || nerr.ExpectedPath != blob || nerr.DirMissing {
+		t.Errorf("unexpected error fields %+v", nerr)
+	}
+	if !strings.Contains(err.Error(), "parent 1 of 3") {
+		t.Errorf("expected error to name the chain position, got %v", err)
+	}
+
+	// A missing snapshot directory is reported as such.
+	if err := os.RemoveAll(s.snapshotDir(middle)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Mounts(t.Context(), "view")
+	if !errors.As(err, &nerr) || !nerr.DirMissing {
+		t.Errorf("expected missing snapshot directory to be reported, got %v", err)
+	}
+}
@@ -2,21 +2,29 @@ package snapshotter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/containerd/containerd/v2/core/mount"
 	"github.com/containerd/containerd/v2/core/snapshots"
 	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/containerd/v2/pkg/tracing"
 	"github.com/containerd/continuity/fs"
 	"github.com/containerd/errdefs"
 	"github.com/containerd/log"
 
-	"github.com/spin-stack/erofs-snapshotter/internal/erofs"
+	"github.com/spin-stack/erofs-snapshotter/internal/store"
 )
 
 // fsmetaTimeout is the maximum time allowed for fsmeta generation.
@@ -50,6 +58,38 @@ func ensureMarkerFile(path string) error {
 	return nil
 }
 
+// MarkerFailureCounter is implemented by snapshotters that count EROFS layer
+// marker files they could not create.
+type MarkerFailureCounter interface {
+	MarkerFailures() uint64
+}
+
+// MarkerFailures returns the number of marker files that could not be
+// created because the filesystem was out of space or quota.
+func (s *snapshotter) MarkerFailures() uint64 {
+	return s.markerFailures.Load()
+}
+
+// ensureMarker creates the EROFS layer marker file at path. The marker is
+// only a hint for the differ; extractLabel in the metadata store remains
+// authoritative. Running out of space or quota is therefore logged and
+// counted rather than failing the operation. Other errors are returned.
+func (s *snapshotter) ensureMarker(ctx context.Context, path string) error {
+	err := ensureMarkerFile(path)
+	if err == nil || !isNoSpace(err) {
+		return err
+	}
+	s.markerFailures.Add(1)
+	log.G(ctx).WithError(err).WithField("path", path).Warn("failed to create erofs layer marker (non-fatal)")
+	return nil
+}
+
+// isNoSpace reports whether err was caused by the filesystem running out of
+// space, inodes or quota.
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
 // checkContext returns an error if the context is cancelled.
 func checkContext(ctx context.Context, operation string) error {
 	if err := ctx.Err(); err != nil {
@@ -63,32 +103,81 @@ func (s *snapshotter) createSnapshot(ctx context.Context, kind snapshots.Kind, k
 		snap     storage.Snapshot
 		td, path string
 		info     snapshots.Info
+		blobs    layerBlobIndex
+		start    = time.Now()
 	)
 
+	ctx, span := startSpan(ctx, "createSnapshot",
+		tracing.WithAttribute(attrSnapshotKey, key),
+		tracing.WithAttribute(attrSnapshotKind, kind.String()))
+	defer func() {
+		span.SetAttributes(
+			tracing.Attribute(attrSnapshotID, snap.ID),
+			tracing.Attribute(attrSnapshotParents, len(snap.ParentIDs)))
+		endSpan(span, err)
+	}()
+
 	defer func() {
 		if err != nil {
 			s.cleanupFailedSnapshot(ctx, td, path)
 		}
+		op := SnapshotOpPrepare
+		if kind == snapshots.KindView {
+			op = SnapshotOpView
+		}
+		s.emitEvent(op, key, snap.ID, kind, start, err)
 	}()
 
 	if err := checkContext(ctx, "before snapshot creation"); err != nil {
 		return nil, err
 	}
 
+	// Extract snapshots do not mount their parents.
+	if !isExtractKey(key) {
+		if err := s.verifyParentLayers(ctx, parent); err != nil {
+			return nil, err
+		}
+	}
+
 	snapshotDir := s.snapshotsDir()
-	td, err = s.prepareDirectory(snapshotDir, kind)
+	td, err = s.prepareDirectory(ctx, snapshotDir, kind)
 	if err != nil {
 		return nil, fmt.Errorf("create prepare snapshot dir: %w", err)
 	}
 
+	// LabelBlockFallback changes where the snapshot's content lives, so
+	// only the snapshotter may set it.
+	opts = append(slices.Clip(opts), func(info *snapshots.Info) error {
+		delete(info.Labels, LabelBlockFallback)
+		return nil
+	})
+
 	// Mark extract snapshots with a label for TOCTOU-safe detection.
+	var blockFallback bool
 	if isExtractKey(key) {
-		opts = append(opts, snapshots.WithLabels(map[string]string{
-			extractLabel: "true",
-		}))
+		labels := map[string]string{extractLabel: "true"}
+		if kind == snapshots.KindActive && s.blockModeFallback && !loopDevicesAvailable() {
+			log.G(ctx).WithField("key", key).Warn("loop devices unavailable, extracting into the snapshot directory")
+			labels[LabelBlockFallback] = "true"
+			blockFallback = true
+		}
+		opts = append(opts, snapshots.WithLabels(labels))
+	}
+
+	var volumes []writableVolume
+	if kind == snapshots.KindActive && !isExtractKey(key) {
+		if volumes, err = volumesFromOpts(opts); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := s.ms.WithTransaction(ctx, true, func(ctx context.Context) (err error) {
+		if kind == snapshots.KindActive {
+			if err := s.checkNamespaceQuota(ctx, key, "", s.defaultWritable+volumesSize(volumes)); err != nil {
+				return err
+			}
+		}
+
 		snap, err = storage.CreateSnapshot(ctx, kind, key, parent, opts...)
 		if err != nil {
 			return fmt.Errorf("create snapshot: %w", err)
@@ -99,11 +188,24 @@ func (s *snapshotter) createSnapshot(ctx context.Context, kind snapshots.Kind, k
 			return fmt.Errorf("get snapshot info: %w", err)
 		}
 
+		if blobs, err = s.mountLayerBlobs(ctx, info); err != nil {
+			return err
+		}
+
 		if len(snap.ParentIDs) > 0 {
 			if err := upperDirectoryPermission(filepath.Join(td, fsDirName), s.upperPath(snap.ParentIDs[0])); err != nil {
 				return fmt.Errorf("set upper directory permissions: %w", err)
 			}
 		}
+		if kind == snapshots.KindActive {
+			xattrs, err := s.upperXattrsFor(info)
+			if err != nil {
+				return err
+			}
+			if err := s.applyUpperXattrs(ctx, filepath.Join(td, fsDirName), xattrs); err != nil {
+				return err
+			}
+		}
 
 		path = filepath.Join(snapshotDir, snap.ID)
 		if err = os.Rename(td, path); err != nil {
@@ -115,6 +217,8 @@ func (s *snapshotter) createSnapshot(ctx context.Context, kind snapshots.Kind, k
 		return nil, err
 	}
 
+	ctx = withLifecycleFields(ctx, key, snap.ID, info, len(snap.ParentIDs))
+
 	if err := checkContext(ctx, "after transaction"); err != nil {
 		return nil, err
 	}
@@ -125,36 +229,49 @@ func (s *snapshotter) createSnapshot(ctx context.Context, kind snapshots.Kind, k
 	// but not required for basic snapshot operations.
 	if !isExtractKey(key) && len(snap.ParentIDs) > 0 {
 		parentIDs := snap.ParentIDs // capture for goroutine
-		s.bgWg.Add(1)
+		s.fsMetaWg.Add(1)
 		//nolint:contextcheck // intentionally using fresh context with timeout for background work
-		go func(ids []string) {
-			defer s.bgWg.Done()
+		go func(ids []string, blobs layerBlobIndex) {
+			defer s.fsMetaWg.Done()
 			// Use a fresh context with timeout - intentionally independent of parent
 			// context to allow completion even if the original request is cancelled.
 			bgCtx, cancel := context.WithTimeout(context.Background(), fsmetaTimeout)
 			defer cancel()
-			s.generateFsMeta(bgCtx, ids)
-		}(parentIDs)
+			s.generateFsMeta(bgCtx, ids, blobs)
+		}(parentIDs, blobs)
 	}
 
-	// For active snapshots, create the writable ext4 layer file.
-	if kind == snapshots.KindActive {
+	// For active snapshots, create the writable ext4 layer file. Fallback
+	// extract snapshots write to fs/ and need none.
+	if kind == snapshots.KindActive && !blockFallback {
 		if err := checkContext(ctx, "before writable layer creation"); err != nil {
 			return nil, err
 		}
 		if err := s.createWritableLayer(ctx, snap.ID); err != nil {
 			return nil, fmt.Errorf("create writable layer: %w", err)
 		}
+		for _, v := range volumes {
+			if err := s.formatWritableLayer(ctx, s.volumePath(snap.ID, v.name), v.size); err != nil {
+				return nil, fmt.Errorf("create writable volume %s: %w", v.name, err)
+			}
+		}
 
 		// For extract snapshots, mount the ext4 on the host so the differ can write to it.
 		if isExtractKey(key) {
-			if err := s.mountBlockRwLayer(ctx, snap.ID); err != nil {
+			if err := withTimeout(ctx, "mount", key, s.settings().timeouts.Mount, func(ctx context.Context) error {
+				return s.mountBlockRwLayer(ctx, snap.ID)
+			}); err != nil {
 				return nil, fmt.Errorf("mount writable layer for extraction: %w", err)
 			}
 		}
 	}
 
-	return s.mounts(snap, info)
+	mounts, err := s.mounts(ctx, snap, info, blobs)
+	if err != nil {
+		return nil, err
+	}
+	log.G(ctx).Debug("snapshot created")
+	return mounts, nil
 }
 
 // cleanupFailedSnapshot removes temporary and final directories on failure.
@@ -165,6 +282,10 @@ func (s *snapshotter) cleanupFailedSnapshot(ctx context.Context, td, path string
 		}
 	}
 	if path != "" {
+		// The writable layer of an extract snapshot may already be mounted.
+		if err := s.hostMounts().Unmount(filepath.Join(path, rwDirName)); err != nil {
+			log.G(ctx).WithError(err).WithField("path", path).Warn("failed to unmount writable layer of failed snapshot")
+		}
 		if err := os.RemoveAll(path); err != nil {
 			log.G(ctx).WithError(err).WithField("path", path).Error("failed to reclaim snapshot directory")
 		}
@@ -172,19 +293,37 @@ func (s *snapshotter) cleanupFailedSnapshot(ctx context.Context, td, path string
 }
 
 // Prepare creates an active snapshot for writing.
-func (s *snapshotter) Prepare(ctx context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
-	return s.createSnapshot(ctx, snapshots.KindActive, key, parent, opts)
+func (s *snapshotter) Prepare(ctx context.Context, key, parent string, opts ...snapshots.Opt) (mounts []mount.Mount, err error) {
+	err = withTimeout(ctx, "prepare", key, s.settings().timeouts.Prepare, func(ctx context.Context) error {
+		mounts, err = s.createSnapshot(ctx, snapshots.KindActive, key, parent, opts)
+		return err
+	})
+	return mounts, err
 }
 
 // View creates a view snapshot for reading.
-func (s *snapshotter) View(ctx context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
-	return s.createSnapshot(ctx, snapshots.KindView, key, parent, opts)
+func (s *snapshotter) View(ctx context.Context, key, parent string, opts ...snapshots.Opt) (mounts []mount.Mount, err error) {
+	err = withTimeout(ctx, "view", key, s.settings().timeouts.Prepare, func(ctx context.Context) error {
+		mounts, err = s.createSnapshot(ctx, snapshots.KindView, key, parent, opts)
+		return err
+	})
+	return mounts, err
 }
 
 // Mounts returns the mounts for a snapshot.
-func (s *snapshotter) Mounts(ctx context.Context, key string) (_ []mount.Mount, err error) {
+func (s *snapshotter) Mounts(ctx context.Context, key string) (mounts []mount.Mount, err error) {
+	err = withTimeout(ctx, "mount", key, s.settings().timeouts.Mount, func(ctx context.Context) error {
+		mounts, err = s.snapshotMounts(ctx, key)
+		return err
+	})
+	return mounts, err
+}
+
+// snapshotMounts returns the mounts for a snapshot without a time limit.
+func (s *snapshotter) snapshotMounts(ctx context.Context, key string) (_ []mount.Mount, err error) {
 	var snap storage.Snapshot
 	var info snapshots.Info
+	var blobs layerBlobIndex
 	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
 		snap, err = storage.GetSnapshot(ctx, key)
 		if err != nil {
@@ -195,11 +334,28 @@ func (s *snapshotter) Mounts(ctx context.Context, key string) (_ []mount.Mount,
 		if err != nil {
 			return fmt.Errorf("get snapshot info: %w", err)
 		}
-		return nil
+
+		blobs, err = s.mountLayerBlobs(ctx, info)
+		return err
 	}); err != nil {
 		return nil, err
 	}
-	return s.mounts(snap, info)
+	if !isExtractSnapshot(info) {
+		if err := s.verifyParentLayers(ctx, info.Parent); err != nil {
+			return nil, err
+		}
+	}
+	return s.mounts(ctx, snap, info, blobs)
+}
+
+// mountLayerBlobs returns the parent layer blob paths needed to build mounts
+// for info. Extract snapshots only mount their own upper, so no lookup is done.
+// Must be called within a transaction.
+func (s *snapshotter) mountLayerBlobs(ctx context.Context, info snapshots.Info) (layerBlobIndex, error) {
+	if isExtractSnapshot(info) {
+		return nil, nil
+	}
+	return parentLayerBlobs(ctx, info)
 }
 
 func (s *snapshotter) getCleanupDirectories(ctx context.Context) ([]string, error) {
@@ -231,38 +387,90 @@ func (s *snapshotter) getCleanupDirectories(ctx context.Context) ([]string, erro
 	return cleanup, nil
 }
 
+// EagerRemover is implemented by snapshotters that can free a removed
+// snapshot's disk space before returning.
+type EagerRemover interface {
+	RemoveNow(ctx context.Context, key string) error
+}
+
 // Remove abandons the snapshot identified by key.
-func (s *snapshotter) Remove(ctx context.Context, key string) (err error) {
+func (s *snapshotter) Remove(ctx context.Context, key string) error {
+	return s.remove(ctx, key, false)
+}
+
+// RemoveNow removes the snapshot identified by key like Remove, then deletes
+// its directory before returning instead of leaving disk reclamation to
+// best-effort cleanup. Only that snapshot's directory is touched, and it is
+// never retained in the graveyard. If the directory cannot be deleted the
+// snapshot is still removed from metadata, the error is returned, and the
+// next Cleanup retries.
+func (s *snapshotter) RemoveNow(ctx context.Context, key string) error {
+	return s.remove(ctx, key, true)
+}
+
+func (s *snapshotter) remove(ctx context.Context, key string, now bool) (err error) {
 	var removals []string
 	var id string
+	var k snapshots.Kind
+	var fields log.Fields
+	var retain bool
+	start := time.Now()
 
 	defer func() {
 		if err == nil {
-			s.cleanupAfterRemove(ctx, id, removals)
+			ctx = log.WithLogger(ctx, log.G(ctx).WithFields(fields))
+			s.usageCache.invalidate(id)
+			if now {
+				err = s.reclaimSnapshotDir(ctx, id)
+			} else {
+				s.cleanupAfterRemove(ctx, id, removals, retain)
+			}
+			if err == nil {
+				log.G(ctx).Debug("snapshot removed")
+			}
 		}
+		s.emitEvent(SnapshotOpRemove, key, id, k, start, err)
 	}()
 
 	return s.ms.WithTransaction(ctx, true, func(ctx context.Context) error {
-		var k snapshots.Kind
+		if err := checkNotPinned(ctx, key); err != nil {
+			return err
+		}
 
-		id, k, err = storage.Remove(ctx, key)
+		_, info, _, err := storage.GetInfo(ctx, key)
 		if err != nil {
 			return fmt.Errorf("remove snapshot %s: %w", key, err)
 		}
+		parents, err := parentCount(ctx, info)
+		if err != nil {
+			return err
+		}
 
-		removals, err = s.getCleanupDirectories(ctx)
+		id, k, err = storage.Remove(ctx, key)
 		if err != nil {
-			return fmt.Errorf("get directories for removal: %w", err)
+			return fmt.Errorf("remove snapshot %s: %w", key, err)
+		}
+		fields = lifecycleFields(key, id, info, parents)
+		retain = retainOnRemove(k, info)
+
+		if !now {
+			removals, err = s.getCleanupDirectories(ctx)
+			if err != nil {
+				return fmt.Errorf("get directories for removal: %w", err)
+			}
 		}
 
-		// The layer blob is only persisted for committed snapshots.
+		// EROFS blobs are only persisted for committed snapshots. Clear the
+		// flag on every blob (layer and fsmeta) so RemoveAll can delete them.
+		// Blobs shared through the content store keep it; their links are
+		// unlinked by clearImmutableFlags just before the directory goes.
 		if k == snapshots.KindCommitted {
-			if layerBlob, ferr := s.findLayerBlob(id); ferr == nil {
+			for _, blob := range unsharedErofsBlobs(s.snapshotDir(id)) {
 				// Use local variable to avoid polluting the named return 'err'.
 				// If err is set here and is errdefs.IsNotImplemented, the defer
 				// would skip cleanupAfterRemove because err != nil.
-				if immErr := setImmutable(layerBlob, false); immErr != nil && !errdefs.IsNotImplemented(immErr) {
-					return fmt.Errorf("clear IMMUTABLE_FL: %w", immErr)
+				if immErr := setImmutable(blob, false); immErr != nil && !errdefs.IsNotImplemented(immErr) {
+					return fmt.Errorf("clear IMMUTABLE_FL on %s: %w", blob, immErr)
 				}
 			}
 		}
@@ -270,66 +478,158 @@ func (s *snapshotter) Remove(ctx context.Context, key string) (err error) {
 	})
 }
 
-// cleanupAfterRemove handles post-removal cleanup.
-func (s *snapshotter) cleanupAfterRemove(ctx context.Context, id string, removals []string) {
+// cleanupAfterRemove handles post-removal cleanup. If retain is set, the
+// snapshot's own directory is offered to the graveyard.
+func (s *snapshotter) cleanupAfterRemove(ctx context.Context, id string, removals []string, retain bool) {
 	// Cleanup block rw mount (only exists if commit was in progress)
-	if err := unmountAll(s.blockRwMountPath(id)); err != nil {
-		log.G(ctx).WithError(err).WithField("id", id).Warnf("failed to cleanup block rw mount")
+	if err := s.hostMounts().Unmount(s.blockRwMountPath(id)); err != nil {
+		log.G(ctx).WithError(err).Warn("failed to cleanup block rw mount")
 	}
 
 	for _, dir := range removals {
+		if retain && dir == s.snapshotDir(id) && s.retainRemovedSnapshot(ctx, id) {
+			continue
+		}
+		clearImmutableFlags(ctx, dir)
 		if err := os.RemoveAll(dir); err != nil {
 			log.G(ctx).WithError(err).WithField("path", dir).Warn("failed to remove directory")
 		}
 	}
 }
 
+// reclaimSnapshotDir unmounts and deletes the directory of the removed
+// snapshot id for RemoveNow.
+func (s *snapshotter) reclaimSnapshotDir(ctx context.Context, id string) error {
+	if err := s.hostMounts().Unmount(s.blockRwMountPath(id)); err != nil {
+		log.G(ctx).WithError(err).Warn("failed to cleanup block rw mount")
+	}
+	clearImmutableFlags(ctx, s.snapshotDir(id))
+	if err := os.RemoveAll(s.snapshotDir(id)); err != nil {
+		return fmt.Errorf("remove snapshot directory %s: %w", id, err)
+	}
+	return nil
+}
+
+// ProgressCleaner is implemented by snapshotters whose Cleanup can report
+// how much it removed before being cancelled.
+type ProgressCleaner interface {
+	CleanupProgress(ctx context.Context) (int, error)
+}
+
 // Cleanup removes unreferenced snapshot directories.
 // Errors are logged but don't stop cleanup (best-effort).
 func (s *snapshotter) Cleanup(ctx context.Context) error {
+	_, err := s.CleanupProgress(ctx)
+	if err == nil && s.contentStore != "" {
+		s.pruneContentStore(ctx)
+	}
+	return err
+}
+
+// CleanupProgress removes unreferenced snapshot directories like Cleanup,
+// using up to WithCleanupConcurrency workers, and returns how many it
+// removed. Once ctx is done no further directories are started, and the
+// count so far is returned with ctx's error. Orphans are found afresh from
+// the metadata store on every run, so calling it again resumes the work.
+func (s *snapshotter) CleanupProgress(ctx context.Context) (int, error) {
 	var removals []string
 	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
 		var err error
 		removals, err = s.getCleanupDirectories(ctx)
 		return err
 	}); err != nil {
-		return err
+		return 0, err
 	}
 
+	var (
+		removed atomic.Int64
+		wg      sync.WaitGroup
+		dirs    = make(chan string)
+	)
+	for range min(max(s.settings().cleanupConcurrency, 1), len(removals)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range dirs {
+				if removeOrphanDir(ctx, dir) {
+					removed.Add(1)
+				}
+			}
+		}()
+	}
+	started := 0
 	for _, dir := range removals {
-		// Cleanup block rw mount
-		if err := unmountAll(filepath.Join(dir, rwDirName)); err != nil {
-			log.G(ctx).WithError(err).WithField("path", dir).Debug("failed to cleanup block rw mount")
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case dirs <- dir:
+			started++
+		case <-ctx.Done():
 		}
+	}
+	close(dirs)
+	wg.Wait()
 
-		// Clear immutable flag on any EROFS blobs before removal
-		clearImmutableFlags(ctx, dir)
+	n := int(removed.Load())
+	if started < len(removals) {
+		return n, fmt.Errorf("cleanup stopped after removing %d of %d directories: %w", n, len(removals), ctx.Err())
+	}
+	return n, nil
+}
 
-		if err := os.RemoveAll(dir); err != nil {
-			log.G(ctx).WithError(err).WithField("path", dir).Warn("failed to remove directory")
-		}
+// removeOrphanDir unmounts and removes a snapshot directory found by
+// Cleanup, reporting whether it was removed. Failures are logged.
+func removeOrphanDir(ctx context.Context, dir string) bool {
+	// Cleanup block rw mount
+	if err := unmountAll(filepath.Join(dir, rwDirName)); err != nil {
+		log.G(ctx).WithError(err).WithField("path", dir).Debug("failed to cleanup block rw mount")
 	}
 
-	return nil
+	// Clear immutable flag on any EROFS blobs before removal
+	clearImmutableFlags(ctx, dir)
+
+	if err := os.RemoveAll(dir); err != nil {
+		log.G(ctx).WithError(err).WithField("path", dir).Warn("failed to remove directory")
+		return false
+	}
+	return true
 }
 
 // clearImmutableFlags clears the immutable flag on all EROFS blobs in a directory.
-// Searches both digest-based (sha256-*.erofs) and fallback (snapshot-*.erofs) patterns.
+// Blobs shared through the content store are unlinked instead, so the other
+// layers linked to them stay immutable.
 func clearImmutableFlags(ctx context.Context, dir string) {
-	patterns := []string{erofs.LayerBlobPattern, "snapshot-*.erofs"}
-	for _, pattern := range patterns {
-		matches, err := filepath.Glob(filepath.Join(dir, pattern))
-		if err != nil {
+	for _, match := range erofsBlobsInDir(dir) {
+		if sharedBlob(match) {
+			if err := unlinkBlob(match, func() error { return os.Remove(match) }); err != nil && !errdefs.IsNotImplemented(err) {
+				log.G(ctx).WithError(err).WithField("path", match).Debug("failed to unlink shared blob")
+			}
 			continue
 		}
-		for _, match := range matches {
-			if err := setImmutable(match, false); err != nil && !errdefs.IsNotImplemented(err) {
-				log.G(ctx).WithError(err).WithField("path", match).Debug("failed to clear immutable flag")
-			}
+		if err := setImmutable(match, false); err != nil && !errdefs.IsNotImplemented(err) {
+			log.G(ctx).WithError(err).WithField("path", match).Debug("failed to clear immutable flag")
 		}
 	}
 }
 
+// unsharedErofsBlobs returns the blobs of erofsBlobsInDir whose immutable
+// flag is cleared before dir is deleted. Blobs shared through the content
+// store keep theirs and are unlinked instead (see clearImmutableFlags).
+func unsharedErofsBlobs(dir string) []string {
+	return slices.DeleteFunc(erofsBlobsInDir(dir), sharedBlob)
+}
+
+// erofsBlobsInDir returns every *.erofs file in a snapshot directory,
+// including digest-named and fallback layer blobs as well as fsmeta.erofs.
+func erofsBlobsInDir(dir string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.erofs"))
+	if err != nil {
+		return nil
+	}
+	return matches
+}
+
 // Stat returns information about a snapshot.
 func (s *snapshotter) Stat(ctx context.Context, key string) (info snapshots.Info, err error) {
 	err = s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
@@ -361,6 +661,45 @@ func (s *snapshotter) Walk(ctx context.Context, fn snapshots.WalkFunc, fs ...str
 	})
 }
 
+// NamespaceWalker is implemented by snapshotters that can restrict Walk to
+// the snapshots of a single containerd namespace.
+type NamespaceWalker interface {
+	WalkNamespace(ctx context.Context, fn snapshots.WalkFunc, filters ...string) error
+}
+
+// WalkNamespace is like Walk but only visits snapshots belonging to the
+// namespace in ctx, falling back to the configured default namespace.
+//
+// Containerd's metadata layer prefixes proxy snapshotter keys with the
+// namespace ("{namespace}/{txid}/{name}"), so the namespace is matched
+// against the key prefix. User filters are preserved: each is combined
+// with the namespace selector.
+func (s *snapshotter) WalkNamespace(ctx context.Context, fn snapshots.WalkFunc, fs ...string) error {
+	ns, err := store.NamespaceOrDefault(ctx, s.defaultNamespace)
+	if err != nil {
+		return err
+	}
+	return s.Walk(ctx, fn, namespaceFilters(ns, fs)...)
+}
+
+// namespaceFilters combines a key-prefix selector for ns with each filter.
+// Filters are OR'ed by containerd, so the selector is AND'ed into each one.
+func namespaceFilters(ns string, fs []string) []string {
+	selector := "name~=" + strconv.Quote("^"+regexp.QuoteMeta(ns)+"/")
+	if len(fs) == 0 {
+		return []string{selector}
+	}
+	scoped := make([]string, 0, len(fs))
+	for _, f := range fs {
+		if f == "" {
+			scoped = append(scoped, selector)
+			continue
+		}
+		scoped = append(scoped, f+","+selector)
+	}
+	return scoped
+}
+
 // Usage returns the resources taken by the snapshot.
 func (s *snapshotter) Usage(ctx context.Context, key string) (_ snapshots.Usage, err error) {
 	var (
@@ -376,12 +715,94 @@ func (s *snapshotter) Usage(ctx context.Context, key string) (_ snapshots.Usage,
 	}
 
 	if info.Kind == snapshots.KindActive {
-		upperPath := s.upperPath(id)
-		du, err := fs.DiskUsage(ctx, upperPath)
+		return s.activeUsage(ctx, id)
+	}
+	return usage, nil
+}
+
+// activeUsage computes the disk usage of an active snapshot's upper directory,
+// consulting the usage cache when enabled.
+func (s *snapshotter) activeUsage(ctx context.Context, id string) (snapshots.Usage, error) {
+	upperPath := s.upperPath(id)
+
+	var modTime time.Time
+	if s.usageCache != nil {
+		st, err := os.Stat(upperPath)
 		if err != nil {
 			return snapshots.Usage{}, err
 		}
-		usage = snapshots.Usage(du)
+		modTime = st.ModTime()
+		if usage, ok := s.usageCache.get(id, modTime); ok {
+			return usage, nil
+		}
+	}
+
+	du, err := fs.DiskUsage(ctx, upperPath)
+	if err != nil {
+		return snapshots.Usage{}, err
 	}
+	usage := snapshots.Usage(du)
+	s.usageCache.put(id, modTime, usage)
 	return usage, nil
 }
+
+// TotalUsageReporter is implemented by snapshotters that can report the
+// resources taken by all of their snapshots.
+type TotalUsageReporter interface {
+	TotalUsage(ctx context.Context) (snapshots.Usage, error)
+}
+
+// TotalUsage returns the resources taken by every snapshot in the store.
+// Committed snapshots count their usage recorded in the metadata store, so
+// their blobs are not rescanned. Active snapshots count their upper
+// directory, as Usage reports, plus the allocated size of their sparse ext4
+// writable layer and named volumes. Views hold no data of their own.
+func (s *snapshotter) TotalUsage(ctx context.Context) (snapshots.Usage, error) {
+	var total snapshots.Usage
+	var active []string
+	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		return storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+			id, _, usage, err := storage.GetInfo(ctx, info.Name)
+			if err != nil {
+				return fmt.Errorf("get usage of %q: %w", info.Name, err)
+			}
+			switch info.Kind {
+			case snapshots.KindCommitted:
+				total.Add(usage)
+			case snapshots.KindActive:
+				active = append(active, id)
+			}
+			return nil
+		})
+	}); err != nil {
+		return snapshots.Usage{}, err
+	}
+
+	// Disk scans run outside the transaction.
+	for _, id := range active {
+		usage, err := s.activeUsage(ctx, id)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return snapshots.Usage{}, fmt.Errorf("active usage of snapshot %s: %w", id, err)
+		}
+		total.Add(usage)
+
+		layer, err := fs.DiskUsage(ctx, s.writablePath(id))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return snapshots.Usage{}, fmt.Errorf("writable layer usage of snapshot %s: %w", id, err)
+		}
+		total.Add(snapshots.Usage(layer))
+
+		volumes, err := s.volumePaths(id)
+		if err != nil {
+			return snapshots.Usage{}, err
+		}
+		for _, p := range volumes {
+			layer, err := fs.DiskUsage(ctx, p)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return snapshots.Usage{}, fmt.Errorf("writable volume usage of snapshot %s: %w", id, err)
+			}
+			total.Add(snapshots.Usage(layer))
+		}
+	}
+	return total, nil
+}
@@ -1,9 +1,17 @@
 package snapshotter
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
 
 	"github.com/spin-stack/erofs-snapshotter/internal/erofs"
 )
@@ -19,6 +27,10 @@ const (
 	// snapshotsDirName is the name of the directory containing all snapshots.
 	snapshotsDirName = "snapshots"
 
+	// graveyardDirName is the name of the directory holding removed snapshots
+	// retained for post-mortem (see WithRetainRemoved).
+	graveyardDirName = "graveyard"
+
 	// fsDirName is the overlay upper directory name within a snapshot.
 	fsDirName = "fs"
 
@@ -42,21 +54,24 @@ const (
 
 	// manifestFilename is the filename for the layer manifest (stores digests in VMDK order).
 	manifestFilename = "layers.manifest"
+
+	// devicesFilename is the filename for the UUIDs of the fsmeta devices, in VMDK order.
+	devicesFilename = "fsmeta.devices"
 )
 
 // upperPath returns the path to the overlay upper directory for a snapshot.
 func (s *snapshotter) upperPath(id string) string {
-	return filepath.Join(s.root, snapshotsDirName, id, fsDirName)
+	return filepath.Join(s.rootDir(), snapshotsDirName, id, fsDirName)
 }
 
 // writablePath returns the path to the ext4 writable layer image file.
 func (s *snapshotter) writablePath(id string) string {
-	return filepath.Join(s.root, snapshotsDirName, id, rwLayerFilename)
+	return filepath.Join(s.rootDir(), snapshotsDirName, id, rwLayerFilename)
 }
 
 // blockRwMountPath returns the mount point for the ext4 rwlayer in block mode.
 func (s *snapshotter) blockRwMountPath(id string) string {
-	return filepath.Join(s.root, snapshotsDirName, id, rwDirName)
+	return filepath.Join(s.rootDir(), snapshotsDirName, id, rwDirName)
 }
 
 // blockUpperPath returns the overlay upperdir inside the mounted ext4.
@@ -64,15 +79,56 @@ func (s *snapshotter) blockUpperPath(id string) string {
 	return filepath.Join(s.blockRwMountPath(id), upperDirName)
 }
 
-// findLayerBlob finds the EROFS layer blob in a snapshot directory.
-// Layer blobs are named using their content digest (sha256-xxx.erofs) or
-// the snapshot ID for walking differ fallback (snapshot-xxx.erofs).
+// BlobNamer returns the file name of the EROFS layer blob the snapshotter
+// writes for snapshot id, inside its snapshot directory. dgst is the layer
+// digest when known (see WithTargetBlobDigest) and empty otherwise. Names
+// must end in ".erofs".
+type BlobNamer func(id string, dgst digest.Digest) string
+
+// DefaultBlobNamer names blobs after their digest (sha256-{hex}.erofs), as
+// the EROFS differ does, or after the snapshot ID (snapshot-{id}.erofs)
+// when the digest is unknown.
+func DefaultBlobNamer(id string, dgst digest.Digest) string {
+	if dgst != "" {
+		return erofs.LayerBlobFilename(dgst.String())
+	}
+	return fallbackLayerPrefix + id + ".erofs"
+}
+
+// validateBlobNamer checks that namer returns plain *.erofs file names
+// that do not collide with the snapshotter's own files.
+func validateBlobNamer(namer BlobNamer) error {
+	for _, dgst := range []digest.Digest{"", digest.FromString("layer")} {
+		name := namer("1", dgst)
+		if name == "" || filepath.Base(name) != name || !strings.HasSuffix(name, ".erofs") || name == fsmetaFilename {
+			return fmt.Errorf("blob namer returned invalid name %q for digest %q: must be a file name ending in .erofs other than %s",
+				name, dgst, fsmetaFilename)
+		}
+	}
+	return nil
+}
+
+// blobName returns the layer blob name for snapshot id (see WithBlobNamer).
+func (s *snapshotter) blobName(id string, dgst digest.Digest) string {
+	if s.blobNamer != nil {
+		return s.blobNamer(id, dgst)
+	}
+	return DefaultBlobNamer(id, dgst)
+}
+
+// findLayerBlob finds the EROFS layer blob in a snapshot directory whose
+// path is not recorded in LabelLayerBlobPath.
+// Blobs the EROFS differ wrote are named after the OCI layer (diff) digest
+// they were converted from (sha256-xxx.erofs). That digest is not recorded
+// on the snapshot (LabelLayerDigest is the sha256 of the blob file itself),
+// so they are globbed first.
+// Otherwise the names the configured and default blob namers give a blob
+// without a digest are checked.
 // Returns the path if found, or LayerBlobNotFoundError if no blob exists.
 func (s *snapshotter) findLayerBlob(id string) (string, error) {
-	dir := filepath.Join(s.root, snapshotsDirName, id)
-	patterns := []string{erofs.LayerBlobPattern, fallbackLayerPrefix + "*.erofs"}
+	dir := filepath.Join(s.rootDir(), snapshotsDirName, id)
 
-	// First try digest-based naming (primary path via EROFS differ)
+	// Digest-based naming (primary path via EROFS differ)
 	matches, err := filepath.Glob(filepath.Join(dir, erofs.LayerBlobPattern))
 	if err != nil {
 		return "", fmt.Errorf("glob layer blob: %w", err)
@@ -81,61 +137,165 @@ func (s *snapshotter) findLayerBlob(id string) (string, error) {
 		return matches[0], nil
 	}
 
-	// Try fallback naming (walking differ creates these)
-	fallbackPath := filepath.Join(dir, fallbackLayerPrefix+id+".erofs")
-	if _, err := os.Stat(fallbackPath); err == nil {
-		return fallbackPath, nil
+	// Try namer-assigned naming (walking differ fallback creates these)
+	names := []string{s.blobName(id, "")}
+	if def := DefaultBlobNamer(id, ""); def != names[0] {
+		names = append(names, def)
+	}
+	for _, name := range names {
+		p := filepath.Join(dir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
 	}
 
+	_, err = os.Stat(dir)
 	return "", &LayerBlobNotFoundError{
-		SnapshotID: id,
-		Dir:        dir,
-		Searched:   patterns,
+		SnapshotID:   id,
+		Dir:          dir,
+		Searched:     append([]string{erofs.LayerBlobPattern}, names...),
+		ExpectedPath: filepath.Join(dir, names[0]),
+		DirMissing:   errors.Is(err, os.ErrNotExist),
 	}
 }
 
+// findLayerBlobFromInfo returns the layer blob recorded in LabelLayerBlobPath
+// when it is valid for the snapshot, and falls back to findLayerBlob otherwise.
+func (s *snapshotter) findLayerBlobFromInfo(id string, info snapshots.Info) (string, error) {
+	if p := info.Labels[LabelLayerBlobPath]; s.isLabeledLayerBlob(id, p) {
+		return p, nil
+	}
+	return s.findLayerBlob(id)
+}
+
+// isLabeledLayerBlob reports whether p, taken from LabelLayerBlobPath, names
+// an existing EROFS blob inside the snapshot directory of id. Labels can be
+// set by clients, so a path outside the snapshot directory is rejected.
+func (s *snapshotter) isLabeledLayerBlob(id, p string) bool {
+	if p == "" || filepath.Dir(p) != s.snapshotDir(id) || !strings.HasSuffix(p, ".erofs") {
+		return false
+	}
+	_, err := os.Stat(p)
+	return err == nil
+}
+
 // fallbackLayerBlobPath returns the path for creating a layer blob when the
-// digest is not available (walking differ fallback). Uses the snapshot ID.
+// digest is not available (walking differ fallback).
 func (s *snapshotter) fallbackLayerBlobPath(id string) string {
-	return filepath.Join(s.root, snapshotsDirName, id, fallbackLayerPrefix+id+".erofs")
+	return filepath.Join(s.rootDir(), snapshotsDirName, id, s.blobName(id, ""))
 }
 
 // fsMetaPath returns the path to the merged fsmeta.erofs file.
 func (s *snapshotter) fsMetaPath(id string) string {
-	return filepath.Join(s.root, snapshotsDirName, id, fsmetaFilename)
+	return filepath.Join(s.rootDir(), snapshotsDirName, id, fsmetaFilename)
 }
 
 // vmdkPath returns the path to the VMDK descriptor file.
 func (s *snapshotter) vmdkPath(id string) string {
-	return filepath.Join(s.root, snapshotsDirName, id, vmdkFilename)
+	return filepath.Join(s.rootDir(), snapshotsDirName, id, vmdkFilename)
 }
 
 // manifestPath returns the path to the layer manifest file.
 func (s *snapshotter) manifestPath(id string) string {
-	return filepath.Join(s.root, snapshotsDirName, id, manifestFilename)
+	return filepath.Join(s.rootDir(), snapshotsDirName, id, manifestFilename)
+}
+
+// fsMetaDevicesPath returns the path to the fsmeta device UUID record.
+func (s *snapshotter) fsMetaDevicesPath(id string) string {
+	return filepath.Join(s.rootDir(), snapshotsDirName, id, devicesFilename)
 }
 
 // viewLowerPath returns the path to the lower directory for View snapshots.
 func (s *snapshotter) viewLowerPath(id string) string {
-	return filepath.Join(s.root, snapshotsDirName, id, lowerDirName)
+	return filepath.Join(s.rootDir(), snapshotsDirName, id, lowerDirName)
 }
 
 // snapshotDir returns the path to a snapshot directory.
 func (s *snapshotter) snapshotDir(id string) string {
-	return filepath.Join(s.root, snapshotsDirName, id)
+	return filepath.Join(s.rootDir(), snapshotsDirName, id)
+}
+
+// graveyardDir returns the path to the retained removed snapshots directory.
+func (s *snapshotter) graveyardDir() string {
+	return filepath.Join(s.rootDir(), graveyardDirName)
+}
+
+// rootDir returns the snapshotter root directory.
+func (s *snapshotter) rootDir() string {
+	s.rootMu.RLock()
+	defer s.rootMu.RUnlock()
+	return s.root
+}
+
+// failedUpperDirPath returns the WithPreserveFailedUpper directory, or "".
+func (s *snapshotter) failedUpperDirPath() string {
+	s.rootMu.RLock()
+	defer s.rootMu.RUnlock()
+	return s.failedUpperDir
+}
+
+// scratchDirPath returns the WithScratchDir directory, or "".
+func (s *snapshotter) scratchDirPath() string {
+	s.rootMu.RLock()
+	defer s.rootMu.RUnlock()
+	return s.scratchDir
 }
 
 // snapshotsDir returns the path to the snapshots root directory.
 func (s *snapshotter) snapshotsDir() string {
-	return filepath.Join(s.root, snapshotsDirName)
+	return filepath.Join(s.rootDir(), snapshotsDirName)
 }
 
 // lowerPath returns the EROFS layer blob path for a snapshot, validating it exists.
-func (s *snapshotter) lowerPath(id string) (string, error) {
-	layerBlob, err := s.findLayerBlob(id)
-	if err != nil {
-		return "", fmt.Errorf("failed to find valid erofs layer blob: %w", err)
+// A path recorded in blobs is used when valid, avoiding a directory glob.
+func (s *snapshotter) lowerPath(id string, blobs layerBlobIndex) (string, error) {
+	layerBlob := blobs[id]
+	if !s.isLabeledLayerBlob(id, layerBlob) {
+		var err error
+		if layerBlob, err = s.findLayerBlob(id); err != nil {
+			var nerr *LayerBlobNotFoundError
+			if p := blobs[id]; p != "" && errors.As(err, &nerr) {
+				nerr.ExpectedPath = p
+			}
+			return "", fmt.Errorf("failed to find valid erofs layer blob: %w", err)
+		}
+	}
+
+	// A zero-length blob left by an interrupted write would only fail the
+	// mount in the kernel without saying why.
+	if fi, err := os.Stat(layerBlob); err == nil && fi.Size() == 0 {
+		log.L.WithField("path", layerBlob).Warn("layer blob is empty, treating it as missing")
+		return "", fmt.Errorf("failed to find valid erofs layer blob: %w", &LayerBlobNotFoundError{
+			SnapshotID:   id,
+			Dir:          s.snapshotDir(id),
+			ExpectedPath: layerBlob,
+			Empty:        true,
+		})
 	}
 
 	return layerBlob, nil
 }
+
+// layerBlobIndex maps committed snapshot IDs to the blob paths recorded in
+// their LabelLayerBlobPath. A nil index is valid and records nothing.
+type layerBlobIndex map[string]string
+
+// parentLayerBlobs walks the parent chain of info and collects the blob
+// paths recorded on each parent. Must be called within a transaction.
+func parentLayerBlobs(ctx context.Context, info snapshots.Info) (layerBlobIndex, error) {
+	var blobs layerBlobIndex
+	for parent := info.Parent; parent != ""; {
+		id, pinfo, _, err := storage.GetInfo(ctx, parent)
+		if err != nil {
+			return nil, fmt.Errorf("get parent info %q: %w", parent, err)
+		}
+		if p := pinfo.Labels[LabelLayerBlobPath]; p != "" {
+			if blobs == nil {
+				blobs = make(layerBlobIndex)
+			}
+			blobs[id] = p
+		}
+		parent = pinfo.Parent
+	}
+	return blobs, nil
+}
@@ -0,0 +1,50 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/errdefs"
+)
+
+// Pinner is implemented by snapshotters that can protect snapshots from
+// removal while they are used out-of-band.
+type Pinner interface {
+	Pin(ctx context.Context, key string) error
+	Unpin(ctx context.Context, key string) error
+}
+
+// Pin sets LabelPinned on the snapshot so that Remove rejects it. The pin is
+// stored in the metadata DB and survives restarts. A pinned snapshot is
+// never reclaimed by Cleanup, which only removes directories unknown to the
+// metadata DB.
+func (s *snapshotter) Pin(ctx context.Context, key string) error {
+	if err := s.setSnapshotLabel(ctx, key, LabelPinned, "true"); err != nil {
+		return fmt.Errorf("pin snapshot %q: %w", key, err)
+	}
+	return nil
+}
+
+// Unpin clears LabelPinned. Unpinning a snapshot that is not pinned is a no-op.
+func (s *snapshotter) Unpin(ctx context.Context, key string) error {
+	// An empty label value removes the label.
+	if err := s.setSnapshotLabel(ctx, key, LabelPinned, ""); err != nil {
+		return fmt.Errorf("unpin snapshot %q: %w", key, err)
+	}
+	return nil
+}
+
+// checkNotPinned returns errdefs.ErrFailedPrecondition if key is pinned.
+// A missing snapshot is not an error here; the caller reports it.
+// Must be called within a transaction.
+func checkNotPinned(ctx context.Context, key string) error {
+	_, info, _, err := storage.GetInfo(ctx, key)
+	if err != nil {
+		return nil
+	}
+	if info.Labels[LabelPinned] != "" {
+		return fmt.Errorf("snapshot %q is pinned: %w", key, errdefs.ErrFailedPrecondition)
+	}
+	return nil
+}
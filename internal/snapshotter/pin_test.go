@@ -0,0 +1,75 @@
+package snapshotter
+
+import (
+	"os"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+)
+
+func TestPinnedSnapshotRejectsRemove(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	if err := os.MkdirAll(s.snapshotDir(snap.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Pin(t.Context(), "active"); err != nil {
+		t.Fatalf("Pin: %v", err)
+	}
+	if err := s.Remove(t.Context(), "active"); !errdefs.IsFailedPrecondition(err) {
+		t.Fatalf("expected failed precondition removing pinned snapshot, got %v", err)
+	}
+	if _, err := s.RemoveDryRun(t.Context(), "active"); !errdefs.IsFailedPrecondition(err) {
+		t.Fatalf("expected failed precondition from dry run, got %v", err)
+	}
+	if _, err := os.Stat(s.snapshotDir(snap.ID)); err != nil {
+		t.Fatalf("pinned snapshot directory should remain: %v", err)
+	}
+
+	if err := s.Unpin(t.Context(), "active"); err != nil {
+		t.Fatalf("Unpin: %v", err)
+	}
+	info, err := s.Stat(t.Context(), "active")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := info.Labels[LabelPinned]; ok {
+		t.Errorf("expected pin label to be removed, got %v", info.Labels)
+	}
+	if err := s.Remove(t.Context(), "active"); err != nil {
+		t.Fatalf("Remove after Unpin: %v", err)
+	}
+}
+
+func TestPinnedSnapshotSurvivesCleanup(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	orphan := s.snapshotDir("orphan")
+	for _, dir := range []string{s.snapshotDir(snap.ID), orphan} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Pin(t.Context(), "active"); err != nil {
+		t.Fatalf("Pin: %v", err)
+	}
+
+	if err := s.Cleanup(t.Context()); err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+	if _, err := os.Stat(s.snapshotDir(snap.ID)); err != nil {
+		t.Errorf("pinned snapshot directory should survive cleanup: %v", err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("orphan directory should be removed, got %v", err)
+	}
+}
+
+func TestPinMissingSnapshot(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	if err := s.Pin(t.Context(), "missing"); !errdefs.IsNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/continuity/fs"
+	"github.com/containerd/log"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/stringutil"
+)
+
+// validateFailedUpperDir checks that the quarantine directory is usable and
+// lies outside the snapshots directory, where Cleanup would remove it.
+func validateFailedUpperDir(root, dir string) error {
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("preserve failed upper directory %q must be absolute", dir)
+	}
+	snapshots := filepath.Join(root, snapshotsDirName)
+	if rel, err := filepath.Rel(snapshots, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("preserve failed upper directory %q must not be inside %s", dir, snapshots)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create preserve failed upper directory: %w", err)
+	}
+	return nil
+}
+
+// recordConversionError records a failed EROFS conversion in
+// LabelConversionError on the active snapshot, preserving the upper
+// directory first when WithPreserveFailedUpper is enabled.
+//
+// The label is written in its own transaction because the commit is
+// aborted. This is best-effort: failures are logged and never replace
+// the conversion error.
+func (s *snapshotter) recordConversionError(ctx context.Context, key string, cerr *CommitConversionError) {
+	value := fmt.Sprintf("snapshot %s: %s", cerr.SnapshotID,
+		stringutil.TruncateOutput([]byte(cerr.Cause.Error()), maxConversionErrorLen))
+	if s.failedUpperDirPath() != "" {
+		if dst := s.preserveFailedUpper(ctx, cerr); dst != "" {
+			value += "; upper preserved at " + dst
+		}
+	}
+
+	if err := s.setSnapshotLabel(ctx, key, LabelConversionError, value); err != nil {
+		log.G(ctx).WithError(err).WithField("key", key).Warn("failed to record conversion error label")
+	}
+}
+
+// preserveFailedUpper copies the upper directory of a snapshot whose EROFS
+// conversion failed into the quarantine directory and returns the copy's
+// path, or "" if the copy failed.
+//
+// The copy leaves the snapshot untouched, so Remove, Cleanup, and a retried
+// Commit behave as if nothing was preserved.
+func (s *snapshotter) preserveFailedUpper(ctx context.Context, cerr *CommitConversionError) string {
+	dst := filepath.Join(s.failedUpperDirPath(), fmt.Sprintf("%s-%d", cerr.SnapshotID, time.Now().UnixNano()))
+	if err := fs.CopyDir(dst, cerr.UpperDir); err != nil {
+		log.G(ctx).WithError(err).WithFields(log.Fields{
+			"id":   cerr.SnapshotID,
+			"from": cerr.UpperDir,
+			"to":   dst,
+		}).Warn("failed to preserve upper directory after conversion failure")
+		_ = os.RemoveAll(dst)
+		return ""
+	}
+
+	log.G(ctx).WithFields(log.Fields{
+		"id":   cerr.SnapshotID,
+		"path": dst,
+	}).Info("preserved upper directory of failed conversion")
+	return dst
+}
+
+// setSnapshotLabel sets a single label on a snapshot in its own write transaction.
+func (s *snapshotter) setSnapshotLabel(ctx context.Context, key, label, value string) error {
+	return s.ms.WithTransaction(ctx, true, func(ctx context.Context) error {
+		info := snapshots.Info{
+			Name:   key,
+			Labels: map[string]string{label: value},
+		}
+		_, err := storage.UpdateInfo(ctx, info, "labels."+label)
+		return err
+	})
+}
@@ -0,0 +1,108 @@
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+func TestValidateFailedUpperDir(t *testing.T) {
+	root := t.TempDir()
+
+	if err := validateFailedUpperDir(root, "relative/dir"); err == nil {
+		t.Error("expected error for relative directory")
+	}
+	if err := validateFailedUpperDir(root, filepath.Join(root, snapshotsDirName, "quarantine")); err == nil {
+		t.Error("expected error for directory inside snapshots")
+	}
+
+	dir := filepath.Join(root, "quarantine")
+	if err := validateFailedUpperDir(root, dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("expected quarantine directory to be created: %v", err)
+	}
+}
+
+// forceConversionFailure replaces the fallback layer blob of id with a
+// dangling symlink so mkfs.erofs cannot create it.
+func forceConversionFailure(t *testing.T, s *snapshotter, id string) {
+	t.Helper()
+	if err := os.MkdirAll(s.upperPath(id), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(t.TempDir(), "missing", "blob"), s.fallbackLayerBlobPath(id)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommitRecordsConversionError(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	forceConversionFailure(t, s, snap.ID)
+
+	if err := s.Commit(t.Context(), "committed", "active"); err == nil {
+		t.Fatal("expected commit to fail")
+	}
+
+	info, err := s.Stat(t.Context(), "active")
+	if err != nil {
+		t.Fatalf("active snapshot should still exist: %v", err)
+	}
+	label, ok := info.Labels[LabelConversionError]
+	if !ok {
+		t.Fatalf("expected %s label, got %v", LabelConversionError, info.Labels)
+	}
+	if !strings.HasPrefix(label, "snapshot "+snap.ID+": ") {
+		t.Errorf("label should start with snapshot ID, got %q", label)
+	}
+	if strings.Contains(label, "preserved") {
+		t.Errorf("label should not mention preservation when disabled, got %q", label)
+	}
+}
+
+func TestCommitPreservesFailedUpper(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.failedUpperDir = t.TempDir()
+
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+	forceConversionFailure(t, s, snap.ID)
+	if err := os.WriteFile(filepath.Join(s.upperPath(snap.ID), "diff.txt"), []byte("diff"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Commit(t.Context(), "committed", "active"); err == nil {
+		t.Fatal("expected commit to fail")
+	}
+
+	info, err := s.Stat(t.Context(), "active")
+	if err != nil {
+		t.Fatalf("active snapshot should still exist: %v", err)
+	}
+	label := info.Labels[LabelConversionError]
+	if !strings.Contains(label, "snapshot "+snap.ID) {
+		t.Errorf("label should contain snapshot ID, got %q", label)
+	}
+
+	entries, err := os.ReadDir(s.failedUpperDir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one preserved upper directory, got %v (err=%v)", entries, err)
+	}
+	preserved := filepath.Join(s.failedUpperDir, entries[0].Name())
+	if !strings.Contains(label, preserved) {
+		t.Errorf("label should contain preserved path %s, got %q", preserved, label)
+	}
+	if data, err := os.ReadFile(filepath.Join(preserved, "diff.txt")); err != nil || string(data) != "diff" {
+		t.Errorf("preserved upper missing diff content: %q, %v", data, err)
+	}
+
+	// The original upper is left in place for Remove and retries.
+	if _, err := os.Stat(filepath.Join(s.upperPath(snap.ID), "diff.txt")); err != nil {
+		t.Errorf("original upper should be untouched: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package snapshotter
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CommitProgress is a progress update for the conversion of an upper
+// directory to an EROFS layer blob during Commit.
+type CommitProgress struct {
+	// SourceBytes is the apparent size of the regular files in the upper
+	// directory, measured once before the conversion starts.
+	SourceBytes int64
+	// WrittenBytes is the size of the layer blob written so far. mkfs.erofs
+	// does not report progress, so this is the only measure of it; with
+	// compression the final size stays well below SourceBytes.
+	WrittenBytes int64
+	// Done is set on the last update, sent once the conversion succeeded.
+	Done bool
+}
+
+// CommitProgressFunc receives progress updates during Commit. Updates are
+// sent from a single goroutine, one at a time.
+type CommitProgressFunc func(CommitProgress)
+
+type commitProgressKey struct{}
+
+// WithCommitProgress returns a context that makes Commit report the
+// progress of a fallback conversion to fn, about once per second. Commits
+// whose layer blob already exists, such as those written by the EROFS
+// differ, convert nothing and report nothing.
+func WithCommitProgress(ctx context.Context, fn CommitProgressFunc) context.Context {
+	return context.WithValue(ctx, commitProgressKey{}, fn)
+}
+
+// commitProgressInterval is how often the layer blob size is polled.
+var commitProgressInterval = time.Second
+
+// watchCommitProgress starts reporting the conversion of upperDir to
+// layerBlob to the CommitProgressFunc in ctx, if any. The returned function
+// stops reporting; if done is set, it sends a final update with Done set.
+func watchCommitProgress(ctx context.Context, layerBlob, upperDir string) func(done bool) {
+	fn, _ := ctx.Value(commitProgressKey{}).(CommitProgressFunc)
+	if fn == nil {
+		return func(bool) {}
+	}
+
+	p := CommitProgress{SourceBytes: apparentSize(upperDir)}
+	fn(p)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(commitProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.WrittenBytes = fileSize(layerBlob)
+				fn(p)
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func(done bool) {
+		close(stop)
+		wg.Wait()
+		if done {
+			p.WrittenBytes = fileSize(layerBlob)
+			p.Done = true
+			fn(p)
+		}
+	}
+}
+
+// apparentSize returns the total size of the regular files under dir.
+// Entries that cannot be read are skipped; the result is an estimate.
+func apparentSize(dir string) int64 {
+	var total int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil //nolint:nilerr // best-effort estimate
+		}
+		if fi, err := d.Info(); err == nil {
+			total += fi.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// fileSize returns the size of the file at path, or 0 if it does not exist
+// yet.
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
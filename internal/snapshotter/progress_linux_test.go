@@ -0,0 +1,86 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+// slowConverter writes its image in two halves with a pause in between, so
+// progress polling sees the blob grow.
+type slowConverter struct {
+	pause time.Duration
+}
+
+func (c slowConverter) Convert(_ context.Context, dest, _ string, _ []string) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(make([]byte, 4096)); err != nil {
+		return err
+	}
+	time.Sleep(c.pause)
+	_, err = f.Write(make([]byte, 4096))
+	return err
+}
+
+func TestCommitBlockReportsProgress(t *testing.T) {
+	interval := commitProgressInterval
+	commitProgressInterval = 5 * time.Millisecond
+	t.Cleanup(func() { commitProgressInterval = interval })
+
+	s := &snapshotter{root: t.TempDir(), converter: slowConverter{pause: 100 * time.Millisecond}}
+	upper := s.upperPath("1")
+	if err := os.MkdirAll(filepath.Join(upper, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(upper, "dir", "file"), make([]byte, 10000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var updates []CommitProgress
+	ctx := WithCommitProgress(t.Context(), func(p CommitProgress) {
+		updates = append(updates, p)
+	})
+	if err := s.commitBlock(ctx, filepath.Join(s.root, "layer.erofs"), "1", snapshots.Info{}, ""); err != nil {
+		t.Fatalf("commitBlock: %v", err)
+	}
+
+	if len(updates) < 3 {
+		t.Fatalf("expected a start, intermediate and final update, got %+v", updates)
+	}
+	if first := updates[0]; first.SourceBytes != 10000 || first.WrittenBytes != 0 || first.Done {
+		t.Errorf("unexpected first update %+v", first)
+	}
+	var partial bool
+	for _, p := range updates[:len(updates)-1] {
+		partial = partial || p.WrittenBytes == 4096
+		if p.Done {
+			t.Errorf("unexpected Done before the last update: %+v", p)
+		}
+	}
+	if !partial {
+		t.Errorf("expected an update with the partial blob, got %+v", updates)
+	}
+	if last := updates[len(updates)-1]; !last.Done || last.WrittenBytes != 8192 || last.SourceBytes != 10000 {
+		t.Errorf("unexpected last update %+v", last)
+	}
+}
+
+func TestCommitBlockWithoutProgress(t *testing.T) {
+	s := &snapshotter{root: t.TempDir(), converter: slowConverter{}}
+	if err := os.MkdirAll(s.upperPath("1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.commitBlock(t.Context(), filepath.Join(s.root, "layer.erofs"), "1", snapshots.Info{}, ""); err != nil {
+		t.Fatalf("commitBlock: %v", err)
+	}
+}
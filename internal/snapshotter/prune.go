@@ -0,0 +1,79 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+)
+
+// Pruner is implemented by snapshotters that can remove committed layers
+// nothing builds on or references.
+type Pruner interface {
+	PruneUnreferenced(ctx context.Context) ([]string, error)
+}
+
+// PruneUnreferenced removes committed snapshots that image tooling marked
+// with LabelUnreferenced, have no children, and are neither pinned nor
+// labeled with containerd's "containerd.io/snapshot.ref", and returns their
+// keys. Unmarked layers are always kept: the top layer of every image is a
+// leaf too, and only image tooling knows which images are still in use.
+//
+// Only current leaves are removed; a parent left without children is
+// pruned by the next call. Snapshots that gain a child or a pin while the
+// prune runs are skipped, as Remove refuses them.
+func (s *snapshotter) PruneUnreferenced(ctx context.Context) ([]string, error) {
+	var candidates []string
+	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		parents := make(map[string]struct{})
+		var committed []snapshots.Info
+		if err := storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+			if info.Parent != "" {
+				parents[info.Parent] = struct{}{}
+			}
+			if info.Kind == snapshots.KindCommitted {
+				committed = append(committed, info)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, info := range committed {
+			if _, ok := parents[info.Name]; ok || !isPruneCandidate(info) {
+				continue
+			}
+			candidates = append(candidates, info.Name)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("find unreferenced layers: %w", err)
+	}
+
+	var removed []string
+	for _, key := range candidates {
+		if err := s.Remove(ctx, key); err != nil {
+			if errdefs.IsFailedPrecondition(err) || errdefs.IsNotFound(err) {
+				log.G(ctx).WithError(err).WithField("key", key).Debug("skipping layer changed during prune")
+				continue
+			}
+			return removed, fmt.Errorf("prune %q: %w", key, err)
+		}
+		removed = append(removed, key)
+	}
+
+	if len(removed) > 0 {
+		log.G(ctx).WithField("removed", len(removed)).Info("pruned unreferenced layers")
+	}
+	return removed, nil
+}
+
+// isPruneCandidate reports whether PruneUnreferenced may remove info if it
+// has no children.
+func isPruneCandidate(info snapshots.Info) bool {
+	return info.Labels[LabelUnreferenced] != "" &&
+		info.Labels[LabelPinned] == "" &&
+		info.Labels[labelSnapshotRef] == ""
+}
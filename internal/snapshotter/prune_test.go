@@ -0,0 +1,73 @@
+package snapshotter
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+)
+
+func TestPruneUnreferenced(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	commitMetadataLayer(t, s, "base", "", []byte("base"))
+	commitMetadataLayer(t, s, "in-use", "base", []byte("in use"))
+	createMetadataSnapshot(t, s, snapshots.KindActive, "container", "in-use")
+	commitMetadataLayer(t, s, "dangling", "base", []byte("dangling"))
+	commitMetadataLayer(t, s, "image-top", "base", []byte("image"))
+	commitMetadataLayer(t, s, "pinned", "base", []byte("pinned"))
+	commitMetadataLayer(t, s, "unpacked", "base", []byte("unpacked"))
+
+	for _, key := range []string{"base", "in-use", "dangling", "pinned", "unpacked"} {
+		if _, err := s.Update(t.Context(), snapshots.Info{
+			Name:   key,
+			Labels: map[string]string{LabelUnreferenced: "true"},
+		}, "labels."+LabelUnreferenced); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Update(t.Context(), snapshots.Info{
+		Name:   "unpacked",
+		Labels: map[string]string{labelSnapshotRef: "sha256:abc"},
+	}, "labels."+labelSnapshotRef); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Pin(t.Context(), "pinned"); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := s.PruneUnreferenced(t.Context())
+	if err != nil {
+		t.Fatalf("PruneUnreferenced: %v", err)
+	}
+	if !slices.Equal(removed, []string{"dangling"}) {
+		t.Errorf("removed %v, want [dangling]", removed)
+	}
+	if _, err := s.Stat(t.Context(), "dangling"); !errdefs.IsNotFound(err) {
+		t.Errorf("expected dangling to be removed, got %v", err)
+	}
+	for _, key := range []string{"base", "in-use", "container", "image-top", "pinned", "unpacked"} {
+		if _, err := s.Stat(t.Context(), key); err != nil {
+			t.Errorf("%s: %v", key, err)
+		}
+	}
+}
+
+func TestPruneUnreferencedKeepsUnlabeledChain(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	commitMetadataLayer(t, s, "base", "", []byte("base"))
+	commitMetadataLayer(t, s, "top", "base", []byte("top"))
+
+	removed, err := s.PruneUnreferenced(t.Context())
+	if err != nil {
+		t.Fatalf("PruneUnreferenced: %v", err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("expected nothing to be removed, got %v", removed)
+	}
+	for _, key := range []string{"base", "top"} {
+		if _, err := s.Stat(t.Context(), key); err != nil {
+			t.Errorf("%s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+)
+
+// NamespaceUsageReporter is implemented by snapshotters that can report the
+// bytes consumed by each containerd namespace.
+type NamespaceUsageReporter interface {
+	NamespaceUsage(ctx context.Context) (map[string]int64, error)
+}
+
+// NamespaceUsage returns the bytes counted against each namespace's quota:
+// the size of committed layer blobs plus the full size of each writable
+// layer. Snapshots whose key carries no namespace are reported under "".
+func (s *snapshotter) NamespaceUsage(ctx context.Context) (map[string]int64, error) {
+	usage := make(map[string]int64)
+	err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		return storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+			size, err := s.quotaSize(ctx, info)
+			if err != nil {
+				return err
+			}
+			ns, _ := keyNamespace(info.Name)
+			usage[ns] += size
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// keyNamespace returns the namespace prefix of a snapshot key. Containerd's
+// metadata layer names proxy snapshotter keys "{namespace}/{txid}/{name}".
+func keyNamespace(key string) (string, bool) {
+	ns, _, ok := strings.Cut(key, "/")
+	if !ok || ns == "" {
+		return "", false
+	}
+	return ns, true
+}
+
+// quotaSize returns the bytes a snapshot counts against its namespace quota.
+// Writable layers and named volumes are sparse but count at their full
+// size, since that is what the VM may fill. The writable layer size is
+// taken from the image itself, or the default size while it does not exist
+// yet. Views hold no data of their own. Must be called within a transaction.
+func (s *snapshotter) quotaSize(ctx context.Context, info snapshots.Info) (int64, error) {
+	switch info.Kind {
+	case snapshots.KindCommitted:
+		_, _, usage, err := storage.GetInfo(ctx, info.Name)
+		if err != nil {
+			return 0, fmt.Errorf("get usage of %q: %w", info.Name, err)
+		}
+		return usage.Size, nil
+	case snapshots.KindActive:
+		id, _, _, err := storage.GetInfo(ctx, info.Name)
+		if err != nil {
+			return 0, fmt.Errorf("get snapshot info for %q: %w", info.Name, err)
+		}
+		size := s.defaultWritable
+		if st, err := os.Stat(s.writablePath(id)); err == nil {
+			size = st.Size()
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return 0, fmt.Errorf("stat writable layer of %q: %w", info.Name, err)
+		}
+		if !isExtractSnapshot(info) {
+			// Prepare rejects invalid volume labels, so a later Update
+			// that breaks them counts no volumes.
+			volumes, _ := volumesFor(info.Labels)
+			size += volumesSize(volumes)
+		}
+		return size, nil
+	default:
+		return 0, nil
+	}
+}
+
+// checkNamespaceQuota returns a QuotaExceededError if adding requested bytes
+// for key would take its namespace over quota. The snapshot named skip, if
+// any, is left out of the current usage because the operation replaces it.
+// Keys without a namespace or namespaces without a quota are not limited.
+// Must be called within a write transaction so that concurrent operations
+// on the namespace are serialized.
+func (s *snapshotter) checkNamespaceQuota(ctx context.Context, key, skip string, requested int64) error {
+	ns, ok := keyNamespace(key)
+	if !ok {
+		return nil
+	}
+	limit, ok := s.namespaceQuota[ns]
+	if !ok {
+		return nil
+	}
+
+	var used int64
+	if err := storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+		if info.Name == skip {
+			return nil
+		}
+		size, err := s.quotaSize(ctx, info)
+		used += size
+		return err
+	}, namespaceFilters(ns, nil)...); err != nil {
+		return fmt.Errorf("compute namespace %q usage: %w", ns, err)
+	}
+
+	if used+requested > limit {
+		return &QuotaExceededError{Namespace: ns, Used: used, Requested: requested, Limit: limit}
+	}
+	return nil
+}
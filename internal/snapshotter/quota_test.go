@@ -0,0 +1,200 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/continuity/fs"
+	"github.com/containerd/errdefs"
+)
+
+func TestKeyNamespace(t *testing.T) {
+	tests := []struct {
+		key    string
+		ns     string
+		scoped bool
+	}{
+		{"default/12/sha256:abc", "default", true},
+		{"k8s.io/3/extract-1", "k8s.io", true},
+		{"plain-key", "", false},
+		{"/leading", "", false},
+	}
+	for _, tc := range tests {
+		ns, ok := keyNamespace(tc.key)
+		if ns != tc.ns || ok != tc.scoped {
+			t.Errorf("keyNamespace(%q) = %q, %v; want %q, %v", tc.key, ns, ok, tc.ns, tc.scoped)
+		}
+	}
+}
+
+func TestNamespaceUsage(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.defaultWritable = 1000
+
+	commitMetadataLayer(t, s, "ns1/1/base", "", []byte("base"))
+	createMetadataSnapshot(t, s, snapshots.KindActive, "ns1/2/container", "ns1/1/base")
+	createMetadataSnapshot(t, s, snapshots.KindView, "ns2/3/view", "ns1/1/base")
+	createMetadataSnapshot(t, s, snapshots.KindActive, "plain", "")
+
+	var blobSize int64
+	if err := s.ms.WithTransaction(t.Context(), false, func(ctx context.Context) error {
+		_, _, usage, err := storage.GetInfo(ctx, "ns1/1/base")
+		blobSize = usage.Size
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.NamespaceUsage(t.Context())
+	if err != nil {
+		t.Fatalf("NamespaceUsage: %v", err)
+	}
+	want := map[string]int64{"ns1": blobSize + 1000, "ns2": 0, "": 1000}
+	if len(got) != len(want) {
+		t.Fatalf("NamespaceUsage = %v, want %v", got, want)
+	}
+	for ns, size := range want {
+		if got[ns] != size {
+			t.Errorf("namespace %q: got %d bytes, want %d", ns, got[ns], size)
+		}
+	}
+}
+
+func TestPrepareRejectedOverQuota(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.defaultWritable = 1000
+	s.namespaceQuota = map[string]int64{"ns1": 1500}
+	if err := os.MkdirAll(s.snapshotsDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	createMetadataSnapshot(t, s, snapshots.KindActive, "ns1/1/first", "")
+
+	_, err := s.Prepare(t.Context(), "ns1/2/second", "")
+	if !errdefs.IsResourceExhausted(err) {
+		t.Fatalf("expected resource exhausted, got %v", err)
+	}
+	var qerr *QuotaExceededError
+	if !errors.As(err, &qerr) {
+		t.Fatalf("expected QuotaExceededError, got %T", err)
+	}
+	if qerr.Namespace != "ns1" || qerr.Used != 1000 || qerr.Requested != 1000 || qerr.Limit != 1500 {
+		t.Errorf("unexpected quota error %+v", qerr)
+	}
+
+	if _, err := s.Stat(t.Context(), "ns1/2/second"); !errdefs.IsNotFound(err) {
+		t.Errorf("rejected snapshot should not exist, got %v", err)
+	}
+	entries, err := os.ReadDir(s.snapshotsDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no snapshot directories after rejection, got %d", len(entries))
+	}
+}
+
+func TestCommitRejectedOverQuota(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.defaultWritable = 1
+	s.namespaceQuota = map[string]int64{"ns1": 100}
+
+	snap := createMetadataSnapshot(t, s, snapshots.KindActive, "ns1/1/active", "")
+	if err := os.MkdirAll(s.upperPath(snap.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// Any non-empty blob takes at least one filesystem block on disk.
+	if err := os.WriteFile(s.fallbackLayerBlobPath(snap.ID), make([]byte, 4096), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.Commit(t.Context(), "ns1/2/committed", "ns1/1/active")
+	if !errdefs.IsResourceExhausted(err) {
+		t.Fatalf("expected resource exhausted, got %v", err)
+	}
+	info, err := s.Stat(t.Context(), "ns1/1/active")
+	if err != nil {
+		t.Fatalf("active snapshot should remain after rejected commit: %v", err)
+	}
+	if info.Kind != snapshots.KindActive {
+		t.Errorf("expected active snapshot, got %v", info.Kind)
+	}
+}
+
+func TestQuotaSizeUsesWritableLayerSize(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.defaultWritable = 64 << 20
+	createMetadataSnapshot(t, s, snapshots.KindActive, "default", "")
+	large := createMetadataSnapshot(t, s, snapshots.KindActive, "large", "")
+	if err := os.MkdirAll(s.snapshotDir(large.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(s.writablePath(large.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(1 << 30); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]int64{"default": 64 << 20, "large": 1 << 30} {
+		var got int64
+		if err := s.ms.WithTransaction(t.Context(), false, func(ctx context.Context) error {
+			_, info, _, err := storage.GetInfo(ctx, key)
+			if err != nil {
+				return err
+			}
+			got, err = s.quotaSize(ctx, info)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("quotaSize(%s) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestTotalUsage(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	commitMetadataLayer(t, s, "base", "", []byte("base"))
+	active := createMetadataSnapshot(t, s, snapshots.KindActive, "container", "base")
+	createMetadataSnapshot(t, s, snapshots.KindActive, "no-dirs", "")
+	createMetadataSnapshot(t, s, snapshots.KindView, "view", "base")
+
+	if err := os.MkdirAll(s.upperPath(active.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.upperPath(active.ID), "file"), make([]byte, 8192), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.writablePath(active.ID), make([]byte, 4096), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	committed, err := s.Usage(t.Context(), "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := committed
+	for _, p := range []string{s.upperPath(active.ID), s.writablePath(active.ID)} {
+		du, err := fs.DiskUsage(t.Context(), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want.Add(snapshots.Usage(du))
+	}
+
+	got, err := s.TotalUsage(t.Context())
+	if err != nil {
+		t.Fatalf("TotalUsage: %v", err)
+	}
+	if got != want {
+		t.Errorf("TotalUsage = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,332 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/fsverity"
+	"github.com/spin-stack/erofs-snapshotter/internal/mountutils"
+)
+
+// compressionAlgorithms are the mkfs.erofs compressors Recompress accepts.
+var compressionAlgorithms = []string{"lz4", "lz4hc", "lzma", "deflate", "libdeflate", "zstd"}
+
+// Recompressor is implemented by snapshotters that can rewrite a committed
+// layer blob with a different compression algorithm.
+type Recompressor interface {
+	Recompress(ctx context.Context, key, algo string) error
+}
+
+// Recompress rebuilds the EROFS blob of the committed snapshot key with the
+// mkfs.erofs compressor algo (e.g. "zstd" or "lz4hc,level=9"). The new blob
+// is mounted and its listing compared with the original before it atomically
+// replaces it; fs-verity and the immutable flag are re-applied, and
+// LabelCompression, LabelLayerDigest and the fs-verity digest labels are
+// updated. With WithContentStore, the new blob is added to the store.
+//
+// The layer must be idle: Recompress fails with errdefs.ErrFailedPrecondition
+// if the blob is mounted on the host or an active or view snapshot is built
+// on it. Merged fsmeta images that include the layer are deleted, since they
+// reference its old block layout; they are regenerated on the next Prepare or
+// View. The snapshot's recorded usage is not updated.
+func (s *snapshotter) Recompress(ctx context.Context, key, algo string) error {
+	if err := validateCompression(algo); err != nil {
+		return err
+	}
+
+	var id, blob string
+	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		var info snapshots.Info
+		var err error
+		id, info, _, err = storage.GetInfo(ctx, key)
+		if err != nil {
+			return fmt.Errorf("get snapshot info for %q: %w", key, err)
+		}
+		if info.Kind != snapshots.KindCommitted {
+			return fmt.Errorf("snapshot %q is not committed: %w", key, errdefs.ErrFailedPrecondition)
+		}
+		if _, err := s.idleLayerDependents(ctx, key); err != nil {
+			return err
+		}
+		blob, err = s.findLayerBlobFromInfo(id, info)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	inUse, err := layerBlobInUse(blob)
+	if err != nil {
+		return fmt.Errorf("check layer blob mounts: %w", err)
+	}
+	if inUse {
+		return fmt.Errorf("layer blob %s is mounted: %w", blob, errdefs.ErrFailedPrecondition)
+	}
+
+	tmp := blob + ".recompress"
+	defer os.Remove(tmp)
+	if err := s.rebuildLayerBlob(ctx, id, blob, tmp, algo); err != nil {
+		return err
+	}
+	if err := syncFile(tmp); err != nil {
+		return fmt.Errorf("sync recompressed blob: %w", err)
+	}
+	dgst, err := digestFile(ctx, tmp)
+	if err != nil {
+		return fmt.Errorf("compute layer digest: %w", err)
+	}
+	labels := map[string]string{LabelCompression: algo}
+	if fsverity.IsEnabled(blob) {
+		if err := fsverity.Enable(tmp); err != nil {
+			return err
+		}
+		verityDigest, err := fsverity.MeasureFsverity(tmp)
+		if err != nil {
+			return err
+		}
+		labels[LabelFsverityDigest] = verityDigest
+	} else if s.fsverityMeasurement {
+		verityDigest, err := fsverity.MeasureFsverity(tmp)
+		if err != nil {
+			return err
+		}
+		labels[LabelFsverityMeasurement] = verityDigest
+	}
+
+	// Share the new blob with identical layers before it becomes immutable,
+	// as Commit does.
+	unlockStore, err := s.addToContentStore(ctx, id, tmp, dgst)
+	if err != nil {
+		return err
+	}
+	defer unlockStore()
+
+	var dependents []string
+	err = s.ms.WithTransaction(ctx, true, func(ctx context.Context) error {
+		// Re-check under the write lock so no Prepare or View can start
+		// using the layer before the blob is replaced.
+		var err error
+		if dependents, err = s.idleLayerDependents(ctx, key); err != nil {
+			return err
+		}
+		labels[LabelLayerDigest] = dgst.String()
+		fieldpaths := make([]string, 0, len(labels))
+		for k := range labels {
+			fieldpaths = append(fieldpaths, "labels."+k)
+		}
+		if _, err := storage.UpdateInfo(ctx, snapshots.Info{
+			Name:   key,
+			Labels: labels,
+		}, fieldpaths...); err != nil {
+			return fmt.Errorf("update layer labels: %w", err)
+		}
+		// Other layers sharing the old blob through the content store
+		// keep it immutable.
+		if err := unlinkBlob(blob, func() error { return os.Rename(tmp, blob) }); err != nil {
+			return fmt.Errorf("replace layer blob: %w", err)
+		}
+		return nil
+	})
+	if s.setImmutable {
+		if ierr := setImmutable(blob, true); ierr != nil && !errdefs.IsNotImplemented(ierr) {
+			log.G(ctx).WithError(ierr).Warn("failed to set immutable flag (non-fatal)")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	s.removeFsMeta(ctx, append(dependents, id))
+
+	log.G(ctx).WithFields(log.Fields{
+		"key":         key,
+		"blob":        blob,
+		"compression": algo,
+	}).Info("layer recompressed")
+	return nil
+}
+
+// validateCompression checks that algo names a supported compressor,
+// optionally followed by mkfs.erofs parameters such as ",level=9".
+func validateCompression(algo string) error {
+	name, _, _ := strings.Cut(algo, ",")
+	if !slices.Contains(compressionAlgorithms, name) || strings.ContainsAny(algo, " \t") {
+		return fmt.Errorf("unsupported compression %q (supported: %s): %w",
+			algo, strings.Join(compressionAlgorithms, ", "), errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// idleLayerDependents returns the IDs of committed snapshots built on key.
+// It fails with errdefs.ErrFailedPrecondition if an active or view snapshot
+// is built on key, since its mounts reference the layer blob.
+// Must be called within a transaction.
+func (s *snapshotter) idleLayerDependents(ctx context.Context, key string) ([]string, error) {
+	children := make(map[string][]snapshots.Info)
+	if err := storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+		if info.Parent != "" {
+			children[info.Parent] = append(children[info.Parent], info)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("walk snapshots: %w", err)
+	}
+
+	var ids []string
+	queue := slices.Clone(children[key])
+	for len(queue) > 0 {
+		info := queue[0]
+		queue = queue[1:]
+		if info.Kind != snapshots.KindCommitted {
+			return nil, fmt.Errorf("layer %q is in use by %s snapshot %q: %w",
+				key, info.Kind, info.Name, errdefs.ErrFailedPrecondition)
+		}
+		id, _, _, err := storage.GetInfo(ctx, info.Name)
+		if err != nil {
+			return nil, fmt.Errorf("get snapshot info for %q: %w", info.Name, err)
+		}
+		ids = append(ids, id)
+		queue = append(queue, children[info.Name]...)
+	}
+	return ids, nil
+}
+
+// rebuildLayerBlob writes blob's contents to dst with the snapshotter's
+// mkfs.erofs options and the compressor algo, then mounts both images and
+// checks that their listings match.
+func (s *snapshotter) rebuildLayerBlob(ctx context.Context, id, blob, dst, algo string) error {
+	src, cleanupSrc, err := s.mountLayerBlobTemp(id, blob)
+	if err != nil {
+		return err
+	}
+	defer cleanupSrc()
+
+	if err := s.withMkfsTimeout(ctx, "mkfs.erofs", dst, func(ctx context.Context) error {
+		return s.erofsConverter().Convert(ctx, dst, src, withCompressionOpt(s.mkfsOpts, algo))
+	}); err != nil {
+		return fmt.Errorf("recompress layer: %w", err)
+	}
+
+	verify, cleanupVerify, err := s.mountLayerBlobTemp(id, dst)
+	if err != nil {
+		return fmt.Errorf("mount recompressed layer: %w", err)
+	}
+	defer cleanupVerify()
+
+	want, err := listTree(src)
+	if err != nil {
+		return fmt.Errorf("list original layer: %w", err)
+	}
+	got, err := listTree(verify)
+	if err != nil {
+		return fmt.Errorf("list recompressed layer: %w", err)
+	}
+	if p, differ := diffTrees(want, got); differ {
+		return fmt.Errorf("recompressed layer differs from original at %q", p)
+	}
+	return nil
+}
+
+// mountLayerBlobTemp mounts an EROFS image read-only on a temporary
+// directory in the snapshot directory of id.
+func (s *snapshotter) mountLayerBlobTemp(id, blob string) (string, func(), error) {
+	target, err := os.MkdirTemp(s.snapshotDir(id), "recompress-")
+	if err != nil {
+		return "", nil, fmt.Errorf("create mount point: %w", err)
+	}
+	m := mount.Mount{Type: "erofs", Source: blob, Options: []string{"ro", "loop"}}
+	unmount, err := mountutils.MountAll([]mount.Mount{m}, target)
+	if err != nil {
+		os.Remove(target)
+		return "", nil, fmt.Errorf("mount %s: %w", blob, err)
+	}
+	return target, func() {
+		_ = unmount()
+		os.Remove(target)
+	}, nil
+}
+
+// treeEntry is the part of a file's metadata compared by listTree.
+type treeEntry struct {
+	mode fs.FileMode
+	size int64
+	link string
+}
+
+// listTree returns the metadata of every entry under root, keyed by path
+// relative to root.
+func listTree(root string) (map[string]treeEntry, error) {
+	entries := make(map[string]treeEntry)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		e := treeEntry{mode: fi.Mode()}
+		switch {
+		case fi.Mode().IsRegular():
+			e.size = fi.Size()
+		case fi.Mode()&fs.ModeSymlink != 0:
+			if e.link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		entries[rel] = e
+		return nil
+	})
+	return entries, err
+}
+
+// diffTrees returns the first path, in sorted order, whose entry differs
+// between a and b.
+func diffTrees(a, b map[string]treeEntry) (string, bool) {
+	if maps.Equal(a, b) {
+		return "", false
+	}
+	paths := slices.Sorted(maps.Keys(a))
+	for p := range b {
+		if _, ok := a[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+	for _, p := range paths {
+		ea, oka := a[p]
+		eb, okb := b[p]
+		if oka != okb || ea != eb {
+			return p, true
+		}
+	}
+	return "", true
+}
+
+// removeFsMeta deletes the merged fsmeta, VMDK descriptor, layer manifest and
+// device UUID record of each snapshot in ids. Errors are logged, not returned.
+func (s *snapshotter) removeFsMeta(ctx context.Context, ids []string) {
+	for _, id := range ids {
+		for _, p := range []string{s.vmdkPath(id), s.fsMetaPath(id), s.manifestPath(id), s.fsMetaDevicesPath(id)} {
+			if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.G(ctx).WithError(err).WithField("path", p).Warn("failed to remove stale fsmeta")
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// TestErofsRecompressRoundTrip commits an uncompressed layer, recompresses
+// it with zstd and checks the blob still holds the same content.
+func TestErofsRecompressRoundTrip(t *testing.T) {
+	env := newSnapshotTestEnv(t)
+	s := env.snapshotter
+	key := env.createLayer("layer", "", "file.txt", testFileContent)
+
+	info, err := s.Stat(env.ctx(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDigest := info.Labels[LabelLayerDigest]
+	blob := info.Labels[LabelLayerBlobPath]
+
+	if err := s.Recompress(env.ctx(), key, "zstd"); err != nil {
+		t.Skipf("mkfs.erofs cannot recompress with zstd: %v", err)
+	}
+
+	info, err = s.Stat(env.ctx(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Labels[LabelCompression]; got != "zstd" {
+		t.Errorf("compression label = %q, want zstd", got)
+	}
+	newDigest := info.Labels[LabelLayerDigest]
+	if newDigest == "" || newDigest == oldDigest {
+		t.Errorf("layer digest label not updated: %q -> %q", oldDigest, newDigest)
+	}
+	if dgst, err := digestFile(env.ctx(), blob); err != nil || dgst.String() != newDigest {
+		t.Errorf("layer digest label %q does not match blob %q: %v", newDigest, dgst, err)
+	}
+	if _, err := os.Stat(blob + ".recompress"); !os.IsNotExist(err) {
+		t.Errorf("temporary blob should be removed, got %v", err)
+	}
+
+	id := snapshotID(env.ctx(), t, s, key)
+	mnt, cleanup, err := s.mountLayerBlobTemp(id, blob)
+	if err != nil {
+		t.Fatalf("mount recompressed blob: %v", err)
+	}
+	defer cleanup()
+	data, err := os.ReadFile(filepath.Join(mnt, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != testFileContent {
+		t.Errorf("content = %q, want %q", data, testFileContent)
+	}
+}
+
+// TestErofsRecompressContentStore checks that a recompressed blob is added
+// to the content store under its new digest.
+func TestErofsRecompressContentStore(t *testing.T) {
+	env := newSnapshotTestEnv(t, WithContentStore(t.TempDir()))
+	s := env.snapshotter
+	key := env.createLayer("layer", "", "file.txt", testFileContent)
+
+	if err := s.Recompress(env.ctx(), key, "zstd"); err != nil {
+		t.Skipf("mkfs.erofs cannot recompress with zstd: %v", err)
+	}
+
+	info, err := s.Stat(env.ctx(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := digest.Parse(info.Labels[LabelLayerDigest])
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.Stat(s.contentStorePath(dgst))
+	if err != nil {
+		t.Fatalf("expected recompressed blob in the content store: %v", err)
+	}
+	blob, err := os.Stat(info.Labels[LabelLayerBlobPath])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(stored, blob) {
+		t.Error("expected the layer blob to share the stored blob")
+	}
+}
@@ -0,0 +1,114 @@
+package snapshotter
+
+import (
+	"context"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+)
+
+func TestValidateCompression(t *testing.T) {
+	for _, algo := range []string{"zstd", "lz4hc,level=9", "lzma"} {
+		if err := validateCompression(algo); err != nil {
+			t.Errorf("validateCompression(%q): %v", algo, err)
+		}
+	}
+	for _, algo := range []string{"", "gzip", "-zlz4", "zstd, --ovlfs-strip=1"} {
+		if err := validateCompression(algo); !errdefs.IsInvalidArgument(err) {
+			t.Errorf("validateCompression(%q): expected invalid argument, got %v", algo, err)
+		}
+	}
+}
+
+func TestRecompressRequiresCommitted(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+
+	if err := s.Recompress(t.Context(), "active", "zstd"); !errdefs.IsFailedPrecondition(err) {
+		t.Errorf("expected failed precondition, got %v", err)
+	}
+	if err := s.Recompress(t.Context(), "missing", "zstd"); !errdefs.IsNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestRecompressRefusesLayerInUse(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	commitMetadataLayer(t, s, "base", "", []byte("base"))
+	commitMetadataLayer(t, s, "mid", "base", []byte("mid"))
+	createMetadataSnapshot(t, s, snapshots.KindView, "view", "mid")
+
+	for _, key := range []string{"base", "mid"} {
+		if err := s.Recompress(t.Context(), key, "zstd"); !errdefs.IsFailedPrecondition(err) {
+			t.Errorf("%s: expected failed precondition, got %v", key, err)
+		}
+	}
+}
+
+func TestIdleLayerDependents(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	commitMetadataLayer(t, s, "base", "", []byte("base"))
+	mid := commitMetadataLayer(t, s, "mid", "base", []byte("mid"))
+	top := commitMetadataLayer(t, s, "top", "mid", []byte("top"))
+
+	var ids []string
+	if err := s.ms.WithTransaction(t.Context(), false, func(ctx context.Context) (err error) {
+		ids, err = s.idleLayerDependents(ctx, "base")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(ids)
+	want := []string{mid, top}
+	slices.Sort(want)
+	if !slices.Equal(ids, want) {
+		t.Errorf("dependents = %v, want %v", ids, want)
+	}
+}
+
+func TestDiffTrees(t *testing.T) {
+	a := map[string]treeEntry{
+		".":     {mode: os.ModeDir | 0o755},
+		"a.txt": {mode: 0o644, size: 3},
+		"link":  {mode: os.ModeSymlink | 0o777, link: "a.txt"},
+	}
+	if p, differ := diffTrees(a, maps.Clone(a)); differ {
+		t.Errorf("identical trees reported different at %q", p)
+	}
+
+	b := maps.Clone(a)
+	b["a.txt"] = treeEntry{mode: 0o644, size: 4}
+	if p, differ := diffTrees(a, b); !differ || p != "a.txt" {
+		t.Errorf("expected difference at a.txt, got %q %v", p, differ)
+	}
+
+	c := maps.Clone(a)
+	c["extra"] = treeEntry{mode: 0o644}
+	if p, differ := diffTrees(a, c); !differ || p != "extra" {
+		t.Errorf("expected difference at extra, got %q %v", p, differ)
+	}
+}
+
+func TestRemoveFsMeta(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	if err := os.MkdirAll(s.snapshotDir("1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{s.fsMetaPath("1"), s.vmdkPath("1"), s.manifestPath("1")}
+	for _, p := range files {
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.removeFsMeta(t.Context(), []string{"1", "missing"})
+	for _, p := range files {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed, got %v", p, err)
+		}
+	}
+}
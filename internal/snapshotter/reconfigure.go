@@ -0,0 +1,99 @@
+package snapshotter
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/containerd/errdefs"
+)
+
+// Reconfigurer is implemented by snapshotters whose settings can be changed
+// without a restart.
+type Reconfigurer interface {
+	Reconfigure(opts ...Opt) error
+}
+
+// settings are the snapshotter fields Reconfigure can change.
+type settings struct {
+	timeouts           TimeoutConfig
+	mkfsTimeout        time.Duration
+	cleanupConcurrency int
+	mountRetry         retryPolicy
+}
+
+// settings returns a consistent copy of the reconfigurable fields.
+// Operations read it once at their start, so a concurrent Reconfigure only
+// affects operations that start after it.
+func (s *snapshotter) settings() settings {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return settings{
+		timeouts:           s.timeouts,
+		mkfsTimeout:        s.mkfsTimeout,
+		cleanupConcurrency: s.cleanupConcurrency,
+		mountRetry:         s.mountRetry,
+	}
+}
+
+// Reconfigure applies opts to the running snapshotter. Only WithTimeouts,
+// WithMkfsTimeout, WithCleanupConcurrency and WithMountRetries are
+// accepted; any other option, such as the root, metadata path or writable
+// layer size, fails with errdefs.ErrInvalidArgument and nothing is applied.
+// Existing snapshots and mounts are unaffected; operations started
+// afterwards use the new values.
+func (s *snapshotter) Reconfigure(opts ...Opt) error {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	next := SnapshotterConfig{
+		timeouts:           s.timeouts,
+		mkfsTimeout:        s.mkfsTimeout,
+		cleanupConcurrency: s.cleanupConcurrency,
+		mountRetries:       s.mountRetry.retries,
+		mountRetryBase:     s.mountRetry.base,
+	}
+	for _, opt := range opts {
+		opt(&next)
+	}
+	if setsFixedField(next) {
+		return fmt.Errorf("only timeouts, mkfs timeout, cleanup concurrency and mount retries can be reconfigured without a restart: %w", errdefs.ErrInvalidArgument)
+	}
+	if err := validateSettings(&next); err != nil {
+		return fmt.Errorf("%w: %w", err, errdefs.ErrInvalidArgument)
+	}
+
+	s.timeouts = next.timeouts
+	s.mkfsTimeout = next.mkfsTimeout
+	s.cleanupConcurrency = next.cleanupConcurrency
+	s.mountRetry = retryPolicy{retries: next.mountRetries, base: next.mountRetryBase}
+	return nil
+}
+
+// setsFixedField reports whether any field of config other than the
+// reconfigurable settings is set.
+func setsFixedField(config SnapshotterConfig) bool {
+	config.timeouts = TimeoutConfig{}
+	config.mkfsTimeout = 0
+	config.cleanupConcurrency = 0
+	config.mountRetries = 0
+	config.mountRetryBase = 0
+	return !reflect.ValueOf(config).IsZero()
+}
+
+// validateSettings checks the reconfigurable fields of config.
+func validateSettings(config *SnapshotterConfig) error {
+	if t := config.timeouts; t.Prepare < 0 || t.Commit < 0 || t.Mount < 0 {
+		return fmt.Errorf("timeouts must be >= 0, got %+v", t)
+	}
+	if config.cleanupConcurrency < 0 {
+		return fmt.Errorf("cleanup concurrency must be >= 0, got %d", config.cleanupConcurrency)
+	}
+	if config.mkfsTimeout < 0 {
+		return fmt.Errorf("mkfs timeout must be >= 0, got %s", config.mkfsTimeout)
+	}
+	if config.mountRetries < 0 || config.mountRetryBase < 0 {
+		return fmt.Errorf("mount retries and backoff must be >= 0, got %d and %s", config.mountRetries, config.mountRetryBase)
+	}
+	return nil
+}
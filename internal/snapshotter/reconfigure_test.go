@@ -0,0 +1,47 @@
+package snapshotter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/containerd/errdefs"
+)
+
+func TestReconfigure(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+
+	timeouts := TimeoutConfig{Prepare: time.Second, Commit: time.Minute}
+	if err := s.Reconfigure(
+		WithTimeouts(timeouts),
+		WithMkfsTimeout(time.Hour),
+		WithCleanupConcurrency(4),
+		WithMountRetries(3, time.Millisecond),
+	); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	got := s.settings()
+	want := settings{
+		timeouts:           timeouts,
+		mkfsTimeout:        time.Hour,
+		cleanupConcurrency: 4,
+		mountRetry:         retryPolicy{retries: 3, base: time.Millisecond},
+	}
+	if got != want {
+		t.Errorf("settings = %+v, want %+v", got, want)
+	}
+
+	// A fixed option fails the whole call.
+	if err := s.Reconfigure(WithCleanupConcurrency(8), WithDefaultSize(1<<30)); !errdefs.IsInvalidArgument(err) {
+		t.Errorf("expected invalid argument for a fixed option, got %v", err)
+	}
+	if err := s.Reconfigure(WithCommitHook(func(context.Context, CommitInfo) error { return nil })); !errdefs.IsInvalidArgument(err) {
+		t.Errorf("expected a commit hook to be rejected, got %v", err)
+	}
+	if err := s.Reconfigure(WithMkfsTimeout(-time.Second)); !errdefs.IsInvalidArgument(err) {
+		t.Errorf("expected invalid argument for a negative timeout, got %v", err)
+	}
+	if got := s.settings(); got != want {
+		t.Errorf("rejected Reconfigure changed settings to %+v", got)
+	}
+}
@@ -0,0 +1,282 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/continuity/fs"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+)
+
+// Relocator is implemented by snapshotters that can move their data to a
+// new root directory.
+type Relocator interface {
+	Relocate(ctx context.Context, newRoot string) error
+}
+
+// relocatedEntry is a root directory entry moved by Relocate.
+type relocatedEntry struct {
+	src, dst string
+	// copied is set when src was copied across filesystems rather than
+	// renamed; the source is removed once the relocation succeeds.
+	copied bool
+}
+
+// Relocate moves everything in the root directory, including metadata.db
+// when it lives there, to newRoot and continues serving from it. newRoot
+// must be empty or not exist. Entries are renamed when newRoot is on the
+// same filesystem and copied otherwise; copies lose fs-verity, and the
+// immutable flag is re-applied when WithImmutable is set.
+//
+// LabelLayerBlobPath labels and the WithPreserveFailedUpper and
+// WithScratchDir paths under the old root are rewritten. Merged fsmeta and
+// VMDK descriptors record blob paths, so they are removed: new snapshots
+// regenerate them and existing ones mount their layers individually.
+//
+// Relocate must run while the snapshotter is otherwise idle, with no VMs
+// using its snapshots. It waits for background fsmeta generation and
+// pauses auto-trim (see WithAutoTrim) until the move is done. It fails with errdefs.ErrFailedPrecondition if
+// anything under the root is mounted or backs a loop device. On failure,
+// moved entries are moved back, newRoot is removed if Relocate created it,
+// and the old root stays in use. The WithContentStore directory is outside
+// the root and is not moved.
+func (s *snapshotter) Relocate(ctx context.Context, newRoot string) (err error) {
+	oldRoot := s.rootDir()
+	created, err := validateNewRoot(oldRoot, newRoot)
+	if err != nil {
+		return err
+	}
+	newRoot = filepath.Clean(newRoot)
+	if created {
+		// Rolled back entries leave it empty.
+		defer func() {
+			if err != nil {
+				_ = os.Remove(newRoot)
+			}
+		}()
+	}
+	if err := checkDType(newRoot); err != nil {
+		return err
+	}
+
+	// Background fsmeta generation and trimming use snapshot directories.
+	// The trim loop runs until Close, so it is stopped for the move.
+	if s.stopAutoTrim() {
+		defer s.startAutoTrim()
+	}
+	s.fsMetaWg.Wait()
+	if err := rootInUse(oldRoot); err != nil {
+		return err
+	}
+
+	var moved []relocatedEntry
+	err = s.ms.relocate(func(metaPath string) (string, error) {
+		entries, err := os.ReadDir(oldRoot)
+		if err != nil {
+			return "", fmt.Errorf("read root directory: %w", err)
+		}
+		for _, e := range entries {
+			entry := relocatedEntry{src: filepath.Join(oldRoot, e.Name()), dst: filepath.Join(newRoot, e.Name())}
+			if entry.copied, err = moveEntry(entry.src, entry.dst); err != nil {
+				undoRelocation(ctx, moved)
+				return "", fmt.Errorf("move %s: %w", entry.src, err)
+			}
+			moved = append(moved, entry)
+		}
+
+		newMetaPath := rebasePath(metaPath, oldRoot, newRoot)
+		if err := rewriteBlobPathLabels(ctx, newMetaPath, oldRoot, newRoot); err != nil {
+			undoRelocation(ctx, moved)
+			return "", err
+		}
+		return newMetaPath, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.rootMu.Lock()
+	s.root = newRoot
+	s.failedUpperDir = rebasePath(s.failedUpperDir, oldRoot, newRoot)
+	s.scratchDir = rebasePath(s.scratchDir, oldRoot, newRoot)
+	s.rootMu.Unlock()
+
+	copied := false
+	for _, m := range moved {
+		if !m.copied {
+			continue
+		}
+		copied = true
+		clearImmutableFlagsUnder(ctx, m.src)
+		if err := os.RemoveAll(m.src); err != nil {
+			log.G(ctx).WithError(err).WithField("path", m.src).Warn("failed to remove relocated source")
+		}
+	}
+	s.finishRelocation(ctx, copied)
+
+	log.G(ctx).WithFields(log.Fields{
+		"from":   oldRoot,
+		"to":     newRoot,
+		"copied": copied,
+	}).Info("snapshotter root relocated")
+	return nil
+}
+
+// finishRelocation removes the fsmeta of every snapshot, which refers to
+// blobs by their old paths, and restores the immutable flag on copied
+// layer blobs.
+func (s *snapshotter) finishRelocation(ctx context.Context, copied bool) {
+	entries, err := os.ReadDir(s.snapshotsDir())
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		s.removeFsMeta(ctx, []string{e.Name()})
+		if !copied || !s.setImmutable {
+			continue
+		}
+		for _, blob := range erofsBlobsInDir(s.snapshotDir(e.Name())) {
+			if err := setImmutable(blob, true); err != nil && !errdefs.IsNotImplemented(err) {
+				log.G(ctx).WithError(err).WithField("path", blob).Warn("failed to set immutable flag (non-fatal)")
+			}
+		}
+	}
+}
+
+// validateNewRoot checks that newRoot is an absolute path outside oldRoot,
+// and empty if it exists, and creates it. It reports whether newRoot was
+// created.
+func validateNewRoot(oldRoot, newRoot string) (bool, error) {
+	if !filepath.IsAbs(newRoot) {
+		return false, fmt.Errorf("new root %q must be absolute: %w", newRoot, errdefs.ErrInvalidArgument)
+	}
+	newRoot = filepath.Clean(newRoot)
+	if isWithin(newRoot, oldRoot) || isWithin(oldRoot, newRoot) {
+		return false, fmt.Errorf("new root %q overlaps %q: %w", newRoot, oldRoot, errdefs.ErrInvalidArgument)
+	}
+	entries, err := os.ReadDir(newRoot)
+	if err == nil {
+		if len(entries) > 0 {
+			return false, fmt.Errorf("new root %q is not empty: %w", newRoot, errdefs.ErrInvalidArgument)
+		}
+		return false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("read new root: %w", err)
+	}
+	if err := os.MkdirAll(newRoot, 0o700); err != nil {
+		return false, fmt.Errorf("create new root: %w", err)
+	}
+	return true, nil
+}
+
+// isWithin reports whether path is dir or inside it.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// rebasePath returns path moved from oldRoot to newRoot if it is inside
+// oldRoot, and path unchanged otherwise.
+func rebasePath(path, oldRoot, newRoot string) string {
+	if path == "" || !isWithin(path, oldRoot) {
+		return path
+	}
+	rel, _ := filepath.Rel(oldRoot, path)
+	return filepath.Join(newRoot, rel)
+}
+
+// moveEntry renames src to dst, copying it when they are on different
+// filesystems. It reports whether src was copied and still exists.
+func moveEntry(src, dst string) (bool, error) {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return false, err
+	}
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return false, err
+	}
+	if fi.IsDir() {
+		err = fs.CopyDir(dst, src)
+	} else {
+		err = fs.CopyFile(dst, src)
+	}
+	if err != nil {
+		_ = os.RemoveAll(dst)
+		return false, err
+	}
+	return true, nil
+}
+
+// undoRelocation moves entries back to where they were, newest first.
+func undoRelocation(ctx context.Context, moved []relocatedEntry) {
+	for i := len(moved) - 1; i >= 0; i-- {
+		m := moved[i]
+		var err error
+		if m.copied {
+			clearImmutableFlagsUnder(ctx, m.dst)
+			err = os.RemoveAll(m.dst)
+		} else {
+			err = os.Rename(m.dst, m.src)
+		}
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("path", m.dst).Error("failed to roll back relocation")
+		}
+	}
+}
+
+// clearImmutableFlagsUnder clears the immutable flag on the EROFS blobs of
+// every snapshot directory under path, a relocated root entry.
+func clearImmutableFlagsUnder(ctx context.Context, path string) {
+	dirs, _ := filepath.Glob(filepath.Join(path, "*"))
+	for _, dir := range dirs {
+		clearImmutableFlags(ctx, dir)
+	}
+}
+
+// rewriteBlobPathLabels rebases the LabelLayerBlobPath labels in the
+// metadata database at path from oldRoot to newRoot in one transaction.
+func rewriteBlobPathLabels(ctx context.Context, path, oldRoot, newRoot string) error {
+	ms, err := storage.NewMetaStore(path)
+	if err != nil {
+		return fmt.Errorf("open relocated metadata store: %w", err)
+	}
+	defer ms.Close()
+
+	return ms.WithTransaction(ctx, true, func(ctx context.Context) error {
+		var updates []snapshots.Info
+		if err := storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+			blob, ok := info.Labels[LabelLayerBlobPath]
+			if !ok {
+				return nil
+			}
+			if rebased := rebasePath(blob, oldRoot, newRoot); rebased != blob {
+				updates = append(updates, snapshots.Info{
+					Name:   info.Name,
+					Labels: map[string]string{LabelLayerBlobPath: rebased},
+				})
+			}
+			return nil
+		}); err != nil {
+			return fmt.Errorf("walk snapshots: %w", err)
+		}
+		for _, info := range updates {
+			if _, err := storage.UpdateInfo(ctx, info, "labels."+LabelLayerBlobPath); err != nil {
+				return fmt.Errorf("update layer blob path of %s: %w", info.Name, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/v2/pkg/testutil"
+	"github.com/containerd/errdefs"
+	"golang.org/x/sys/unix"
+)
+
+func TestRelocateRemovesCreatedRootOnFailure(t *testing.T) {
+	testutil.RequiresRoot(t)
+
+	s := newMetadataOnlySnapshotter(t)
+	commitMetadataLayer(t, s, "base", "", []byte("base"))
+	mnt := filepath.Join(s.root, "mnt")
+	if err := os.Mkdir(mnt, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Mount("tmpfs", mnt, "tmpfs", 0, "size=1m"); err != nil {
+		t.Skipf("cannot mount tmpfs: %v", err)
+	}
+	t.Cleanup(func() { _ = unix.Unmount(mnt, unix.MNT_DETACH) })
+
+	newRoot := filepath.Join(t.TempDir(), "new")
+	if err := s.Relocate(t.Context(), newRoot); !errdefs.IsFailedPrecondition(err) {
+		t.Fatalf("expected failed precondition, got %v", err)
+	}
+	if _, err := os.Stat(newRoot); !os.IsNotExist(err) {
+		t.Errorf("expected new root to be removed, got %v", err)
+	}
+
+	// An existing empty root is left in place.
+	existing := t.TempDir()
+	if err := s.Relocate(t.Context(), existing); !errdefs.IsFailedPrecondition(err) {
+		t.Fatalf("expected failed precondition, got %v", err)
+	}
+	if _, err := os.Stat(existing); err != nil {
+		t.Errorf("expected existing root to be kept: %v", err)
+	}
+}
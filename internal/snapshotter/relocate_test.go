@@ -0,0 +1,111 @@
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+)
+
+func TestRelocate(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	oldRoot := s.root
+	commitMetadataLayer(t, s, "base", "", []byte("base"))
+	top := commitMetadataLayer(t, s, "top", "base", []byte("top layer"))
+	createMetadataSnapshot(t, s, snapshots.KindView, "view", "top")
+	if err := os.WriteFile(s.vmdkPath(top), []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	newRoot := filepath.Join(t.TempDir(), "new")
+	if err := s.Relocate(t.Context(), newRoot); err != nil {
+		t.Fatalf("Relocate: %v", err)
+	}
+
+	if s.root != newRoot {
+		t.Errorf("root = %q, want %q", s.root, newRoot)
+	}
+	if entries, err := os.ReadDir(oldRoot); err != nil || len(entries) != 0 {
+		t.Errorf("expected empty old root, got %d entries (%v)", len(entries), err)
+	}
+	if _, err := os.Stat(filepath.Join(newRoot, "metadata.db")); err != nil {
+		t.Errorf("metadata not moved: %v", err)
+	}
+	if _, err := os.Stat(s.vmdkPath(top)); !os.IsNotExist(err) {
+		t.Errorf("expected stale VMDK to be removed, got %v", err)
+	}
+
+	info, err := s.Stat(t.Context(), "top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.Labels[LabelLayerBlobPath], s.fallbackLayerBlobPath(top); got != want {
+		t.Errorf("%s = %q, want %q", LabelLayerBlobPath, got, want)
+	}
+
+	mounts, err := s.Mounts(t.Context(), "view")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mounts {
+		if !strings.HasPrefix(m.Source, newRoot) {
+			t.Errorf("mount source %q not under the new root", m.Source)
+		}
+	}
+}
+
+func TestRelocateWithAutoTrim(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.autoTrimInterval = time.Millisecond
+	s.startAutoTrim()
+	t.Cleanup(func() { s.stopAutoTrim() })
+	createMetadataSnapshot(t, s, snapshots.KindActive, "active", "")
+
+	newRoot := filepath.Join(t.TempDir(), "new")
+	done := make(chan error, 1)
+	go func() { done <- s.Relocate(t.Context(), newRoot) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Relocate: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Relocate did not return with auto-trim enabled")
+	}
+
+	if s.rootDir() != newRoot {
+		t.Errorf("root = %q, want %q", s.rootDir(), newRoot)
+	}
+	// Let the restarted loop run a pass against the new root.
+	time.Sleep(10 * time.Millisecond)
+	s.trimMu.Lock()
+	running := s.trimStop != nil
+	s.trimMu.Unlock()
+	if !running {
+		t.Error("expected auto-trim to be restarted after Relocate")
+	}
+}
+
+func TestRelocateRejectsTarget(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	commitMetadataLayer(t, s, "base", "", []byte("base"))
+
+	nonEmpty := t.TempDir()
+	if err := os.WriteFile(filepath.Join(nonEmpty, "file"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"relative", nonEmpty, filepath.Join(s.root, "nested"), filepath.Dir(s.root)} {
+		if err := s.Relocate(t.Context(), target); !errdefs.IsInvalidArgument(err) {
+			t.Errorf("%s: expected invalid argument, got %v", target, err)
+		}
+	}
+
+	// The snapshotter keeps working from the old root.
+	if _, err := s.Stat(t.Context(), "base"); err != nil {
+		t.Errorf("Stat after rejected relocation: %v", err)
+	}
+}
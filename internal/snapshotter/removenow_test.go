@@ -0,0 +1,53 @@
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+func TestRemoveNow(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.retainRemoved = 1
+
+	base := commitMetadataLayer(t, s, "base", "", []byte("erofs"))
+	child := createMetadataSnapshot(t, s, snapshots.KindActive, "child", "base")
+	if err := os.MkdirAll(s.snapshotDir(child.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.writablePath(child.ID), []byte("ext4"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(s.snapshotsDir(), "orphan")
+	if err := os.MkdirAll(orphan, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RemoveNow(t.Context(), "base"); err == nil {
+		t.Fatal("expected error when removing a parent with a child")
+	}
+	if _, err := os.Stat(s.snapshotDir(base)); err != nil {
+		t.Fatalf("parent directory should be kept: %v", err)
+	}
+
+	if err := s.RemoveNow(t.Context(), "child"); err != nil {
+		t.Fatalf("RemoveNow child: %v", err)
+	}
+	if err := s.RemoveNow(t.Context(), "base"); err != nil {
+		t.Fatalf("RemoveNow base: %v", err)
+	}
+	for _, id := range []string{child.ID, base} {
+		if _, err := os.Stat(s.snapshotDir(id)); !os.IsNotExist(err) {
+			t.Errorf("snapshot dir %s should be gone, got: %v", id, err)
+		}
+	}
+	if retained, _ := s.RetainedSnapshots(t.Context()); len(retained) != 0 {
+		t.Errorf("RemoveNow should not retain snapshots, got %+v", retained)
+	}
+	// Unrelated orphans are left to Cleanup.
+	if _, err := os.Stat(orphan); err != nil {
+		t.Errorf("orphan directory should be left for Cleanup: %v", err)
+	}
+}
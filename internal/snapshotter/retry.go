@@ -0,0 +1,62 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+const (
+	// defaultMountRetries is the number of extra attempts for a loop mount
+	// that fails with a transient error.
+	defaultMountRetries = 3
+	// defaultMountRetryBase is the delay before the first retry. It doubles
+	// on each subsequent attempt.
+	defaultMountRetryBase = 50 * time.Millisecond
+)
+
+// retryPolicy bounds retries of transient host mount failures.
+// The zero value makes a single attempt.
+type retryPolicy struct {
+	retries int
+	base    time.Duration
+}
+
+// do runs fn until it succeeds, returns a permanent error, the retries are
+// exhausted, or ctx is done. The last error from fn is returned.
+func (p retryPolicy) do(ctx context.Context, op string, fn func() error) error {
+	delay := p.base
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.retries || !isTransientMountError(err) {
+			return err
+		}
+
+		log.G(ctx).WithError(err).WithFields(log.Fields{
+			"op":      op,
+			"attempt": attempt + 1,
+			"delay":   delay,
+		}).Debug("transient mount failure, retrying")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// isTransientMountError reports whether a mount failure is worth retrying.
+// Loop device allocation on busy nodes intermittently fails with EAGAIN or
+// EBUSY; errors such as ENOENT or EINVAL are permanent and never retried.
+func isTransientMountError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EBUSY) ||
+		errors.Is(err, syscall.EINTR)
+}
@@ -0,0 +1,88 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := retryPolicy{retries: 3, base: time.Millisecond}
+
+	t.Run("retries transient errors", func(t *testing.T) {
+		calls := 0
+		err := policy.do(t.Context(), "test", func() error {
+			calls++
+			if calls < 3 {
+				return fmt.Errorf("setup loop: %w", syscall.EBUSY)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if calls != 3 {
+			t.Errorf("expected 3 calls, got %d", calls)
+		}
+	})
+
+	t.Run("does not retry permanent errors", func(t *testing.T) {
+		for _, errno := range []syscall.Errno{syscall.ENOENT, syscall.EINVAL} {
+			calls := 0
+			err := policy.do(t.Context(), "test", func() error {
+				calls++
+				return fmt.Errorf("mount: %w", errno)
+			})
+			if !errors.Is(err, errno) {
+				t.Errorf("expected %v, got %v", errno, err)
+			}
+			if calls != 1 {
+				t.Errorf("%v: expected 1 call, got %d", errno, calls)
+			}
+		}
+	})
+
+	t.Run("gives up after retries", func(t *testing.T) {
+		calls := 0
+		err := policy.do(t.Context(), "test", func() error {
+			calls++
+			return syscall.EAGAIN
+		})
+		if !errors.Is(err, syscall.EAGAIN) {
+			t.Errorf("expected EAGAIN, got %v", err)
+		}
+		if calls != 4 {
+			t.Errorf("expected 4 calls, got %d", calls)
+		}
+	})
+
+	t.Run("stops when context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		calls := 0
+		err := retryPolicy{retries: 3, base: time.Hour}.do(ctx, "test", func() error {
+			calls++
+			return syscall.EBUSY
+		})
+		if !errors.Is(err, syscall.EBUSY) {
+			t.Errorf("expected EBUSY, got %v", err)
+		}
+		if calls != 1 {
+			t.Errorf("expected 1 call, got %d", calls)
+		}
+	})
+
+	t.Run("zero value makes one attempt", func(t *testing.T) {
+		calls := 0
+		_ = retryPolicy{}.do(t.Context(), "test", func() error {
+			calls++
+			return syscall.EBUSY
+		})
+		if calls != 1 {
+			t.Errorf("expected 1 call, got %d", calls)
+		}
+	})
+}
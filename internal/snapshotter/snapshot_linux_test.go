@@ -35,6 +35,9 @@ package snapshotter
 // - TestErofsExtractSnapshotWithParents
 // - TestErofsImmutableFlagOnCommit
 // - TestErofsImmutableFlagClearedOnRemove
+// - TestErofsCleanupClearsImmutableOnAllBlobs
+// - TestInodeFlagsError
+// - TestSetImmutableUnsupportedFilesystem
 // - TestErofsConcurrentMounts
 // - TestErofsViewNoParent
 // - TestErofsViewNoParentBlockMode
@@ -44,6 +47,7 @@ package snapshotter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -58,7 +62,9 @@ import (
 	"github.com/containerd/containerd/v2/core/snapshots/storage"
 	"github.com/containerd/containerd/v2/pkg/namespaces"
 	"github.com/containerd/containerd/v2/pkg/testutil"
+	"github.com/containerd/errdefs"
 	bolt "go.etcd.io/bbolt"
+	"golang.org/x/sys/unix"
 
 	erofsdiffer "github.com/spin-stack/erofs-snapshotter/internal/differ"
 	"github.com/spin-stack/erofs-snapshotter/internal/mountutils"
@@ -1012,6 +1018,82 @@ func TestErofsImmutableFlagClearedOnRemove(t *testing.T) {
 	}
 }
 
+// TestErofsCleanupClearsImmutableOnAllBlobs verifies that Cleanup clears
+// IMMUTABLE_FL on digest-named layer blobs and fsmeta.erofs before removing
+// an orphaned snapshot directory.
+func TestErofsCleanupClearsImmutableOnAllBlobs(t *testing.T) {
+	testutil.RequiresRoot(t)
+	s := newMetadataOnlySnapshotter(t)
+
+	orphan := filepath.Join(s.snapshotsDir(), "orphan")
+	if err := os.MkdirAll(orphan, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	skipIfNoImmutableSupport(t, orphan)
+
+	blobs := []string{
+		filepath.Join(orphan, "sha256-"+strings.Repeat("a", 64)+".erofs"),
+		filepath.Join(orphan, fsmetaFilename),
+	}
+	for _, blob := range blobs {
+		if err := os.WriteFile(blob, []byte("erofs"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := setImmutable(blob, true); err != nil {
+			t.Skipf("cannot set immutable flag: %v", err)
+		}
+		t.Cleanup(func() { _ = setImmutable(blob, false) })
+	}
+
+	if err := s.Cleanup(t.Context()); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatalf("expected orphan directory to be removed, got: %v", err)
+	}
+}
+
+func TestInodeFlagsError(t *testing.T) {
+	for _, errno := range []unix.Errno{unix.ENOTTY, unix.EOPNOTSUPP} {
+		err := inodeFlagsError("get flags", errno)
+		if !errdefs.IsNotImplemented(err) || !errors.Is(err, errno) {
+			t.Errorf("%v: expected not implemented wrapping the errno, got %v", errno, err)
+		}
+	}
+	err := inodeFlagsError("get flags", unix.EPERM)
+	if errdefs.IsNotImplemented(err) || !errors.Is(err, unix.EPERM) {
+		t.Errorf("EPERM: expected plain wrapped errno, got %v", err)
+	}
+}
+
+// TestSetImmutableUnsupportedFilesystem checks that setImmutable reports
+// ErrNotImplemented on tmpfs, which lacks inode flags on older kernels.
+func TestSetImmutableUnsupportedFilesystem(t *testing.T) {
+	testutil.RequiresRoot(t)
+
+	dir := t.TempDir()
+	if err := unix.Mount("tmpfs", dir, "tmpfs", 0, "size=1m"); err != nil {
+		t.Skipf("cannot mount tmpfs: %v", err)
+	}
+	t.Cleanup(func() { _ = unix.Unmount(dir, unix.MNT_DETACH) })
+
+	blob := filepath.Join(dir, "layer.erofs")
+	if err := os.WriteFile(blob, []byte("erofs"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := setImmutable(blob, true)
+	if err == nil {
+		_ = setImmutable(blob, false)
+		t.Skip("tmpfs supports inode flags on this kernel")
+	}
+	if !errdefs.IsNotImplemented(err) {
+		t.Fatalf("expected not implemented error, got %v", err)
+	}
+	if err := setImmutable(blob, false); !errdefs.IsNotImplemented(err) {
+		t.Fatalf("expected not implemented error clearing the flag, got %v", err)
+	}
+}
+
 // TestErofsConcurrentMounts verifies that concurrent mount operations
 // are safe and produce consistent results.
 func TestErofsConcurrentMounts(t *testing.T) {
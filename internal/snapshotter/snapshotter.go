@@ -18,19 +18,25 @@ package snapshotter
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/containerd/containerd/v2/core/snapshots"
-	"github.com/containerd/containerd/v2/core/snapshots/storage"
 	"github.com/containerd/log"
+	"github.com/docker/go-units"
 	"github.com/moby/sys/mountinfo"
 
 	"github.com/spin-stack/erofs-snapshotter/internal/erofs"
+	"github.com/spin-stack/erofs-snapshotter/internal/preflight"
 	"github.com/spin-stack/erofs-snapshotter/internal/stringutil"
 )
 
@@ -38,14 +44,86 @@ import (
 type SnapshotterConfig struct {
 	// setImmutable enables IMMUTABLE_FL file attribute for EROFS layers
 	setImmutable bool
-	// defaultSize is the size in bytes of the ext4 writable layer (must be > 0)
+	// defaultSize is the size in bytes of the ext4 writable layer (see minWritableSize)
 	defaultSize int64
+	// defaultSizeString, when set, is parsed into defaultSize by NewSnapshotter
+	defaultSizeString string
+	// usageCacheTTL enables caching of active snapshot usage when > 0
+	usageCacheTTL time.Duration
+	// autoTrimInterval enables periodic trimming of writable layers when > 0
+	autoTrimInterval time.Duration
+	// writableTemplate is a prebuilt ext4 image copied for new writable layers
+	writableTemplate string
+	// autoModprobe attempts to load the EROFS module if it is not registered
+	autoModprobe bool
+	// defaultNamespace is used by namespace-scoped operations when the
+	// context carries no namespace
+	defaultNamespace string
+	// failedUpperDir receives copies of upper directories whose conversion failed
+	failedUpperDir string
+	// retainRemoved is the number of removed writable snapshots kept in the graveyard
+	retainRemoved int
+	// mountRetries and mountRetryBase bound retries of transient loop mount failures
+	mountRetries   int
+	mountRetryBase time.Duration
+	// blockModeFallback extracts into the snapshot directory when loop devices are unavailable
+	blockModeFallback bool
+	// eagerExt4Init formats writable layers without lazy inode table and
+	// journal initialization
+	eagerExt4Init bool
+	// namespaceQuota caps the bytes used by each listed namespace
+	namespaceQuota map[string]int64
+	// verifyDigestOnMount checks parent layer blobs against LabelLayerDigest
+	// before returning mounts
+	verifyDigestOnMount bool
+	// metadataPath overrides the location of metadata.db
+	metadataPath string
+	// scratchDir holds temporary fsmeta files and mkfs.erofs scratch space
+	scratchDir string
+	// erofsBlockSize is the mkfs.erofs block size in bytes (0 = mkfs default)
+	erofsBlockSize int
+	// mkfsTimeout bounds each mkfs.erofs and mkfs.ext4 run (0 = no limit)
+	mkfsTimeout time.Duration
+	// minFreeLoopDevices is the loop device headroom required at startup
+	minFreeLoopDevices int
+	// fsMetaConcurrency caps concurrent fsmeta generations (0 = unlimited)
+	fsMetaConcurrency int
+	// timeouts bounds individual operations (zero fields = no limit)
+	timeouts TimeoutConfig
+	// upperXattrs are set on the upper directory of new active snapshots
+	upperXattrs map[string]string
+	// blobNamer names the layer blobs the snapshotter writes (nil = DefaultBlobNamer)
+	blobNamer BlobNamer
+	// fsverityMeasurement records userspace fs-verity digests of layers without fs-verity
+	fsverityMeasurement bool
+	// contentStore is the directory of the shared layer blob store ("" = disabled)
+	contentStore string
+	// startupConsistencyCheck compares the metadata store with snapshot directories at startup
+	startupConsistencyCheck bool
+	// blockDeviceHandoff enables WritableDevice
+	blockDeviceHandoff bool
+	// cleanupConcurrency caps directories removed in parallel by Cleanup (0 = 1)
+	cleanupConcurrency int
+	// ext4MountOptions are extra mount options for the ext4 writable layer
+	ext4MountOptions []string
+	// unsafeExt4MountOptions allows ext4 mount options that risk data loss
+	unsafeExt4MountOptions bool
+	// commitHook is called after each successful commit
+	commitHook CommitHook
+	// commitHookFatal makes a commit hook error fail the commit
+	commitHookFatal bool
+	// converter builds EROFS images instead of mkfs.erofs
+	converter Converter
+	// erofsTailPacking packs file tails into inodes in layers built here
+	erofsTailPacking bool
 }
 
 // Opt is an option to configure the erofs snapshotter
 type Opt func(config *SnapshotterConfig)
 
-// WithImmutable enables IMMUTABLE_FL file attribute for EROFS layers
+// WithImmutable enables IMMUTABLE_FL file attribute for EROFS layers.
+// Layers committed before it was enabled can be protected with
+// ReapplyImmutable.
 func WithImmutable() Opt {
 	return func(config *SnapshotterConfig) {
 		config.setImmutable = true
@@ -53,21 +131,624 @@ func WithImmutable() Opt {
 }
 
 // WithDefaultSize sets the size of the ext4 writable layer for active snapshots.
-// Size must be > 0. The writable layer is an ext4 image that is loop-mounted.
+// Size must be a multiple of 1024 and at least 1 MiB. The writable layer is an
+// ext4 image that is loop-mounted.
 func WithDefaultSize(size int64) Opt {
 	return func(config *SnapshotterConfig) {
 		config.defaultSize = size
+		config.defaultSizeString = ""
 	}
 }
 
+// WithDefaultSizeString is WithDefaultSize with a human-readable size such
+// as "512MiB" or "2GiB". Units are binary: "512M" and "512MB" are also
+// 512 MiB. NewSnapshotter fails if size cannot be parsed.
+func WithDefaultSizeString(size string) Opt {
+	return func(config *SnapshotterConfig) {
+		config.defaultSizeString = size
+	}
+}
+
+// WithUsageCache caches the disk usage of active snapshots for up to ttl.
+// A cached value is reused while the upper directory's mtime is unchanged,
+// avoiding a full tree walk on every Usage call. The cache is invalidated
+// on Commit and Remove. A ttl <= 0 disables caching.
+func WithUsageCache(ttl time.Duration) Opt {
+	return func(config *SnapshotterConfig) {
+		config.usageCacheTTL = ttl
+	}
+}
+
+// WithAutoTrim periodically runs fstrim on the ext4 writable layers mounted
+// on the host (those of extract snapshots), returning freed blocks to the
+// sparse rwlayer.img. Layers attached to a VM are never mounted for this;
+// the guest must trim them. An interval <= 0 disables trimming.
+func WithAutoTrim(interval time.Duration) Opt {
+	return func(config *SnapshotterConfig) {
+		config.autoTrimInterval = interval
+	}
+}
+
+// WithWritableTemplate seeds new ext4 writable layers from a prebuilt ext4
+// image instead of running mkfs.ext4. The template is reflinked when the
+// filesystem supports it and sparse-copied otherwise, and each copy gets a
+// new filesystem UUID with tune2fs. Its size must equal the writable layer
+// size (see WithDefaultSize).
+func WithWritableTemplate(path string) Opt {
+	return func(config *SnapshotterConfig) {
+		config.writableTemplate = path
+	}
+}
+
+// WithAutoModprobe attempts `modprobe erofs` during startup when EROFS is not
+// listed in /proc/filesystems, before failing the compatibility check.
+func WithAutoModprobe() Opt {
+	return func(config *SnapshotterConfig) {
+		config.autoModprobe = true
+	}
+}
+
+// WithDefaultNamespace sets the namespace used by namespace-scoped operations
+// such as WalkNamespace when the request context carries no namespace.
+func WithDefaultNamespace(ns string) Opt {
+	return func(config *SnapshotterConfig) {
+		config.defaultNamespace = ns
+	}
+}
+
+// WithRetainRemoved keeps the directories of the n most recently removed
+// active snapshots, including their rwlayer.img, in a graveyard/ directory
+// under the root instead of deleting them. Older entries are pruned.
+// Retained entries can be listed with RetainedSnapshots. n = 0 disables
+// retention.
+func WithRetainRemoved(n int) Opt {
+	return func(config *SnapshotterConfig) {
+		config.retainRemoved = n
+	}
+}
+
+// WithMountRetries sets how many times a host loop mount of a writable layer
+// is retried after a transient failure (EAGAIN, EBUSY), starting with a delay
+// of base and doubling on each attempt. Permanent errors are never retried.
+// n = 0 disables retries.
+func WithMountRetries(n int, base time.Duration) Opt {
+	return func(config *SnapshotterConfig) {
+		config.mountRetries = n
+		config.mountRetryBase = base
+	}
+}
+
+// WithBlockModeFallback lets extract snapshots work on hosts without loop
+// devices, such as some nested container setups. When /dev/loop-control is
+// missing, Prepare skips the ext4 writable layer of an extract snapshot and
+// the differ writes into the snapshot's fs/ directory instead, which Commit
+// converts as usual. Such snapshots are marked with LabelBlockFallback. Other
+// snapshots are unaffected: their writable layers are mounted by the VM.
+func WithBlockModeFallback() Opt {
+	return func(config *SnapshotterConfig) {
+		config.blockModeFallback = true
+	}
+}
+
+// WithEagerExt4Init formats writable layers with lazy_itable_init=0 and
+// lazy_journal_init=0. By default mkfs.ext4 defers zeroing the inode tables
+// and journal to the ext4lazyinit kernel thread, which runs after the layer
+// is first mounted and competes for I/O with the container. Eager init makes
+// Prepare slower but keeps runtime I/O latency steady.
+func WithEagerExt4Init() Opt {
+	return func(config *SnapshotterConfig) {
+		config.eagerExt4Init = true
+	}
+}
+
+// WithNamespaceQuota caps the bytes each listed namespace may use: the size
+// of its committed layer blobs plus the full size of its writable layers.
+// Prepare and Commit fail with errdefs.ErrResourceExhausted when they would
+// exceed the quota. Namespaces not in quotas are unlimited.
+func WithNamespaceQuota(quotas map[string]int64) Opt {
+	return func(config *SnapshotterConfig) {
+		config.namespaceQuota = maps.Clone(quotas)
+	}
+}
+
+// WithVerifyDigestOnMount makes Prepare, View and Mounts check each parent
+// layer blob against its LabelLayerDigest before returning mounts, failing
+// with an IntegrityError on mismatch. Verified digests are cached by blob
+// path, size and modification time, so a blob is only re-hashed after it
+// changes. Layers committed without a digest label are not checked.
+func WithVerifyDigestOnMount() Opt {
+	return func(config *SnapshotterConfig) {
+		config.verifyDigestOnMount = true
+	}
+}
+
+// WithMetadataPath stores the metadata database at path instead of
+// root/metadata.db, e.g. to keep it on faster storage than the layer blobs.
+// path must be absolute; its directory is created if missing.
+func WithMetadataPath(path string) Opt {
+	return func(config *SnapshotterConfig) {
+		config.metadataPath = path
+	}
+}
+
+// WithScratchDir makes fsmeta generation write its temporary files, and
+// mkfs.erofs its scratch files, in dir instead of the snapshot directory.
+// The results are moved into place when done, by copying if dir is on
+// another filesystem. dir must be absolute; it is created if missing.
+func WithScratchDir(dir string) Opt {
+	return func(config *SnapshotterConfig) {
+		config.scratchDir = dir
+	}
+}
+
+// WithErofsBlockSize sets the block size, in bytes, of the EROFS images the
+// snapshotter builds on Commit, and of merged fsmeta when all its layers
+// match. size must be a power of two between 512 and 65536.
+// Layers with blocks smaller than 4096 bytes cannot be merged into fsmeta.
+func WithErofsBlockSize(size int) Opt {
+	return func(config *SnapshotterConfig) {
+		config.erofsBlockSize = size
+	}
+}
+
+// WithMkfsTimeout kills any mkfs.erofs or mkfs.ext4 run that takes longer
+// than d, removes its partial output and fails the operation with a
+// TimeoutError. Zero disables the limit.
+func WithMkfsTimeout(d time.Duration) Opt {
+	return func(config *SnapshotterConfig) {
+		config.mkfsTimeout = d
+	}
+}
+
+// TimeoutConfig sets deadlines for individual operations (see WithTimeouts).
+// A zero field leaves the operation bounded only by the caller's context.
+type TimeoutConfig struct {
+	// Prepare bounds Prepare and View, including creating the writable
+	// layer and, for extract snapshots, mounting it.
+	Prepare time.Duration
+	// Commit bounds Commit, including converting the upper directory.
+	Commit time.Duration
+	// Mount bounds mounting the writable layer of extract snapshots and
+	// Mounts.
+	Mount time.Duration
+}
+
+// WithTimeouts fails operations that run longer than the limits in t with
+// an OperationTimeoutError, after removing their partial directories,
+// mounts and mkfs output. The caller's context still applies; whichever
+// deadline is shorter wins. Unlike WithMkfsTimeout, the limits cover the
+// whole operation, such as waiting for a loop device.
+func WithTimeouts(t TimeoutConfig) Opt {
+	return func(config *SnapshotterConfig) {
+		config.timeouts = t
+	}
+}
+
+// WithUpperXattrs sets the extended attributes in xattrs on the upper
+// directory of every new active snapshot, after its ownership is copied from
+// the parent. Names must be in the user, security or trusted namespace.
+// LabelUpperXattrPrefix labels override them per snapshot. On filesystems
+// that do not support a namespace, a warning is logged and the attributes
+// are skipped.
+func WithUpperXattrs(xattrs map[string]string) Opt {
+	return func(config *SnapshotterConfig) {
+		config.upperXattrs = maps.Clone(xattrs)
+	}
+}
+
+// WithBlobNamer names the EROFS layer blobs the snapshotter writes with
+// namer instead of DefaultBlobNamer. Commit records the resulting path in
+// LabelLayerBlobPath, which lookups use. For layers without that label,
+// lookups try namer's name for a blob without a digest after globbing for
+// blobs the EROFS differ wrote. Blobs named by DefaultBlobNamer are still found.
+func WithBlobNamer(namer BlobNamer) Opt {
+	return func(config *SnapshotterConfig) {
+		config.blobNamer = namer
+	}
+}
+
+// WithFsverityMeasurement makes Commit and Recompress compute the fs-verity
+// digest of layer blobs that do not have fs-verity enabled, in userspace,
+// and record it in LabelFsverityMeasurement. This lets layers be attested on
+// nodes whose filesystem cannot enable fs-verity. Hashing the whole blob
+// adds to commit time.
+func WithFsverityMeasurement() Opt {
+	return func(config *SnapshotterConfig) {
+		config.fsverityMeasurement = true
+	}
+}
+
+// WithContentStore keeps one copy of each committed layer blob in dir,
+// keyed by its LabelLayerDigest, so identical layers pulled under different
+// image chains share their data. Snapshot directories hold a hard link to
+// the stored blob, or a symlink when dir is on another filesystem. Layers
+// committed before the store was enabled are added at startup, and Cleanup
+// removes stored blobs no layer uses any more. With WithImmutable, the flag
+// is shared by all layers linked to a blob and stays set until the last of
+// them is removed. dir must be absolute and outside the snapshotter root;
+// it is created if missing.
+func WithContentStore(dir string) Opt {
+	return func(config *SnapshotterConfig) {
+		config.contentStore = dir
+	}
+}
+
+// WithStartupConsistencyCheck makes NewSnapshotter compare the metadata
+// store with the snapshots directory (see CheckConsistency). Snapshots
+// whose directory is missing and directories without a snapshot are
+// logged, and the former are labeled with LabelDirectoryMissing. Nothing
+// is deleted.
+func WithStartupConsistencyCheck() Opt {
+	return func(config *SnapshotterConfig) {
+		config.startupConsistencyCheck = true
+	}
+}
+
+// WithBlockDeviceHandoff enables WritableDevice, for VM runtimes that attach
+// the ext4 writable layer image of an active snapshot to the guest as a
+// virtio-blk device. Mounts never mounts that image on the host for
+// non-extract snapshots; see WritableDevice for the operations that still
+// read it.
+func WithBlockDeviceHandoff() Opt {
+	return func(config *SnapshotterConfig) {
+		config.blockDeviceHandoff = true
+	}
+}
+
+// WithMinFreeLoopDevices makes NewSnapshotter fail with a
+// preflight.LoopDeviceHeadroomError when fewer than n loop devices are
+// free. Without it, low headroom is only logged.
+func WithMinFreeLoopDevices(n int) Opt {
+	return func(config *SnapshotterConfig) {
+		config.minFreeLoopDevices = n
+	}
+}
+
+// WithFsMetaConcurrency limits the number of fsmeta merges running
+// mkfs.erofs at once to n; further merges queue until a slot frees up or
+// their context is done. Zero means no limit.
+func WithFsMetaConcurrency(n int) Opt {
+	return func(config *SnapshotterConfig) {
+		config.fsMetaConcurrency = n
+	}
+}
+
+// WithCleanupConcurrency lets Cleanup remove up to n orphaned snapshot
+// directories in parallel, which speeds up stores with thousands of
+// orphans. Zero or one removes them one at a time.
+func WithCleanupConcurrency(n int) Opt {
+	return func(config *SnapshotterConfig) {
+		config.cleanupConcurrency = n
+	}
+}
+
+// WithExt4MountOptions adds opts, such as "commit=60", to the mount options
+// of the ext4 writable layer, both in the mounts returned to VM runtimes and
+// when the layer is mounted on the host for extraction. Options conflicting
+// with rw and loop are rejected, and options that can lose data on a host
+// crash also require WithUnsafeExt4MountOptions.
+func WithExt4MountOptions(opts []string) Opt {
+	return func(config *SnapshotterConfig) {
+		config.ext4MountOptions = slices.Clone(opts)
+	}
+}
+
+// WithUnsafeExt4MountOptions acknowledges that options passed to
+// WithExt4MountOptions, such as "nobarrier" or "data=writeback", may
+// corrupt writable layers on a host crash. Use for ephemeral scratch only.
+func WithUnsafeExt4MountOptions() Opt {
+	return func(config *SnapshotterConfig) {
+		config.unsafeExt4MountOptions = true
+	}
+}
+
+// WithCommitHook sets a hook that Commit calls after the committed snapshot
+// is recorded, with the layer's name, ID, blob path, digest and usage. Hook
+// errors are logged and do not fail the commit unless
+// WithCommitHookErrorsFatal is also set.
+func WithCommitHook(hook CommitHook) Opt {
+	return func(config *SnapshotterConfig) {
+		config.commitHook = hook
+	}
+}
+
+// WithCommitHookErrorsFatal makes Commit fail when the hook set by
+// WithCommitHook returns an error. The committed snapshot is removed again,
+// so the layer must be unpacked anew.
+func WithCommitHookErrorsFatal() Opt {
+	return func(config *SnapshotterConfig) {
+		config.commitHookFatal = true
+	}
+}
+
+// WithConverter builds EROFS layer blobs with c instead of mkfs.erofs, for
+// commits and Recompress. If c also implements FsMetaMerger it generates
+// fsmeta too, and mkfs.erofs is no longer required at startup.
+func WithConverter(c Converter) Opt {
+	return func(config *SnapshotterConfig) {
+		config.converter = c
+	}
+}
+
+// WithErofsTailPacking packs the tail of each file into its inode in the
+// EROFS blobs the snapshotter commits instead of giving it a block of its
+// own, which shrinks layers with many small files. Such layers are labeled with LabelTailPacking. Requires a
+// mkfs.erofs with ztailpacking support. Merging them into fsmeta may not be
+// possible, in which case they are mounted individually.
+func WithErofsTailPacking() Opt {
+	return func(config *SnapshotterConfig) {
+		config.erofsTailPacking = true
+	}
+}
+
+// WithPreserveFailedUpper copies the upper directory of a snapshot whose
+// EROFS conversion fails during Commit into dir for debugging, and records
+// the copy's path and the error in LabelConversionError. dir must be
+// absolute and outside the snapshotter's snapshots directory.
+func WithPreserveFailedUpper(dir string) Opt {
+	return func(config *SnapshotterConfig) {
+		config.failedUpperDir = dir
+	}
+}
+
+// needsMkfsErofs reports whether mkfs.erofs builds any images: unless
+// WithConverter sets a converter that is also an FsMetaMerger.
+func (config *SnapshotterConfig) needsMkfsErofs() bool {
+	_, ok := config.converter.(FsMetaMerger)
+	return !ok
+}
+
+// requiredFeatures returns the kernel features needed by the enabled options.
+func (config *SnapshotterConfig) requiredFeatures() []string {
+	features := []string{preflight.FeatureErofs}
+	if config.autoTrimInterval > 0 {
+		features = append(features, preflight.FeatureLoopDiscard)
+	}
+	if config.fsverityMeasurement {
+		// Digests of blobs with fs-verity enabled are read from the kernel.
+		features = append(features, preflight.FeatureFsverity)
+	}
+	return features
+}
+
+// validate checks config for a snapshotter rooted at root, resolving
+// WithDefaultSizeString and creating the directories options point to.
+func (config *SnapshotterConfig) validate(root string) error {
+	if config.defaultSizeString != "" {
+		size, err := parseWritableSize(config.defaultSizeString)
+		if err != nil {
+			return fmt.Errorf("default_writable_size: %w", err)
+		}
+		config.defaultSize = size
+	} else if err := validateWritableSize(config.defaultSize); err != nil {
+		return fmt.Errorf("default_writable_size: %w", err)
+	}
+	if err := config.validateValues(); err != nil {
+		return err
+	}
+	if err := config.validatePaths(root); err != nil {
+		return err
+	}
+
+	if config.setImmutable && runtime.GOOS != "linux" {
+		return fmt.Errorf("setting IMMUTABLE_FL is only supported on Linux")
+	}
+	if config.autoTrimInterval > 0 && runtime.GOOS != "linux" {
+		return fmt.Errorf("auto-trim is only supported on Linux")
+	}
+	return nil
+}
+
+// validateValues checks the options that do not name a path.
+func (config *SnapshotterConfig) validateValues() error {
+	if config.retainRemoved < 0 {
+		return fmt.Errorf("retain removed must be >= 0, got %d", config.retainRemoved)
+	}
+	for ns, limit := range config.namespaceQuota {
+		if limit <= 0 {
+			return fmt.Errorf("quota for namespace %q must be > 0, got %d", ns, limit)
+		}
+	}
+	if err := validateExt4MountOptions(config.ext4MountOptions, config.unsafeExt4MountOptions); err != nil {
+		return err
+	}
+	if config.blobNamer != nil {
+		if err := validateBlobNamer(config.blobNamer); err != nil {
+			return err
+		}
+	}
+	for name := range config.upperXattrs {
+		if err := validateXattrName(name); err != nil {
+			return err
+		}
+	}
+	if err := validateSettings(config); err != nil {
+		return err
+	}
+	if config.fsMetaConcurrency < 0 {
+		return fmt.Errorf("fsmeta concurrency must be >= 0, got %d", config.fsMetaConcurrency)
+	}
+	if config.minFreeLoopDevices < 0 {
+		return fmt.Errorf("minimum free loop devices must be >= 0, got %d", config.minFreeLoopDevices)
+	}
+	return nil
+}
+
+// validatePaths checks the paths set by options and creates the
+// directories they name.
+func (config *SnapshotterConfig) validatePaths(root string) error {
+	if config.writableTemplate != "" {
+		if err := validateWritableTemplate(config.writableTemplate, config.defaultSize); err != nil {
+			return err
+		}
+	}
+	if config.failedUpperDir != "" {
+		if err := validateFailedUpperDir(root, config.failedUpperDir); err != nil {
+			return err
+		}
+	}
+	if config.contentStore != "" {
+		if !filepath.IsAbs(config.contentStore) {
+			return fmt.Errorf("content store %q must be absolute", config.contentStore)
+		}
+		// Relocate moves everything under the root, which would break the
+		// links snapshot directories hold into the store.
+		if isWithin(filepath.Clean(config.contentStore), root) {
+			return fmt.Errorf("content store %q must be outside the root %q", config.contentStore, root)
+		}
+		if err := os.MkdirAll(config.contentStore, 0o700); err != nil {
+			return fmt.Errorf("create content store: %w", err)
+		}
+	}
+	if config.scratchDir != "" {
+		if !filepath.IsAbs(config.scratchDir) {
+			return fmt.Errorf("scratch directory %q must be absolute", config.scratchDir)
+		}
+		if err := os.MkdirAll(config.scratchDir, 0o700); err != nil {
+			return fmt.Errorf("create scratch directory: %w", err)
+		}
+	}
+	if config.metadataPath != "" {
+		return validateMetadataPath(config.metadataPath)
+	}
+	return nil
+}
+
+// mkfsOptions returns the mkfs.erofs options for the layers the
+// snapshotter builds.
+func (config *SnapshotterConfig) mkfsOptions() ([]string, error) {
+	var mkfsOpts []string
+	if config.erofsBlockSize != 0 {
+		opt, err := erofs.BlockSizeOpt(config.erofsBlockSize)
+		if err != nil {
+			return nil, err
+		}
+		// The kernel cannot mount images with blocks larger than its page
+		// size. The guest kernel may differ from the host's, so only warn.
+		if pageSize := os.Getpagesize(); config.erofsBlockSize > pageSize {
+			log.L.WithFields(log.Fields{
+				"blockSize": config.erofsBlockSize,
+				"pageSize":  pageSize,
+			}).Warn("erofs block size exceeds the page size; layers may fail to mount")
+		}
+		mkfsOpts = []string{opt}
+	}
+	if config.erofsTailPacking {
+		if config.converter == nil {
+			ok, err := erofs.SupportsTailPacking()
+			if err != nil {
+				return nil, fmt.Errorf("check mkfs.erofs tail packing support: %w", err)
+			}
+			if !ok {
+				return nil, errors.New("mkfs.erofs does not support tail packing (ztailpacking), upgrade erofs-utils")
+			}
+		}
+		mkfsOpts = append(mkfsOpts, erofs.TailPackingOpt)
+	}
+	return mkfsOpts, nil
+}
+
 type snapshotter struct {
-	root            string
-	ms              *storage.MetaStore
-	setImmutable    bool
+	// root holds metadata.db and the snapshots directory; read it through
+	// rootDir.
+	root string
+	// ms is the snapshot metadata store.
+	ms *metaStore
+	// setImmutable sets IMMUTABLE_FL on committed layer blobs.
+	setImmutable bool
+	// defaultWritable is the size of new writable layers.
 	defaultWritable int64
+	// writableTemplate is the WithWritableTemplate image, or "".
+	writableTemplate string
+	// defaultNamespace is used for requests without a namespace.
+	defaultNamespace string
+	// failedUpperDir is the WithPreserveFailedUpper directory, or "".
+	failedUpperDir string
+	// scratchDir is the WithScratchDir directory, or "".
+	scratchDir string
+	// retainRemoved is the number of removed snapshots kept in the graveyard.
+	retainRemoved int
+	// usageCache caches active snapshot usage; nil when disabled.
+	usageCache *usageCache
+	// events streams snapshot lifecycle events.
+	events *eventStream
+	// mountRetry is the backoff for transient mount failures.
+	mountRetry retryPolicy
+	// eagerExt4Init disables lazy ext4 initialization of writable layers.
+	eagerExt4Init bool
+	// namespaceQuota limits the disk usage of each namespace.
+	namespaceQuota map[string]int64
+	// verifiedDigests caches blobs verified on mount; nil when disabled.
+	verifiedDigests *digestCache
+	// markerFailures counts markers not created for lack of space or quota.
+	markerFailures atomic.Uint64
+	// requiredFeatures are the kernel features checked by the health check.
+	requiredFeatures []string
+	// erofsBlockSize is the WithErofsBlockSize block size (0 = default).
+	erofsBlockSize int
+	// mkfsTimeout bounds each mkfs invocation (0 = no limit).
+	mkfsTimeout time.Duration
+	// erofsTailPacking enables LabelTailPacking on layers built here.
+	erofsTailPacking bool
+	// commitHook runs after each Commit; commitHookFatal makes its errors
+	// fail the Commit.
+	commitHook      CommitHook
+	commitHookFatal bool
+	// timeouts bounds Prepare, View, Commit and Mounts.
+	timeouts TimeoutConfig
+	// blobNamer names layer blobs; see blobName.
+	blobNamer BlobNamer
+	// fsverityMeasurement enables LabelFsverityMeasurement.
+	fsverityMeasurement bool
+	// blockModeFallback enables LabelBlockFallback extract snapshots.
+	blockModeFallback bool
+	// contentStore is the WithContentStore directory, or "".
+	contentStore string
+	// contentStoreMu serializes adding blobs to the content store, from
+	// linking until the commit is recorded, with pruning it.
+	contentStoreMu sync.Mutex
+	// blockDeviceHandoff enables WritableDevice.
+	blockDeviceHandoff bool
+	// upperXattrs are the WithUpperXattrs defaults; see upperXattrsFor.
+	upperXattrs map[string]string
+	// xattrUnsupported records xattr namespaces already warned about.
+	xattrUnsupported sync.Map
+	// cleanupConcurrency is the number of Cleanup removal workers.
+	cleanupConcurrency int
+	// converter replaces mkfs.erofs when set; see erofsConverter.
+	converter Converter
+	// mounter replaces host mounts in tests; see hostMounts.
+	mounter mounter
+	// settingsMu guards the fields Reconfigure changes; read them through
+	// settings.
+	settingsMu sync.RWMutex
+	// ext4MountOpts are appended to the rw,loop options of writable layers.
+	ext4MountOpts []string
+	// fsMetaSem holds a token per running fsmeta merge; nil means no limit.
+	fsMetaSem chan struct{}
+	// mkfsOpts are extra mkfs.erofs options for the layers built here.
+	mkfsOpts []string
+
+	// fsMetaWg tracks background fsmeta generation for clean shutdown.
+	fsMetaWg sync.WaitGroup
 
-	// bgWg tracks background operations (fsmeta generation) for clean shutdown.
-	bgWg sync.WaitGroup
+	// autoTrimInterval is the WithAutoTrim interval (0 = disabled).
+	autoTrimInterval time.Duration
+	// trimMu guards the auto-trim loop state below.
+	trimMu sync.Mutex
+	// trimStop stops the running auto-trim loop, which closes trimDone
+	// when it returns; both are nil when no loop runs.
+	trimStop, trimDone chan struct{}
+	// trimClosed is set by Close so the loop is not restarted.
+	trimClosed bool
+
+	// rootMu guards root, failedUpperDir and scratchDir, which Relocate
+	// changes; read them through rootDir, failedUpperDirPath and
+	// scratchDirPath.
+	rootMu sync.RWMutex
+
+	// graveyardMu serializes moves into and pruning of the graveyard.
+	graveyardMu sync.Mutex
 }
 
 // isMounted checks if a path is currently mounted.
@@ -89,10 +770,13 @@ func isMounted(target string) bool {
 const extractLabel = "containerd.io/snapshot/erofs.extract"
 
 // NewSnapshotter returns a Snapshotter which uses EROFS+OverlayFS. The layers
-// are stored under the provided root. A metadata file is stored under the root.
+// are stored under the provided root. A metadata file is stored under the root
+// unless WithMetadataPath is set.
 func NewSnapshotter(root string, opts ...Opt) (snapshots.Snapshotter, error) {
 	config := SnapshotterConfig{
-		defaultSize: defaultWritableSize,
+		defaultSize:    defaultWritableSize,
+		mountRetries:   defaultMountRetries,
+		mountRetryBase: defaultMountRetryBase,
 	}
 	for _, opt := range opts {
 		opt(&config)
@@ -101,20 +785,24 @@ func NewSnapshotter(root string, opts ...Opt) (snapshots.Snapshotter, error) {
 	if err := os.MkdirAll(root, 0o700); err != nil {
 		return nil, fmt.Errorf("create root directory %q: %w", root, err)
 	}
-
-	if config.defaultSize <= 0 {
-		return nil, fmt.Errorf("default_writable_size must be > 0, got %d", config.defaultSize)
+	if err := config.validate(root); err != nil {
+		return nil, err
+	}
+	mkfsOpts, err := config.mkfsOptions()
+	if err != nil {
+		return nil, err
 	}
 
-	if err := checkCompatibility(root); err != nil {
-		return nil, fmt.Errorf("compatibility check for %q: %w", root, err)
+	metadataPath := filepath.Join(root, "metadata.db")
+	if config.metadataPath != "" {
+		metadataPath = config.metadataPath
 	}
 
-	if config.setImmutable && runtime.GOOS != "linux" {
-		return nil, fmt.Errorf("setting IMMUTABLE_FL is only supported on Linux")
+	if err := checkCompatibility(root, config); err != nil {
+		return nil, fmt.Errorf("compatibility check for %q: %w", root, err)
 	}
 
-	ms, err := storage.NewMetaStore(filepath.Join(root, "metadata.db"))
+	ms, err := newMetaStore(metadataPath)
 	if err != nil {
 		return nil, fmt.Errorf("create metadata store: %w", err)
 	}
@@ -124,23 +812,75 @@ func NewSnapshotter(root string, opts ...Opt) (snapshots.Snapshotter, error) {
 	}
 
 	s := &snapshotter{
-		root:            root,
-		ms:              ms,
-		setImmutable:    config.setImmutable,
-		defaultWritable: config.defaultSize,
+		root:                root,
+		ms:                  ms,
+		setImmutable:        config.setImmutable,
+		defaultWritable:     config.defaultSize,
+		writableTemplate:    config.writableTemplate,
+		defaultNamespace:    config.defaultNamespace,
+		failedUpperDir:      config.failedUpperDir,
+		scratchDir:          config.scratchDir,
+		erofsBlockSize:      config.erofsBlockSize,
+		mkfsTimeout:         config.mkfsTimeout,
+		erofsTailPacking:    config.erofsTailPacking,
+		ext4MountOpts:       config.ext4MountOptions,
+		commitHook:          config.commitHook,
+		commitHookFatal:     config.commitHookFatal,
+		converter:           config.converter,
+		mkfsOpts:            mkfsOpts,
+		retainRemoved:       config.retainRemoved,
+		mountRetry:          retryPolicy{retries: config.mountRetries, base: config.mountRetryBase},
+		requiredFeatures:    config.requiredFeatures(),
+		eagerExt4Init:       config.eagerExt4Init,
+		namespaceQuota:      config.namespaceQuota,
+		events:              newEventStream(defaultEventBuffer),
+		autoTrimInterval:    config.autoTrimInterval,
+		cleanupConcurrency:  config.cleanupConcurrency,
+		timeouts:            config.timeouts,
+		upperXattrs:         config.upperXattrs,
+		blockDeviceHandoff:  config.blockDeviceHandoff,
+		blobNamer:           config.blobNamer,
+		fsverityMeasurement: config.fsverityMeasurement,
+		blockModeFallback:   config.blockModeFallback,
+		contentStore:        config.contentStore,
+	}
+	if config.usageCacheTTL > 0 {
+		s.usageCache = newUsageCache(config.usageCacheTTL)
+	}
+	if config.verifyDigestOnMount {
+		s.verifiedDigests = newDigestCache()
+	}
+	if config.fsMetaConcurrency > 0 {
+		s.fsMetaSem = make(chan struct{}, config.fsMetaConcurrency)
 	}
 
 	// Clean up any orphaned mounts from previous runs.
 	s.cleanupOrphanedMounts() //nolint:contextcheck // startup cleanup uses background context
 
+	if config.startupConsistencyCheck {
+		s.startupConsistencyCheck(context.Background())
+	}
+
+	if s.contentStore != "" {
+		s.migrateToContentStore(context.Background())
+	}
+
+	s.startAutoTrim()
+
 	return s, nil
 }
 
 // Close releases all resources held by the snapshotter.
-// It waits for any background operations (fsmeta generation) to complete.
+// It stops periodic background jobs and waits for any background operations
+// (fsmeta generation, trimming) to complete.
 func (s *snapshotter) Close() error {
-	s.bgWg.Wait() // Wait for background operations to complete
+	s.trimMu.Lock()
+	s.trimClosed = true
+	s.trimMu.Unlock()
+	s.stopAutoTrim()
+	s.fsMetaWg.Wait() // Wait for background operations to complete
 	s.cleanupBlockMounts()
+	s.events.close()
 	return s.ms.Close()
 }
 
@@ -164,7 +904,7 @@ func (s *snapshotter) cleanupBlockMounts() {
 }
 
 // prepareDirectory creates a temporary snapshot directory with proper structure.
-func (s *snapshotter) prepareDirectory(snapshotDir string, kind snapshots.Kind) (string, error) {
+func (s *snapshotter) prepareDirectory(ctx context.Context, snapshotDir string, kind snapshots.Kind) (string, error) {
 	td, err := os.MkdirTemp(snapshotDir, "new-")
 	if err != nil {
 		return "", fmt.Errorf("create temp dir: %w", err)
@@ -174,7 +914,7 @@ func (s *snapshotter) prepareDirectory(snapshotDir string, kind snapshots.Kind)
 		return td, err
 	}
 	if kind == snapshots.KindActive {
-		if err := ensureMarkerFile(filepath.Join(td, erofs.ErofsLayerMarker)); err != nil {
+		if err := s.ensureMarker(ctx, filepath.Join(td, erofs.ErofsLayerMarker)); err != nil {
 			return td, err
 		}
 	}
@@ -182,11 +922,136 @@ func (s *snapshotter) prepareDirectory(snapshotDir string, kind snapshots.Kind)
 	return td, nil
 }
 
+// validateMetadataPath checks that path is absolute and that its directory
+// exists, creating it if needed, and is writable.
+func validateMetadataPath(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("metadata path %q must be absolute", path)
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create metadata directory: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".metadata-probe-")
+	if err != nil {
+		return fmt.Errorf("metadata directory %q is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// withMkfsTimeout runs fn, which invokes the mkfs command writing output,
+// within the limit set by WithMkfsTimeout. exec.CommandContext kills the
+// command when the limit expires or ctx is done; its partial output is then
+// removed. Expiry of the limit itself is reported as a TimeoutError.
+func (s *snapshotter) withMkfsTimeout(ctx context.Context, command, output string, fn func(context.Context) error) error {
+	limit := s.settings().mkfsTimeout
+	tctx, cancel := ctx, context.CancelFunc(func() {})
+	if limit > 0 {
+		tctx, cancel = context.WithTimeout(ctx, limit)
+	}
+	defer cancel()
+	err := fn(tctx)
+	if err == nil || tctx.Err() == nil {
+		return err
+	}
+	if rerr := os.Remove(output); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+		log.G(ctx).WithError(rerr).WithField("path", output).Warn("failed to remove partial mkfs output")
+	}
+	if ctx.Err() == nil && errors.Is(tctx.Err(), context.DeadlineExceeded) {
+		return &TimeoutError{Command: command, Output: output, Timeout: limit}
+	}
+	return err
+}
+
+// withTimeout runs fn for operation op on key within limit d (see
+// WithTimeouts). When the limit, rather than ctx, expires, the error fn
+// returns is wrapped in an OperationTimeoutError. Zero d runs fn with ctx.
+func withTimeout(ctx context.Context, op, key string, d time.Duration, fn func(context.Context) error) error {
+	if d <= 0 {
+		return fn(ctx)
+	}
+	tctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	err := fn(tctx)
+	if err != nil && ctx.Err() == nil && errors.Is(tctx.Err(), context.DeadlineExceeded) {
+		return &OperationTimeoutError{Operation: op, Key: key, Timeout: d, Err: err}
+	}
+	return err
+}
+
+// minWritableSize is the smallest ext4 writable layer accepted. mkfs.ext4
+// rejects images of about 100 KiB or less, and anything much larger than
+// that still leaves almost no room for data once metadata is allocated.
+const minWritableSize = 1 << 20 // 1 MiB
+
+// validateWritableSize checks that size can hold an ext4 writable layer:
+// at least minWritableSize, in whole 1 KiB ext4 blocks.
+func validateWritableSize(size int64) error {
+	if size < minWritableSize {
+		return fmt.Errorf("writable layer size must be at least %s to hold an ext4 filesystem, got %d bytes",
+			units.BytesSize(minWritableSize), size)
+	}
+	if size%1024 != 0 {
+		return fmt.Errorf("writable layer size must be a multiple of 1024, got %d bytes", size)
+	}
+	return nil
+}
+
+// parseWritableSize parses a human-readable writable layer size such as
+// "512MiB" and validates it with validateWritableSize.
+func parseWritableSize(s string) (int64, error) {
+	size, err := units.RAMInBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("parse size %q: %w", s, err)
+	}
+	if err := validateWritableSize(size); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// ext4ExtendedOptions returns the mkfs.ext4 -E options for writable layers.
+// Lazy initialization is used unless eager is set (see WithEagerExt4Init).
+func ext4ExtendedOptions(eager bool) string {
+	if eager {
+		return "nodiscard,lazy_itable_init=0,lazy_journal_init=0"
+	}
+	return "nodiscard,lazy_itable_init=1,lazy_journal_init=1"
+}
+
 // createWritableLayer creates and formats an ext4 filesystem image file.
+// When a writable template is configured, the template is copied instead.
 func (s *snapshotter) createWritableLayer(ctx context.Context, id string) error {
 	path := s.writablePath(id)
-	size := s.defaultWritable
 
+	if s.writableTemplate != "" {
+		if err := copyWritableTemplate(s.writableTemplate, path); err != nil {
+			return err
+		}
+		// Copies share the template's filesystem UUID; give each its own
+		// so a guest with several layers attached can tell them apart.
+		var out []byte
+		if err := s.withMkfsTimeout(ctx, "tune2fs", path, func(ctx context.Context) error {
+			var err error
+			out, err = exec.CommandContext(ctx, "tune2fs", "-U", "random", path).CombinedOutput()
+			return err
+		}); err != nil {
+			os.Remove(path)
+			return fmt.Errorf("set writable layer UUID: %w: %s", err, stringutil.TruncateOutput(out, 256))
+		}
+		log.G(ctx).WithFields(log.Fields{
+			"path":     path,
+			"template": s.writableTemplate,
+		}).Debug("created writable layer from template")
+		return nil
+	}
+	return s.formatWritableLayer(ctx, path, s.defaultWritable)
+}
+
+// formatWritableLayer creates the sparse file path of size bytes and formats
+// it as ext4.
+func (s *snapshotter) formatWritableLayer(ctx context.Context, path string, size int64) error {
 	// Create sparse file
 	f, err := os.Create(path)
 	if err != nil {
@@ -201,9 +1066,14 @@ func (s *snapshotter) createWritableLayer(ctx context.Context, id string) error
 	f.Close()
 
 	// Format as ext4 directly on the file.
-	cmd := exec.CommandContext(ctx, "mkfs.ext4", "-q", "-F", "-L", "rwlayer",
-		"-E", "nodiscard,lazy_itable_init=1,lazy_journal_init=1", path)
-	if out, err := cmd.CombinedOutput(); err != nil {
+	var out []byte
+	if err := s.withMkfsTimeout(ctx, "mkfs.ext4", path, func(ctx context.Context) error {
+		cmd := exec.CommandContext(ctx, "mkfs.ext4", "-q", "-F", "-L", "rwlayer",
+			"-E", ext4ExtendedOptions(s.eagerExt4Init), path)
+		var err error
+		out, err = cmd.CombinedOutput()
+		return err
+	}); err != nil {
 		os.Remove(path)
 		return fmt.Errorf("format ext4: %w: %s", err, stringutil.TruncateOutput(out, 256))
 	}
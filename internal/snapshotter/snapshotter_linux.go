@@ -27,11 +27,14 @@ import (
 	"github.com/containerd/containerd/v2/core/mount"
 	"github.com/containerd/containerd/v2/core/snapshots"
 	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/containerd/v2/pkg/tracing"
 	"github.com/containerd/continuity/fs"
+	"github.com/containerd/errdefs"
 	"github.com/containerd/log"
+	"github.com/moby/sys/mountinfo"
 	"golang.org/x/sys/unix"
 
-	"github.com/spin-stack/erofs-snapshotter/internal/erofs"
+	"github.com/spin-stack/erofs-snapshotter/internal/loop"
 	"github.com/spin-stack/erofs-snapshotter/internal/preflight"
 )
 
@@ -40,37 +43,84 @@ import (
 // active snapshot's writable layer.
 const defaultWritableSize = 64 * 1024 * 1024 // 64 MiB
 
-func checkCompatibility(root string) error {
+func checkCompatibility(root string, config SnapshotterConfig) error {
+	// Some distros ship EROFS as a module that is not loaded until first use.
+	if config.autoModprobe && preflight.CheckErofsSupport() != nil {
+		log.L.Info("EROFS not available, attempting modprobe erofs")
+		if err := preflight.LoadErofsModule(); err != nil {
+			log.L.WithError(err).Warn("failed to load EROFS module")
+		} else {
+			log.L.Info("loaded EROFS module")
+		}
+	}
+
 	// Check kernel version and EROFS support via preflight
-	if err := preflight.Check(); err != nil {
+	check := preflight.Check
+	if !config.needsMkfsErofs() {
+		check = preflight.CheckKernel
+	}
+	if err := check(); err != nil {
+		return fmt.Errorf("preflight check failed: %w", err)
+	}
+
+	// Options may require newer kernels than baseline EROFS support.
+	if err := preflight.CheckFeatures(config.requiredFeatures()...); err != nil {
 		return fmt.Errorf("preflight check failed: %w", err)
 	}
 
-	supportsDType, err := fs.SupportsDType(root)
+	if config.minFreeLoopDevices > 0 {
+		if err := preflight.CheckLoopDevices(config.minFreeLoopDevices); err != nil {
+			return fmt.Errorf("preflight check failed: %w", err)
+		}
+	}
+
+	return checkDType(root)
+}
+
+// supportsDType is fs.SupportsDType, replaceable in tests.
+var supportsDType = fs.SupportsDType
+
+// checkDType returns an error if the filesystem backing root lacks d_type
+// support, which overlayfs needs to handle whiteouts correctly.
+//
+// Writable layers are ext4 images, but the differ's host overlay view of a
+// snapshot being diffed still has its mount points and snapshot directory
+// on root.
+func checkDType(root string) error {
+	ok, err := supportsDType(root)
 	if err != nil {
 		return err
 	}
-	if !supportsDType {
-		return fmt.Errorf("%s does not support d_type. If the backing filesystem is xfs, please reformat with ftype=1 to enable d_type support", root)
+	if !ok {
+		return fmt.Errorf("%s does not support d_type, which the host overlay mounts used by the differ need even though writable layers are ext4 images. "+
+			"If the backing filesystem is xfs, please reformat with ftype=1 to enable d_type support", root)
 	}
-
 	return nil
 }
 
+//nolint:revive,staticcheck	// silence "don't use ALL_CAPS in Go names; use CamelCase"
+const (
+	FS_IMMUTABLE_FL = 0x10
+)
+
+// setImmutable sets or clears FS_IMMUTABLE_FL on path. It returns an error
+// wrapping errdefs.ErrNotImplemented when the backing filesystem does not
+// support inode flags.
 func setImmutable(path string, enable bool) error {
-	//nolint:revive,staticcheck	// silence "don't use ALL_CAPS in Go names; use CamelCase"
-	const (
-		FS_IMMUTABLE_FL = 0x10
-	)
 	f, err := os.Open(path)
 	if err != nil {
 		return fmt.Errorf("failed to open: %w", err)
 	}
 	defer f.Close()
+	return setFileImmutable(f, enable)
+}
 
+// setFileImmutable is setImmutable for an open file, which also works after
+// the file's path has been unlinked.
+func setFileImmutable(f *os.File, enable bool) error {
 	oldattr, err := unix.IoctlGetInt(int(f.Fd()), unix.FS_IOC_GETFLAGS)
 	if err != nil {
-		return fmt.Errorf("error getting inode flags: %w", err)
+		return inodeFlagsError("error getting inode flags", err)
 	}
 	newattr := oldattr | FS_IMMUTABLE_FL
 	if !enable {
@@ -79,7 +129,65 @@ func setImmutable(path string, enable bool) error {
 	if newattr == oldattr {
 		return nil
 	}
-	return unix.IoctlSetPointerInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, newattr)
+	if err := unix.IoctlSetPointerInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, newattr); err != nil {
+		return inodeFlagsError("error setting inode flags", err)
+	}
+	return nil
+}
+
+// inodeFlagsError wraps an FS_IOC_GETFLAGS/SETFLAGS failure. Filesystems
+// without inode flag support fail with ENOTTY or EOPNOTSUPP; those errors
+// also wrap errdefs.ErrNotImplemented so callers can skip them.
+func inodeFlagsError(msg string, err error) error {
+	if errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EOPNOTSUPP) {
+		return fmt.Errorf("%s: %w: %w", msg, errdefs.ErrNotImplemented, err)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// isImmutable reports whether IMMUTABLE_FL is set on path.
+// Returns false if the flags cannot be read.
+func isImmutable(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	attr, err := unix.IoctlGetInt(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return false
+	}
+	return attr&FS_IMMUTABLE_FL != 0
+}
+
+// nextDataExtent returns the first extent of f at or after off that holds
+// data, using SEEK_DATA and SEEK_HOLE. start is size when only holes are
+// left. Filesystems without hole reporting return the rest of the file.
+func nextDataExtent(f *os.File, off, size int64) (start, end int64, err error) {
+	start, err = unix.Seek(int(f.Fd()), off, unix.SEEK_DATA)
+	if errors.Is(err, unix.ENXIO) {
+		return size, size, nil
+	}
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP) {
+		return off, size, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err = unix.Seek(int(f.Fd()), start, unix.SEEK_HOLE)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, min(end, size), nil
+}
+
+// linkCount returns the number of hard links to the file fi describes.
+func linkCount(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
 }
 
 // syncFile opens a file and calls fsync to ensure its data is flushed to disk.
@@ -111,7 +219,7 @@ func isNotMountError(err error) bool {
 // 2. Stale mounts for existing snapshots (mounts left behind from previous runs)
 // Errors are logged but not returned since this is best-effort cleanup.
 func (s *snapshotter) cleanupOrphanedMounts() {
-	snapshotsDir := filepath.Join(s.root, "snapshots")
+	snapshotsDir := filepath.Join(s.rootDir(), "snapshots")
 	entries, err := os.ReadDir(snapshotsDir)
 	if err != nil {
 		// If the directory doesn't exist, there's nothing to clean up
@@ -155,9 +263,8 @@ func (s *snapshotter) cleanupOrphanedMounts() {
 				log.L.WithError(err).WithField("path", rwDir).Debug("failed to unmount orphan rw")
 			}
 
-			// Clear immutable flag if present
-			layerBlob := filepath.Join(snapshotDir, "layer.erofs")
-			_ = setImmutable(layerBlob, false)
+			// Clear immutable flag on any EROFS blobs
+			clearImmutableFlags(context.Background(), snapshotDir)
 
 			// Remove the entire directory
 			if err := os.RemoveAll(snapshotDir); err != nil {
@@ -175,37 +282,51 @@ func (s *snapshotter) cleanupOrphanedMounts() {
 	}
 }
 
-// unmountAll attempts to unmount the target. If normal unmount fails (e.g., due
-// to EBUSY), it falls back to lazy unmount (MNT_DETACH) which detaches the mount
-// immediately but may leave the mount lingering until all references are closed.
+// unmountAll unmounts every mount stacked on target.
 //
-// Returns nil if the path was not mounted (EINVAL) or doesn't exist (ENOENT),
-// as these are expected during cleanup. Returns an error only for unexpected
-// failures like EBUSY that lazy unmount also can't resolve.
+// Returns nil without unmounting if target is not a mount point or doesn't
+// exist, as is normal during cleanup of snapshots that were never mounted.
+// If the unmount fails with EBUSY, it falls back to a lazy unmount
+// (MNT_DETACH), which detaches the mount immediately but may leave it
+// lingering until all references are closed, and logs a warning. Any other
+// failure, or a failed lazy unmount, is returned.
 func unmountAll(target string) error {
-	if err := mount.UnmountAll(target, 0); err != nil {
-		// If the target wasn't a mount point, that's fine - nothing to unmount
+	mounted, err := mountinfo.Mounted(target)
+	if err != nil {
 		if isNotMountError(err) {
 			return nil
 		}
-		// Normal unmount failed, try lazy unmount as fallback.
-		// This detaches the mount immediately but resources may linger.
-		if derr := mount.UnmountAll(target, unix.MNT_DETACH); derr != nil {
-			// If lazy unmount also says "not mounted", that's fine
-			if isNotMountError(derr) {
-				return nil
-			}
-			// Both normal and lazy unmount failed - wrap the original error
-			return fmt.Errorf("unmount %s failed (lazy unmount also failed): %w", target, err)
-		}
-		// Lazy unmount succeeded - mount is detached but may linger.
+		return fmt.Errorf("check mount %s: %w", target, err)
+	}
+	if !mounted {
 		return nil
 	}
+
+	err = mount.UnmountAll(target, 0)
+	if err == nil || isNotMountError(err) {
+		// Raced with another unmount; nothing left to do.
+		return nil
+	}
+	if !errors.Is(err, unix.EBUSY) {
+		return fmt.Errorf("unmount %s: %w", target, err)
+	}
+	if derr := mount.UnmountAll(target, unix.MNT_DETACH); derr != nil && !isNotMountError(derr) {
+		return fmt.Errorf("unmount %s failed (lazy unmount also failed: %v): %w", target, derr, err)
+	}
+	log.L.WithField("path", target).Warn("mount busy, detached lazily")
 	return nil
 }
 
-func convertDirToErofs(ctx context.Context, layerBlob, upperDir string) error {
-	err := erofs.ConvertErofs(ctx, layerBlob, upperDir, nil)
+// convertDirToErofs builds layerBlob from upperDir with the configured
+// mkfs.erofs options, compressing it with compression if set.
+func (s *snapshotter) convertDirToErofs(ctx context.Context, layerBlob, upperDir, compression string) error {
+	opts := s.mkfsOpts
+	if compression != "" {
+		opts = withCompressionOpt(opts, compression)
+	}
+	err := s.withMkfsTimeout(ctx, "mkfs.erofs", layerBlob, func(ctx context.Context) error {
+		return s.erofsConverter().Convert(ctx, layerBlob, upperDir, opts)
+	})
 	if err != nil {
 		return err
 	}
@@ -255,10 +376,64 @@ func upperDirectoryPermission(p, parent string) error {
 	return nil
 }
 
+// writableLayerInUse reports whether the writable layer of snapshot id is
+// mounted on the host or attached to a loop device.
+func (s *snapshotter) writableLayerInUse(id string) (bool, error) {
+	mounted, err := mountinfo.Mounted(s.blockRwMountPath(id))
+	if err != nil && !isNotMountError(err) {
+		return false, fmt.Errorf("check mount %s: %w", s.blockRwMountPath(id), err)
+	}
+	if mounted {
+		return true, nil
+	}
+	return layerBlobInUse(s.writablePath(id))
+}
+
+// rootInUse fails with errdefs.ErrFailedPrecondition if anything under root
+// is mounted or backs a loop device.
+func rootInUse(root string) error {
+	mounts, err := mountinfo.GetMounts(mountinfo.PrefixFilter(root))
+	if err != nil {
+		return fmt.Errorf("read mountinfo: %w", err)
+	}
+	if len(mounts) > 0 {
+		return fmt.Errorf("%s is mounted: %w", mounts[0].Mountpoint, errdefs.ErrFailedPrecondition)
+	}
+	devices, err := loop.FindByBackingDir(root)
+	if err != nil {
+		return err
+	}
+	if len(devices) > 0 {
+		return fmt.Errorf("loop device %s is backed by a file in %s: %w", devices[0].Path, root, errdefs.ErrFailedPrecondition)
+	}
+	return nil
+}
+
+// lsetxattr sets the extended attribute name on path without following
+// symlinks.
+func lsetxattr(path, name string, value []byte) error {
+	if err := unix.Lsetxattr(path, name, value, 0); err != nil {
+		return &os.PathError{Op: "lsetxattr", Path: path, Err: err}
+	}
+	return nil
+}
+
+// loopControlPath is the loop device control node; tests point it elsewhere.
+var loopControlPath = "/dev/loop-control"
+
+// loopDevicesAvailable reports whether loop devices can be set up on the host.
+func loopDevicesAvailable() bool {
+	_, err := os.Stat(loopControlPath)
+	return err == nil
+}
+
 // mountBlockRwLayer mounts the ext4 writable layer for extract snapshots.
 // This allows the differ to write content to the mounted filesystem.
 // The mount is cleaned up during Commit() after converting to EROFS.
-func (s *snapshotter) mountBlockRwLayer(ctx context.Context, id string) error {
+func (s *snapshotter) mountBlockRwLayer(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "mountBlockRwLayer", tracing.WithAttribute(attrSnapshotID, id))
+	defer func() { endSpan(span, err) }()
+
 	rwLayerPath := s.writablePath(id)
 	rwMountPath := s.blockRwMountPath(id)
 
@@ -268,13 +443,11 @@ func (s *snapshotter) mountBlockRwLayer(ctx context.Context, id string) error {
 	}
 
 	// Mount the ext4 file
-	m := mount.Mount{
-		Source:  rwLayerPath,
-		Type:    "ext4",
-		Options: []string{"rw", "loop"},
-	}
-	if err := m.Mount(rwMountPath); err != nil {
-		return fmt.Errorf("failed to mount ext4 layer: %w", err)
+	m := s.writableMount(id)
+	if err := s.settings().mountRetry.do(ctx, "mount ext4 layer", func() error {
+		return s.hostMounts().Mount(m, rwMountPath)
+	}); err != nil {
+		return newBlockMountError(id, rwLayerPath, rwMountPath, err)
 	}
 
 	// Create upper and work directories inside the mounted ext4
@@ -283,11 +456,11 @@ func (s *snapshotter) mountBlockRwLayer(ctx context.Context, id string) error {
 
 	if err := os.MkdirAll(upperDir, 0o755); err != nil {
 		// Cleanup mount on failure
-		_ = unmountAll(rwMountPath)
+		_ = s.hostMounts().Unmount(rwMountPath)
 		return fmt.Errorf("failed to create upper directory: %w", err)
 	}
 	if err := os.MkdirAll(workDir, 0o755); err != nil {
-		_ = unmountAll(rwMountPath)
+		_ = s.hostMounts().Unmount(rwMountPath)
 		return fmt.Errorf("failed to create work directory: %w", err)
 	}
 
@@ -298,3 +471,59 @@ func (s *snapshotter) mountBlockRwLayer(ctx context.Context, id string) error {
 
 	return nil
 }
+
+// layerBlobInUse reports whether a loop device is attached to the blob,
+// which means it is mounted on the host.
+func layerBlobInUse(path string) (bool, error) {
+	dev, err := loop.FindByBackingFile(path)
+	if err != nil {
+		return false, err
+	}
+	return dev != nil, nil
+}
+
+// checkCommitSourceIdle fails with errdefs.ErrFailedPrecondition when the
+// upper directory of snapshot id may still be written to: a filesystem is
+// mounted beneath upperDir, or the writable layer image is mounted somewhere
+// other than the snapshot's own rw mount (for example a leftover overlay
+// from a previous extract). Converting such a directory would race with
+// those writers.
+func (s *snapshotter) checkCommitSourceIdle(id, upperDir string) error {
+	nested, err := mountinfo.GetMounts(mountinfo.PrefixFilter(upperDir))
+	if err != nil {
+		return fmt.Errorf("read mountinfo: %w", err)
+	}
+	for _, m := range nested {
+		if m.Mountpoint != upperDir {
+			return fmt.Errorf("snapshot %s: %s is still mounted inside %s: %w",
+				id, m.Mountpoint, upperDir, errdefs.ErrFailedPrecondition)
+		}
+	}
+
+	dev, err := loop.FindByBackingFile(s.writablePath(id))
+	if err != nil || dev == nil {
+		// No loop device (or no sysfs to look it up): the image is not
+		// mounted anywhere.
+		return nil //nolint:nilerr // best-effort lookup
+	}
+	rwMount := s.blockRwMountPath(id)
+	mounts, err := mountinfo.GetMounts(func(m *mountinfo.Info) (bool, bool) {
+		return m.Source != dev.Path, false
+	})
+	if err != nil {
+		return fmt.Errorf("read mountinfo: %w", err)
+	}
+	for _, m := range mounts {
+		if m.Mountpoint != rwMount {
+			return fmt.Errorf("snapshot %s: writable layer is still mounted at %s: %w",
+				id, m.Mountpoint, errdefs.ErrFailedPrecondition)
+		}
+	}
+	return nil
+}
+
+// cloneFile reflinks src into dst using FICLONE. This only succeeds on
+// filesystems that support shared extents (e.g., XFS with reflink, Btrfs).
+func cloneFile(dst, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
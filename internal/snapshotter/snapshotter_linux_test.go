@@ -29,6 +29,7 @@ package snapshotter
 // Core tests in this file:
 // - TestErofs (testsuite) - SKIPPED (VM-only)
 // - TestErofsWithQuota - SKIPPED (VM-only)
+// - TestCheckDType
 //
 // Helper functions shared with erofs_differ_linux_test.go and
 // erofs_snapshot_linux_test.go:
@@ -247,6 +248,24 @@ func TestErofsWithQuota(t *testing.T) {
 	testsuite.SnapshotterSuite(t, "erofs", newSnapshotter(t, WithDefaultSize(16*1024*1024)))
 }
 
+func TestCheckDType(t *testing.T) {
+	orig := supportsDType
+	t.Cleanup(func() { supportsDType = orig })
+
+	supportsDType = func(string) (bool, error) { return true, nil }
+	if err := checkDType(t.TempDir()); err != nil {
+		t.Fatalf("checkDType: %v", err)
+	}
+
+	// An xfs root formatted without ftype=1 fails even though writable
+	// layers are ext4 images.
+	supportsDType = func(string) (bool, error) { return false, nil }
+	err := checkDType(t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "ftype=1") || !strings.Contains(err.Error(), "ext4 images") {
+		t.Fatalf("expected block mode d_type error, got %v", err)
+	}
+}
+
 // createTestTarContent creates test tar content using tartest.
 func createTestTarContent() io.ReadCloser {
 	// Create a tar context with current time for consistency
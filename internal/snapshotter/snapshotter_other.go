@@ -20,6 +20,7 @@ package snapshotter
 
 import (
 	"context"
+	"os"
 
 	"github.com/containerd/errdefs"
 )
@@ -29,7 +30,11 @@ import (
 // active snapshot's writable layer.
 const defaultWritableSize = 64 * 1024 * 1024 // 64 MiB
 
-func checkCompatibility(root string) error {
+func checkCompatibility(root string, config SnapshotterConfig) error {
+	return nil
+}
+
+func checkDType(root string) error {
 	return nil
 }
 
@@ -37,6 +42,22 @@ func setImmutable(path string, enable bool) error {
 	return errdefs.ErrNotImplemented
 }
 
+func setFileImmutable(f *os.File, enable bool) error {
+	return errdefs.ErrNotImplemented
+}
+
+func isImmutable(path string) bool {
+	return false
+}
+
+func nextDataExtent(f *os.File, off, size int64) (start, end int64, err error) {
+	return off, size, nil
+}
+
+func linkCount(fi os.FileInfo) uint64 {
+	return 1
+}
+
 func unmountAll(target string) error {
 	return nil
 }
@@ -45,7 +66,7 @@ func upperDirectoryPermission(p, parent string) error {
 	return nil
 }
 
-func convertDirToErofs(ctx context.Context, layerBlob, upperDir string) error {
+func (s *snapshotter) convertDirToErofs(ctx context.Context, layerBlob, upperDir, compression string) error {
 	return errdefs.ErrNotImplemented
 }
 
@@ -53,6 +74,46 @@ func (s *snapshotter) cleanupOrphanedMounts() {
 	// No-op on non-Linux platforms
 }
 
+func loopDevicesAvailable() bool {
+	return false
+}
+
 func (s *snapshotter) mountBlockRwLayer(ctx context.Context, id string) error {
 	return errdefs.ErrNotImplemented
 }
+
+func syncFile(path string) error {
+	return errdefs.ErrNotImplemented
+}
+
+func layerBlobInUse(path string) (bool, error) {
+	return false, nil
+}
+
+func (s *snapshotter) checkCommitSourceIdle(id, upperDir string) error {
+	return nil
+}
+
+func cloneFile(dst, src *os.File) error {
+	return errdefs.ErrNotImplemented
+}
+
+func blobLoopDevices(blob string) ([]LoopDeviceInfo, error) {
+	return nil, nil
+}
+
+func pageCacheResidency(path string) (int64, error) {
+	return 0, errdefs.ErrNotImplemented
+}
+
+func lsetxattr(path, name string, value []byte) error {
+	return errdefs.ErrNotImplemented
+}
+
+func rootInUse(root string) error {
+	return nil
+}
+
+func (s *snapshotter) writableLayerInUse(id string) (bool, error) {
+	return false, nil
+}
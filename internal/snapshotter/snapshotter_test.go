@@ -17,12 +17,23 @@
 package snapshotter
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/containerd/containerd/v2/core/snapshots"
 	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/preflight"
 
 	// Import testutil to register the -test.root flag
 	_ "github.com/spin-stack/erofs-snapshotter/internal/testutil"
@@ -203,6 +214,40 @@ func TestEnsureMarkerFile(t *testing.T) {
 	})
 }
 
+func TestEnsureMarker(t *testing.T) {
+	t.Run("read-only snapshot dir fails", func(t *testing.T) {
+		if os.Getuid() == 0 {
+			t.Skip("root ignores directory permissions")
+		}
+		s := newMetadataOnlySnapshotter(t)
+		dir := t.TempDir()
+		if err := os.Chmod(dir, 0o500); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = os.Chmod(dir, 0o700) })
+
+		err := s.ensureMarker(t.Context(), filepath.Join(dir, "marker"))
+		if !errors.Is(err, os.ErrPermission) {
+			t.Fatalf("expected permission error, got %v", err)
+		}
+		if n := s.MarkerFailures(); n != 0 {
+			t.Errorf("permission errors should not be counted, got %d", n)
+		}
+	})
+
+	t.Run("classifies out of space errors", func(t *testing.T) {
+		for _, errno := range []syscall.Errno{syscall.ENOSPC, syscall.EDQUOT} {
+			err := fmt.Errorf("create marker file: %w", &os.PathError{Op: "open", Path: "marker", Err: errno})
+			if !isNoSpace(err) {
+				t.Errorf("expected %v to be treated as out of space", errno)
+			}
+		}
+		if isNoSpace(&os.PathError{Op: "open", Path: "marker", Err: syscall.EACCES}) {
+			t.Error("EACCES should not be treated as out of space")
+		}
+	})
+}
+
 func TestSnapshotterOptions(t *testing.T) {
 	t.Run("WithImmutable", func(t *testing.T) {
 		config := &SnapshotterConfig{}
@@ -223,6 +268,393 @@ func TestSnapshotterOptions(t *testing.T) {
 			t.Errorf("expected defaultSize to be 100MB, got %d", config.defaultSize)
 		}
 	})
+
+	t.Run("WithDefaultSizeString", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		WithDefaultSizeString("512MiB")(config)
+		if config.defaultSizeString != "512MiB" {
+			t.Errorf("expected defaultSizeString to be 512MiB, got %q", config.defaultSizeString)
+		}
+
+		// The last size option wins.
+		WithDefaultSize(1 << 30)(config)
+		if config.defaultSizeString != "" || config.defaultSize != 1<<30 {
+			t.Errorf("expected WithDefaultSize to override the string size, got %+v", config)
+		}
+	})
+
+	t.Run("WithUsageCache", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithUsageCache(30 * time.Second)
+		opt(config)
+
+		if config.usageCacheTTL != 30*time.Second {
+			t.Errorf("expected usageCacheTTL to be 30s, got %v", config.usageCacheTTL)
+		}
+	})
+
+	t.Run("WithAutoTrim", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithAutoTrim(time.Hour)
+		opt(config)
+
+		if config.autoTrimInterval != time.Hour {
+			t.Errorf("expected autoTrimInterval to be 1h, got %v", config.autoTrimInterval)
+		}
+	})
+
+	t.Run("WithWritableTemplate", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithWritableTemplate("/path/to/template.img")
+		opt(config)
+
+		if config.writableTemplate != "/path/to/template.img" {
+			t.Errorf("expected writableTemplate to be set, got %q", config.writableTemplate)
+		}
+	})
+
+	t.Run("WithAutoModprobe", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithAutoModprobe()
+		opt(config)
+
+		if !config.autoModprobe {
+			t.Error("expected autoModprobe to be enabled")
+		}
+	})
+
+	t.Run("WithPreserveFailedUpper", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithPreserveFailedUpper("/var/lib/erofs-quarantine")
+		opt(config)
+
+		if config.failedUpperDir != "/var/lib/erofs-quarantine" {
+			t.Errorf("expected failedUpperDir to be set, got %q", config.failedUpperDir)
+		}
+	})
+
+	t.Run("WithRetainRemoved", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithRetainRemoved(3)
+		opt(config)
+
+		if config.retainRemoved != 3 {
+			t.Errorf("expected retainRemoved to be 3, got %d", config.retainRemoved)
+		}
+	})
+
+	t.Run("WithMountRetries", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithMountRetries(5, 10*time.Millisecond)
+		opt(config)
+
+		if config.mountRetries != 5 || config.mountRetryBase != 10*time.Millisecond {
+			t.Errorf("expected 5 retries with 10ms base, got %d and %s", config.mountRetries, config.mountRetryBase)
+		}
+	})
+
+	t.Run("WithNamespaceQuota", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		quotas := map[string]int64{"default": 1 << 30}
+		opt := WithNamespaceQuota(quotas)
+		opt(config)
+
+		quotas["default"] = 1
+		if config.namespaceQuota["default"] != 1<<30 {
+			t.Errorf("expected namespace quota to be copied, got %v", config.namespaceQuota)
+		}
+	})
+
+	t.Run("WithVerifyDigestOnMount", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithVerifyDigestOnMount()
+		opt(config)
+
+		if !config.verifyDigestOnMount {
+			t.Error("expected verifyDigestOnMount to be enabled")
+		}
+	})
+
+	t.Run("WithScratchDir", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithScratchDir("/scratch")
+		opt(config)
+
+		if config.scratchDir != "/scratch" {
+			t.Errorf("expected scratchDir to be set, got %q", config.scratchDir)
+		}
+	})
+
+	t.Run("WithErofsBlockSize", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithErofsBlockSize(16384)
+		opt(config)
+
+		if config.erofsBlockSize != 16384 {
+			t.Errorf("expected erofsBlockSize to be 16384, got %d", config.erofsBlockSize)
+		}
+	})
+
+	t.Run("WithStartupConsistencyCheck", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithStartupConsistencyCheck()
+		opt(config)
+
+		if !config.startupConsistencyCheck {
+			t.Error("expected startupConsistencyCheck to be true")
+		}
+	})
+
+	t.Run("WithFsverityMeasurement", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithFsverityMeasurement()
+		opt(config)
+
+		if !config.fsverityMeasurement {
+			t.Error("expected fsverityMeasurement to be true")
+		}
+	})
+
+	t.Run("WithBlockModeFallback", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithBlockModeFallback()
+		opt(config)
+
+		if !config.blockModeFallback {
+			t.Error("expected blockModeFallback to be true")
+		}
+	})
+
+	t.Run("WithBlobNamer", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithBlobNamer(func(id string, _ digest.Digest) string { return "layer-" + id + ".erofs" })
+		opt(config)
+
+		if config.blobNamer == nil || config.blobNamer("7", "") != "layer-7.erofs" {
+			t.Error("expected blobNamer to be set")
+		}
+	})
+
+	t.Run("WithContentStore", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithContentStore("/var/lib/erofs-store")
+		opt(config)
+
+		if config.contentStore != "/var/lib/erofs-store" {
+			t.Errorf("expected contentStore to be set, got %q", config.contentStore)
+		}
+	})
+
+	t.Run("WithBlockDeviceHandoff", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithBlockDeviceHandoff()
+		opt(config)
+
+		if !config.blockDeviceHandoff {
+			t.Error("expected blockDeviceHandoff to be true")
+		}
+	})
+
+	t.Run("WithUpperXattrs", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		xattrs := map[string]string{"user.marker": "1"}
+		opt := WithUpperXattrs(xattrs)
+		opt(config)
+		xattrs["user.marker"] = "changed"
+
+		if config.upperXattrs["user.marker"] != "1" {
+			t.Errorf("expected upperXattrs to be copied, got %v", config.upperXattrs)
+		}
+	})
+
+	t.Run("WithTimeouts", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		want := TimeoutConfig{Prepare: time.Second, Commit: time.Minute, Mount: 2 * time.Second}
+		opt := WithTimeouts(want)
+		opt(config)
+
+		if config.timeouts != want {
+			t.Errorf("expected timeouts to be %+v, got %+v", want, config.timeouts)
+		}
+	})
+
+	t.Run("WithCleanupConcurrency", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithCleanupConcurrency(8)
+		opt(config)
+
+		if config.cleanupConcurrency != 8 {
+			t.Errorf("expected cleanupConcurrency to be 8, got %d", config.cleanupConcurrency)
+		}
+	})
+
+	t.Run("WithErofsTailPacking", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithErofsTailPacking()
+		opt(config)
+
+		if !config.erofsTailPacking {
+			t.Error("expected erofsTailPacking to be true")
+		}
+	})
+
+	t.Run("WithMkfsTimeout", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithMkfsTimeout(time.Minute)
+		opt(config)
+
+		if config.mkfsTimeout != time.Minute {
+			t.Errorf("expected mkfsTimeout to be 1m, got %s", config.mkfsTimeout)
+		}
+	})
+
+	t.Run("WithMinFreeLoopDevices", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithMinFreeLoopDevices(32)
+		opt(config)
+
+		if config.minFreeLoopDevices != 32 {
+			t.Errorf("expected minFreeLoopDevices to be 32, got %d", config.minFreeLoopDevices)
+		}
+	})
+
+	t.Run("WithFsMetaConcurrency", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithFsMetaConcurrency(4)
+		opt(config)
+
+		if config.fsMetaConcurrency != 4 {
+			t.Errorf("expected fsMetaConcurrency to be 4, got %d", config.fsMetaConcurrency)
+		}
+	})
+
+	t.Run("WithExt4MountOptions", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		WithExt4MountOptions([]string{"commit=60"})(config)
+		WithUnsafeExt4MountOptions()(config)
+
+		if len(config.ext4MountOptions) != 1 || config.ext4MountOptions[0] != "commit=60" {
+			t.Errorf("expected ext4MountOptions to be [commit=60], got %v", config.ext4MountOptions)
+		}
+		if !config.unsafeExt4MountOptions {
+			t.Error("expected unsafeExt4MountOptions to be true")
+		}
+	})
+
+	t.Run("WithCommitHook", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		WithCommitHook(func(context.Context, CommitInfo) error { return nil })(config)
+		WithCommitHookErrorsFatal()(config)
+
+		if config.commitHook == nil {
+			t.Error("expected commitHook to be set")
+		}
+		if !config.commitHookFatal {
+			t.Error("expected commitHookFatal to be true")
+		}
+	})
+
+	t.Run("WithConverter", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		c := mkfsConverter{}
+		WithConverter(c)(config)
+
+		if config.converter != c {
+			t.Errorf("expected converter to be set, got %v", config.converter)
+		}
+	})
+
+	t.Run("WithMetadataPath", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithMetadataPath("/fast/metadata.db")
+		opt(config)
+
+		if config.metadataPath != "/fast/metadata.db" {
+			t.Errorf("expected metadataPath to be set, got %q", config.metadataPath)
+		}
+	})
+
+	t.Run("WithEagerExt4Init", func(t *testing.T) {
+		config := &SnapshotterConfig{}
+		opt := WithEagerExt4Init()
+		opt(config)
+
+		if !config.eagerExt4Init {
+			t.Error("expected eagerExt4Init to be enabled")
+		}
+	})
+}
+
+func TestValidateMetadataPath(t *testing.T) {
+	t.Run("creates missing directory", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "db", "metadata.db")
+		if err := validateMetadataPath(path); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		entries, err := os.ReadDir(filepath.Dir(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("probe file should be removed, found %d entries", len(entries))
+		}
+	})
+
+	t.Run("rejects relative path", func(t *testing.T) {
+		if err := validateMetadataPath("metadata.db"); err == nil {
+			t.Error("expected error for relative path")
+		}
+	})
+
+	t.Run("rejects unwritable directory", func(t *testing.T) {
+		if os.Getuid() == 0 {
+			t.Skip("root ignores directory permissions")
+		}
+		dir := t.TempDir()
+		if err := os.Chmod(dir, 0o500); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = os.Chmod(dir, 0o700) })
+
+		if err := validateMetadataPath(filepath.Join(dir, "metadata.db")); err == nil {
+			t.Error("expected error for read-only directory")
+		}
+	})
+}
+
+func TestExt4ExtendedOptions(t *testing.T) {
+	lazy := ext4ExtendedOptions(false)
+	if !strings.Contains(lazy, "lazy_itable_init=1") || !strings.Contains(lazy, "lazy_journal_init=1") {
+		t.Errorf("default options should use lazy init, got %q", lazy)
+	}
+
+	eager := ext4ExtendedOptions(true)
+	if !strings.Contains(eager, "lazy_itable_init=0") || !strings.Contains(eager, "lazy_journal_init=0") {
+		t.Errorf("eager options should disable lazy init, got %q", eager)
+	}
+
+	for _, opts := range []string{lazy, eager} {
+		if !strings.Contains(opts, "nodiscard") {
+			t.Errorf("options should keep nodiscard, got %q", opts)
+		}
+	}
+}
+
+func TestRequiredFeatures(t *testing.T) {
+	config := &SnapshotterConfig{}
+	if got := config.requiredFeatures(); len(got) != 1 || got[0] != preflight.FeatureErofs {
+		t.Errorf("default features = %v, want [%s]", got, preflight.FeatureErofs)
+	}
+
+	WithAutoTrim(time.Minute)(config)
+	if !slices.Contains(config.requiredFeatures(), preflight.FeatureLoopDiscard) {
+		t.Errorf("expected %s with auto-trim enabled", preflight.FeatureLoopDiscard)
+	}
+
+	WithFsverityMeasurement()(config)
+	if !slices.Contains(config.requiredFeatures(), preflight.FeatureFsverity) {
+		t.Errorf("expected %s with fs-verity measurement enabled", preflight.FeatureFsverity)
+	}
 }
 
 func TestMountFsMetaReturnsFormatErofs(t *testing.T) {
@@ -257,7 +689,7 @@ func TestMountFsMetaReturnsFormatErofs(t *testing.T) {
 		ParentIDs: []string{"parent1"},
 	}
 
-	mount, ok := s.mountFsMeta(snap)
+	mount, ok := s.mountFsMeta(snap, nil)
 	if !ok {
 		t.Fatal("mountFsMeta should return true when fsmeta/vmdk exist")
 	}
@@ -337,7 +769,7 @@ func TestMountFsMetaDeviceOrder(t *testing.T) {
 		ParentIDs: parentIDs,
 	}
 
-	mount, ok := s.mountFsMeta(snap)
+	mount, ok := s.mountFsMeta(snap, nil)
 	if !ok {
 		t.Fatal("mountFsMeta should return true when fsmeta/vmdk exist")
 	}
@@ -406,3 +838,170 @@ func TestSnapshotterPaths(t *testing.T) {
 		}
 	})
 }
+
+func TestFindLayerBlobFromInfo(t *testing.T) {
+	root := t.TempDir()
+	s := &snapshotter{root: root}
+
+	dir := s.snapshotDir("1")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	labeled := filepath.Join(dir, "custom.erofs")
+	fallback := s.fallbackLayerBlobPath("1")
+	outside := filepath.Join(root, "outside.erofs")
+	for _, p := range []string{labeled, fallback, outside} {
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		label string
+		want  string
+	}{
+		{"no label", "", fallback},
+		{"label inside snapshot dir", labeled, labeled},
+		{"label outside snapshot dir", outside, fallback},
+		{"label to missing file", filepath.Join(dir, "missing.erofs"), fallback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := snapshots.Info{Labels: map[string]string{}}
+			if tt.label != "" {
+				info.Labels[LabelLayerBlobPath] = tt.label
+			}
+			got, err := s.findLayerBlobFromInfo("1", info)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("findLayerBlobFromInfo() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMkfsTimeout(t *testing.T) {
+	// A fake mkfs.ext4 that hangs until killed.
+	bin := t.TempDir()
+	script := "#!/bin/sh\nexec sleep 60\n"
+	if err := os.WriteFile(filepath.Join(bin, "mkfs.ext4"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	s := newMetadataOnlySnapshotter(t)
+	s.defaultWritable = 1 << 20
+	s.mkfsTimeout = 100 * time.Millisecond
+	if err := os.MkdirAll(s.snapshotDir("1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err := s.createWritableLayer(t.Context(), "1")
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("mkfs was not killed on timeout, took %s", elapsed)
+	}
+	var terr *TimeoutError
+	if !errors.As(err, &terr) || terr.Command != "mkfs.ext4" {
+		t.Fatalf("expected mkfs.ext4 TimeoutError, got %v", err)
+	}
+	if _, err := os.Stat(s.writablePath("1")); !os.IsNotExist(err) {
+		t.Errorf("expected partial writable layer to be removed, got %v", err)
+	}
+}
+
+func TestPrepareTimeout(t *testing.T) {
+	// A fake mkfs.ext4 that hangs until killed.
+	bin := t.TempDir()
+	script := "#!/bin/sh\nexec sleep 60\n"
+	if err := os.WriteFile(filepath.Join(bin, "mkfs.ext4"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	s := newMetadataOnlySnapshotter(t)
+	s.defaultWritable = 1 << 20
+	s.timeouts.Prepare = 100 * time.Millisecond
+	if err := os.MkdirAll(s.snapshotsDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err := s.Prepare(t.Context(), "active", "")
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("Prepare was not stopped on timeout, took %s", elapsed)
+	}
+	var terr *OperationTimeoutError
+	if !errors.As(err, &terr) || terr.Operation != "prepare" || terr.Key != "active" {
+		t.Fatalf("expected prepare OperationTimeoutError, got %v", err)
+	}
+	if !errdefs.IsDeadlineExceeded(err) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	entries, err := os.ReadDir(s.snapshotsDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected partial snapshot directories to be removed, found %d", len(entries))
+	}
+}
+
+func TestParseWritableSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "512MiB", want: 512 << 20},
+		{in: "2GiB", want: 2 << 30},
+		{in: "64m", want: 64 << 20},
+		{in: "1048576", want: 1 << 20},
+		{in: "512KiB", wantErr: true},
+		{in: "1048577", wantErr: true},
+		{in: "lots", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := parseWritableSize(tc.in)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("parseWritableSize(%q) = %d, %v; want %d, error %v", tc.in, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestNewSnapshotterRejectsSmallWritableSize(t *testing.T) {
+	for _, opt := range []Opt{WithDefaultSize(64 << 10), WithDefaultSize(0), WithDefaultSizeString("100KiB")} {
+		_, err := NewSnapshotter(t.TempDir(), opt)
+		if err == nil || !strings.Contains(err.Error(), "at least 1MiB") {
+			t.Errorf("expected minimum size error, got %v", err)
+		}
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	wait := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	err := withTimeout(t.Context(), "commit", "key", time.Millisecond, wait)
+	var terr *OperationTimeoutError
+	if !errors.As(err, &terr) || terr.Timeout != time.Millisecond {
+		t.Errorf("expected OperationTimeoutError, got %v", err)
+	}
+
+	// The caller's own deadline is not reported as the operation's.
+	ctx, cancel := context.WithTimeout(t.Context(), time.Millisecond)
+	defer cancel()
+	err = withTimeout(ctx, "commit", "key", time.Hour, wait)
+	if errors.As(err, &terr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected plain deadline exceeded, got %v", err)
+	}
+
+	if err := withTimeout(t.Context(), "commit", "key", 0, func(context.Context) error { return nil }); err != nil {
+		t.Errorf("expected no error without a limit, got %v", err)
+	}
+}
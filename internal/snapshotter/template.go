@@ -0,0 +1,87 @@
+package snapshotter
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// validateWritableTemplate checks that a writable layer template exists, is a
+// regular file, and matches the configured writable layer size.
+func validateWritableTemplate(path string, size int64) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat writable template: %w", err)
+	}
+	if !st.Mode().IsRegular() {
+		return fmt.Errorf("writable template %q is not a regular file", path)
+	}
+	if st.Size() != size {
+		return fmt.Errorf("writable template %q size %d does not match writable layer size %d", path, st.Size(), size)
+	}
+	return nil
+}
+
+// copyWritableTemplate copies the template image to dst. It tries a reflink
+// clone first and falls back to a sparse copy that preserves holes.
+func copyWritableTemplate(template, dst string) (err error) {
+	src, err := os.Open(template)
+	if err != nil {
+		return fmt.Errorf("open writable template: %w", err)
+	}
+	defer src.Close()
+
+	st, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("stat writable template: %w", err)
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("create writable layer file: %w", err)
+	}
+	defer func() {
+		if cerr := out.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("close writable layer file: %w", cerr)
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	if cloneFile(out, src) == nil {
+		return nil
+	}
+	return copySparse(out, src, st.Size())
+}
+
+// copySparse copies size bytes from src to dst, copying only the data
+// extents of src so that its holes stay holes in dst. The extents are
+// copied in the kernel with copy_file_range where available.
+func copySparse(dst, src *os.File, size int64) error {
+	for off := int64(0); off < size; {
+		start, end, err := nextDataExtent(src, off, size)
+		if err != nil {
+			return fmt.Errorf("find data in writable template: %w", err)
+		}
+		if start >= size {
+			break
+		}
+		if _, err := src.Seek(start, io.SeekStart); err != nil {
+			return fmt.Errorf("seek writable template: %w", err)
+		}
+		if _, err := dst.Seek(start, io.SeekStart); err != nil {
+			return fmt.Errorf("seek writable layer: %w", err)
+		}
+		if _, err := io.Copy(dst, io.LimitReader(src, end-start)); err != nil {
+			return fmt.Errorf("copy writable layer: %w", err)
+		}
+		off = end
+	}
+
+	// Trailing holes are not materialized by Seek; extend to the full size.
+	if err := dst.Truncate(size); err != nil {
+		return fmt.Errorf("truncate writable layer: %w", err)
+	}
+	return nil
+}
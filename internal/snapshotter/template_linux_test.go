@@ -0,0 +1,98 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCopySparseKeepsHoles(t *testing.T) {
+	dir := t.TempDir()
+	const size = 64 << 20
+	src, err := os.Create(filepath.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if err := src.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.WriteAt([]byte("middle"), size/2); err != nil {
+		t.Fatal(err)
+	}
+
+	dstPath := filepath.Join(dir, "dst")
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err := copySparse(dst, src, size); err != nil {
+		t.Fatalf("copySparse failed: %v", err)
+	}
+
+	got := make([]byte, len("middle"))
+	if _, err := dst.ReadAt(got, size/2); err != nil || string(got) != "middle" {
+		t.Errorf("ReadAt = %q, %v; want %q", got, err, "middle")
+	}
+	allocated, err := allocatedBytes(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated > 1<<20 {
+		t.Errorf("expected holes to be preserved, %d bytes allocated", allocated)
+	}
+}
+
+func TestWritableTemplateCopiesGetOwnUUID(t *testing.T) {
+	for _, tool := range []string{"mkfs.ext4", "tune2fs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+	s := &snapshotter{root: t.TempDir()}
+	s.writableTemplate = filepath.Join(t.TempDir(), "template.img")
+	if err := os.WriteFile(s.writableTemplate, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(s.writableTemplate, 16<<20); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("mkfs.ext4", "-q", "-F", s.writableTemplate).CombinedOutput(); err != nil {
+		t.Fatalf("mkfs.ext4: %v: %s", err, out)
+	}
+
+	uuids := make(map[string]string)
+	for _, id := range []string{"template", "1", "2"} {
+		path := s.writableTemplate
+		if id != "template" {
+			if err := os.MkdirAll(s.snapshotDir(id), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.createWritableLayer(t.Context(), id); err != nil {
+				t.Fatalf("createWritableLayer: %v", err)
+			}
+			path = s.writablePath(id)
+		}
+		out, err := exec.Command("tune2fs", "-l", path).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for line := range strings.Lines(string(out)) {
+			if uuid, ok := strings.CutPrefix(line, "Filesystem UUID:"); ok {
+				uuid = strings.TrimSpace(uuid)
+				if other, dup := uuids[uuid]; dup {
+					t.Errorf("%s has the same UUID as %s", id, other)
+				}
+				uuids[uuid] = id
+			}
+		}
+	}
+	if len(uuids) != 3 {
+		t.Errorf("expected 3 distinct UUIDs, got %v", uuids)
+	}
+}
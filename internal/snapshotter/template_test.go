@@ -0,0 +1,111 @@
+package snapshotter
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testBlockSize is the extent size the sparse copy tests write and punch.
+const testBlockSize = 4096
+
+func TestValidateWritableTemplate(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "template.img")
+	if err := os.WriteFile(tmpl, make([]byte, 8192), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validateWritableTemplate(tmpl, 8192); err != nil {
+		t.Errorf("expected matching template to validate, got %v", err)
+	}
+	if err := validateWritableTemplate(tmpl, 4096); err == nil {
+		t.Error("expected size mismatch to fail validation")
+	}
+	if err := validateWritableTemplate(dir, 8192); err == nil {
+		t.Error("expected directory to fail validation")
+	}
+	if err := validateWritableTemplate(filepath.Join(dir, "missing"), 8192); err == nil {
+		t.Error("expected missing template to fail validation")
+	}
+}
+
+func TestCopyWritableTemplate(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "template.img")
+
+	// Data at the start and in the middle, with holes around it.
+	const size = 1024 * 1024
+	want := make([]byte, size)
+	copy(want, "superblock")
+	copy(want[size/2:], "bootstrap files")
+
+	f, err := os.Create(tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(want[:testBlockSize], 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(want[size/2:size/2+testBlockSize], size/2); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	dst := filepath.Join(dir, "rwlayer.img")
+	if err := copyWritableTemplate(tmpl, dst); err != nil {
+		t.Fatalf("copyWritableTemplate failed: %v", err)
+	}
+
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("copied writable layer does not match template content")
+	}
+
+	// Copying onto an existing layer must fail rather than clobber it.
+	if err := copyWritableTemplate(tmpl, dst); err == nil {
+		t.Error("expected copy onto existing file to fail")
+	}
+}
+
+func TestCopySparseTrailingHole(t *testing.T) {
+	dir := t.TempDir()
+	src, err := os.Create(filepath.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if _, err := src.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Truncate(3 * testBlockSize); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := os.Create(filepath.Join(dir, "dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	if err := copySparse(dst, src, 3*testBlockSize); err != nil {
+		t.Fatalf("copySparse failed: %v", err)
+	}
+	st, err := dst.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Size() != 3*testBlockSize {
+		t.Errorf("expected size %d, got %d", 3*testBlockSize, st.Size())
+	}
+}
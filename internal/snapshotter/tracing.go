@@ -0,0 +1,31 @@
+package snapshotter
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/v2/pkg/tracing"
+)
+
+// tracingPrefix is prepended to the names of spans started by the snapshotter.
+const tracingPrefix = "erofs.snapshotter"
+
+// Span attribute keys.
+const (
+	attrSnapshotID      = "snapshot.id"
+	attrSnapshotKey     = "snapshot.key"
+	attrSnapshotKind    = "snapshot.kind"
+	attrSnapshotParents = "snapshot.parents"
+)
+
+// startSpan starts a span named after op using the tracer containerd
+// configures globally. Without a configured tracer provider the span is a
+// no-op.
+func startSpan(ctx context.Context, op string, opts ...tracing.SpanOpt) (context.Context, *tracing.Span) {
+	return tracing.StartSpan(ctx, tracing.Name(tracingPrefix, op), opts...)
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span *tracing.Span, err error) {
+	span.SetStatus(err)
+	span.End()
+}
@@ -0,0 +1,99 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordingProvider records the names and statuses of started spans.
+type recordingProvider struct {
+	embedded.TracerProvider
+
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (p *recordingProvider) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return &recordingTracer{provider: p}
+}
+
+type recordingTracer struct {
+	embedded.Tracer
+	provider *recordingProvider
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	_, inner := noop.NewTracerProvider().Tracer("").Start(ctx, name)
+	s := &recordedSpan{Span: inner, name: name}
+	t.provider.mu.Lock()
+	t.provider.spans = append(t.provider.spans, s)
+	t.provider.mu.Unlock()
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+type recordedSpan struct {
+	trace.Span
+	name   string
+	status codes.Code
+	ended  bool
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+func (s *recordedSpan) End(...trace.SpanEndOption)          { s.ended = true }
+
+// useRecordingProvider installs a recording global tracer provider for the
+// duration of the test.
+func useRecordingProvider(t *testing.T) *recordingProvider {
+	t.Helper()
+	prev := otel.GetTracerProvider()
+	p := &recordingProvider{}
+	otel.SetTracerProvider(p)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return p
+}
+
+func TestStartSpanName(t *testing.T) {
+	p := useRecordingProvider(t)
+
+	_, span := startSpan(t.Context(), "op")
+	endSpan(span, errors.New("failed"))
+
+	if len(p.spans) != 1 {
+		t.Fatalf("expected one span, got %d", len(p.spans))
+	}
+	got := p.spans[0]
+	if got.name != tracingPrefix+".op" {
+		t.Errorf("span name = %q, want %q", got.name, tracingPrefix+".op")
+	}
+	if !got.ended || got.status != codes.Error {
+		t.Errorf("expected ended span with error status, got ended=%v status=%v", got.ended, got.status)
+	}
+}
+
+func TestCommitBlockSpanRecordsError(t *testing.T) {
+	p := useRecordingProvider(t)
+	s := newMetadataOnlySnapshotter(t)
+
+	err := s.commitBlock(t.Context(), s.fallbackLayerBlobPath("1"), "1", snapshots.Info{}, "")
+	if err == nil {
+		t.Fatal("expected conversion of missing upper dir to fail")
+	}
+
+	idx := slices.IndexFunc(p.spans, func(s *recordedSpan) bool { return s.name == tracingPrefix+".commitBlock" })
+	if idx < 0 {
+		t.Fatalf("no commitBlock span recorded: %v", p.spans)
+	}
+	if span := p.spans[idx]; !span.ended || span.status != codes.Error {
+		t.Errorf("expected ended span with error status, got ended=%v status=%v", span.ended, span.status)
+	}
+}
@@ -0,0 +1,125 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/log"
+)
+
+// errWritableLayerNotMounted is returned by trimWritableLayer for layers
+// that are not mounted on the host.
+var errWritableLayerNotMounted = errors.New("writable layer is not mounted on the host")
+
+// autoTrimLoop periodically trims the writable layers of active snapshots
+// until stop is closed. Trimming punches freed ext4 blocks back into the
+// sparse rwlayer.img so host disk usage shrinks after churn.
+func (s *snapshotter) autoTrimLoop(interval time.Duration, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// Cancel an in-flight pass promptly on shutdown.
+			done := make(chan struct{})
+			go func() {
+				select {
+				case <-stop:
+					cancel()
+				case <-done:
+				}
+			}()
+			s.trimWritableLayers(ctx)
+			close(done)
+		}
+	}
+}
+
+// startAutoTrim starts autoTrimLoop with the WithAutoTrim interval, unless
+// auto-trim is disabled, already running or the snapshotter is closed.
+func (s *snapshotter) startAutoTrim() {
+	if s.autoTrimInterval <= 0 {
+		return
+	}
+	s.trimMu.Lock()
+	defer s.trimMu.Unlock()
+	if s.trimStop != nil || s.trimClosed {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	s.trimStop, s.trimDone = stop, done
+	go func() {
+		defer close(done)
+		s.autoTrimLoop(s.autoTrimInterval, stop)
+	}()
+}
+
+// stopAutoTrim stops the auto-trim loop and waits for it to return. It
+// reports whether the loop was running.
+func (s *snapshotter) stopAutoTrim() bool {
+	s.trimMu.Lock()
+	defer s.trimMu.Unlock()
+	if s.trimStop == nil {
+		return false
+	}
+	close(s.trimStop)
+	<-s.trimDone
+	s.trimStop, s.trimDone = nil, nil
+	return true
+}
+
+// trimWritableLayers runs one trim pass over all active snapshots.
+// Layers not mounted on the host are skipped. Errors are logged, not returned.
+func (s *snapshotter) trimWritableLayers(ctx context.Context) {
+	ids, err := s.activeSnapshotIDs(ctx)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("auto-trim: failed to enumerate active snapshots")
+		return
+	}
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		reclaimed, err := s.trimWritableLayer(ctx, id)
+		switch {
+		case errors.Is(err, errWritableLayerNotMounted):
+			log.G(ctx).WithField("id", id).Debug("auto-trim: skipping writable layer not mounted on the host")
+		case err != nil:
+			log.G(ctx).WithError(err).WithField("id", id).Warn("auto-trim: failed to trim writable layer")
+		default:
+			log.G(ctx).WithFields(log.Fields{
+				"id":        id,
+				"reclaimed": reclaimed,
+			}).Info("auto-trim: trimmed writable layer")
+		}
+	}
+}
+
+// activeSnapshotIDs returns the IDs of all active snapshots in the metadata store.
+func (s *snapshotter) activeSnapshotIDs(ctx context.Context) ([]string, error) {
+	var ids []string
+	err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		return storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+			if info.Kind != snapshots.KindActive {
+				return nil
+			}
+			id, _, _, err := storage.GetInfo(ctx, info.Name)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+			return nil
+		})
+	})
+	return ids, err
+}
@@ -0,0 +1,65 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/stringutil"
+)
+
+// trimWritableLayer discards unused blocks in a snapshot's ext4 writable layer
+// and returns the number of bytes reclaimed from the sparse backing file.
+//
+// Only layers already mounted on the host (extract snapshots) are trimmed,
+// through that mount. Any other layer may be attached to a running VM, which
+// locks the image in ways a host check cannot see (QEMU uses OFD locks), and
+// mounting it a second time would corrupt it; those fail with
+// errWritableLayerNotMounted and are left for the guest to trim.
+func (s *snapshotter) trimWritableLayer(ctx context.Context, id string) (int64, error) {
+	rwMount := s.blockRwMountPath(id)
+	if !isMounted(rwMount) {
+		return 0, errWritableLayerNotMounted
+	}
+
+	rwLayer := s.writablePath(id)
+	before, err := allocatedBytes(rwLayer)
+	if err != nil {
+		return 0, err
+	}
+	if err := fstrim(ctx, rwMount); err != nil {
+		return 0, err
+	}
+	after, err := allocatedBytes(rwLayer)
+	if err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// fstrim discards unused blocks on the filesystem mounted at target.
+func fstrim(ctx context.Context, target string) error {
+	cmd := exec.CommandContext(ctx, "fstrim", target)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("fstrim %s: %w: %s", target, err, stringutil.TruncateOutput(out, 256))
+	}
+	return nil
+}
+
+// allocatedBytes returns the number of bytes actually allocated on disk for path.
+func allocatedBytes(path string) (int64, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	stat, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("failed to get syscall.Stat_t from file info")
+	}
+	// st_blocks is always in 512-byte units regardless of filesystem block size.
+	return stat.Blocks * 512, nil
+}
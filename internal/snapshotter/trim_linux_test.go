@@ -0,0 +1,52 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllocatedBytesSparseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sparse.img")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(64 * 1024 * 1024); err != nil {
+		f.Close()
+		t.Fatal(err)
+	}
+	f.Close()
+
+	allocated, err := allocatedBytes(path)
+	if err != nil {
+		t.Fatalf("allocatedBytes failed: %v", err)
+	}
+	if allocated >= 64*1024*1024 {
+		t.Errorf("expected sparse file to allocate less than its size, got %d", allocated)
+	}
+}
+
+func TestTrimWritableLayerSkipsUnmountedLayer(t *testing.T) {
+	s := &snapshotter{root: t.TempDir()}
+	if err := os.MkdirAll(s.snapshotDir("1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.writablePath("1"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.trimWritableLayer(t.Context(), "1"); !errors.Is(err, errWritableLayerNotMounted) {
+		t.Fatalf("expected errWritableLayerNotMounted, got %v", err)
+	}
+	entries, err := os.ReadDir(s.snapshotDir("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no mount point to be created, got %v", entries)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package snapshotter
+
+import (
+	"context"
+
+	"github.com/containerd/errdefs"
+)
+
+func (s *snapshotter) trimWritableLayer(ctx context.Context, id string) (int64, error) {
+	return 0, errdefs.ErrNotImplemented
+}
@@ -0,0 +1,24 @@
+package snapshotter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoTrimLoopStops(t *testing.T) {
+	s := &snapshotter{root: t.TempDir()}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		s.autoTrimLoop(time.Hour, stop)
+		close(done)
+	}()
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("autoTrimLoop did not stop after stop channel was closed")
+	}
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/pkg/testutil"
+	"github.com/moby/sys/mountinfo"
+)
+
+func TestUnmountAllNotMounted(t *testing.T) {
+	dir := t.TempDir()
+	if err := unmountAll(dir); err != nil {
+		t.Errorf("unmountAll on a plain directory: %v", err)
+	}
+	if err := unmountAll(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("unmountAll on a missing path: %v", err)
+	}
+}
+
+func TestUnmountAllBusy(t *testing.T) {
+	testutil.RequiresRoot(t)
+	target := t.TempDir()
+	if err := mount.All([]mount.Mount{{Type: "tmpfs", Source: "tmpfs"}}, target); err != nil {
+		t.Skipf("cannot mount tmpfs: %v", err)
+	}
+	t.Cleanup(func() { _ = mount.UnmountAll(target, 0) })
+
+	// An open file keeps the mount busy, so only a lazy unmount succeeds.
+	f, err := os.Create(filepath.Join(target, "busy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := unmountAll(target); err != nil {
+		t.Fatalf("unmountAll on a busy mount: %v", err)
+	}
+	mounted, err := mountinfo.Mounted(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mounted {
+		t.Error("expected busy mount to be detached")
+	}
+}
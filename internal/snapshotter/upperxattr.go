@@ -0,0 +1,72 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+)
+
+// xattrNamespaces are the extended attribute namespaces WithUpperXattrs and
+// LabelUpperXattrPrefix may set.
+var xattrNamespaces = []string{"user.", "security.", "trusted."}
+
+// validateXattrName checks that name is in one of xattrNamespaces and has a
+// name after the namespace prefix.
+func validateXattrName(name string) error {
+	for _, ns := range xattrNamespaces {
+		if rest, ok := strings.CutPrefix(name, ns); ok && rest != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid upper xattr name %q (must start with %s): %w",
+		name, strings.Join(xattrNamespaces, ", "), errdefs.ErrInvalidArgument)
+}
+
+// upperXattrsFor returns the extended attributes to set on the upper
+// directory of the new active snapshot info: the WithUpperXattrs defaults
+// overridden by its LabelUpperXattrPrefix labels.
+func (s *snapshotter) upperXattrsFor(info snapshots.Info) (map[string]string, error) {
+	xattrs := maps.Clone(s.upperXattrs)
+	for k, v := range info.Labels {
+		name, ok := strings.CutPrefix(k, LabelUpperXattrPrefix)
+		if !ok {
+			continue
+		}
+		if err := validateXattrName(name); err != nil {
+			return nil, err
+		}
+		if xattrs == nil {
+			xattrs = make(map[string]string)
+		}
+		xattrs[name] = v
+	}
+	return xattrs, nil
+}
+
+// applyUpperXattrs sets xattrs on the upper directory dir. If the filesystem
+// does not support an attribute's namespace, it is skipped and a warning is
+// logged once per namespace; other failures are returned.
+func (s *snapshotter) applyUpperXattrs(ctx context.Context, dir string, xattrs map[string]string) error {
+	for _, name := range slices.Sorted(maps.Keys(xattrs)) {
+		err := lsetxattr(dir, name, []byte(xattrs[name]))
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, syscall.ENOTSUP) && !errdefs.IsNotImplemented(err) {
+			return fmt.Errorf("set upper xattr %s: %w", name, err)
+		}
+		ns, _, _ := strings.Cut(name, ".")
+		if _, warned := s.xattrUnsupported.LoadOrStore(ns, struct{}{}); !warned {
+			log.G(ctx).WithError(err).WithField("namespace", ns).Warn("filesystem does not support upper xattrs in this namespace, skipping them")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+//go:build linux
+
+package snapshotter
+
+import (
+	"os"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+	"golang.org/x/sys/unix"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/testutil"
+)
+
+func TestPrepareUpperXattrs(t *testing.T) {
+	testutil.InstallFakeMkfs(t)
+
+	s := newMetadataOnlySnapshotter(t)
+	s.defaultWritable = 1 << 20
+	s.upperXattrs = map[string]string{"user.marker": "default", "user.other": "kept"}
+	if err := os.MkdirAll(s.snapshotsDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := lsetxattr(s.snapshotsDir(), "user.probe", []byte("1")); err != nil {
+		t.Skipf("user xattrs not supported: %v", err)
+	}
+
+	label := snapshots.WithLabels(map[string]string{LabelUpperXattrPrefix + "user.marker": "label"})
+	if _, err := s.Prepare(t.Context(), "active", "", label); err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	upper := s.upperPath(snapshotID(t.Context(), t, s, "active"))
+	for name, want := range map[string]string{"user.marker": "label", "user.other": "kept"} {
+		buf := make([]byte, 64)
+		n, err := unix.Lgetxattr(upper, name, buf)
+		if err != nil {
+			t.Errorf("get %s: %v", name, err)
+			continue
+		}
+		if got := string(buf[:n]); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// Names outside the allowed namespaces are rejected.
+	bad := snapshots.WithLabels(map[string]string{LabelUpperXattrPrefix + "system.posix_acl_access": "x"})
+	if _, err := s.Prepare(t.Context(), "bad", "", bad); !errdefs.IsInvalidArgument(err) {
+		t.Errorf("expected invalid argument, got %v", err)
+	}
+}
+
+func TestValidateXattrName(t *testing.T) {
+	for _, name := range []string{"user.a", "security.selinux", "trusted.overlay.opaque"} {
+		if err := validateXattrName(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "user.", "system.posix_acl_access", "marker"} {
+		if err := validateXattrName(name); !errdefs.IsInvalidArgument(err) {
+			t.Errorf("%q: expected invalid argument, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package snapshotter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+// usageCacheEntry is a cached DiskUsage result for an active snapshot.
+type usageCacheEntry struct {
+	usage    snapshots.Usage
+	modTime  time.Time
+	cachedAt time.Time
+}
+
+// usageCache caches active snapshot disk usage keyed by snapshot ID.
+//
+// An entry is valid while the upper directory's mtime is unchanged and the
+// entry is younger than ttl. The mtime only reflects changes to direct
+// children of the upper directory, so ttl bounds staleness for writes
+// deeper in the tree.
+//
+// A nil *usageCache is valid and caches nothing.
+type usageCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]usageCacheEntry
+}
+
+func newUsageCache(ttl time.Duration) *usageCache {
+	return &usageCache{
+		ttl:     ttl,
+		entries: make(map[string]usageCacheEntry),
+	}
+}
+
+// get returns the cached usage for id if it is still valid for modTime.
+func (c *usageCache) get(id string, modTime time.Time) (snapshots.Usage, bool) {
+	if c == nil {
+		return snapshots.Usage{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[id]
+	if !ok {
+		return snapshots.Usage{}, false
+	}
+	if !e.modTime.Equal(modTime) || time.Since(e.cachedAt) > c.ttl {
+		delete(c.entries, id)
+		return snapshots.Usage{}, false
+	}
+	return e.usage, true
+}
+
+// put stores usage for id, computed when the upper directory had modTime.
+func (c *usageCache) put(id string, modTime time.Time, usage snapshots.Usage) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[id] = usageCacheEntry{
+		usage:    usage,
+		modTime:  modTime,
+		cachedAt: time.Now(),
+	}
+}
+
+// invalidate drops any cached usage for id.
+func (c *usageCache) invalidate(id string) {
+	if c == nil || id == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, id)
+}
@@ -0,0 +1,125 @@
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+func TestUsageCache(t *testing.T) {
+	mtime := time.Now()
+
+	t.Run("nil cache never hits", func(t *testing.T) {
+		var c *usageCache
+		c.put("id", mtime, snapshots.Usage{Size: 1})
+		if _, ok := c.get("id", mtime); ok {
+			t.Error("expected miss on nil cache")
+		}
+		c.invalidate("id") // must not panic
+	})
+
+	t.Run("hit when mtime unchanged", func(t *testing.T) {
+		c := newUsageCache(time.Minute)
+		c.put("id", mtime, snapshots.Usage{Size: 42, Inodes: 3})
+		got, ok := c.get("id", mtime)
+		if !ok {
+			t.Fatal("expected cache hit")
+		}
+		if got.Size != 42 || got.Inodes != 3 {
+			t.Errorf("got %+v, want size=42 inodes=3", got)
+		}
+	})
+
+	t.Run("miss when mtime changed", func(t *testing.T) {
+		c := newUsageCache(time.Minute)
+		c.put("id", mtime, snapshots.Usage{Size: 42})
+		if _, ok := c.get("id", mtime.Add(time.Second)); ok {
+			t.Error("expected miss after mtime change")
+		}
+	})
+
+	t.Run("miss when ttl expired", func(t *testing.T) {
+		c := newUsageCache(time.Nanosecond)
+		c.put("id", mtime, snapshots.Usage{Size: 42})
+		time.Sleep(time.Millisecond)
+		if _, ok := c.get("id", mtime); ok {
+			t.Error("expected miss after ttl expiry")
+		}
+	})
+
+	t.Run("invalidate drops entry", func(t *testing.T) {
+		c := newUsageCache(time.Minute)
+		c.put("id", mtime, snapshots.Usage{Size: 42})
+		c.invalidate("id")
+		if _, ok := c.get("id", mtime); ok {
+			t.Error("expected miss after invalidate")
+		}
+	})
+}
+
+func TestUsageCacheConcurrent(t *testing.T) {
+	c := newUsageCache(time.Minute)
+	mtime := time.Now()
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			id := []string{"a", "b", "c"}[n%3]
+			c.put(id, mtime, snapshots.Usage{Size: int64(n)})
+			c.get(id, mtime)
+			if n%7 == 0 {
+				c.invalidate(id)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestActiveUsageUsesCache(t *testing.T) {
+	root := t.TempDir()
+	s := &snapshotter{root: root, usageCache: newUsageCache(time.Minute)}
+
+	upper := s.upperPath("active")
+	if err := os.MkdirAll(upper, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(upper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Seed the cache with a sentinel that DiskUsage would never produce.
+	sentinel := snapshots.Usage{Size: 123456789, Inodes: 987}
+	s.usageCache.put("active", st.ModTime(), sentinel)
+
+	got, err := s.activeUsage(t.Context(), "active")
+	if err != nil {
+		t.Fatalf("activeUsage failed: %v", err)
+	}
+	if got != sentinel {
+		t.Errorf("expected cached usage %+v, got %+v", sentinel, got)
+	}
+
+	// Adding a file changes the upper directory mtime and forces a rescan.
+	future := st.ModTime().Add(time.Hour)
+	if err := os.WriteFile(filepath.Join(upper, "file"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(upper, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = s.activeUsage(t.Context(), "active")
+	if err != nil {
+		t.Fatalf("activeUsage failed: %v", err)
+	}
+	if got == sentinel {
+		t.Error("expected fresh usage after upper directory changed")
+	}
+}
@@ -0,0 +1,119 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+)
+
+// digestCache remembers layer blobs whose content was verified against their
+// LabelLayerDigest. Entries are keyed by path and hold the file's size and
+// modification time at verification, so a rewritten blob (for example by
+// Recompress) is hashed again. A nil cache disables verification.
+type digestCache struct {
+	mu      sync.Mutex
+	entries map[string]verifiedBlob
+}
+
+type verifiedBlob struct {
+	size    int64
+	modTime time.Time
+	digest  digest.Digest
+}
+
+func newDigestCache() *digestCache {
+	return &digestCache{entries: make(map[string]verifiedBlob)}
+}
+
+// verify checks that the blob at path, belonging to snapshot id, has digest
+// expected. It returns an IntegrityError on mismatch.
+func (c *digestCache) verify(ctx context.Context, id, path string, expected digest.Digest) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat layer blob: %w", err)
+	}
+	entry := verifiedBlob{size: fi.Size(), modTime: fi.ModTime(), digest: expected}
+
+	c.mu.Lock()
+	cached, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && cached == entry {
+		return nil
+	}
+
+	actual, err := digestFile(ctx, path)
+	if err != nil {
+		return fmt.Errorf("compute layer digest: %w", err)
+	}
+	if actual != expected {
+		c.mu.Lock()
+		delete(c.entries, path)
+		c.mu.Unlock()
+		return &IntegrityError{SnapshotID: id, Path: path, Expected: expected, Actual: actual}
+	}
+
+	c.mu.Lock()
+	c.entries[path] = entry
+	c.mu.Unlock()
+	return nil
+}
+
+// verifyParentLayers checks the blob of parent and each of its ancestors
+// against their LabelLayerDigest when WithVerifyDigestOnMount is set.
+// Hashing runs outside the metadata transaction so large blobs do not hold
+// it open.
+func (s *snapshotter) verifyParentLayers(ctx context.Context, parent string) error {
+	if s.verifiedDigests == nil || parent == "" {
+		return nil
+	}
+
+	type layer struct {
+		id     string
+		digest digest.Digest
+	}
+	var layers []layer
+	var blobs layerBlobIndex
+	if err := s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		for key := parent; key != ""; {
+			id, info, _, err := storage.GetInfo(ctx, key)
+			if err != nil {
+				return fmt.Errorf("get parent info %q: %w", key, err)
+			}
+			if d := info.Labels[LabelLayerDigest]; d != "" {
+				dgst, err := digest.Parse(d)
+				if err != nil {
+					return fmt.Errorf("parse layer digest of %q: %w", key, err)
+				}
+				layers = append(layers, layer{id: id, digest: dgst})
+			}
+			if p := info.Labels[LabelLayerBlobPath]; p != "" {
+				if blobs == nil {
+					blobs = make(layerBlobIndex)
+				}
+				blobs[id] = p
+			}
+			key = info.Parent
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, l := range layers {
+		path, err := s.lowerPath(l.id, blobs)
+		if err != nil {
+			return err
+		}
+		if err := s.verifiedDigests.verify(ctx, l.id, path, l.digest); err != nil {
+			log.G(ctx).WithError(err).WithField("id", l.id).Error("layer blob verification failed")
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package snapshotter
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestDigestCacheVerify(t *testing.T) {
+	path := t.TempDir() + "/layer.erofs"
+	if err := os.WriteFile(path, []byte("layer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := newDigestCache()
+
+	if err := c.verify(t.Context(), "1", path, digest.FromString("layer")); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// Same size and modification time: the cached result is used.
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("LAYER"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.verify(t.Context(), "1", path, digest.FromString("layer")); err != nil {
+		t.Errorf("expected cached verification, got %v", err)
+	}
+
+	// A rewritten blob is hashed again.
+	if err := os.WriteFile(path, []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = c.verify(t.Context(), "1", path, digest.FromString("layer"))
+	var ierr *IntegrityError
+	if !errors.As(err, &ierr) {
+		t.Fatalf("expected IntegrityError, got %v", err)
+	}
+	if ierr.Expected != digest.FromString("layer") || ierr.Actual != digest.FromString("changed") {
+		t.Errorf("unexpected integrity error %+v", ierr)
+	}
+}
+
+func TestMountsVerifiesParentDigests(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	s.verifiedDigests = newDigestCache()
+	base := commitMetadataLayer(t, s, "base", "", []byte("base"))
+	commitMetadataLayer(t, s, "top", "base", []byte("top layer"))
+	createMetadataSnapshot(t, s, snapshots.KindView, "view", "top")
+
+	if _, err := s.Mounts(t.Context(), "view"); err != nil {
+		t.Fatalf("Mounts: %v", err)
+	}
+
+	if err := os.WriteFile(s.fallbackLayerBlobPath(base), []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Mounts(t.Context(), "view")
+	if !errdefs.IsDataLoss(err) {
+		t.Fatalf("expected data loss error, got %v", err)
+	}
+	var ierr *IntegrityError
+	if !errors.As(err, &ierr) || ierr.SnapshotID != base {
+		t.Errorf("expected IntegrityError for snapshot %s, got %v", base, err)
+	}
+
+	if _, err := s.View(t.Context(), "view2", "top"); !errdefs.IsDataLoss(err) {
+		t.Errorf("expected View to fail verification, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package snapshotter
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+)
+
+// VolumeMountOption is the mount option carrying the name of a named
+// writable volume, e.g. "X-erofs.volume=tmp". Mounts returns volume mounts
+// between the read-only layers and the primary writable layer, which stays
+// last and holds the overlay upperdir. Runtimes bind-mount each volume into
+// the container themselves, at a path of their choosing.
+const VolumeMountOption = "X-erofs.volume"
+
+const (
+	// volumeFilePrefix and volumeFileSuffix surround the volume name in the
+	// file name of a named writable volume image.
+	volumeFilePrefix = "volume-"
+	volumeFileSuffix = ".img"
+)
+
+// volumeNameRegex restricts volume names to what is safe in a file name
+// and a mount option.
+var volumeNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// writableVolume is a named ext4 volume of an active snapshot, requested
+// with a LabelVolumePrefix label.
+type writableVolume struct {
+	name string
+	size int64
+}
+
+// volumesFor returns the named writable volumes requested by the
+// LabelVolumePrefix labels in labels, sorted by name.
+func volumesFor(labels map[string]string) ([]writableVolume, error) {
+	var volumes []writableVolume
+	for k, v := range labels {
+		name, ok := strings.CutPrefix(k, LabelVolumePrefix)
+		if !ok {
+			continue
+		}
+		if !volumeNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid volume name %q (must match %s): %w",
+				name, volumeNameRegex, errdefs.ErrInvalidArgument)
+		}
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid size %q for volume %q (must be a positive byte count): %w",
+				v, name, errdefs.ErrInvalidArgument)
+		}
+		volumes = append(volumes, writableVolume{name: name, size: size})
+	}
+	slices.SortFunc(volumes, func(a, b writableVolume) int { return strings.Compare(a.name, b.name) })
+	return volumes, nil
+}
+
+// volumesFromOpts returns the named writable volumes requested by the
+// labels in opts.
+func volumesFromOpts(opts []snapshots.Opt) ([]writableVolume, error) {
+	var info snapshots.Info
+	for _, opt := range opts {
+		if err := opt(&info); err != nil {
+			return nil, err
+		}
+	}
+	return volumesFor(info.Labels)
+}
+
+// volumesSize returns the total size of volumes in bytes.
+func volumesSize(volumes []writableVolume) int64 {
+	var total int64
+	for _, v := range volumes {
+		total += v.size
+	}
+	return total
+}
+
+// volumePath returns the path of the named writable volume image of
+// snapshot id.
+func (s *snapshotter) volumePath(id, name string) string {
+	return filepath.Join(s.snapshotDir(id), volumeFilePrefix+name+volumeFileSuffix)
+}
+
+// volumePaths returns the named writable volume images of snapshot id,
+// sorted by name. The images on disk are authoritative, since the labels
+// that requested them can be changed after Prepare.
+func (s *snapshotter) volumePaths(id string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(s.snapshotDir(id), volumeFilePrefix+"*"+volumeFileSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("glob writable volumes: %w", err)
+	}
+	return paths, nil
+}
+
+// volumeMounts returns one ext4 mount per named writable volume of
+// snapshot id, tagged with VolumeMountOption.
+func (s *snapshotter) volumeMounts(id string) ([]mount.Mount, error) {
+	paths, err := s.volumePaths(id)
+	if err != nil {
+		return nil, err
+	}
+	var mounts []mount.Mount
+	for _, p := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(p), volumeFilePrefix), volumeFileSuffix)
+		mounts = append(mounts, mount.Mount{
+			Source:  p,
+			Type:    "ext4",
+			Options: append([]string{"rw", "loop", VolumeMountOption + "=" + name}, s.ext4MountOpts...),
+		})
+	}
+	return mounts, nil
+}
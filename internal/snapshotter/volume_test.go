@@ -0,0 +1,92 @@
+package snapshotter
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/errdefs"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/testutil"
+)
+
+func TestVolumesFor(t *testing.T) {
+	volumes, err := volumesFor(map[string]string{
+		LabelVolumePrefix + "var": "4096",
+		LabelVolumePrefix + "tmp": "2048",
+		"unrelated":               "x",
+	})
+	if err != nil {
+		t.Fatalf("volumesFor: %v", err)
+	}
+	want := []writableVolume{{name: "tmp", size: 2048}, {name: "var", size: 4096}}
+	if !slices.Equal(volumes, want) {
+		t.Errorf("volumesFor = %v, want %v", volumes, want)
+	}
+
+	for _, labels := range []map[string]string{
+		{LabelVolumePrefix + "Tmp": "2048"},
+		{LabelVolumePrefix + "../tmp": "2048"},
+		{LabelVolumePrefix: "2048"},
+		{LabelVolumePrefix + "tmp": "0"},
+		{LabelVolumePrefix + "tmp": "1Gi"},
+	} {
+		if _, err := volumesFor(labels); !errdefs.IsInvalidArgument(err) {
+			t.Errorf("volumesFor(%v): expected invalid argument, got %v", labels, err)
+		}
+	}
+}
+
+func TestPrepareWritableVolumes(t *testing.T) {
+	testutil.InstallFakeMkfs(t)
+
+	s := newMetadataOnlySnapshotter(t)
+	s.defaultWritable = 1 << 20
+	if err := os.MkdirAll(s.snapshotsDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := snapshots.WithLabels(map[string]string{
+		LabelVolumePrefix + "var": "4096",
+		LabelVolumePrefix + "tmp": "2048",
+	})
+	mounts, err := s.Prepare(t.Context(), "active", "", labels)
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if len(mounts) != 3 {
+		t.Fatalf("expected 2 volume mounts and the writable layer, got %+v", mounts)
+	}
+	for i, name := range []string{"tmp", "var"} {
+		m := mounts[i]
+		if m.Type != "ext4" || filepath.Base(m.Source) != "volume-"+name+".img" ||
+			!slices.Contains(m.Options, VolumeMountOption+"="+name) {
+			t.Errorf("unexpected mount for volume %s: %+v", name, m)
+		}
+	}
+	if filepath.Base(mounts[2].Source) != rwLayerFilename {
+		t.Errorf("expected writable layer last, got %+v", mounts[2])
+	}
+	fi, err := os.Stat(mounts[0].Source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 2048 {
+		t.Errorf("expected tmp volume of 2048 bytes, got %d", fi.Size())
+	}
+
+	usage, err := s.NamespaceUsage(t.Context())
+	if err != nil {
+		t.Fatalf("NamespaceUsage: %v", err)
+	}
+	if want := int64(1<<20 + 2048 + 4096); usage[""] != want {
+		t.Errorf("expected usage %d, got %d", want, usage[""])
+	}
+
+	bad := snapshots.WithLabels(map[string]string{LabelVolumePrefix + "tmp": "-1"})
+	if _, err := s.Prepare(t.Context(), "bad", "", bad); !errdefs.IsInvalidArgument(err) {
+		t.Errorf("expected invalid argument for bad volume size, got %v", err)
+	}
+}
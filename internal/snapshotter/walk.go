@@ -0,0 +1,93 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+)
+
+// DetailedInfo is a snapshots.Info with the physical layout of the
+// snapshot on disk.
+type DetailedInfo struct {
+	snapshots.Info
+
+	// ID is the snapshot's directory name under the snapshots directory.
+	ID string
+	// LayerBlobPath is the EROFS blob of a committed snapshot. It is empty
+	// for active and view snapshots and when the blob is missing.
+	LayerBlobPath string
+	// BlobSize is the size of LayerBlobPath in bytes.
+	BlobSize int64
+	// HasFsMeta reports whether merged fsmeta for the chain ending at this
+	// snapshot has been generated.
+	HasFsMeta bool
+	// MountPlan is the kind of mounts Mounts returns for an active or view
+	// snapshot. It is empty for committed snapshots.
+	MountPlan MountPlanKind
+}
+
+// DetailedWalkFunc is called by WalkDetailed for each matching snapshot.
+type DetailedWalkFunc func(context.Context, DetailedInfo) error
+
+// DetailedWalker is implemented by snapshotters that can walk snapshots
+// together with their on-disk layout.
+type DetailedWalker interface {
+	WalkDetailed(ctx context.Context, fn DetailedWalkFunc, filters ...string) error
+}
+
+// WalkDetailed is like Walk but also resolves each snapshot's layer blob,
+// fsmeta and mount plan, all within the same read transaction. It does not
+// modify anything on disk.
+func (s *snapshotter) WalkDetailed(ctx context.Context, fn DetailedWalkFunc, fs ...string) error {
+	return s.ms.WithTransaction(ctx, false, func(ctx context.Context) error {
+		return storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+			di, err := s.detailedInfo(ctx, info)
+			if err != nil {
+				return err
+			}
+			return fn(ctx, di)
+		}, fs...)
+	})
+}
+
+// detailedInfo resolves the layout of the snapshot described by info.
+// Must be called within a transaction.
+func (s *snapshotter) detailedInfo(ctx context.Context, info snapshots.Info) (DetailedInfo, error) {
+	id, _, _, err := storage.GetInfo(ctx, info.Name)
+	if err != nil {
+		return DetailedInfo{}, fmt.Errorf("get snapshot info for %q: %w", info.Name, err)
+	}
+	di := DetailedInfo{Info: info, ID: id}
+	if _, err := os.Stat(s.fsMetaPath(id)); err == nil {
+		di.HasFsMeta = true
+	}
+
+	if info.Kind == snapshots.KindCommitted {
+		blob, err := s.findLayerBlobFromInfo(id, info)
+		if err != nil {
+			// Reported as a missing blob rather than failing the walk.
+			return di, nil //nolint:nilerr // missing blob is part of the layout
+		}
+		di.LayerBlobPath = blob
+		if fi, err := os.Stat(blob); err == nil {
+			di.BlobSize = fi.Size()
+		}
+		return di, nil
+	}
+
+	snap, err := storage.GetSnapshot(ctx, info.Name)
+	if err != nil {
+		return DetailedInfo{}, fmt.Errorf("get snapshot %q: %w", info.Name, err)
+	}
+	blobs, err := s.mountLayerBlobs(ctx, info)
+	if err != nil {
+		return DetailedInfo{}, err
+	}
+	if di.MountPlan, err = s.mountPlan(snap, info, blobs); err != nil {
+		return DetailedInfo{}, err
+	}
+	return di, nil
+}
@@ -0,0 +1,57 @@
+package snapshotter
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+func TestWalkDetailed(t *testing.T) {
+	s := newMetadataOnlySnapshotter(t)
+	base := commitMetadataLayer(t, s, "base", "", []byte("base layer"))
+	createMetadataSnapshot(t, s, snapshots.KindActive, "active", "base")
+	if err := os.WriteFile(s.fsMetaPath(base), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]DetailedInfo{}
+	if err := s.WalkDetailed(t.Context(), func(_ context.Context, di DetailedInfo) error {
+		got[di.Name] = di
+		return nil
+	}); err != nil {
+		t.Fatalf("WalkDetailed: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", len(got))
+	}
+
+	b := got["base"]
+	if b.ID != base || b.LayerBlobPath != s.fallbackLayerBlobPath(base) || b.BlobSize != int64(len("base layer")) {
+		t.Errorf("unexpected committed layout %+v", b)
+	}
+	if !b.HasFsMeta || b.MountPlan != "" {
+		t.Errorf("expected fsmeta and no mount plan for committed snapshot, got %+v", b)
+	}
+
+	a := got["active"]
+	if a.LayerBlobPath != "" || a.HasFsMeta {
+		t.Errorf("unexpected active layout %+v", a)
+	}
+	// Only fsmeta.erofs exists, without the VMDK descriptor.
+	if a.MountPlan != MountPlanErofs {
+		t.Errorf("expected %q mount plan, got %q", MountPlanErofs, a.MountPlan)
+	}
+
+	var names []string
+	if err := s.WalkDetailed(t.Context(), func(_ context.Context, di DetailedInfo) error {
+		names = append(names, di.Name)
+		return nil
+	}, "kind==committed"); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "base" {
+		t.Errorf("expected filter to select base, got %v", names)
+	}
+}
@@ -57,11 +57,15 @@ type NamespaceAwareStore struct {
 
 // NewNamespaceAwareStore creates a new namespace-aware content store wrapper.
 // The defaultNamespace is used when the context doesn't contain a namespace.
-func NewNamespaceAwareStore(client *containerd.Client, defaultNamespace string) *NamespaceAwareStore {
+// Returns ErrFailedPrecondition if client is nil.
+func NewNamespaceAwareStore(client *containerd.Client, defaultNamespace string) (*NamespaceAwareStore, error) {
+	if client == nil {
+		return nil, fmt.Errorf("containerd client is required: %w", errdefs.ErrFailedPrecondition)
+	}
 	return &NamespaceAwareStore{
 		provider:         &clientStoreProvider{client: client},
 		defaultNamespace: defaultNamespace,
-	}
+	}, nil
 }
 
 // newNamespaceAwareStoreWithProvider creates a NamespaceAwareStore with a custom
@@ -73,16 +77,27 @@ func newNamespaceAwareStoreWithProvider(provider storeProvider, defaultNamespace
 	}
 }
 
-// getNamespacedContext returns a context with the namespace set.
-// If the context already has a namespace, it returns it unchanged.
-// Otherwise, it uses the default namespace.
-func (s *NamespaceAwareStore) getNamespacedContext(ctx context.Context) (context.Context, error) {
+// NamespaceOrDefault returns the namespace from the context, or
+// defaultNamespace if the context has none. Returns ErrFailedPrecondition
+// if neither is set.
+func NamespaceOrDefault(ctx context.Context, defaultNamespace string) (string, error) {
 	ns, ok := namespaces.Namespace(ctx)
 	if !ok || ns == "" {
-		ns = s.defaultNamespace
+		ns = defaultNamespace
 	}
 	if ns == "" {
-		return nil, fmt.Errorf("namespace is required: %w", errdefs.ErrFailedPrecondition)
+		return "", fmt.Errorf("namespace is required: %w", errdefs.ErrFailedPrecondition)
+	}
+	return ns, nil
+}
+
+// getNamespacedContext returns a context with the namespace set.
+// If the context already has a namespace, it returns it unchanged.
+// Otherwise, it uses the default namespace.
+func (s *NamespaceAwareStore) getNamespacedContext(ctx context.Context) (context.Context, error) {
+	ns, err := NamespaceOrDefault(ctx, s.defaultNamespace)
+	if err != nil {
+		return nil, err
 	}
 	// Ensure namespace is set in context for the content store call
 	return namespaces.WithNamespace(ctx, ns), nil
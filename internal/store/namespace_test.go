@@ -19,7 +19,7 @@ import (
 )
 
 func TestNewNamespaceAwareStore(t *testing.T) {
-	store := NewNamespaceAwareStore(nil, "default")
+	store := newNamespaceAwareStoreWithProvider(nil, "default")
 	if store == nil {
 		t.Fatal("expected non-nil store")
 	}
@@ -68,7 +68,7 @@ func TestGetNamespacedContext(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			store := NewNamespaceAwareStore(nil, tc.defaultNamespace)
+			store := newNamespaceAwareStoreWithProvider(nil, tc.defaultNamespace)
 
 			ctx := t.Context()
 			if tc.inputNamespace != "" {
@@ -103,22 +103,17 @@ func TestGetNamespacedContext(t *testing.T) {
 }
 
 func TestNamespaceAwareStore_NilClient(t *testing.T) {
-	// Test that operations fail gracefully with nil client
-	// This documents expected behavior when misconfigured
-	store := NewNamespaceAwareStore(nil, "default")
-
-	// These should panic or return errors due to nil client
-	// We're documenting this behavior, not endorsing it
-	t.Run("store returns nil for nil client", func(t *testing.T) {
-		// store() calls client.ContentStore() which will panic on nil client
-		// This test documents that the store requires a valid client
-		defer func() {
-			if r := recover(); r == nil {
-				t.Log("no panic occurred - client might handle nil")
-			}
-		}()
-		_ = store.store()
-	})
+	// A nil client must be rejected up front rather than panicking on first use.
+	store, err := NewNamespaceAwareStore(nil, "default")
+	if err == nil {
+		t.Fatal("expected error for nil client")
+	}
+	if !errdefs.IsFailedPrecondition(err) {
+		t.Errorf("expected ErrFailedPrecondition, got %v", err)
+	}
+	if store != nil {
+		t.Errorf("expected nil store, got %v", store)
+	}
 }
 
 // memoryLabelStore is a simple in-memory label store for testing.
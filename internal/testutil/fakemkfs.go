@@ -0,0 +1,114 @@
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeMkfsErofs stands in for mkfs.erofs. It accepts the options the
+// snapshotter and differ pass and writes a 4 KiB image with a valid EROFS
+// superblock: magic, block size (from -b, 512 bytes for --tar=i) and UUID
+// (from -U, random otherwise). A sorted listing of the source directory
+// follows, so images of different trees differ. With --vmdk-desc it writes
+// a descriptor listing the image and the given blobs as FLAT extents.
+const fakeMkfsErofs = `#!/bin/sh
+set -e
+if [ "$1" = "--help" ]; then
+	echo "usage: mkfs.erofs [OPTIONS] FILE SOURCE(s)"
+	echo " --tar=X               generate a full or index-only image from a tarball"
+	echo " -E X[,...]            X=extended options (ztailpacking, noinline_data)"
+	echo " -b#                   set block size to # (# = page size by default)"
+	exit 0
+fi
+
+bits=12 uuid= vmdk= tar= out= srcs=
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-U) uuid=$2; shift ;;
+	-b*)
+		n=${1#-b} bits=0
+		while [ "$n" -gt 1 ]; do n=$((n / 2)) bits=$((bits + 1)); done
+		;;
+	--vmdk-desc=*) vmdk=${1#--vmdk-desc=} ;;
+	--tar=*) tar=${1#--tar=} ;;
+	-*) ;;
+	*)
+		if [ -z "$out" ]; then out=$1; else srcs="$srcs
+$1"; fi
+		;;
+	esac
+	shift
+done
+[ "$tar" = i ] && bits=9
+src=$(echo "$srcs" | sed -n 2p)
+
+uuidbytes() {
+	if [ -z "$uuid" ]; then
+		head -c 16 /dev/urandom
+		return
+	fi
+	hex=$(echo "$uuid" | tr -d -)
+	while [ -n "$hex" ]; do
+		rest=${hex#??}
+		printf "\\$(printf %03o "0x${hex%"$rest"}")"
+		hex=$rest
+	done
+}
+
+{
+	head -c 1024 /dev/zero
+	printf '\342\341\365\340'
+	head -c 8 /dev/zero
+	printf "\\$(printf %03o "$bits")"
+	head -c 35 /dev/zero
+	uuidbytes
+	head -c 3008 /dev/zero
+	if [ -n "$tar" ]; then
+		cat
+	elif [ -n "$src" ] && [ -z "$vmdk" ]; then
+		(cd "$src" && find . | LC_ALL=C sort)
+	fi
+} > "$out"
+
+if [ -n "$vmdk" ]; then
+	{
+		echo "# Disk DescriptorFile"
+		echo "version=1"
+		echo 'createType="twoGbMaxExtentFlat"'
+		IFS='
+'
+		for f in $out $srcs; do
+			echo "RW $((($(wc -c < "$f") + 511) / 512)) FLAT \"$f\" 0"
+		done
+	} > "$vmdk"
+fi
+`
+
+// fakeMkfsExt4 stands in for mkfs.ext4. It writes the ext4 superblock magic
+// into the image file given as the last argument, keeping its size.
+const fakeMkfsExt4 = `#!/bin/sh
+set -e
+for img; do :; done
+printf '\123\357' | dd of="$img" bs=1 seek=1080 conv=notrunc 2>/dev/null
+`
+
+// InstallFakeMkfs installs fake mkfs.erofs and mkfs.ext4 executables in a
+// temporary directory placed first on PATH for the rest of the test, and
+// returns the directory. The images they write only carry what the
+// snapshotter reads from superblocks (block size, UUID, ext4 magic); they
+// cannot be mounted. Tests using it must not run in parallel.
+func InstallFakeMkfs(t testing.TB) string {
+	t.Helper()
+	bin := t.TempDir()
+	for name, script := range map[string]string{
+		"mkfs.erofs": fakeMkfsErofs,
+		"mkfs.ext4":  fakeMkfsExt4,
+	} {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return bin
+}
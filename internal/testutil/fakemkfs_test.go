@@ -0,0 +1,94 @@
+package testutil_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spin-stack/erofs-snapshotter/internal/erofs"
+	"github.com/spin-stack/erofs-snapshotter/internal/testutil"
+)
+
+func TestFakeMkfsErofs(t *testing.T) {
+	testutil.InstallFakeMkfs(t)
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "file"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	t.Run("directory", func(t *testing.T) {
+		layer := filepath.Join(dir, "dir.erofs")
+		if err := erofs.ConvertErofs(t.Context(), layer, src, []string{"-b16384"}); err != nil {
+			t.Fatalf("ConvertErofs: %v", err)
+		}
+		if bs, err := erofs.GetBlockSize(layer); err != nil || bs != 16384 {
+			t.Errorf("GetBlockSize = %d, %v; want 16384", bs, err)
+		}
+		data, err := os.ReadFile(layer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(data, []byte("./file")) {
+			t.Error("expected the image to list the source tree")
+		}
+	})
+
+	t.Run("tar with uuid", func(t *testing.T) {
+		const want = "550e8400-e29b-41d4-a716-446655440000"
+		layer := filepath.Join(dir, "tar.erofs")
+		if err := erofs.ConvertTarErofs(t.Context(), strings.NewReader("tar"), layer, want, nil); err != nil {
+			t.Fatalf("ConvertTarErofs: %v", err)
+		}
+		if u, err := erofs.GetUUID(layer); err != nil || u.String() != want {
+			t.Errorf("GetUUID = %s, %v; want %s", u, err, want)
+		}
+		if bs, err := erofs.GetBlockSize(layer); err != nil || bs != 4096 {
+			t.Errorf("GetBlockSize = %d, %v; want 4096", bs, err)
+		}
+	})
+
+	t.Run("tar index", func(t *testing.T) {
+		layer := filepath.Join(dir, "index.erofs")
+		if err := erofs.GenerateTarIndexAndAppendTar(t.Context(), strings.NewReader("tar"), layer, nil); err != nil {
+			t.Fatalf("GenerateTarIndexAndAppendTar: %v", err)
+		}
+		if bs, err := erofs.GetBlockSize(layer); err != nil || bs != 512 {
+			t.Errorf("GetBlockSize = %d, %v; want 512", bs, err)
+		}
+	})
+
+	t.Run("capabilities", func(t *testing.T) {
+		if ok, err := erofs.SupportsTailPacking(); err != nil || !ok {
+			t.Errorf("SupportsTailPacking = %v, %v", ok, err)
+		}
+		if ok, err := erofs.SupportGenerateFromTar(); err != nil || !ok {
+			t.Errorf("SupportGenerateFromTar = %v, %v", ok, err)
+		}
+	})
+}
+
+func TestFakeMkfsExt4(t *testing.T) {
+	bin := testutil.InstallFakeMkfs(t)
+	img := filepath.Join(t.TempDir(), "rwlayer.img")
+	if err := os.WriteFile(img, make([]byte, 1<<16), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := exec.Command(filepath.Join(bin, "mkfs.ext4"), "-q", "-F", "-L", "rwlayer", img).CombinedOutput(); err != nil {
+		t.Fatalf("mkfs.ext4: %v: %s", err, out)
+	}
+	data, err := os.ReadFile(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1<<16 {
+		t.Errorf("expected the image size to be kept, got %d", len(data))
+	}
+	if data[1080] != 0x53 || data[1081] != 0xef {
+		t.Errorf("expected ext4 magic, got %#x %#x", data[1080], data[1081])
+	}
+}